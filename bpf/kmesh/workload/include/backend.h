@@ -25,21 +25,41 @@ static inline int waypoint_manager(struct kmesh_context *kmesh_ctx, struct ip_ad
     return 0;
 }
 
-static inline int svc_dnat(struct kmesh_context *kmesh_ctx, backend_value *backend_v, service_value *service_v)
+static inline void
+set_dnat(struct kmesh_context *kmesh_ctx, ctx_buff_t *ctx, backend_value *backend_v, __u32 target_port)
+{
+    if (ctx->user_family == AF_INET)
+        kmesh_ctx->dnat_ip.ip4 = backend_v->addr.ip4;
+    else
+        bpf_memcpy(kmesh_ctx->dnat_ip.ip6, backend_v->addr.ip6, IPV6_ADDR_LEN);
+
+    kmesh_ctx->dnat_port = target_port;
+    kmesh_ctx->via_waypoint = false;
+}
+
+static inline int
+svc_dnat(struct kmesh_context *kmesh_ctx, backend_value *backend_v, __u32 service_id, service_value *service_v)
 {
     int i;
     ctx_buff_t *ctx = (ctx_buff_t *)kmesh_ctx->ctx;
+    service_port_key port_k = {0};
+    service_port_value *port_v = NULL;
 
 #pragma unroll
     for (i = 0; i < MAX_PORT_COUNT; i++) {
         if (ctx->user_port == service_v->service_port[i]) {
-            if (ctx->user_family == AF_INET)
-                kmesh_ctx->dnat_ip.ip4 = backend_v->addr.ip4;
-            else
-                bpf_memcpy(kmesh_ctx->dnat_ip.ip6, backend_v->addr.ip6, IPV6_ADDR_LEN);
+            set_dnat(kmesh_ctx, ctx, backend_v, service_v->target_port[i]);
+            return 0;
+        }
+    }
 
-            kmesh_ctx->dnat_port = service_v->target_port[i];
-            kmesh_ctx->via_waypoint = false;
+    // the ports exceeding MAX_PORT_COUNT are stored in map_of_service_port
+    if (service_v->service_port[MAX_PORT_COUNT - 1] != 0) {
+        port_k.service_id = service_id;
+        port_k.service_port = ctx->user_port;
+        port_v = kmesh_map_lookup_elem(&map_of_service_port, &port_k);
+        if (port_v) {
+            set_dnat(kmesh_ctx, ctx, backend_v, port_v->target_port);
             return 0;
         }
     }
@@ -71,7 +91,7 @@ backend_manager(struct kmesh_context *kmesh_ctx, backend_value *backend_v, __u32
         return ret;
     }
 
-    ret = svc_dnat(kmesh_ctx, backend_v, service_v);
+    ret = svc_dnat(kmesh_ctx, backend_v, service_id, service_v);
     if (ret == 0) {
         BPF_LOG(
             DEBUG,
@@ -13,6 +13,10 @@
 #define MAP_SIZE_OF_DSTINFO       8192
 #define MAP_SIZE_OF_AUTH_TAILCALL 100000
 #define MAP_SIZE_OF_AUTH_POLICY   512
+#define MAP_SIZE_OF_SERVICE_PORT  65536
+
+// maglev lookup table size of a service, must be a prime number
+#define MAGLEV_TABLE_SIZE 16381
 
 // rename map to avoid truncation when name length exceeds BPF_OBJ_NAME_LEN = 16
 #define map_of_frontend      km_frontend
@@ -30,5 +34,7 @@
 #define map_of_wl_policy     km_wlpolicy
 #define kmesh_perf_map       km_perf_map
 #define kmesh_perf_info      km_perf_info
+#define map_of_wl_maglev     km_wl_maglev
+#define map_of_service_port  km_svc_port
 
 #endif // _CONFIG_H_
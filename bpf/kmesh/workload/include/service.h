@@ -103,6 +103,54 @@ lb_locality_failover_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, s
     return ret;
 }
 
+static inline __u32 *map_lookup_maglev_table(__u32 service_id)
+{
+    __u32 inner_key = 0;
+    void *inner_map = kmesh_map_lookup_elem(&map_of_wl_maglev, &service_id);
+
+    if (!inner_map)
+        return NULL;
+    return kmesh_map_lookup_elem(inner_map, &inner_key);
+}
+
+// lb_maglev_handle pins the clients of a network namespace to the same backend, the lookup
+// table is rebuilt in userspace when the endpoints of the service change.
+static inline int lb_maglev_handle(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
+{
+    int ret = 0;
+    __u32 *table = NULL;
+    __u64 netns_cookie = 0;
+    __u32 index = 0;
+    backend_key backend_k = {0};
+    backend_value *backend_v = NULL;
+
+    table = map_lookup_maglev_table(service_id);
+    if (!table) {
+        BPF_LOG(DEBUG, SERVICE, "maglev table of service %u not found, fall back to random", service_id);
+        return lb_random_handle(kmesh_ctx, service_id, service_v);
+    }
+
+    netns_cookie = bpf_get_netns_cookie(kmesh_ctx->ctx);
+    index = (__u32)((netns_cookie ^ (netns_cookie >> 32)) % MAGLEV_TABLE_SIZE);
+    backend_k.backend_uid = table[index];
+    backend_v = map_lookup_backend(&backend_k);
+    if (!backend_v) {
+        BPF_LOG(WARN, SERVICE, "maglev select backend %u of service %u failed", backend_k.backend_uid, service_id);
+        return lb_random_handle(kmesh_ctx, service_id, service_v);
+    }
+
+    BPF_LOG(DEBUG, SERVICE, "lb_maglev_handle select backend [%u/%u]", service_id, backend_k.backend_uid);
+
+    ret = backend_manager(kmesh_ctx, backend_v, service_id, service_v);
+    if (ret != 0) {
+        if (ret != -ENOENT)
+            BPF_LOG(ERR, SERVICE, "backend_manager failed, ret:%d\n", ret);
+        return ret;
+    }
+
+    return 0;
+}
+
 static inline int service_manager(struct kmesh_context *kmesh_ctx, __u32 service_id, service_value *service_v)
 {
     int ret = 0;
@@ -133,6 +181,9 @@ static inline int service_manager(struct kmesh_context *kmesh_ctx, __u32 service
     case LB_POLICY_FAILOVER:
         ret = lb_locality_failover_handle(kmesh_ctx, service_id, service_v);
         break;
+    case LB_POLICY_MAGLEV:
+        ret = lb_maglev_handle(kmesh_ctx, service_id, service_v);
+        break;
     default:
         BPF_LOG(ERR, SERVICE, "unsupported load balance type:%u\n", service_v->lb_policy);
         ret = -EINVAL;
@@ -31,14 +31,24 @@ typedef struct {
 typedef struct {
     __u32 prio_endpoint_count[PRIO_COUNT]; // endpoint count of current service with prio
     __u32 lb_policy; // load balancing algorithm, currently supports random algorithm, locality loadbalance
-                     // Failover/strict mode
+                     // Failover/strict mode and maglev consistent hash
     __u32 service_port[MAX_PORT_COUNT]; // service_port[i] and target_port[i] are a pair, i starts from 0 and max value
-                                        // is MAX_PORT_COUNT-1
+                                        // is MAX_PORT_COUNT-1, the rest ports are stored in map_of_service_port
     __u32 target_port[MAX_PORT_COUNT];
     struct ip_addr wp_addr;
     __u32 waypoint_port;
 } service_value;
 
+// service port map, stores the ports of a service exceeding MAX_PORT_COUNT
+typedef struct {
+    __u32 service_id;
+    __u32 service_port;
+} service_port_key;
+
+typedef struct {
+    __u32 target_port;
+} service_port_value;
+
 // endpoint map
 typedef struct {
     __u32 service_id;    // service id
@@ -79,6 +89,14 @@ struct {
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_service SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(service_port_key));
+    __uint(value_size, sizeof(service_port_value));
+    __uint(max_entries, MAP_SIZE_OF_SERVICE_PORT);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} map_of_service_port SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(key_size, sizeof(endpoint_key));
@@ -95,6 +113,23 @@ struct {
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } map_of_backend SEC(".maps");
 
+// maglev lookup table of a service, each element is a backend uid
+struct inner_of_wl_maglev {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32) * MAGLEV_TABLE_SIZE);
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH_OF_MAPS);
+    __uint(key_size, sizeof(__u32)); // service id
+    __uint(value_size, sizeof(__u32));
+    __uint(max_entries, MAP_SIZE_OF_SERVICE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __array(values, struct inner_of_wl_maglev);
+} map_of_wl_maglev SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __type(key, struct bpf_sock_tuple);
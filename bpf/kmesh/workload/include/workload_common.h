@@ -24,6 +24,7 @@ typedef enum {
     LB_POLICY_RANDOM = 0,
     LB_POLICY_STRICT = 1,
     LB_POLICY_FAILOVER = 2,
+    LB_POLICY_MAGLEV = 3,
 } lb_policy_t;
 
 #pragma pack(1)
@@ -48,6 +48,7 @@ func NewCmd() *cobra.Command {
 	authzCmd.AddCommand(NewEnableCmd())
 	authzCmd.AddCommand(NewDisableCmd())
 	authzCmd.AddCommand(NewStatusCmd())
+	authzCmd.AddCommand(NewCheckCmd())
 
 	return authzCmd
 }
@@ -85,17 +86,29 @@ func NewDisableCmd() *cobra.Command {
 
 // NewStatusCmd creates a command to display the current authz status.
 func NewStatusCmd() *cobra.Command {
+	opts := policyReportOptions{}
 	cmd := &cobra.Command{
-		Use:     "status [podNames...]",
-		Short:   "Display the current authorization status",
-		Example: "kmeshctl authz status\nkmeshctl authz status pod1 pod2",
-		Args:    cobra.ArbitraryArgs,
+		Use:   "status [podNames...]",
+		Short: "Display the current authorization status",
+		Example: `kmeshctl authz status
+kmeshctl authz status pod1 pod2
+
+# Display all the authorization policies and the warnings of them:
+kmeshctl authz status --policies
+
+# Display the effective authorization policies of a workload:
+kmeshctl authz status --workload default/sleep-7d5f9b9c4-x2x5z`,
+		Args: cobra.ArbitraryArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			cli, err := utils.CreateKubeClient()
 			if err != nil {
 				log.Errorf("failed to create cli client: %v", err)
 				os.Exit(1)
 			}
+			if opts.policies || opts.workload != "" {
+				showPolicyReport(cli, args, &opts)
+				return
+			}
 
 			// Determine which pods to query.
 			var podNames []string
@@ -140,6 +153,10 @@ func NewStatusCmd() *cobra.Command {
 			fmt.Print(buf.String())
 		},
 	}
+	cmd.Flags().BoolVar(&opts.policies, "policies", false, "Display all the authorization policies and the warnings of them")
+	cmd.Flags().StringVar(&opts.workload, "workload", "",
+		"Display the effective authorization policies of the workload, given by uid, namespace/name or ip")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format of the policies: table or json")
 	return cmd
 }
 
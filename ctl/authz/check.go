@@ -0,0 +1,178 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/ctl/utils"
+	"kmesh.net/kmesh/pkg/auth"
+	"kmesh.net/kmesh/pkg/kube"
+)
+
+const (
+	patternAuthzCheck = "/debug/authz/check"
+)
+
+type checkOptions struct {
+	srcIp       string
+	srcIdentity string
+	dstIp       string
+	dstPort     uint32
+	network     string
+	output      string
+}
+
+// NewCheckCmd creates a command to evaluate a connection against the live authorization policies.
+func NewCheckCmd() *cobra.Command {
+	opts := checkOptions{}
+	cmd := &cobra.Command{
+		Use:   "check [podName]",
+		Short: "Check whether a connection would be allowed by the authorization policies",
+		Example: `# Check with any kmesh daemon pod, the source identity is looked up by the source ip:
+kmeshctl authz check --src-ip 10.244.0.5 --dst-ip 10.244.0.8 --dst-port 8080
+
+# Check with the specified kmesh daemon pod and source identity, and output as raw JSON:
+kmeshctl authz check kmesh-6ct4h --src-ip 10.244.0.5 --src-identity spiffe://cluster.local/ns/default/sa/sleep --dst-ip 10.244.0.8 --dst-port 8080 -o json`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli, err := utils.CreateKubeClient()
+			if err != nil {
+				log.Errorf("failed to create cli client: %v", err)
+				os.Exit(1)
+			}
+
+			var podName string
+			if len(args) == 0 {
+				podList, err := cli.PodsForSelector(context.TODO(), utils.KmeshNamespace, utils.KmeshLabel)
+				if err != nil || len(podList.Items) == 0 {
+					log.Errorf("failed to get kmesh podList: %v", err)
+					os.Exit(1)
+				}
+				podName = podList.Items[0].GetName()
+			} else {
+				podName = args[0]
+			}
+
+			body, err := checkAuthz(cli, podName, &opts)
+			if err != nil {
+				log.Errorf("failed to check authorization with pod %s: %v", podName, err)
+				os.Exit(1)
+			}
+			if opts.output == "json" {
+				fmt.Println(string(body))
+				return
+			}
+			printCheckResult(body)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.srcIp, "src-ip", "", "Source ip of the connection")
+	cmd.Flags().StringVar(&opts.srcIdentity, "src-identity", "",
+		"Source spiffe identity of the connection, looked up by the source ip if not specified")
+	cmd.Flags().StringVar(&opts.dstIp, "dst-ip", "", "Destination ip of the connection")
+	cmd.Flags().Uint32Var(&opts.dstPort, "dst-port", 0, "Destination port of the connection")
+	cmd.Flags().StringVar(&opts.network, "network", "", "Network of the destination")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format: table or json")
+	_ = cmd.MarkFlagRequired("src-ip")
+	_ = cmd.MarkFlagRequired("dst-ip")
+	_ = cmd.MarkFlagRequired("dst-port")
+	return cmd
+}
+
+// checkAuthz sends a GET request to a specific kmesh daemon pod to evaluate the connection, and returns the result.
+func checkAuthz(cli kube.CLIClient, podName string, opts *checkOptions) ([]byte, error) {
+	fw, err := utils.CreateKmeshPortForwarder(cli, podName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	if err := fw.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	defer fw.Close()
+
+	query := url.Values{}
+	query.Set("src_ip", opts.srcIp)
+	query.Set("dst_ip", opts.dstIp)
+	query.Set("dst_port", strconv.FormatUint(uint64(opts.dstPort), 10))
+	if opts.srcIdentity != "" {
+		query.Set("src_identity", opts.srcIdentity)
+	}
+	if opts.network != "" {
+		query.Set("network", opts.network)
+	}
+	resp, err := http.Get(fmt.Sprintf("http://%s%s?%s", fw.Address(), patternAuthzCheck, query.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func printCheckResult(body []byte) {
+	result := auth.CheckResult{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Errorf("failed to parse check result: %v, falling back to raw output", err)
+		fmt.Println(string(body))
+		return
+	}
+
+	decision := "DENY"
+	if result.Allow {
+		decision = "ALLOW"
+	}
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DECISION:\t%s\n", decision)
+	fmt.Fprintf(w, "REASON:\t%s\n", result.Reason)
+	fmt.Fprintf(w, "POLICY:\t%s\n", orNone(result.Policy))
+	fmt.Fprintf(w, "SOURCE IDENTITY:\t%s\n", orNone(result.SourceIdentity))
+	if result.DestinationWorkload != "" {
+		fmt.Fprintf(w, "DESTINATION WORKLOAD:\t%s/%s\n", result.DestinationNamespace, result.DestinationWorkload)
+	} else {
+		fmt.Fprintf(w, "DESTINATION WORKLOAD:\t-\n")
+	}
+	fmt.Fprintf(w, "MATCHED POLICIES:\t%s\n", orNone(strings.Join(result.MatchedPolicies, ", ")))
+	if result.DryRunPolicy != "" {
+		fmt.Fprintf(w, "DRY-RUN DENY:\t%s (%s)\n", result.DryRunPolicy, result.DryRunReason)
+	}
+	w.Flush()
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"kmesh.net/kmesh/ctl/utils"
+	"kmesh.net/kmesh/pkg/auth"
+	"kmesh.net/kmesh/pkg/kube"
+)
+
+const (
+	patternAuthzPolicies = "/debug/authz/policies"
+)
+
+type policyReportOptions struct {
+	policies bool
+	workload string
+	output   string
+}
+
+// showPolicyReport displays the policy report of each kmesh daemon pod, or the first one if no pod names are given.
+func showPolicyReport(cli kube.CLIClient, podNames []string, opts *policyReportOptions) {
+	if len(podNames) == 0 {
+		podList, err := cli.PodsForSelector(context.TODO(), utils.KmeshNamespace, utils.KmeshLabel)
+		if err != nil || len(podList.Items) == 0 {
+			log.Errorf("failed to get kmesh podList: %v", err)
+			os.Exit(1)
+		}
+		podNames = []string{podList.Items[0].GetName()}
+	}
+
+	for _, podName := range podNames {
+		body, err := fetchPolicyReport(cli, podName, opts.workload)
+		if err != nil {
+			log.Errorf("failed to get authz policies for pod %s: %v", podName, err)
+			continue
+		}
+		if len(podNames) > 1 {
+			fmt.Printf("POD: %s\n", podName)
+		}
+		switch {
+		case opts.output == "json":
+			fmt.Println(string(body))
+		case opts.workload != "":
+			printWorkloadPolicyReport(body)
+		default:
+			printPolicyReport(body)
+		}
+	}
+}
+
+// fetchPolicyReport sends a GET request to a specific kmesh daemon pod to retrieve the policy report,
+// of the workload if given.
+func fetchPolicyReport(cli kube.CLIClient, podName, workload string) ([]byte, error) {
+	fw, err := utils.CreateKmeshPortForwarder(cli, podName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	if err := fw.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	defer fw.Close()
+
+	reqUrl := fmt.Sprintf("http://%s%s", fw.Address(), patternAuthzPolicies)
+	if workload != "" {
+		reqUrl += "?" + url.Values{"workload": []string{workload}}.Encode()
+	}
+	resp, err := http.Get(reqUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func printPolicies(w io.Writer, policies []auth.PolicyInfo) {
+	fmt.Fprintln(w, "NAME\tSCOPE\tACTION\tRULES\tDRY-RUN")
+	for _, policy := range policies {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\n", policy.Name, policy.Scope, policy.Action, policy.Rules, policy.DryRun)
+	}
+}
+
+func printWarnings(w io.Writer, warnings []auth.PolicyWarning) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nWARNINGS:")
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "%s\t%s\n", warning.Policy, warning.Message)
+	}
+}
+
+func printPolicyReport(body []byte) {
+	report := auth.PolicyReport{}
+	if err := json.Unmarshal(body, &report); err != nil {
+		log.Errorf("failed to parse policy report: %v, falling back to raw output", err)
+		fmt.Println(string(body))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printPolicies(w, report.Policies)
+	printWarnings(w, report.Warnings)
+	w.Flush()
+}
+
+func printWorkloadPolicyReport(body []byte) {
+	report := auth.WorkloadPolicyReport{}
+	if err := json.Unmarshal(body, &report); err != nil {
+		log.Errorf("failed to parse policy report: %v, falling back to raw output", err)
+		fmt.Println(string(body))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "WORKLOAD: %s/%s\n", report.Namespace, report.Workload)
+	fmt.Fprintf(w, "DEFAULT ACTION: %s\n", report.DefaultAction)
+	// deny policies are evaluated before allow policies
+	fmt.Fprintln(w, "\nDENY POLICIES (evaluated first):")
+	printPolicies(w, report.Deny)
+	fmt.Fprintln(w, "\nALLOW POLICIES:")
+	printPolicies(w, report.Allow)
+	printWarnings(w, report.Warnings)
+	w.Flush()
+}
@@ -21,9 +21,11 @@ import (
 
 	"kmesh.net/kmesh/ctl/authz"
 	"kmesh.net/kmesh/ctl/dump"
+	"kmesh.net/kmesh/ctl/graph"
 	logcmd "kmesh.net/kmesh/ctl/log"
 	"kmesh.net/kmesh/ctl/monitoring"
 	"kmesh.net/kmesh/ctl/secret"
+	"kmesh.net/kmesh/ctl/trace"
 	"kmesh.net/kmesh/ctl/version"
 	"kmesh.net/kmesh/ctl/waypoint"
 )
@@ -45,6 +47,8 @@ func GetRootCommand() *cobra.Command {
 	rootCmd.AddCommand(monitoring.NewCmd())
 	rootCmd.AddCommand(authz.NewCmd())
 	rootCmd.AddCommand(secret.NewCmd())
+	rootCmd.AddCommand(graph.NewCmd())
+	rootCmd.AddCommand(trace.NewCmd())
 
 	return rootCmd
 }
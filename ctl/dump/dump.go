@@ -122,7 +122,8 @@ func RunDump(cmd *cobra.Command, args []string, outputFormat string) error {
 // Static and dynamic resources of the same type are consolidated under a single header.
 func printKernelNativeTable(body []byte) {
 	configDump := &adminv2.ConfigDump{}
-	if err := protojson.Unmarshal(body, configDump); err != nil {
+	// the dump may carry fields out of the config dump proto, such as the endpoint health
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, configDump); err != nil {
 		log.Errorf("failed to parse config dump: %v, falling back to raw output", err)
 		fmt.Println(string(body))
 		return
@@ -198,6 +199,32 @@ func printKernelNativeTable(body []byte) {
 		_ = w.Flush()
 		fmt.Println()
 	}
+
+	// Endpoint health
+	var health struct {
+		EndpointHealth []endpointHealthEntry `json:"endpointHealth"`
+	}
+	if err := json.Unmarshal(body, &health); err == nil && len(health.EndpointHealth) > 0 {
+		fmt.Fprintln(w, "CLUSTER\tENDPOINT\tHEALTHY\tLAST_CHECK\tLAST_ERROR")
+		for _, e := range health.EndpointHealth {
+			lastError := e.LastError
+			if lastError == "" {
+				lastError = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", e.Cluster, e.Address, e.Healthy, e.LastCheck, lastError)
+		}
+		_ = w.Flush()
+		fmt.Println()
+	}
+}
+
+// endpointHealthEntry mirrors the active health check status in the kernel-native config dump.
+type endpointHealthEntry struct {
+	Cluster   string `json:"cluster"`
+	Address   string `json:"address"`
+	Healthy   bool   `json:"healthy"`
+	LastCheck string `json:"lastCheck"`
+	LastError string `json:"lastError"`
 }
 
 // workloadDump mirrors the JSON structure returned by the dual-engine config dump endpoint.
@@ -0,0 +1,161 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"kmesh.net/kmesh/ctl/utils"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	"kmesh.net/kmesh/pkg/kube"
+	"kmesh.net/kmesh/pkg/logger"
+)
+
+const (
+	patternServiceGraph = "/debug/service_graph"
+)
+
+var log = logger.NewLoggerScope("kmeshctl/graph")
+
+func NewCmd() *cobra.Command {
+	var outputFormat string
+
+	cmd := &cobra.Command{
+		Use:   "graph [podNames...]",
+		Short: "Display the service dependency graph built from the dual-engine telemetry",
+		Example: `# Display the cluster-wide service graph merged from all kmesh daemons:
+kmeshctl graph
+
+# Display the service graph of the given kmesh daemons:
+kmeshctl graph <kmesh-daemon-pod1> <kmesh-daemon-pod2>
+
+# Render the cluster-wide service graph with Graphviz:
+kmeshctl graph -o dot | dot -Tsvg > graph.svg`,
+		Args: cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if outputFormat != "table" && outputFormat != "json" && outputFormat != "dot" {
+				log.Errorf("invalid output format %s, must be table, json or dot", outputFormat)
+				os.Exit(1)
+			}
+			cli, err := utils.CreateKubeClient()
+			if err != nil {
+				log.Errorf("failed to create cli client: %v", err)
+				os.Exit(1)
+			}
+			graph := collectServiceGraph(cli, args)
+			if err := printServiceGraph(os.Stdout, graph, outputFormat); err != nil {
+				log.Errorf("failed to print service graph: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format: table, json or dot")
+	return cmd
+}
+
+// collectServiceGraph merges the service graphs of the given kmesh daemon pods, or all of them if no pod names are given.
+func collectServiceGraph(cli kube.CLIClient, podNames []string) *telemetry.ServiceGraph {
+	if len(podNames) == 0 {
+		podList, err := cli.PodsForSelector(context.TODO(), utils.KmeshNamespace, utils.KmeshLabel)
+		if err != nil || len(podList.Items) == 0 {
+			log.Errorf("failed to get kmesh podList: %v", err)
+			os.Exit(1)
+		}
+		for _, pod := range podList.Items {
+			podNames = append(podNames, pod.GetName())
+		}
+	}
+
+	graph := &telemetry.ServiceGraph{Edges: []telemetry.GraphEdge{}}
+	for _, podName := range podNames {
+		podGraph, err := fetchServiceGraph(cli, podName)
+		if err != nil {
+			log.Errorf("failed to get service graph for pod %s: %v", podName, err)
+			continue
+		}
+		if len(podGraph.Nodes) == 0 {
+			podGraph.Nodes = []string{podName}
+		}
+		graph.Merge(podGraph)
+	}
+	graph.Deduplicate()
+	return graph
+}
+
+// fetchServiceGraph sends a GET request to a specific kmesh daemon pod to retrieve its service graph.
+func fetchServiceGraph(cli kube.CLIClient, podName string) (*telemetry.ServiceGraph, error) {
+	fw, err := utils.CreateKmeshPortForwarder(cli, podName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	if err := fw.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start port forwarder for Kmesh daemon pod %s: %v", podName, err)
+	}
+	defer fw.Close()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", fw.Address(), patternServiceGraph))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	graph := &telemetry.ServiceGraph{}
+	if err := json.Unmarshal(body, graph); err != nil {
+		return nil, fmt.Errorf("failed to parse service graph: %v", err)
+	}
+	return graph, nil
+}
+
+func printServiceGraph(out io.Writer, graph *telemetry.ServiceGraph, outputFormat string) error {
+	switch outputFormat {
+	case "json":
+		data, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	case "dot":
+		fmt.Fprint(out, graph.DOT())
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tDESTINATION\tCONNECTIONS\tFAILURES\tSENT\tRECEIVED\tMEAN RTT")
+		for _, edge := range graph.Edges {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%.3fms\n", edge.Source, edge.Destination, edge.Connections,
+				edge.Failures, edge.SentBytes, edge.ReceivedBytes, edge.MeanRttMs)
+		}
+		return w.Flush()
+	}
+	return nil
+}
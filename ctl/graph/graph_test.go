@@ -0,0 +1,52 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+func TestPrintServiceGraph(t *testing.T) {
+	graph := &telemetry.ServiceGraph{
+		Window: "5m0s",
+		Edges: []telemetry.GraphEdge{{
+			Source:        telemetry.GraphNode{Namespace: "default", Name: "sleep"},
+			Destination:   telemetry.GraphNode{Namespace: "default", Name: "httpbin"},
+			Connections:   3,
+			Failures:      1,
+			SentBytes:     100,
+			ReceivedBytes: 200,
+			MeanRttMs:     1.5,
+			RttSamples:    3,
+		}},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, printServiceGraph(&out, graph, "table"))
+	assert.Equal(t, `SOURCE         DESTINATION      CONNECTIONS  FAILURES  SENT  RECEIVED  MEAN RTT
+sleep.default  httpbin.default  3            1         100   200       1.500ms
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, printServiceGraph(&out, graph, "dot"))
+	assert.Equal(t, graph.DOT(), out.String())
+}
@@ -22,7 +22,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -69,6 +71,12 @@ kmeshctl monitoring <kmesh-daemon-pod> --connectionMetrics enable/disable
 # Enable/Disable Kmesh's accesslog in each node:
 kmeshctl monitoring --accesslog enable/disable
 
+# Only output the accesslog of 10% of the connections from or to the default and foo namespaces in each node:
+kmeshctl monitoring --accesslogNamespaces default,foo --accesslogSampling 0.1
+
+# Only output the accesslog of the connections to the httpbin service, clear it with an empty list:
+kmeshctl monitoring <kmesh-daemon-pod> --accesslogServices httpbin.default.svc.cluster.local
+
 # Enable/Disable workload granularity metrics in each node:
 kmeshctl monitoring --workloadMetrics enable/disable
 
@@ -86,6 +94,9 @@ kmeshctl monitoring --all enable/disable`,
 	cmd.Flags().String("all", "", "Control accesslog and services' and workloads' metrics enable or disable together")
 	cmd.Flags().String("workloadMetrics", "", "Control workload granularity metrics enable or disable")
 	cmd.Flags().String("connectionMetrics", "", "Control connection granularity metrics enable or disable")
+	cmd.Flags().StringSlice("accesslogNamespaces", nil, "Only output the accesslog of the connections from or to these namespaces, empty for all")
+	cmd.Flags().StringSlice("accesslogServices", nil, "Only output the accesslog of the connections to these services, by hostname or name, empty for all")
+	cmd.Flags().Float64("accesslogSampling", 1, "Ratio of the connections whose accesslog is output, in [0, 1]")
 	return cmd
 }
 
@@ -99,8 +110,9 @@ func ControlMonitoring(cmd *cobra.Command, args []string) {
 	allFlag, _ := cmd.Flags().GetString("all")
 	workloadMetricsFlag, _ := cmd.Flags().GetString("workloadMetrics")
 	connectionMetricsFlag, _ := cmd.Flags().GetString("connectionMetrics")
-	if accesslogFlag == "" && allFlag == "" && workloadMetricsFlag == "" && connectionMetricsFlag == "" {
-		log.Print("no parameters. Need --accesslog, --workloadMetrics, --connectionMetrics or --all")
+	accesslogFilter := accesslogFilterQuery(cmd)
+	if accesslogFlag == "" && allFlag == "" && workloadMetricsFlag == "" && connectionMetricsFlag == "" && accesslogFilter == nil {
+		log.Print("no parameters. Need --accesslog, --accesslogNamespaces, --accesslogServices, --accesslogSampling, --workloadMetrics, --connectionMetrics or --all")
 		return
 	}
 
@@ -113,6 +125,9 @@ func ControlMonitoring(cmd *cobra.Command, args []string) {
 		if accesslogFlag != "" {
 			SetObservabilityPerKmeshDaemon(client, podName, accesslogFlag, ACCESSLOG, patternAccesslog)
 		}
+		if accesslogFilter != nil {
+			SetAccesslogFilterPerKmeshDaemon(client, podName, accesslogFilter)
+		}
 		if workloadMetricsFlag != "" {
 			SetObservabilityPerKmeshDaemon(client, podName, workloadMetricsFlag, WORKLOAD, patternWorkloadMetrics)
 		}
@@ -133,6 +148,9 @@ func ControlMonitoring(cmd *cobra.Command, args []string) {
 			if accesslogFlag != "" {
 				SetObservabilityPerKmeshDaemon(client, pod.GetName(), accesslogFlag, ACCESSLOG, patternAccesslog)
 			}
+			if accesslogFilter != nil {
+				SetAccesslogFilterPerKmeshDaemon(client, pod.GetName(), accesslogFilter)
+			}
 			if workloadMetricsFlag != "" {
 				SetObservabilityPerKmeshDaemon(client, pod.GetName(), workloadMetricsFlag, WORKLOAD, patternWorkloadMetrics)
 			}
@@ -153,6 +171,53 @@ func getKmeshDaemonPod(args []string) (string, bool) {
 	return args[0], true
 }
 
+// accesslogFilterQuery returns the query updating the accesslog filter, nil if no filter flag is set.
+func accesslogFilterQuery(cmd *cobra.Command) url.Values {
+	query := url.Values{}
+	if cmd.Flags().Changed("accesslogNamespaces") {
+		namespaces, _ := cmd.Flags().GetStringSlice("accesslogNamespaces")
+		query.Set("namespaces", strings.Join(namespaces, ","))
+	}
+	if cmd.Flags().Changed("accesslogServices") {
+		services, _ := cmd.Flags().GetStringSlice("accesslogServices")
+		query.Set("services", strings.Join(services, ","))
+	}
+	if cmd.Flags().Changed("accesslogSampling") {
+		sampling, _ := cmd.Flags().GetFloat64("accesslogSampling")
+		query.Set("sampling", strconv.FormatFloat(sampling, 'f', -1, 64))
+	}
+	if len(query) == 0 {
+		return nil
+	}
+	return query
+}
+
+func SetAccesslogFilterPerKmeshDaemon(cli kube.CLIClient, podName string, query url.Values) {
+	fw, err := utils.CreateKmeshPortForwarder(cli, podName)
+	if err != nil {
+		log.Errorf("failed to create port forwarder for Kmesh daemon pod %s: %v", podName, err)
+		os.Exit(1)
+	}
+	if err := fw.Start(); err != nil {
+		log.Errorf("failed to start port forwarder for Kmesh daemon pod %s: %v", podName, err)
+		os.Exit(1)
+	}
+	defer fw.Close()
+
+	requestURL := fmt.Sprintf("http://%s%s?%s", fw.Address(), patternAccesslog, query.Encode())
+	resp, err := http.Post(requestURL, "application/json", nil)
+	if err != nil {
+		log.Errorf("failed to make HTTP request: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Errorf("failed to set accesslog filter of %s: received status code %d, %s", podName, resp.StatusCode, string(bodyBytes))
+	}
+}
+
 func SetObservabilityPerKmeshDaemon(cli kube.CLIClient, podName, info string, observablityType string, pattern string) {
 	var status string
 	if info == "enable" {
@@ -47,9 +47,25 @@ func TestNewCmd(t *testing.T) {
 	if cmd.Use != "monitoring" {
 		t.Fatalf("Use = %q, want %q", cmd.Use, "monitoring")
 	}
-	for _, name := range []string{"accesslog", "all", "workloadMetrics", "connectionMetrics"} {
+	for _, name := range []string{"accesslog", "all", "workloadMetrics", "connectionMetrics", "accesslogNamespaces", "accesslogServices", "accesslogSampling"} {
 		if cmd.Flags().Lookup(name) == nil {
 			t.Errorf("--%s flag not defined", name)
 		}
 	}
 }
+
+func TestAccesslogFilterQuery(t *testing.T) {
+	cmd := NewCmd()
+	if query := accesslogFilterQuery(cmd); query != nil {
+		t.Fatalf("accesslogFilterQuery() = %v, want nil without filter flags", query)
+	}
+
+	if err := cmd.ParseFlags([]string{"--accesslogNamespaces", "default,foo", "--accesslogServices", "", "--accesslogSampling", "0.25"}); err != nil {
+		t.Fatalf("ParseFlags() failed: %v", err)
+	}
+	query := accesslogFilterQuery(cmd)
+	want := "namespaces=default%2Cfoo&sampling=0.25&services="
+	if got := query.Encode(); got != want {
+		t.Errorf("accesslogFilterQuery() = %q, want %q", got, want)
+	}
+}
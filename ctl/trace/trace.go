@@ -0,0 +1,229 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kmesh.net/kmesh/ctl/utils"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	"kmesh.net/kmesh/pkg/kube"
+	"kmesh.net/kmesh/pkg/logger"
+)
+
+const (
+	patternTrace = "/debug/trace"
+)
+
+var log = logger.NewLoggerScope("kmeshctl/trace")
+
+type traceOptions struct {
+	namespace string
+	ip        string
+	port      uint16
+	output    string
+}
+
+func NewCmd() *cobra.Command {
+	opts := traceOptions{}
+	cmd := &cobra.Command{
+		Use:   "trace <pod>",
+		Short: "Stream the connection open, close and deny events of a pod live until interrupted",
+		Example: `# Trace the connections of a pod:
+kmeshctl trace sleep-7d5f9b9c4-x2x5z -n default
+
+# Only trace the connections to port 80 of an ip:
+kmeshctl trace sleep-7d5f9b9c4-x2x5z --ip 10.244.1.12 --port 80
+
+# Output the events as newline delimited json:
+kmeshctl trace sleep-7d5f9b9c4-x2x5z -o json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.output != "text" && opts.output != "json" {
+				log.Errorf("invalid output format %s, must be text or json", opts.output)
+				os.Exit(1)
+			}
+			cli, err := utils.CreateKubeClient()
+			if err != nil {
+				log.Errorf("failed to create cli client: %v", err)
+				os.Exit(1)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			if err := runTrace(ctx, cli, args[0], &opts); err != nil {
+				log.Errorf("failed to trace pod %s/%s: %v", opts.namespace, args[0], err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Namespace of the pod")
+	cmd.Flags().StringVar(&opts.ip, "ip", "", "Only trace the connections from or to the ip")
+	cmd.Flags().Uint16Var(&opts.port, "port", 0, "Only trace the connections from or to the port")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "text", "Output format: text or json")
+	return cmd
+}
+
+// runTrace streams the events of the pod from the kmesh daemon running on the same node, until ctx is done.
+func runTrace(ctx context.Context, cli kube.CLIClient, podName string, opts *traceOptions) error {
+	daemonName, err := kmeshDaemonForPod(ctx, cli, podName, opts.namespace)
+	if err != nil {
+		return err
+	}
+
+	fw, err := utils.CreateKmeshPortForwarder(cli, daemonName)
+	if err != nil {
+		return fmt.Errorf("failed to create port forwarder for Kmesh daemon pod %s: %v", daemonName, err)
+	}
+	if err := fw.Start(); err != nil {
+		return fmt.Errorf("failed to start port forwarder for Kmesh daemon pod %s: %v", daemonName, err)
+	}
+	defer fw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s?%s", fw.Address(), patternTrace, traceQuery(podName, opts).Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("received status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	log.Infof("tracing the connections of pod %s/%s through Kmesh daemon pod %s, press Ctrl+C to stop", opts.namespace, podName, daemonName)
+	err = printEvents(os.Stdout, resp.Body, opts.output)
+	if ctx.Err() != nil {
+		// interrupted by the user
+		return nil
+	}
+	return err
+}
+
+// kmeshDaemonForPod returns the name of the kmesh daemon pod running on the same node as the pod.
+func kmeshDaemonForPod(ctx context.Context, cli kube.CLIClient, podName, namespace string) (string, error) {
+	pod, err := cli.Kube().CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod: %v", err)
+	}
+	if pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("pod is not scheduled to any node")
+	}
+
+	daemons, err := cli.Kube().CoreV1().Pods(utils.KmeshNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: utils.KmeshLabel,
+		FieldSelector: "spec.nodeName=" + pod.Spec.NodeName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get kmesh daemon pod on node %s: %v", pod.Spec.NodeName, err)
+	}
+	if len(daemons.Items) == 0 {
+		return "", fmt.Errorf("no kmesh daemon pod is running on node %s", pod.Spec.NodeName)
+	}
+	return daemons.Items[0].GetName(), nil
+}
+
+func traceQuery(podName string, opts *traceOptions) url.Values {
+	query := url.Values{}
+	query.Set("pod", podName)
+	query.Set("namespace", opts.namespace)
+	if opts.ip != "" {
+		query.Set("ip", opts.ip)
+	}
+	if opts.port != 0 {
+		query.Set("port", strconv.Itoa(int(opts.port)))
+	}
+	return query
+}
+
+// printEvents prints the newline delimited json events read from the stream until it is closed.
+func printEvents(out io.Writer, stream io.Reader, outputFormat string) error {
+	decoder := json.NewDecoder(stream)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if outputFormat == "json" {
+			fmt.Fprintln(out, string(raw))
+			continue
+		}
+
+		event := telemetry.ConnectionEvent{}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, formatEvent(&event))
+	}
+}
+
+func formatEvent(event *telemetry.ConnectionEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %-8s %s -> %s", event.Time.Format(time.RFC3339Nano), strings.ToUpper(event.Type), event.Direction,
+		formatEnd(event.SourceIp, event.SourcePort, event.SourcePod, event.SourceNamespace),
+		formatEnd(event.DestinationIp, event.DestinationPort, event.DestinationPod, event.DestinationNamespace))
+	if event.DestinationService != "" {
+		fmt.Fprintf(&b, " service=%s", event.DestinationService)
+	}
+	switch event.Type {
+	case telemetry.ConnectionEventClose:
+		fmt.Fprintf(&b, " success=%v sent=%dB received=%dB duration=%dms", event.Success, event.SentBytes, event.ReceivedBytes, event.DurationMs)
+	case telemetry.ConnectionEventDeny:
+		fmt.Fprintf(&b, " reason=%s", event.Reason)
+		if event.Policy != "" {
+			fmt.Fprintf(&b, " policy=%s", event.Policy)
+		}
+		if event.DryRun {
+			b.WriteString(" dry-run")
+		}
+	}
+	return b.String()
+}
+
+func formatEnd(ip string, port uint32, pod, namespace string) string {
+	address := ip
+	if port != 0 {
+		address = net.JoinHostPort(ip, strconv.Itoa(int(port)))
+	}
+	if pod == "" {
+		return address
+	}
+	return fmt.Sprintf("%s(%s/%s)", address, namespace, pod)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const events = `{"time":"2026-01-01T00:00:00Z","type":"open","direction":"OUTBOUND","src_ip":"10.244.0.10","src_port":47667,"src_pod":"sleep","src_namespace":"default","dst_ip":"10.244.0.7","dst_port":8080,"dst_service":"httpbin.default.svc.cluster.local"}
+{"time":"2026-01-01T00:00:01Z","type":"close","direction":"OUTBOUND","src_ip":"10.244.0.10","src_port":47667,"src_pod":"sleep","src_namespace":"default","dst_ip":"10.244.0.7","dst_port":8080,"success":true,"sent_bytes":100,"received_bytes":200,"duration_ms":1000}
+{"time":"2026-01-01T00:00:02Z","type":"deny","direction":"INBOUND","src_ip":"fd00::2","dst_ip":"fd00::1","dst_port":80,"dst_pod":"httpbin","dst_namespace":"default","policy":"default/deny-all","reason":"deny_matched","dry_run":true}
+`
+
+func TestPrintEvents(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, printEvents(&out, strings.NewReader(events), "text"))
+	assert.Equal(t, `2026-01-01T00:00:00Z OPEN  OUTBOUND 10.244.0.10:47667(default/sleep) -> 10.244.0.7:8080 service=httpbin.default.svc.cluster.local
+2026-01-01T00:00:01Z CLOSE OUTBOUND 10.244.0.10:47667(default/sleep) -> 10.244.0.7:8080 success=true sent=100B received=200B duration=1000ms
+2026-01-01T00:00:02Z DENY  INBOUND  fd00::2 -> [fd00::1]:80(default/httpbin) reason=deny_matched policy=default/deny-all dry-run
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, printEvents(&out, strings.NewReader(events), "json"))
+	assert.Equal(t, events, out.String())
+
+	assert.Error(t, printEvents(&out, strings.NewReader("{"), "text"))
+}
+
+func TestTraceQuery(t *testing.T) {
+	assert.Equal(t, "namespace=default&pod=sleep", traceQuery("sleep", &traceOptions{namespace: "default"}).Encode())
+	assert.Equal(t, "ip=10.0.0.1&namespace=foo&pod=sleep&port=80", traceQuery("sleep", &traceOptions{namespace: "foo", ip: "10.0.0.1", port: 80}).Encode())
+}
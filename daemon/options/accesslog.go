@@ -0,0 +1,78 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	AccesslogFormatText = "text"
+	AccesslogFormatJSON = "json"
+
+	AccesslogSinkStdout = "stdout"
+	AccesslogSinkFile   = "file"
+	AccesslogSinkSyslog = "syslog"
+)
+
+// AccesslogConfig configures the format of the access logs and where they are written to.
+type AccesslogConfig struct {
+	Format string
+	Sink   string
+	// FilePath is the file the access logs are written to by the file sink
+	FilePath string
+	// FileMaxSizeMB is the size the file is rotated at
+	FileMaxSizeMB int
+	// FileMaxBackups is the number of rotated files kept
+	FileMaxBackups int
+	// SyslogAddress is the syslog socket the syslog sink writes to, as network://address,
+	// the local syslog daemon is used when it is empty
+	SyslogAddress string
+}
+
+func (c *AccesslogConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&c.Format, "accesslog-format", AccesslogFormatText, "format of the access logs, text or json")
+	cmd.PersistentFlags().StringVar(&c.Sink, "accesslog-sink", AccesslogSinkStdout, "where the access logs are written to, stdout, file or syslog")
+	cmd.PersistentFlags().StringVar(&c.FilePath, "accesslog-file", "/var/log/kmesh/accesslog.log", "file the access logs are written to by the file sink")
+	cmd.PersistentFlags().IntVar(&c.FileMaxSizeMB, "accesslog-file-max-size", 100, "size in megabytes the access log file is rotated at")
+	cmd.PersistentFlags().IntVar(&c.FileMaxBackups, "accesslog-file-max-backups", 3, "number of rotated access log files kept")
+	cmd.PersistentFlags().StringVar(&c.SyslogAddress, "accesslog-syslog-address", "", "syslog socket the syslog sink writes to, e.g. unixgram:///dev/log or udp://127.0.0.1:514, empty uses the local syslog daemon")
+}
+
+func (c *AccesslogConfig) ParseConfig() error {
+	if c.Format != AccesslogFormatText && c.Format != AccesslogFormatJSON {
+		return fmt.Errorf("invalid accesslog-format %q, must be %s or %s", c.Format, AccesslogFormatText, AccesslogFormatJSON)
+	}
+	switch c.Sink {
+	case AccesslogSinkStdout, AccesslogSinkSyslog:
+	case AccesslogSinkFile:
+		if c.FilePath == "" {
+			return fmt.Errorf("accesslog-file is required by the file sink")
+		}
+		if c.FileMaxSizeMB <= 0 {
+			return fmt.Errorf("accesslog-file-max-size must be positive")
+		}
+		if c.FileMaxBackups < 0 {
+			return fmt.Errorf("accesslog-file-max-backups must not be negative")
+		}
+	default:
+		return fmt.Errorf("invalid accesslog-sink %q, must be %s, %s or %s", c.Sink, AccesslogSinkStdout, AccesslogSinkFile, AccesslogSinkSyslog)
+	}
+	return nil
+}
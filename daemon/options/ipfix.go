@@ -0,0 +1,75 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// IpfixDocumentationEnterpriseNumber is the private enterprise number reserved for documentation use by RFC 5612,
+// operators should set their own one so the enterprise-specific fields can not collide with other exporters.
+const IpfixDocumentationEnterpriseNumber = 32473
+
+// IpfixConfig configures the export of the connection flows to an IPFIX collector over UDP,
+// the export is disabled when CollectorAddress is empty.
+type IpfixConfig struct {
+	// CollectorAddress is the host:port of the IPFIX collector
+	CollectorAddress string
+	// ObservationDomainId identifies the exporting node to the collector
+	ObservationDomainId uint32
+	// EnterpriseNumber is the private enterprise number of the workload and namespace fields
+	EnterpriseNumber uint32
+	// TemplateRefreshInterval is the interval the templates are resent at, as UDP is unreliable
+	TemplateRefreshInterval time.Duration
+	// FlushInterval bounds how long a flow record is buffered before it is sent
+	FlushInterval time.Duration
+}
+
+func (c *IpfixConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&c.CollectorAddress, "ipfix-collector", "", "host:port of the IPFIX collector to export the connection flows to over UDP, empty disables the export")
+	cmd.PersistentFlags().Uint32Var(&c.ObservationDomainId, "ipfix-observation-domain-id", 0, "observation domain id of the exported IPFIX messages")
+	cmd.PersistentFlags().Uint32Var(&c.EnterpriseNumber, "ipfix-enterprise-number", IpfixDocumentationEnterpriseNumber, "private enterprise number of the workload and namespace IPFIX fields")
+	cmd.PersistentFlags().DurationVar(&c.TemplateRefreshInterval, "ipfix-template-refresh-interval", 10*time.Minute, "interval the IPFIX templates are resent to the collector at")
+	cmd.PersistentFlags().DurationVar(&c.FlushInterval, "ipfix-flush-interval", time.Second, "max time a flow record is buffered before it is sent to the IPFIX collector")
+}
+
+func (c *IpfixConfig) Enabled() bool {
+	return c != nil && c.CollectorAddress != ""
+}
+
+func (c *IpfixConfig) ParseConfig() error {
+	if c.CollectorAddress == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.CollectorAddress); err != nil {
+		return fmt.Errorf("invalid ipfix-collector %q: %v", c.CollectorAddress, err)
+	}
+	if c.EnterpriseNumber == 0 {
+		return fmt.Errorf("ipfix-enterprise-number must not be 0")
+	}
+	if c.TemplateRefreshInterval <= 0 {
+		return fmt.Errorf("ipfix-template-refresh-interval must be positive")
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("ipfix-flush-interval must be positive")
+	}
+	return nil
+}
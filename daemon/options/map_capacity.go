@@ -0,0 +1,56 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// MapCapacityConfig configures the alerts raised when the utilization of a kmesh bpf map,
+// the ratio of its entries to its max entries, crosses a threshold. A threshold of 0 disables the alert,
+// and the map capacity is only monitored once a threshold is set.
+type MapCapacityConfig struct {
+	// WarningThreshold is the utilization a warning log and Kubernetes event are raised at
+	WarningThreshold float64
+	// CriticalThreshold is the utilization an error log and Kubernetes event are raised at
+	CriticalThreshold float64
+}
+
+func (c *MapCapacityConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Float64Var(&c.WarningThreshold, "map-utilization-warning-threshold", 0, "bpf map utilization (0, 1] a warning is raised at, e.g. 0.8, 0 disables the warning")
+	cmd.PersistentFlags().Float64Var(&c.CriticalThreshold, "map-utilization-critical-threshold", 0, "bpf map utilization (0, 1] a critical alert is raised at, e.g. 0.95, 0 disables the alert")
+}
+
+func (c *MapCapacityConfig) Enabled() bool {
+	return c != nil && (c.WarningThreshold > 0 || c.CriticalThreshold > 0)
+}
+
+func (c *MapCapacityConfig) ParseConfig() error {
+	if c.WarningThreshold < 0 || c.WarningThreshold > 1 {
+		return fmt.Errorf("map-utilization-warning-threshold must be in [0, 1], got %v", c.WarningThreshold)
+	}
+	if c.CriticalThreshold < 0 || c.CriticalThreshold > 1 {
+		return fmt.Errorf("map-utilization-critical-threshold must be in [0, 1], got %v", c.CriticalThreshold)
+	}
+	if c.WarningThreshold > 0 && c.CriticalThreshold > 0 && c.WarningThreshold > c.CriticalThreshold {
+		return fmt.Errorf("map-utilization-warning-threshold %v must not be greater than map-utilization-critical-threshold %v",
+			c.WarningThreshold, c.CriticalThreshold)
+	}
+	return nil
+}
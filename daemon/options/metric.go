@@ -0,0 +1,85 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// MetricConfig configures the latency histograms and the cardinality of dual-engine telemetry.
+type MetricConfig struct {
+	// DurationBuckets are the buckets of the connection duration histograms in seconds, empty uses the default ones
+	DurationBuckets []float64
+	// SrttBuckets are the buckets of the smoothed RTT histograms in seconds, empty uses the default ones
+	SrttBuckets []float64
+
+	// WorkloadLabels, ServiceLabels and ConnectionLabels are the labels kept by the workload, service and
+	// connection metrics, the series only differing in the other labels are aggregated. Empty keeps all the labels.
+	WorkloadLabels   []string
+	ServiceLabels    []string
+	ConnectionLabels []string
+	// WorkloadMaxSeries, ServiceMaxSeries and ConnectionMaxSeries cap the number of live series of each family,
+	// new series beyond the cap are aggregated into an overflow series. 0 is unlimited.
+	WorkloadMaxSeries   int
+	ServiceMaxSeries    int
+	ConnectionMaxSeries int
+	// SeriesExpiryInterval is how often the series of the workloads no longer existing are removed, 0 disables it
+	SeriesExpiryInterval time.Duration
+}
+
+func (c *MetricConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Float64SliceVar(&c.DurationBuckets, "metric-duration-buckets", nil, "buckets of the connection duration histograms in seconds, e.g. 0.1,1,10,60")
+	cmd.PersistentFlags().Float64SliceVar(&c.SrttBuckets, "metric-srtt-buckets", nil, "buckets of the smoothed RTT histograms in seconds, e.g. 0.001,0.01,0.1")
+	cmd.PersistentFlags().StringSliceVar(&c.WorkloadLabels, "metric-workload-labels", nil, "labels kept by the workload metrics, the others are aggregated, empty keeps all the labels")
+	cmd.PersistentFlags().StringSliceVar(&c.ServiceLabels, "metric-service-labels", nil, "labels kept by the service metrics, the others are aggregated, empty keeps all the labels")
+	cmd.PersistentFlags().StringSliceVar(&c.ConnectionLabels, "metric-connection-labels", nil, "labels kept by the connection metrics, the others are aggregated, empty keeps all the labels")
+	cmd.PersistentFlags().IntVar(&c.WorkloadMaxSeries, "metric-workload-max-series", 0, "max number of live series of the workload metrics, 0 is unlimited")
+	cmd.PersistentFlags().IntVar(&c.ServiceMaxSeries, "metric-service-max-series", 0, "max number of live series of the service metrics, 0 is unlimited")
+	cmd.PersistentFlags().IntVar(&c.ConnectionMaxSeries, "metric-connection-max-series", 0, "max number of live series of the connection metrics, 0 is unlimited")
+	cmd.PersistentFlags().DurationVar(&c.SeriesExpiryInterval, "metric-series-expiry-interval", time.Minute, "interval of removing the series of the workloads no longer existing, 0 disables it")
+}
+
+func (c *MetricConfig) ParseConfig() error {
+	if err := validateBuckets(c.DurationBuckets); err != nil {
+		return fmt.Errorf("invalid metric-duration-buckets: %v", err)
+	}
+	if err := validateBuckets(c.SrttBuckets); err != nil {
+		return fmt.Errorf("invalid metric-srtt-buckets: %v", err)
+	}
+	if c.WorkloadMaxSeries < 0 || c.ServiceMaxSeries < 0 || c.ConnectionMaxSeries < 0 {
+		return fmt.Errorf("invalid metric max series: must not be negative")
+	}
+	if c.SeriesExpiryInterval < 0 {
+		return fmt.Errorf("invalid metric-series-expiry-interval %v: must not be negative", c.SeriesExpiryInterval)
+	}
+	return nil
+}
+
+func validateBuckets(buckets []float64) error {
+	for i, bucket := range buckets {
+		if bucket <= 0 {
+			return fmt.Errorf("bucket %v is not positive", bucket)
+		}
+		if i > 0 && bucket <= buckets[i-1] {
+			return fmt.Errorf("buckets are not in increasing order")
+		}
+	}
+	return nil
+}
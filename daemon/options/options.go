@@ -29,6 +29,13 @@ type BootstrapConfigs struct {
 	CniConfig           *cniConfig
 	ByPassConfig        *byPassConfig
 	SecretManagerConfig *secretConfig
+	OutlierDetection    *OutlierDetectionConfig
+	StaticConfig        *StaticConfig
+	Otlp                *OtlpConfig
+	Accesslog           *AccesslogConfig
+	Metric              *MetricConfig
+	Ipfix               *IpfixConfig
+	MapCapacity         *MapCapacityConfig
 }
 
 func NewBootstrapConfigs() *BootstrapConfigs {
@@ -37,6 +44,13 @@ func NewBootstrapConfigs() *BootstrapConfigs {
 		CniConfig:           &cniConfig{},
 		ByPassConfig:        &byPassConfig{},
 		SecretManagerConfig: &secretConfig{},
+		OutlierDetection:    &OutlierDetectionConfig{},
+		StaticConfig:        &StaticConfig{},
+		Otlp:                &OtlpConfig{},
+		Accesslog:           &AccesslogConfig{},
+		Metric:              &MetricConfig{},
+		Ipfix:               &IpfixConfig{},
+		MapCapacity:         &MapCapacityConfig{},
 	}
 }
 
@@ -54,6 +68,13 @@ func (c *BootstrapConfigs) AttachFlags(cmd *cobra.Command) {
 	c.CniConfig.AttachFlags(cmd)
 	c.ByPassConfig.AttachFlags(cmd)
 	c.SecretManagerConfig.AttachFlags(cmd)
+	c.OutlierDetection.AttachFlags(cmd)
+	c.StaticConfig.AttachFlags(cmd)
+	c.Otlp.AttachFlags(cmd)
+	c.Accesslog.AttachFlags(cmd)
+	c.Metric.AttachFlags(cmd)
+	c.Ipfix.AttachFlags(cmd)
+	c.MapCapacity.AttachFlags(cmd)
 }
 
 func (c *BootstrapConfigs) ParseConfigs() error {
@@ -63,5 +84,26 @@ func (c *BootstrapConfigs) ParseConfigs() error {
 	if err := c.CniConfig.ParseConfig(); err != nil {
 		return fmt.Errorf("parse CniConfig failed, %v", err)
 	}
+	if err := c.OutlierDetection.ParseConfig(); err != nil {
+		return fmt.Errorf("parse OutlierDetectionConfig failed, %v", err)
+	}
+	if err := c.StaticConfig.ParseConfig(); err != nil {
+		return fmt.Errorf("parse StaticConfig failed, %v", err)
+	}
+	if err := c.Otlp.ParseConfig(); err != nil {
+		return fmt.Errorf("parse OtlpConfig failed, %v", err)
+	}
+	if err := c.Accesslog.ParseConfig(); err != nil {
+		return fmt.Errorf("parse AccesslogConfig failed, %v", err)
+	}
+	if err := c.Metric.ParseConfig(); err != nil {
+		return fmt.Errorf("parse MetricConfig failed, %v", err)
+	}
+	if err := c.Ipfix.ParseConfig(); err != nil {
+		return fmt.Errorf("parse IpfixConfig failed, %v", err)
+	}
+	if err := c.MapCapacity.ParseConfig(); err != nil {
+		return fmt.Errorf("parse MapCapacityConfig failed, %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,76 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	OtlpTemporalityCumulative = "cumulative"
+	OtlpTemporalityDelta      = "delta"
+)
+
+// OtlpConfig configures the export of the workload, service and connection metrics and of the
+// access logs to an OpenTelemetry collector over OTLP/gRPC, the export is disabled when Endpoint is empty.
+type OtlpConfig struct {
+	// Endpoint is the host:port of the OTLP/gRPC receiver
+	Endpoint string
+	Insecure bool
+	// ExportInterval is the interval the metrics are pushed at
+	ExportInterval time.Duration
+	// Timeout bounds each export request
+	Timeout time.Duration
+	// Temporality is the aggregation temporality of the exported counters, cumulative or delta.
+	// The connection metrics are only exported with delta, as cumulative keeps every series exported in memory.
+	Temporality     string
+	ExportMetrics   bool
+	ExportAccesslog bool
+}
+
+func (c *OtlpConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&c.Endpoint, "otlp-endpoint", "", "host:port of the OTLP/gRPC collector to export metrics and access logs to, empty disables the export")
+	cmd.PersistentFlags().BoolVar(&c.Insecure, "otlp-insecure", false, "connect to the OTLP collector without TLS")
+	cmd.PersistentFlags().DurationVar(&c.ExportInterval, "otlp-export-interval", 30*time.Second, "interval the metrics are exported to the OTLP collector at")
+	cmd.PersistentFlags().DurationVar(&c.Timeout, "otlp-timeout", 10*time.Second, "timeout of each export request to the OTLP collector")
+	cmd.PersistentFlags().StringVar(&c.Temporality, "otlp-temporality", OtlpTemporalityDelta, "aggregation temporality of the exported metrics, cumulative or delta, the connection metrics are only exported with delta")
+	cmd.PersistentFlags().BoolVar(&c.ExportMetrics, "otlp-export-metrics", true, "export the workload, service and connection metrics to the OTLP collector")
+	cmd.PersistentFlags().BoolVar(&c.ExportAccesslog, "otlp-export-accesslog", true, "export the access logs to the OTLP collector, the access log still has to be enabled")
+}
+
+func (c *OtlpConfig) Enabled() bool {
+	return c != nil && c.Endpoint != "" && (c.ExportMetrics || c.ExportAccesslog)
+}
+
+func (c *OtlpConfig) ParseConfig() error {
+	if c.Endpoint == "" {
+		return nil
+	}
+	if c.ExportInterval <= 0 {
+		return fmt.Errorf("otlp-export-interval must be positive")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("otlp-timeout must be positive")
+	}
+	if c.Temporality != OtlpTemporalityCumulative && c.Temporality != OtlpTemporalityDelta {
+		return fmt.Errorf("invalid otlp-temporality %q, must be %s or %s", c.Temporality, OtlpTemporalityCumulative, OtlpTemporalityDelta)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// OutlierDetectionConfig configures the passive outlier detection of dual-engine mode, which ejects
+// the endpoints failing to be connected from the endpoint set of their services for a while.
+type OutlierDetectionConfig struct {
+	Enable bool
+	// ConsecutiveFailures is the number of consecutive connect failures before an endpoint is ejected
+	ConsecutiveFailures uint32
+	// BaseEjectionTime is multiplied by the number of times an endpoint has been ejected
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection time of an endpoint
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the max percent of the endpoints of a service that can be ejected, none is ejected
+	// if it is 0, otherwise at least one endpoint can be ejected as long as another one is left.
+	MaxEjectionPercent uint32
+}
+
+func (c *OutlierDetectionConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&c.Enable, "enable-outlier-detection", false, "eject the endpoints failing to be connected consecutively in dual-engine mode, requires monitoring")
+	cmd.PersistentFlags().Uint32Var(&c.ConsecutiveFailures, "outlier-consecutive-failures", 5, "number of consecutive connect failures before an endpoint is ejected")
+	cmd.PersistentFlags().DurationVar(&c.BaseEjectionTime, "outlier-base-ejection-time", 30*time.Second, "base time an endpoint is ejected, multiplied by the number of times it has been ejected")
+	cmd.PersistentFlags().DurationVar(&c.MaxEjectionTime, "outlier-max-ejection-time", 300*time.Second, "max time an endpoint is ejected")
+	cmd.PersistentFlags().Uint32Var(&c.MaxEjectionPercent, "outlier-max-ejection-percent", 10, "max percent of the endpoints of a service that can be ejected, none is ejected if it is 0")
+}
+
+func (c *OutlierDetectionConfig) ParseConfig() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.ConsecutiveFailures == 0 {
+		return fmt.Errorf("outlier-consecutive-failures must be positive")
+	}
+	if c.BaseEjectionTime <= 0 || c.MaxEjectionTime < c.BaseEjectionTime {
+		return fmt.Errorf("invalid outlier ejection time, base: %v, max: %v", c.BaseEjectionTime, c.MaxEjectionTime)
+	}
+	if c.MaxEjectionPercent > 100 {
+		return fmt.Errorf("outlier-max-ejection-percent %d exceeds 100", c.MaxEjectionPercent)
+	}
+	return nil
+}
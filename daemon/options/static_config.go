@@ -0,0 +1,48 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package options
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// StaticConfig configures the resources to be loaded from local files instead of the discovery server.
+type StaticConfig struct {
+	// Dir is the directory of the yaml or json files, the files are watched and the changes are applied
+	Dir string
+}
+
+func (c *StaticConfig) AttachFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&c.Dir, "static-config-dir", "", "load the xds resources from the yaml or json files of the directory instead of the discovery server")
+}
+
+func (c *StaticConfig) ParseConfig() error {
+	if c.Dir == "" {
+		return nil
+	}
+	info, err := os.Stat(c.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid static-config-dir: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("static-config-dir %s is not a directory", c.Dir)
+	}
+	return nil
+}
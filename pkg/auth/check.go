@@ -0,0 +1,129 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// CheckRequest is a connection to evaluate against the live policies, without generating any traffic.
+type CheckRequest struct {
+	SourceIp string `json:"source_ip"`
+	// SourceIdentity is the spiffe id of the source, it is looked up by the source ip if not specified
+	SourceIdentity  string `json:"source_identity,omitempty"`
+	DestinationIp   string `json:"destination_ip"`
+	DestinationPort uint32 `json:"destination_port"`
+	Network         string `json:"network,omitempty"`
+}
+
+// CheckResult is the decision which would be made for the connection of a CheckRequest.
+type CheckResult struct {
+	Allow                bool   `json:"allow"`
+	Reason               string `json:"reason"`
+	Policy               string `json:"policy,omitempty"`
+	SourceIdentity       string `json:"source_identity,omitempty"`
+	DestinationWorkload  string `json:"destination_workload,omitempty"`
+	DestinationNamespace string `json:"destination_namespace,omitempty"`
+	// MatchedPolicies are all the policies of the destination workload matching the connection, including dry-run ones
+	MatchedPolicies []string `json:"matched_policies"`
+	DryRunPolicy    string   `json:"dry_run_policy,omitempty"`
+	DryRunReason    string   `json:"dry_run_reason,omitempty"`
+}
+
+// Check evaluates the connection of the request with the same logic as the connections from the bpf prog,
+// but the decision is neither cached nor reported.
+func (r *Rbac) Check(req *CheckRequest) (*CheckResult, error) {
+	if r == nil {
+		return nil, fmt.Errorf("rbac module uninitialized")
+	}
+	conn, err := r.buildConnFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CheckResult{MatchedPolicies: []string{}}
+	if conn.srcIdentity != (Identity{}) {
+		result.SourceIdentity = conn.srcIdentity.String()
+	}
+	dstWorkload := r.getDstWorkload(conn)
+	if dstWorkload == nil {
+		result.Reason = ReasonNoWorkload
+		return result, nil
+	}
+	result.DestinationWorkload = dstWorkload.GetName()
+	result.DestinationNamespace = dstWorkload.GetNamespace()
+
+	d := r.evaluate(conn, dstWorkload)
+	result.Allow = d.allow
+	result.Reason = d.reason
+	result.Policy = d.policy
+	result.DryRunPolicy = d.dryRunPolicy
+	result.DryRunReason = d.dryRunReason
+
+	allowPolicies, denyPolicies := r.aggregate(dstWorkload)
+	for _, policy := range append(allowPolicies, denyPolicies...) {
+		if r.policyStore.matches(conn, policy) {
+			result.MatchedPolicies = append(result.MatchedPolicies, policy.ResourceName())
+		}
+	}
+	slices.Sort(result.MatchedPolicies)
+	return result, nil
+}
+
+func (r *Rbac) buildConnFromRequest(req *CheckRequest) (*rbacConnection, error) {
+	srcIp, err := netip.ParseAddr(req.SourceIp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source ip %q: %v", req.SourceIp, err)
+	}
+	dstIp, err := netip.ParseAddr(req.DestinationIp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination ip %q: %v", req.DestinationIp, err)
+	}
+	if req.DestinationPort == 0 || req.DestinationPort > 65535 {
+		return nil, fmt.Errorf("invalid destination port %d", req.DestinationPort)
+	}
+
+	conn := &rbacConnection{
+		dstNetwork: req.Network,
+		srcIp:      srcIp.Unmap().AsSlice(),
+		dstIp:      dstIp.Unmap().AsSlice(),
+		dstPort:    req.DestinationPort,
+	}
+	if req.SourceIdentity == "" {
+		conn.srcIdentity = r.getIdentityByIp(conn.srcIp)
+	} else if conn.srcIdentity, err = parseIdentity(req.SourceIdentity); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// parseIdentity parses the spiffe id in the form of spiffe://<trust domain>/ns/<namespace>/sa/<service account>
+func parseIdentity(spiffeId string) (Identity, error) {
+	parts := strings.Split(strings.TrimPrefix(spiffeId, SPIFFE_PREFIX), "/")
+	if !strings.HasPrefix(spiffeId, SPIFFE_PREFIX) || len(parts) != 5 || parts[1] != "ns" || parts[3] != "sa" {
+		return Identity{}, fmt.Errorf("invalid source identity %q, expected %s<trust domain>/ns/<namespace>/sa/<service account>",
+			spiffeId, SPIFFE_PREFIX)
+	}
+	return Identity{
+		trustDomain:    parts[0],
+		namespace:      parts[2],
+		serviceAccount: parts[4],
+	}, nil
+}
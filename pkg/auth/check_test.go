@@ -0,0 +1,142 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbacCheck(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Name:      "dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	})
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:            "cluster0//Pod/ns2/src",
+		Name:           "src",
+		Namespace:      "ns2",
+		ServiceAccount: "sa2",
+		TrustDomain:    "cluster.local",
+		Addresses:      [][]byte{{10, 0, 0, 2}},
+	})
+	rbac := NewRbac(workloadCache)
+	assert.NoError(t, rbac.UpdatePolicy(portPolicy("deny-80", security.Action_DENY, 80)))
+	assert.NoError(t, rbac.UpdatePolicy(portPolicy("allow-81", security.Action_ALLOW, 81)))
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "allow-ns2",
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{Namespaces: []*security.StringMatch{{
+					MatchType: &security.StringMatch_Exact{Exact: "ns2"},
+				}}}},
+			}},
+		}},
+	}))
+
+	tests := []struct {
+		name    string
+		req     *CheckRequest
+		want    *CheckResult
+		wantErr bool
+	}{
+		{
+			name: "deny matched",
+			req:  &CheckRequest{SourceIp: "10.0.0.2", DestinationIp: "10.0.0.1", DestinationPort: 80},
+			want: &CheckResult{
+				Reason:               ReasonDenyMatched,
+				Policy:               "ns1/deny-80",
+				SourceIdentity:       "spiffe://cluster.local/ns/ns2/sa/sa2",
+				DestinationWorkload:  "dst",
+				DestinationNamespace: "ns1",
+				MatchedPolicies:      []string{"ns1/allow-ns2", "ns1/deny-80"},
+			},
+		},
+		{
+			name: "source identity specified",
+			req: &CheckRequest{
+				SourceIp:        "10.0.0.3",
+				SourceIdentity:  "spiffe://cluster.local/ns/ns2/sa/other",
+				DestinationIp:   "10.0.0.1",
+				DestinationPort: 8080,
+			},
+			want: &CheckResult{
+				Allow:                true,
+				Reason:               ReasonAllowMatched,
+				Policy:               "ns1/allow-ns2",
+				SourceIdentity:       "spiffe://cluster.local/ns/ns2/sa/other",
+				DestinationWorkload:  "dst",
+				DestinationNamespace: "ns1",
+				MatchedPolicies:      []string{"ns1/allow-ns2"},
+			},
+		},
+		{
+			name: "no allow matched",
+			req:  &CheckRequest{SourceIp: "10.0.0.3", DestinationIp: "10.0.0.1", DestinationPort: 8080},
+			want: &CheckResult{
+				Reason:               ReasonNoAllowMatched,
+				DestinationWorkload:  "dst",
+				DestinationNamespace: "ns1",
+				MatchedPolicies:      []string{},
+			},
+		},
+		{
+			name: "destination workload not found",
+			req:  &CheckRequest{SourceIp: "10.0.0.2", DestinationIp: "10.0.0.9", DestinationPort: 80},
+			want: &CheckResult{
+				Reason:          ReasonNoWorkload,
+				SourceIdentity:  "spiffe://cluster.local/ns/ns2/sa/sa2",
+				MatchedPolicies: []string{},
+			},
+		},
+		{
+			name:    "invalid source identity",
+			req:     &CheckRequest{SourceIp: "10.0.0.2", SourceIdentity: "ns2/sa2", DestinationIp: "10.0.0.1", DestinationPort: 80},
+			wantErr: true,
+		},
+		{
+			name:    "invalid destination ip",
+			req:     &CheckRequest{SourceIp: "10.0.0.2", DestinationIp: "dst", DestinationPort: 80},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rbac.Check(tt.req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// the check is neither cached nor reported
+	assert.Equal(t, 0, rbac.decisionCache.len())
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"container/list"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"istio.io/pkg/env"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+var decisionCacheSize = env.Register("KMESH_AUTHZ_DECISION_CACHE_SIZE", 65536,
+	"Max number of authorization decisions cached, 0 disables the cache").Get()
+
+// decisionKey is everything of a connection an authorization decision depends on, besides the destination workload.
+type decisionKey struct {
+	srcIdentity Identity
+	srcIp       netip.Addr
+	dstIp       netip.Addr
+	dstPort     uint32
+}
+
+type decisionEntry struct {
+	key decisionKey
+	// dstWorkload is the destination workload the decision is made for, a workload update replaces
+	// the workload in the cache, so the decision is stale once it differs from the current one
+	dstWorkload *workloadapi.Workload
+	decision    decision
+}
+
+// decisionCache is a LRU cache of the authorization decisions.
+type decisionCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[decisionKey]*list.Element
+	lru      *list.List
+	// generation is increased on each invalidation, a decision made before an invalidation is not cached
+	generation uint64
+}
+
+func newDecisionCache(capacity int) *decisionCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &decisionCache{
+		capacity: capacity,
+		entries:  make(map[decisionKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func newDecisionKey(conn *rbacConnection) decisionKey {
+	srcIp, _ := netip.AddrFromSlice(conn.srcIp)
+	dstIp, _ := netip.AddrFromSlice(conn.dstIp)
+	return decisionKey{
+		srcIdentity: conn.srcIdentity,
+		srcIp:       srcIp,
+		dstIp:       dstIp,
+		dstPort:     conn.dstPort,
+	}
+}
+
+// get returns the decision cached for the connection to the workload, and the generation to put the decision made on a miss.
+func (c *decisionCache) get(key decisionKey, dstWorkload *workloadapi.Workload) (d decision, ok bool, generation uint64) {
+	if c == nil {
+		return decision{}, false, 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, found := c.entries[key]
+	if found && elem.Value.(*decisionEntry).dstWorkload == dstWorkload {
+		c.lru.MoveToFront(elem)
+		telemetry.IncAuthzDecisionCacheLookup(true)
+		return elem.Value.(*decisionEntry).decision, true, c.generation
+	}
+	if found {
+		// the destination workload has changed
+		c.remove(elem)
+	}
+	telemetry.IncAuthzDecisionCacheLookup(false)
+	return decision{}, false, c.generation
+}
+
+// put caches the decision, unless the cache has been invalidated since the generation was got.
+func (c *decisionCache) put(key decisionKey, dstWorkload *workloadapi.Workload, d decision, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&decisionEntry{key: key, dstWorkload: dstWorkload, decision: d})
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *decisionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*decisionEntry).key)
+}
+
+// invalidatePolicy drops the decisions of the workloads the policies apply to.
+// It must be called after the policy store is changed: a decision made with the old policies is either
+// dropped here, or not cached by put as its generation is stale.
+func (c *decisionCache) invalidatePolicy(policies ...*security.Authorization) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for _, policy := range policies {
+		if policy == nil {
+			continue
+		}
+		if policy.GetScope() == security.Scope_GLOBAL {
+			c.entries = make(map[decisionKey]*list.Element)
+			c.lru.Init()
+			return
+		}
+
+		key := policy.ResourceName()
+		for elem := c.lru.Front(); elem != nil; {
+			next := elem.Next()
+			workload := elem.Value.(*decisionEntry).dstWorkload
+			switch policy.GetScope() {
+			case security.Scope_NAMESPACE:
+				if workload.GetNamespace() == policy.GetNamespace() {
+					c.remove(elem)
+				}
+			case security.Scope_WORKLOAD_SELECTOR:
+				if slices.Contains(workload.GetAuthorizationPolicies(), key) {
+					c.remove(elem)
+				}
+			}
+			elem = next
+		}
+	}
+}
+
+func (c *decisionCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestDecisionCache(t *testing.T) {
+	c := newDecisionCache(2)
+	wl1 := &workloadapi.Workload{Uid: "wl1", Namespace: "ns1", AuthorizationPolicies: []string{"ns1/selector"}}
+	wl2 := &workloadapi.Workload{Uid: "wl2", Namespace: "ns2"}
+	key1 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.1"), dstPort: 80}
+	key2 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.2"), dstPort: 80}
+	key3 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.2"), dstPort: 81}
+
+	_, ok, generation := c.get(key1, wl1)
+	assert.False(t, ok)
+	c.put(key1, wl1, decision{allow: true}, generation)
+	d, ok, _ := c.get(key1, wl1)
+	assert.True(t, ok)
+	assert.True(t, d.allow)
+
+	// the least recently used one is evicted
+	c.put(key2, wl2, decision{}, generation)
+	c.get(key1, wl1)
+	c.put(key3, wl2, decision{}, generation)
+	assert.Equal(t, 2, c.len())
+	_, ok, _ = c.get(key2, wl2)
+	assert.False(t, ok)
+
+	// a decision of the workload before update is stale
+	_, ok, _ = c.get(key1, &workloadapi.Workload{Uid: "wl1", Namespace: "ns1"})
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.len())
+
+	// a decision made before an invalidation is not cached
+	_, _, generation = c.get(key1, wl1)
+	c.invalidatePolicy(&security.Authorization{Name: "other", Namespace: "ns3", Scope: security.Scope_NAMESPACE})
+	c.put(key1, wl1, decision{allow: true}, generation)
+	_, ok, _ = c.get(key1, wl1)
+	assert.False(t, ok)
+}
+
+func TestDecisionCacheInvalidatePolicies(t *testing.T) {
+	wl1 := &workloadapi.Workload{Uid: "wl1", Namespace: "ns1"}
+	wl2 := &workloadapi.Workload{Uid: "wl2", Namespace: "ns2"}
+	wl3 := &workloadapi.Workload{Uid: "wl3", Namespace: "ns3"}
+	key1 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.1"), dstPort: 80}
+	key2 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.2"), dstPort: 80}
+	key3 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.3"), dstPort: 80}
+
+	c := newDecisionCache(10)
+	_, _, generation := c.get(key1, wl1)
+	c.put(key1, wl1, decision{}, generation)
+	c.put(key2, wl2, decision{}, generation)
+	c.put(key3, wl3, decision{}, generation)
+
+	// a decision made with the old policy while the policy is moved from ns1 to ns2
+	_, _, stale := c.get(key3, wl1)
+	c.invalidatePolicy(
+		&security.Authorization{Name: "p", Namespace: "ns1", Scope: security.Scope_NAMESPACE},
+		nil,
+		&security.Authorization{Name: "p", Namespace: "ns2", Scope: security.Scope_NAMESPACE},
+	)
+	c.put(key3, wl1, decision{allow: true}, stale)
+
+	_, ok, _ := c.get(key1, wl1)
+	assert.False(t, ok)
+	_, ok, _ = c.get(key2, wl2)
+	assert.False(t, ok)
+	_, ok, _ = c.get(key3, wl1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.len())
+}
+
+func TestDecisionCacheInvalidatePolicy(t *testing.T) {
+	wl1 := &workloadapi.Workload{Uid: "wl1", Namespace: "ns1", AuthorizationPolicies: []string{"ns1/selector"}}
+	wl2 := &workloadapi.Workload{Uid: "wl2", Namespace: "ns2"}
+	key1 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.1"), dstPort: 80}
+	key2 := decisionKey{dstIp: netip.MustParseAddr("10.0.0.2"), dstPort: 80}
+
+	tests := []struct {
+		name   string
+		policy *security.Authorization
+		want1  bool
+		want2  bool
+	}{
+		{
+			name:   "workload selector",
+			policy: &security.Authorization{Name: "selector", Namespace: "ns1", Scope: security.Scope_WORKLOAD_SELECTOR},
+			want1:  false,
+			want2:  true,
+		},
+		{
+			name:   "workload selector not applied",
+			policy: &security.Authorization{Name: "selector", Namespace: "ns2", Scope: security.Scope_WORKLOAD_SELECTOR},
+			want1:  true,
+			want2:  true,
+		},
+		{
+			name:   "namespace",
+			policy: &security.Authorization{Name: "ns", Namespace: "ns2", Scope: security.Scope_NAMESPACE},
+			want1:  true,
+			want2:  false,
+		},
+		{
+			name:   "global",
+			policy: &security.Authorization{Name: "global", Namespace: "istio-system", Scope: security.Scope_GLOBAL},
+			want1:  false,
+			want2:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newDecisionCache(10)
+			c.put(key1, wl1, decision{allow: true}, 0)
+			c.put(key2, wl2, decision{allow: true}, 0)
+			c.invalidatePolicy(tt.policy)
+			_, ok, _ := c.get(key1, wl1)
+			assert.Equal(t, tt.want1, ok)
+			_, ok, _ = c.get(key2, wl2)
+			assert.Equal(t, tt.want2, ok)
+		})
+	}
+}
+
+func TestRbacDecisionCache(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	dst := &workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	}
+	workloadCache.AddOrUpdateWorkload(dst)
+	rbac := &Rbac{
+		policyStore:   newPolicyStore(),
+		workloadCache: workloadCache,
+		decisionCache: newDecisionCache(10),
+	}
+	conn := &rbacConnection{
+		srcIp:   []byte{10, 0, 0, 2},
+		dstIp:   []byte{10, 0, 0, 1},
+		dstPort: 80,
+	}
+
+	assert.True(t, rbac.doRbac(conn))
+	assert.Equal(t, 1, rbac.decisionCache.len())
+	assert.True(t, rbac.doRbac(conn))
+
+	// a deny policy of the namespace invalidates the decision
+	deny := &security.Authorization{
+		Name:      "deny-80",
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{DestinationPorts: []uint32{80}}},
+			}},
+		}},
+	}
+	assert.NoError(t, rbac.UpdatePolicy(deny))
+	assert.False(t, rbac.doRbac(conn))
+
+	rbac.RemovePolicy(deny.ResourceName())
+	assert.True(t, rbac.doRbac(conn))
+
+	// the decision is made again once the destination workload is updated
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:                   dst.Uid,
+		Namespace:             "ns1",
+		Addresses:             [][]byte{{10, 0, 0, 1}},
+		AuthorizationPolicies: []string{"ns1/selector"},
+	})
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "selector",
+		Namespace: "ns1",
+		Scope:     security.Scope_WORKLOAD_SELECTOR,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{DestinationPorts: []uint32{8080}}},
+			}},
+		}},
+	}))
+	assert.False(t, rbac.doRbac(conn))
+}
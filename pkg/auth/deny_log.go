@@ -0,0 +1,89 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"time"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+// The reasons of an authorization decision.
+const (
+	ReasonNoWorkload     = "no_workload"
+	ReasonDenyMatched    = "deny_matched"
+	ReasonNoAllowMatched = "no_allow_matched"
+	ReasonAllowMatched   = "allow_matched"
+	ReasonNoPolicy       = "no_policy"
+)
+
+// DenyRecord is the structured record of a connection denied, or would have been denied by a dry-run policy.
+type DenyRecord struct {
+	Time                 time.Time `json:"time"`
+	SourceIdentity       string    `json:"source_identity,omitempty"`
+	SourceIp             string    `json:"source_ip"`
+	DestinationIp        string    `json:"destination_ip"`
+	DestinationPort      uint32    `json:"destination_port"`
+	DestinationWorkload  string    `json:"destination_workload,omitempty"`
+	DestinationNamespace string    `json:"destination_namespace,omitempty"`
+	Policy               string    `json:"policy,omitempty"`
+	Reason               string    `json:"reason"`
+	DryRun               bool      `json:"dry_run,omitempty"`
+}
+
+func newDenyRecord(conn *rbacConnection, dstWorkload *workloadapi.Workload, policy, reason string) DenyRecord {
+	srcIp, _ := netip.AddrFromSlice(conn.srcIp)
+	dstIp, _ := netip.AddrFromSlice(conn.dstIp)
+	record := DenyRecord{
+		Time:                 time.Now(),
+		SourceIp:             srcIp.String(),
+		DestinationIp:        dstIp.String(),
+		DestinationPort:      conn.dstPort,
+		DestinationWorkload:  dstWorkload.GetName(),
+		DestinationNamespace: dstWorkload.GetNamespace(),
+		Policy:               policy,
+		Reason:               reason,
+	}
+	if conn.srcIdentity != (Identity{}) {
+		record.SourceIdentity = conn.srcIdentity.String()
+	}
+	return record
+}
+
+// SubscribeDenyLog returns the stream of the deny records from now on, and the func to cancel the subscription.
+// The records are only built while there is any subscriber.
+func (r *Rbac) SubscribeDenyLog(bufferSize int) (<-chan DenyRecord, func()) {
+	return r.denyLog.Subscribe(bufferSize)
+}
+
+// report records the decision made for the connection into the metrics and the deny log.
+func (r *Rbac) report(conn *rbacConnection, dstWorkload *workloadapi.Workload, d decision) {
+	telemetry.IncAuthzDecision(dstWorkload.GetWorkloadName(), dstWorkload.GetNamespace(), d.policy, d.allow, d.reason)
+	if !d.allow && r.denyLog.Enabled() {
+		r.denyLog.Publish(newDenyRecord(conn, dstWorkload, d.policy, d.reason))
+	}
+	if d.dryRunPolicy != "" {
+		reportDryRunDeny(conn, dstWorkload, d.dryRunPolicy)
+		if r.denyLog.Enabled() {
+			record := newDenyRecord(conn, dstWorkload, d.dryRunPolicy, d.dryRunReason)
+			record.DryRun = true
+			r.denyLog.Publish(record)
+		}
+	}
+}
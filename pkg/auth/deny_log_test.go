@@ -0,0 +1,124 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestDecisionReason(t *testing.T) {
+	conn := &rbacConnection{
+		srcIp:   []byte{10, 0, 0, 2},
+		dstIp:   []byte{10, 0, 0, 1},
+		dstPort: 80,
+	}
+	allow80 := portPolicy("allow-80", security.Action_ALLOW, 80)
+	allow81 := portPolicy("allow-81", security.Action_ALLOW, 81)
+	deny80 := portPolicy("deny-80", security.Action_DENY, 80)
+
+	tests := []struct {
+		name          string
+		allowPolicies []*security.Authorization
+		denyPolicies  []*security.Authorization
+		want          decision
+	}{
+		{
+			name: "no policy",
+			want: decision{allow: true, reason: ReasonNoPolicy},
+		},
+		{
+			name:          "deny matched",
+			allowPolicies: []*security.Authorization{allow80},
+			denyPolicies:  []*security.Authorization{deny80},
+			want:          decision{policy: "ns1/deny-80", reason: ReasonDenyMatched},
+		},
+		{
+			name:          "allow matched",
+			allowPolicies: []*security.Authorization{allow81, allow80},
+			want:          decision{allow: true, policy: "ns1/allow-80", reason: ReasonAllowMatched},
+		},
+		{
+			name:          "no allow matched",
+			allowPolicies: []*security.Authorization{allow81},
+			want:          decision{reason: ReasonNoAllowMatched},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRbac(cache.NewWorkloadCache())
+			assert.Equal(t, tt.want, r.decide(conn, tt.allowPolicies, tt.denyPolicies))
+		})
+	}
+}
+
+func TestDenyLog(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Name:      "dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	})
+	rbac := NewRbac(workloadCache)
+	assert.NoError(t, rbac.UpdatePolicy(portPolicy("deny-80", security.Action_DENY, 80)))
+	assert.NoError(t, rbac.UpdatePolicy(portPolicy("dry-run-deny-80", security.Action_DENY, 80)))
+	rbac.SetDryRun("ns1/dry-run-deny-80", true)
+
+	records, cancel := rbac.SubscribeDenyLog(10)
+	conn := &rbacConnection{
+		srcIdentity: Identity{trustDomain: "cluster.local", namespace: "ns2", serviceAccount: "sa"},
+		srcIp:       []byte{10, 0, 0, 2},
+		dstIp:       []byte{10, 0, 0, 1},
+		dstPort:     80,
+	}
+	assert.False(t, rbac.doRbac(conn))
+
+	record := <-records
+	assert.Equal(t, "spiffe://cluster.local/ns/ns2/sa/sa", record.SourceIdentity)
+	assert.Equal(t, "10.0.0.2", record.SourceIp)
+	assert.Equal(t, "10.0.0.1", record.DestinationIp)
+	assert.Equal(t, uint32(80), record.DestinationPort)
+	assert.Equal(t, "dst", record.DestinationWorkload)
+	assert.Equal(t, "ns1", record.DestinationNamespace)
+	assert.Equal(t, "ns1/deny-80", record.Policy)
+	assert.Equal(t, ReasonDenyMatched, record.Reason)
+	assert.False(t, record.DryRun)
+
+	record = <-records
+	assert.Equal(t, "ns1/dry-run-deny-80", record.Policy)
+	assert.True(t, record.DryRun)
+
+	// the destination workload not found
+	conn.dstIp = []byte{10, 0, 0, 3}
+	assert.False(t, rbac.doRbac(conn))
+	record = <-records
+	assert.Equal(t, ReasonNoWorkload, record.Reason)
+	assert.Empty(t, record.DestinationWorkload)
+
+	cancel()
+	_, ok := <-records
+	assert.False(t, ok)
+	assert.False(t, rbac.denyLog.Enabled())
+	// cancel twice is fine
+	cancel()
+}
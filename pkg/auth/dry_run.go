@@ -0,0 +1,123 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"istio.io/istio/pkg/util/sets"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+// DryRunAnnotation marks an authorization policy to be evaluated without enforcement,
+// following the semantics of istio, the connections it would deny are logged and counted instead.
+const DryRunAnnotation = "istio.io/dry-run"
+
+// SetDryRun marks or unmarks the policy as dry-run, and returns whether the mark is changed.
+// The mark is kept even if the policy has not been received yet.
+func (r *Rbac) SetDryRun(policyKey string, dryRun bool) bool {
+	r.dryRunLock.Lock()
+	if r.dryRunPolicies == nil {
+		r.dryRunPolicies = sets.New[string]()
+	}
+	changed := r.dryRunPolicies.Contains(policyKey) != dryRun
+	if dryRun {
+		r.dryRunPolicies.Insert(policyKey)
+	} else {
+		r.dryRunPolicies.Delete(policyKey)
+	}
+	r.dryRunLock.Unlock()
+
+	if changed {
+		r.decisionCache.invalidatePolicy(r.policyStore.get(policyKey))
+	}
+	return changed
+}
+
+// IsDryRun returns whether the policy is marked as dry-run
+func (r *Rbac) IsDryRun(policyKey string) bool {
+	if r == nil {
+		return false
+	}
+	r.dryRunLock.RLock()
+	defer r.dryRunLock.RUnlock()
+	return r.dryRunPolicies.Contains(policyKey)
+}
+
+// GetPolicy returns the policy of the key, or nil if not exists
+func (r *Rbac) GetPolicy(policyKey string) *security.Authorization {
+	if r == nil {
+		return nil
+	}
+	return r.policyStore.get(policyKey)
+}
+
+// splitDryRun splits the policies into the enforced ones and the dry-run ones.
+func (r *Rbac) splitDryRun(policies []*security.Authorization) (enforced, dryRun []*security.Authorization) {
+	r.dryRunLock.RLock()
+	defer r.dryRunLock.RUnlock()
+
+	if len(r.dryRunPolicies) == 0 {
+		return policies, nil
+	}
+	for _, policy := range policies {
+		if r.dryRunPolicies.Contains(policy.ResourceName()) {
+			dryRun = append(dryRun, policy)
+		} else {
+			enforced = append(enforced, policy)
+		}
+	}
+	return
+}
+
+// dryRunDenyPolicy evaluates the dry-run policies as if they were the only policies enforced,
+// and returns the name of the policy which would deny the connection and the reason, or "" if it would be allowed.
+func (r *Rbac) dryRunDenyPolicy(conn *rbacConnection, allowPolicies, denyPolicies []*security.Authorization) (string, string) {
+	for _, denyPolicy := range denyPolicies {
+		if r.policyStore.matches(conn, denyPolicy) {
+			return denyPolicy.ResourceName(), ReasonDenyMatched
+		}
+	}
+
+	if len(allowPolicies) == 0 {
+		return "", ""
+	}
+	names := make([]string, 0, len(allowPolicies))
+	for _, allowPolicy := range allowPolicies {
+		if r.policyStore.matches(conn, allowPolicy) {
+			return "", ""
+		}
+		names = append(names, allowPolicy.ResourceName())
+	}
+	// none of the allow policies matches, all of them are accountable
+	slices.Sort(names)
+	return strings.Join(names, ","), ReasonNoAllowMatched
+}
+
+func reportDryRunDeny(conn *rbacConnection, dstWorkload *workloadapi.Workload, policy string) {
+	srcIp, _ := netip.AddrFromSlice(conn.srcIp)
+	dstIp, _ := netip.AddrFromSlice(conn.dstIp)
+	log.Infof("dry-run authorization policy %s would deny connection from %s (%s) to %s (%s)",
+		policy, conn.srcIdentity.String(), srcIp, dstWorkload.ResourceName(),
+		netip.AddrPortFrom(dstIp, uint16(conn.dstPort)))
+	telemetry.IncAuthzDryRunDeny(policy)
+}
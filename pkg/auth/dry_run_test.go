@@ -0,0 +1,161 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func portPolicy(name string, action security.Action, port uint32) *security.Authorization {
+	return &security.Authorization{
+		Name:      name,
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    action,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{DestinationPorts: []uint32{port}}},
+			}},
+		}},
+	}
+}
+
+func TestRbacDryRun(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	dst := &workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	}
+	workloadCache.AddOrUpdateWorkload(dst)
+	conn := &rbacConnection{
+		srcIp:   []byte{10, 0, 0, 2},
+		dstIp:   []byte{10, 0, 0, 1},
+		dstPort: 80,
+	}
+
+	tests := []struct {
+		name     string
+		policies []*security.Authorization
+		dryRun   []string
+		allow    bool
+		dryRunBy string
+	}{
+		{
+			name:     "dry-run deny matched",
+			policies: []*security.Authorization{portPolicy("deny-80", security.Action_DENY, 80)},
+			dryRun:   []string{"ns1/deny-80"},
+			allow:    true,
+			dryRunBy: "ns1/deny-80",
+		},
+		{
+			name:     "dry-run deny not matched",
+			policies: []*security.Authorization{portPolicy("deny-81", security.Action_DENY, 81)},
+			dryRun:   []string{"ns1/deny-81"},
+			allow:    true,
+		},
+		{
+			name: "dry-run allow not matched",
+			policies: []*security.Authorization{
+				portPolicy("allow-82", security.Action_ALLOW, 82),
+				portPolicy("allow-81", security.Action_ALLOW, 81),
+			},
+			dryRun:   []string{"ns1/allow-81", "ns1/allow-82"},
+			allow:    true,
+			dryRunBy: "ns1/allow-81,ns1/allow-82",
+		},
+		{
+			name: "dry-run allow matched",
+			policies: []*security.Authorization{
+				portPolicy("allow-80", security.Action_ALLOW, 80),
+				portPolicy("allow-81", security.Action_ALLOW, 81),
+			},
+			dryRun: []string{"ns1/allow-80", "ns1/allow-81"},
+			allow:  true,
+		},
+		{
+			name: "enforced policies are not affected by dry-run ones",
+			policies: []*security.Authorization{
+				portPolicy("allow-80", security.Action_ALLOW, 80),
+				portPolicy("allow-81", security.Action_ALLOW, 81),
+			},
+			dryRun:   []string{"ns1/allow-81"},
+			allow:    true,
+			dryRunBy: "ns1/allow-81",
+		},
+		{
+			name: "enforced deny",
+			policies: []*security.Authorization{
+				portPolicy("deny-80", security.Action_DENY, 80),
+				portPolicy("dry-run-deny-80", security.Action_DENY, 80),
+			},
+			dryRun:   []string{"ns1/dry-run-deny-80"},
+			allow:    false,
+			dryRunBy: "ns1/dry-run-deny-80",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbac := NewRbac(workloadCache)
+			for _, key := range tt.dryRun {
+				assert.True(t, rbac.SetDryRun(key, true))
+			}
+			for _, policy := range tt.policies {
+				assert.NoError(t, rbac.UpdatePolicy(policy))
+			}
+			d := rbac.evaluate(conn, dst)
+			assert.Equal(t, tt.allow, d.allow)
+			assert.Equal(t, tt.dryRunBy, d.dryRunPolicy)
+			assert.Equal(t, tt.allow, rbac.doRbac(conn))
+		})
+	}
+}
+
+func TestRbacSetDryRun(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	})
+	rbac := NewRbac(workloadCache)
+	conn := &rbacConnection{
+		srcIp:   []byte{10, 0, 0, 2},
+		dstIp:   []byte{10, 0, 0, 1},
+		dstPort: 80,
+	}
+
+	deny := portPolicy("deny-80", security.Action_DENY, 80)
+	assert.NoError(t, rbac.UpdatePolicy(deny))
+	assert.False(t, rbac.doRbac(conn))
+
+	// the cached decision is dropped once the policy turns into dry-run
+	assert.True(t, rbac.SetDryRun(deny.ResourceName(), true))
+	assert.False(t, rbac.SetDryRun(deny.ResourceName(), true))
+	assert.True(t, rbac.IsDryRun(deny.ResourceName()))
+	assert.True(t, rbac.doRbac(conn))
+
+	assert.True(t, rbac.SetDryRun(deny.ResourceName(), false))
+	assert.False(t, rbac.IsDryRun(deny.ResourceName()))
+	assert.False(t, rbac.doRbac(conn))
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+// PolicyInfo is the summary of an authorization policy.
+type PolicyInfo struct {
+	Name   string `json:"name"`
+	Scope  string `json:"scope"`
+	Action string `json:"action"`
+	Rules  int    `json:"rules"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// PolicyWarning flags a policy which may not work as expected.
+type PolicyWarning struct {
+	Policy  string `json:"policy"`
+	Message string `json:"message"`
+}
+
+// WorkloadPolicyReport is the effective policies of a workload after aggregated from the workload, namespace
+// and root namespace scopes. All the deny policies are evaluated before the allow ones.
+type WorkloadPolicyReport struct {
+	Workload  string `json:"workload"`
+	Namespace string `json:"namespace"`
+	// DefaultAction is the action taken when no policy matches, it is DENY once there is any enforced allow policy
+	DefaultAction string          `json:"default_action"`
+	Deny          []PolicyInfo    `json:"deny"`
+	Allow         []PolicyInfo    `json:"allow"`
+	Warnings      []PolicyWarning `json:"warnings"`
+}
+
+// PolicyReport is all the authorization policies and the warnings of them.
+type PolicyReport struct {
+	Policies []PolicyInfo    `json:"policies"`
+	Warnings []PolicyWarning `json:"warnings"`
+}
+
+func (r *Rbac) policyInfo(policy *security.Authorization) PolicyInfo {
+	return PolicyInfo{
+		Name:   policy.ResourceName(),
+		Scope:  policy.GetScope().String(),
+		Action: policy.GetAction().String(),
+		Rules:  len(policy.GetRules()),
+		DryRun: r.IsDryRun(policy.ResourceName()),
+	}
+}
+
+// sortPolicies sorts the policies from the narrowest scope to the widest, then by name.
+func sortPolicies(policies []*security.Authorization) {
+	scopeOrder := map[security.Scope]int{
+		security.Scope_WORKLOAD_SELECTOR: 0,
+		security.Scope_NAMESPACE:         1,
+		security.Scope_GLOBAL:            2,
+	}
+	slices.SortFunc(policies, func(a, b *security.Authorization) int {
+		if scopeOrder[a.GetScope()] != scopeOrder[b.GetScope()] {
+			return scopeOrder[a.GetScope()] - scopeOrder[b.GetScope()]
+		}
+		return strings.Compare(a.ResourceName(), b.ResourceName())
+	})
+}
+
+// WorkloadPolicyReport returns the effective policies of the workload and the warnings of them.
+func (r *Rbac) WorkloadPolicyReport(workload *workloadapi.Workload) *WorkloadPolicyReport {
+	allowPolicies, denyPolicies := r.aggregate(workload)
+	sortPolicies(allowPolicies)
+	sortPolicies(denyPolicies)
+
+	report := &WorkloadPolicyReport{
+		Workload:      workload.GetName(),
+		Namespace:     workload.GetNamespace(),
+		DefaultAction: security.Action_ALLOW.String(),
+		Deny:          []PolicyInfo{},
+		Allow:         []PolicyInfo{},
+		Warnings:      []PolicyWarning{},
+	}
+	for _, policy := range denyPolicies {
+		report.Deny = append(report.Deny, r.policyInfo(policy))
+		report.Warnings = append(report.Warnings, unreachableRules(policy)...)
+	}
+	for _, policy := range allowPolicies {
+		info := r.policyInfo(policy)
+		report.Allow = append(report.Allow, info)
+		if !info.DryRun {
+			report.DefaultAction = security.Action_DENY.String()
+		}
+		report.Warnings = append(report.Warnings, unreachableRules(policy)...)
+	}
+
+	for _, key := range workload.GetAuthorizationPolicies() {
+		if r.policyStore.get(key) == nil {
+			report.Warnings = append(report.Warnings, PolicyWarning{
+				Policy:  key,
+				Message: "referenced by the workload but not found",
+			})
+		}
+	}
+
+	// an allow rule is shadowed by the enforced deny rules matching all the connections it matches
+	for _, denyPolicy := range denyPolicies {
+		if r.IsDryRun(denyPolicy.ResourceName()) {
+			continue
+		}
+		for _, allowPolicy := range allowPolicies {
+			report.Warnings = append(report.Warnings, shadowedRules(allowPolicy, denyPolicy)...)
+		}
+	}
+	return report
+}
+
+// PolicyReport returns all the policies, and the warnings of them not depending on any single workload.
+func (r *Rbac) PolicyReport() *PolicyReport {
+	policies := r.policyStore.list()
+	sortPolicies(policies)
+
+	selected := make(map[string]struct{})
+	for _, workload := range r.workloadCache.List() {
+		for _, key := range workload.GetAuthorizationPolicies() {
+			selected[key] = struct{}{}
+		}
+	}
+
+	report := &PolicyReport{
+		Policies: []PolicyInfo{},
+		Warnings: []PolicyWarning{},
+	}
+	for _, policy := range policies {
+		report.Policies = append(report.Policies, r.policyInfo(policy))
+		if policy.GetScope() == security.Scope_WORKLOAD_SELECTOR {
+			if _, ok := selected[policy.ResourceName()]; !ok {
+				report.Warnings = append(report.Warnings, PolicyWarning{
+					Policy:  policy.ResourceName(),
+					Message: "selects no existing workload",
+				})
+			}
+		}
+		report.Warnings = append(report.Warnings, unreachableRules(policy)...)
+	}
+	return report
+}
+
+// unreachableRules flags the rules of the policy which can never be matched.
+func unreachableRules(policy *security.Authorization) []PolicyWarning {
+	if len(policy.GetRules()) == 0 {
+		return []PolicyWarning{{
+			Policy:  policy.ResourceName(),
+			Message: "has no rules and never matches",
+		}}
+	}
+
+	var warnings []PolicyWarning
+	for i, rule := range policy.GetRules() {
+		for j, clause := range rule.GetClauses() {
+			if len(clause.GetMatches()) == 0 {
+				continue
+			}
+			if !slices.ContainsFunc(clause.GetMatches(), matchable) {
+				warnings = append(warnings, PolicyWarning{
+					Policy:  policy.ResourceName(),
+					Message: fmt.Sprintf("rule %d is unreachable as none of the matches of clause %d can be matched", i, j),
+				})
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// matchable returns false if the match is empty, which is skipped, or any of its fields is contradicted
+// by the negative one of the same type.
+func matchable(match *security.Match) bool {
+	if isEmptyMatch(match) {
+		return false
+	}
+	if len(match.GetDestinationPorts()) > 0 && !slices.ContainsFunc(match.GetDestinationPorts(), func(port uint32) bool {
+		return !slices.Contains(match.GetNotDestinationPorts(), port)
+	}) {
+		return false
+	}
+	return stringsMatchable(match.GetPrincipals(), match.GetNotPrincipals()) &&
+		stringsMatchable(match.GetNamespaces(), match.GetNotNamespaces())
+}
+
+// stringsMatchable returns false if all the positive string matches are excluded by the negative ones.
+func stringsMatchable(positive, negative []*security.StringMatch) bool {
+	if len(positive) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(positive, func(p *security.StringMatch) bool {
+		return !slices.ContainsFunc(negative, func(n *security.StringMatch) bool {
+			return proto.Equal(p, n)
+		})
+	})
+}
+
+// matchesAll returns whether the rule matches all the connections.
+func matchesAll(rule *security.Rule) bool {
+	for _, clause := range rule.GetClauses() {
+		if len(clause.GetMatches()) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shadowedRules flags the rules of the allow policy which can never take effect because of the deny policy.
+func shadowedRules(allowPolicy, denyPolicy *security.Authorization) []PolicyWarning {
+	if slices.ContainsFunc(denyPolicy.GetRules(), matchesAll) {
+		return []PolicyWarning{{
+			Policy:  allowPolicy.ResourceName(),
+			Message: fmt.Sprintf("shadowed by deny policy %s which matches all connections", denyPolicy.ResourceName()),
+		}}
+	}
+
+	var warnings []PolicyWarning
+	for i, allowRule := range allowPolicy.GetRules() {
+		for j, denyRule := range denyPolicy.GetRules() {
+			if proto.Equal(allowRule, denyRule) {
+				warnings = append(warnings, PolicyWarning{
+					Policy:  allowPolicy.ResourceName(),
+					Message: fmt.Sprintf("rule %d is shadowed by the same rule %d of deny policy %s", i, j, denyPolicy.ResourceName()),
+				})
+				break
+			}
+		}
+	}
+	return warnings
+}
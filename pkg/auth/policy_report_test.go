@@ -0,0 +1,122 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestWorkloadPolicyReport(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workload := &workloadapi.Workload{
+		Uid:                   "cluster0//Pod/ns1/dst",
+		Name:                  "dst",
+		Namespace:             "ns1",
+		Addresses:             [][]byte{{10, 0, 0, 1}},
+		AuthorizationPolicies: []string{"ns1/selector", "ns1/missing"},
+	}
+	workloadCache.AddOrUpdateWorkload(workload)
+	rbac := NewRbac(workloadCache)
+
+	allow80 := portPolicy("allow-80", security.Action_ALLOW, 80)
+	selector := portPolicy("selector", security.Action_ALLOW, 8080)
+	selector.Scope = security.Scope_WORKLOAD_SELECTOR
+	deny80 := portPolicy("deny-80", security.Action_DENY, 80)
+	global := &security.Authorization{
+		Name:      "empty",
+		Namespace: "istio-system",
+		Scope:     security.Scope_GLOBAL,
+		Action:    security.Action_ALLOW,
+	}
+	unreachable := &security.Authorization{
+		Name:      "unreachable",
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{{DestinationPorts: []uint32{81}, NotDestinationPorts: []uint32{81}}},
+			}},
+		}},
+	}
+	for _, policy := range []*security.Authorization{allow80, selector, deny80, global, unreachable} {
+		assert.NoError(t, rbac.UpdatePolicy(policy))
+	}
+	rbac.SetDryRun(global.ResourceName(), true)
+
+	report := rbac.WorkloadPolicyReport(workload)
+	assert.Equal(t, "dst", report.Workload)
+	assert.Equal(t, "DENY", report.DefaultAction)
+	assert.Equal(t, []PolicyInfo{
+		{Name: "ns1/deny-80", Scope: "NAMESPACE", Action: "DENY", Rules: 1},
+		{Name: "ns1/unreachable", Scope: "NAMESPACE", Action: "DENY", Rules: 1},
+	}, report.Deny)
+	assert.Equal(t, []PolicyInfo{
+		{Name: "ns1/selector", Scope: "WORKLOAD_SELECTOR", Action: "ALLOW", Rules: 1},
+		{Name: "ns1/allow-80", Scope: "NAMESPACE", Action: "ALLOW", Rules: 1},
+		{Name: "istio-system/empty", Scope: "GLOBAL", Action: "ALLOW", DryRun: true},
+	}, report.Allow)
+	assert.ElementsMatch(t, []PolicyWarning{
+		{Policy: "ns1/unreachable", Message: "rule 0 is unreachable as none of the matches of clause 0 can be matched"},
+		{Policy: "istio-system/empty", Message: "has no rules and never matches"},
+		{Policy: "ns1/missing", Message: "referenced by the workload but not found"},
+		{Policy: "ns1/allow-80", Message: "rule 0 is shadowed by the same rule 0 of deny policy ns1/deny-80"},
+	}, report.Warnings)
+
+	// a deny policy matching all connections shadows all the allow policies
+	assert.NoError(t, rbac.UpdatePolicy(&security.Authorization{
+		Name:      "deny-all",
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_DENY,
+		Rules:     []*security.Rule{{}},
+	}))
+	report = rbac.WorkloadPolicyReport(workload)
+	assert.Contains(t, report.Warnings, PolicyWarning{
+		Policy:  "ns1/selector",
+		Message: "shadowed by deny policy ns1/deny-all which matches all connections",
+	})
+}
+
+func TestPolicyReport(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:                   "cluster0//Pod/ns1/dst",
+		Name:                  "dst",
+		Namespace:             "ns1",
+		AuthorizationPolicies: []string{"ns1/selected"},
+	})
+	rbac := NewRbac(workloadCache)
+	for _, name := range []string{"selected", "orphan"} {
+		policy := portPolicy(name, security.Action_ALLOW, 80)
+		policy.Scope = security.Scope_WORKLOAD_SELECTOR
+		assert.NoError(t, rbac.UpdatePolicy(policy))
+	}
+
+	report := rbac.PolicyReport()
+	assert.Equal(t, []PolicyInfo{
+		{Name: "ns1/orphan", Scope: "WORKLOAD_SELECTOR", Action: "ALLOW", Rules: 1},
+		{Name: "ns1/selected", Scope: "WORKLOAD_SELECTOR", Action: "ALLOW", Rules: 1},
+	}, report.Policies)
+	assert.Equal(t, []PolicyWarning{{Policy: "ns1/orphan", Message: "selects no existing workload"}}, report.Warnings)
+}
@@ -32,6 +32,9 @@ type policyStore struct {
 	// byNamespace maintains a mapping of namespace (or "" for global) to policy names
 	byNamespace map[string]sets.Set[string]
 
+	// ipsByMatch maintains the ips of the matches of the policies compiled into prefix tries
+	ipsByMatch map[*security.Match]*matchIps
+
 	rwLock sync.RWMutex
 }
 
@@ -39,6 +42,7 @@ func newPolicyStore() *policyStore {
 	return &policyStore{
 		byKey:       make(map[string]*security.Authorization),
 		byNamespace: make(map[string]sets.Set[string]),
+		ipsByMatch:  make(map[*security.Match]*matchIps),
 	}
 }
 
@@ -52,6 +56,7 @@ func (ps *policyStore) updatePolicy(authPolicy *security.Authorization) error {
 	var ns string
 	switch authPolicy.GetScope() {
 	case security.Scope_WORKLOAD_SELECTOR:
+		ps.compile(ps.byKey[key], authPolicy)
 		ps.byKey[key] = authPolicy
 		return nil
 	case security.Scope_GLOBAL:
@@ -67,10 +72,44 @@ func (ps *policyStore) updatePolicy(authPolicy *security.Authorization) error {
 	} else {
 		s.Insert(key)
 	}
+	ps.compile(ps.byKey[key], authPolicy)
 	ps.byKey[key] = authPolicy
 	return nil
 }
 
+// compile replaces the compiled ips of the old policy with the ones of the new policy,
+// either of them can be nil.
+func (ps *policyStore) compile(oldPolicy, newPolicy *security.Authorization) {
+	if ps.ipsByMatch == nil {
+		ps.ipsByMatch = make(map[*security.Match]*matchIps)
+	}
+	forEachMatch(oldPolicy, func(match *security.Match) {
+		delete(ps.ipsByMatch, match)
+	})
+	forEachMatch(newPolicy, func(match *security.Match) {
+		if ips := newMatchIps(match); ips != nil {
+			ps.ipsByMatch[match] = ips
+		}
+	})
+}
+
+func forEachMatch(policy *security.Authorization, fn func(match *security.Match)) {
+	for _, rule := range policy.GetRules() {
+		for _, clause := range rule.GetClauses() {
+			for _, match := range clause.GetMatches() {
+				fn(match)
+			}
+		}
+	}
+}
+
+// getMatchIps returns the compiled ips of the match, or nil if not compiled
+func (ps *policyStore) getMatchIps(match *security.Match) *matchIps {
+	ps.rwLock.RLock()
+	defer ps.rwLock.RUnlock()
+	return ps.ipsByMatch[match]
+}
+
 func (ps *policyStore) removePolicy(policyKey string) {
 	ps.rwLock.Lock()
 	defer ps.rwLock.Unlock()
@@ -82,6 +121,7 @@ func (ps *policyStore) removePolicy(policyKey string) {
 	}
 	// remove authPolicy from byKey
 	delete(ps.byKey, policyKey)
+	ps.compile(authPolicy, nil)
 
 	var ns string
 	switch authPolicy.Scope {
@@ -102,6 +142,13 @@ func (ps *policyStore) removePolicy(policyKey string) {
 	}
 }
 
+// get returns the policy of the key, or nil if not exists
+func (ps *policyStore) get(policyKey string) *security.Authorization {
+	ps.rwLock.RLock()
+	defer ps.rwLock.RUnlock()
+	return ps.byKey[policyKey]
+}
+
 // getAllPolicies returns a copied set of all policy names
 func (ps *policyStore) getAllPolicies() map[string]string {
 	ps.rwLock.RLock()
@@ -0,0 +1,158 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"net"
+
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+// trieNode is a node of a binary trie, each level of which is a bit of the ip.
+type trieNode struct {
+	children [2]*trieNode
+	// terminal means a prefix ends at the node, so all the ips under it are contained
+	terminal bool
+}
+
+// prefixTrie is a set of ip prefixes, the lookup of an ip takes at most as many steps as the bits of the ip,
+// no matter how many prefixes there are.
+type prefixTrie struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+// newPrefixTrie builds the trie of the addresses, an address not forming a valid cidr is ignored,
+// which is the same as the linear matching of internalMatchSrcIp and internalMatchDstIp.
+func newPrefixTrie(addresses []*security.Address) *prefixTrie {
+	t := &prefixTrie{}
+	for _, addr := range addresses {
+		_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", net.IP(addr.GetAddress()).String(), addr.GetLength()))
+		if err != nil {
+			continue
+		}
+		t.insert(ipNet)
+	}
+	return t
+}
+
+func (t *prefixTrie) insert(ipNet *net.IPNet) {
+	ones, _ := ipNet.Mask.Size()
+	root := &t.v6
+	if len(ipNet.IP) == net.IPv4len {
+		root = &t.v4
+	}
+	if *root == nil {
+		*root = &trieNode{}
+	}
+
+	node := *root
+	for i := 0; i < ones; i++ {
+		if node.terminal {
+			// a shorter prefix already contains this one
+			return
+		}
+		bit := ipBit(ipNet.IP, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	// the longer prefixes under it are contained
+	node.children = [2]*trieNode{}
+}
+
+// contains returns whether any prefix contains the ip, an IPv4-mapped IPv6 ip is matched as an IPv4 one,
+// following net.IPNet.Contains.
+func (t *prefixTrie) contains(ip []byte) bool {
+	if t == nil {
+		return false
+	}
+	node := t.v6
+	if v4 := net.IP(ip).To4(); v4 != nil {
+		ip = v4
+		node = t.v4
+	} else if len(ip) != net.IPv6len {
+		return false
+	}
+
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		node = node.children[ipBit(ip, i)]
+	}
+	return false
+}
+
+func ipBit(ip []byte, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
+
+// matchIps is the ips of a match compiled into prefix tries.
+type matchIps struct {
+	srcIps    *prefixTrie
+	notSrcIps *prefixTrie
+	dstIps    *prefixTrie
+	notDstIps *prefixTrie
+}
+
+func newMatchIps(match *security.Match) *matchIps {
+	if len(match.GetSourceIps()) == 0 && len(match.GetNotSourceIps()) == 0 &&
+		len(match.GetDestinationIps()) == 0 && len(match.GetNotDestinationIps()) == 0 {
+		return nil
+	}
+	return &matchIps{
+		srcIps:    newPrefixTrie(match.GetSourceIps()),
+		notSrcIps: newPrefixTrie(match.GetNotSourceIps()),
+		dstIps:    newPrefixTrie(match.GetDestinationIps()),
+		notDstIps: newPrefixTrie(match.GetNotDestinationIps()),
+	}
+}
+
+func (m *matchIps) containsSrcIp(ip []byte, match *security.Match) bool {
+	if m == nil {
+		return internalMatchSrcIp(ip, match.GetSourceIps())
+	}
+	return m.srcIps.contains(ip)
+}
+
+func (m *matchIps) containsNotSrcIp(ip []byte, match *security.Match) bool {
+	if m == nil {
+		return internalMatchSrcIp(ip, match.GetNotSourceIps())
+	}
+	return m.notSrcIps.contains(ip)
+}
+
+func (m *matchIps) containsDstIp(ip []byte, match *security.Match) bool {
+	if m == nil {
+		return internalMatchDstIp(ip, match.GetDestinationIps())
+	}
+	return m.dstIps.contains(ip)
+}
+
+func (m *matchIps) containsNotDstIp(ip []byte, match *security.Match) bool {
+	if m == nil {
+		return internalMatchDstIp(ip, match.GetNotDestinationIps())
+	}
+	return m.notDstIps.contains(ip)
+}
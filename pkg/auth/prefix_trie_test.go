@@ -0,0 +1,169 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	addresses := []*security.Address{
+		{Address: net.ParseIP("10.0.0.0").To4(), Length: 8},
+		{Address: net.ParseIP("10.1.0.0").To4(), Length: 16},
+		{Address: net.ParseIP("192.168.1.1").To4(), Length: 32},
+		{Address: net.ParseIP("fd00::").To16(), Length: 16},
+		// IPv4-mapped IPv6 address is parsed as an IPv4 one
+		{Address: net.ParseIP("172.16.0.0").To16(), Length: 12},
+		// invalid ones are ignored
+		{Address: net.ParseIP("172.16.0.0").To16(), Length: 120},
+		{Address: net.ParseIP("11.0.0.0").To4(), Length: 33},
+		{Address: []byte{1, 2, 3}, Length: 8},
+		{},
+	}
+	trie := newPrefixTrie(addresses)
+
+	tests := []struct {
+		ip   net.IP
+		want bool
+	}{
+		{net.ParseIP("10.2.3.4").To4(), true},
+		{net.ParseIP("10.2.3.4").To16(), true},
+		{net.ParseIP("11.0.0.1").To4(), false},
+		{net.ParseIP("192.168.1.1").To4(), true},
+		{net.ParseIP("192.168.1.2").To4(), false},
+		{net.ParseIP("172.31.0.1").To4(), true},
+		{net.ParseIP("172.32.0.1").To4(), false},
+		{net.ParseIP("fd00::1"), true},
+		{net.ParseIP("fd01::1"), false},
+		{[]byte{10, 0, 0}, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trie.contains(tt.ip), tt.ip.String())
+		assert.Equal(t, tt.want, internalMatchSrcIp(tt.ip, addresses), tt.ip.String())
+	}
+
+	// a shorter prefix contains the longer ones, no matter the order inserted
+	trie = newPrefixTrie([]*security.Address{
+		{Address: net.ParseIP("10.1.2.0").To4(), Length: 24},
+		{Address: net.ParseIP("10.0.0.0").To4(), Length: 8},
+		{Address: net.ParseIP("10.1.0.0").To4(), Length: 16},
+	})
+	assert.True(t, trie.contains(net.ParseIP("10.200.0.1").To4()))
+
+	// /0 contains all the ips of the family
+	trie = newPrefixTrie([]*security.Address{{Address: net.IPv4zero.To4(), Length: 0}})
+	assert.True(t, trie.contains(net.ParseIP("1.2.3.4").To4()))
+	assert.False(t, trie.contains(net.ParseIP("fd00::1")))
+}
+
+func randomAddress(r *rand.Rand) *security.Address {
+	switch r.Intn(4) {
+	case 0:
+		ip := make([]byte, net.IPv6len)
+		r.Read(ip)
+		return &security.Address{Address: ip, Length: uint32(r.Intn(130))}
+	case 1:
+		// IPv4-mapped IPv6 address
+		ip := net.IPv4(byte(10+r.Intn(2)), byte(r.Intn(4)), byte(r.Intn(256)), byte(r.Intn(256)))
+		return &security.Address{Address: ip.To16(), Length: uint32(r.Intn(130))}
+	default:
+		ip := []byte{byte(10 + r.Intn(2)), byte(r.Intn(4)), byte(r.Intn(256)), byte(r.Intn(256))}
+		return &security.Address{Address: ip, Length: uint32(r.Intn(34))}
+	}
+}
+
+func randomIp(r *rand.Rand) net.IP {
+	switch r.Intn(3) {
+	case 0:
+		ip := make([]byte, net.IPv6len)
+		r.Read(ip)
+		// share the prefix with some of the addresses
+		ip[0] = byte(r.Intn(2))
+		return ip
+	case 1:
+		return net.IPv4(byte(10+r.Intn(2)), byte(r.Intn(4)), byte(r.Intn(256)), byte(r.Intn(256)))
+	default:
+		return []byte{byte(10 + r.Intn(2)), byte(r.Intn(4)), byte(r.Intn(256)), byte(r.Intn(256))}
+	}
+}
+
+// TestPrefixTrieEquivalence makes sure the prefix trie matches the same ips as the linear matching.
+func TestPrefixTrieEquivalence(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		addresses := make([]*security.Address, r.Intn(200))
+		for i := range addresses {
+			addresses[i] = randomAddress(r)
+		}
+		// IPv6 addresses sharing the prefix with the random ips
+		for i := range addresses {
+			if len(addresses[i].Address) == net.IPv6len && net.IP(addresses[i].Address).To4() == nil {
+				addresses[i].Address[0] = byte(r.Intn(2))
+			}
+		}
+		trie := newPrefixTrie(addresses)
+		for i := 0; i < 1000; i++ {
+			ip := randomIp(r)
+			if trie.contains(ip) != internalMatchDstIp(ip, addresses) {
+				t.Fatalf("ip %v: trie and linear matching differ, addresses %v", ip, addresses)
+			}
+		}
+	}
+}
+
+func TestPolicyStoreCompile(t *testing.T) {
+	match := &security.Match{
+		SourceIps:      []*security.Address{{Address: net.ParseIP("10.0.0.0").To4(), Length: 8}},
+		NotSourceIps:   []*security.Address{{Address: net.ParseIP("10.0.0.1").To4(), Length: 32}},
+		DestinationIps: []*security.Address{{Address: net.ParseIP("192.168.0.0").To4(), Length: 16}},
+	}
+	policy := &security.Authorization{
+		Name:      "ip",
+		Namespace: "ns1",
+		Scope:     security.Scope_NAMESPACE,
+		Action:    security.Action_ALLOW,
+		Rules: []*security.Rule{{
+			Clauses: []*security.Clause{{
+				Matches: []*security.Match{match, {DestinationPorts: []uint32{80}}},
+			}},
+		}},
+	}
+	ps := newPolicyStore()
+	assert.NoError(t, ps.updatePolicy(policy))
+	assert.Len(t, ps.ipsByMatch, 1)
+	assert.NotNil(t, ps.getMatchIps(match))
+
+	conn := &rbacConnection{srcIp: []byte{10, 0, 0, 2}, dstIp: []byte{192, 168, 1, 1}, dstPort: 8080}
+	assert.True(t, ps.matches(conn, policy))
+	conn.srcIp = []byte{10, 0, 0, 1}
+	assert.False(t, ps.matches(conn, policy))
+
+	// the compiled ips of the policy replaced are dropped
+	updated := &security.Authorization{Name: "ip", Namespace: "ns1", Scope: security.Scope_NAMESPACE}
+	assert.NoError(t, ps.updatePolicy(updated))
+	assert.Empty(t, ps.ipsByMatch)
+
+	assert.NoError(t, ps.updatePolicy(policy))
+	ps.removePolicy(policy.ResourceName())
+	assert.Empty(t, ps.ipsByMatch)
+}
@@ -24,16 +24,19 @@ import (
 	"net"
 	"net/netip"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/ringbuf"
+	"istio.io/istio/pkg/util/sets"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
 	"kmesh.net/kmesh/pkg/logger"
+	"kmesh.net/kmesh/pkg/utils"
 )
 
 const (
@@ -66,6 +69,13 @@ type Rbac struct {
 	policyStore   *policyStore
 	workloadCache cache.WorkloadCache
 	notifyFunc    notifyFunc
+	decisionCache *decisionCache
+
+	// dryRunPolicies is the keys of the policies evaluated without enforcement
+	dryRunPolicies sets.Set[string]
+	dryRunLock     sync.RWMutex
+
+	denyLog utils.Broadcaster[DenyRecord]
 }
 
 type Identity struct {
@@ -105,9 +115,11 @@ type bpfSockTupleV6 struct {
 
 func NewRbac(workloadCache cache.WorkloadCache) *Rbac {
 	return &Rbac{
-		policyStore:   newPolicyStore(),
-		workloadCache: workloadCache,
-		notifyFunc:    xdpNotifyConnRst,
+		policyStore:    newPolicyStore(),
+		workloadCache:  workloadCache,
+		notifyFunc:     xdpNotifyConnRst,
+		decisionCache:  newDecisionCache(decisionCacheSize),
+		dryRunPolicies: sets.New[string](),
 	}
 }
 
@@ -127,56 +139,41 @@ func (r *Rbac) Run(ctx context.Context, authReq, authRes *ebpf.Map) {
 	defer func() {
 		_ = reader.Close()
 	}()
-
-	rec := ringbuf.Record{}
-	var conn rbacConnection
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if err = reader.ReadInto(&rec); err != nil {
-				log.Errorf("km_auth_req read failed: %v", err)
-				continue
-			}
-			if len(rec.RawSample) != MSG_LEN {
-				log.Errorf("wrong length %v of a msg, should be %v", len(rec.RawSample), MSG_LEN)
-				continue
-			}
-			// RawSample is network order
-			msgType := binary.LittleEndian.Uint32(rec.RawSample)
-			tupleData := rec.RawSample[unsafe.Sizeof(msgType):]
-			buf := bytes.NewBuffer(tupleData)
-			switch msgType {
-			case constants.MSG_TYPE_IPV4:
-				conn, err = r.buildConnV4(buf)
-			case constants.MSG_TYPE_IPV6:
-				conn, err = r.buildConnV6(buf)
-			default:
-				log.Error("invalid msg type: ", msgType)
-				continue
-			}
-			if err != nil {
-				continue
-			}
-
-			if !r.doRbac(&conn) {
-				log.Debugf("Auth denied for connection: %+v", conn)
-				// If conn is denied, write tuples into XDP map, which includes source/destination IP/Port
-				if err = r.notifyFunc(authRes, msgType, tupleData); err != nil {
-					log.Error("km_auth_res update FAILED, err: ", err)
-				}
+	// closing the reader interrupts the blocking read once the context is done
+	stop := context.AfterFunc(ctx, func() {
+		_ = reader.Close()
+	})
+	defer stop()
+
+	requests := make(chan authRequest, max(authzQueueSize, 1))
+	var wg sync.WaitGroup
+	for range max(authzWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range requests {
+				r.handleRequest(req, authRes)
 			}
-		}
+		}()
 	}
+
+	r.readRequests(reader, requests)
+	close(requests)
+	wg.Wait()
 }
 
 func (r *Rbac) UpdatePolicy(auth *security.Authorization) error {
-	return r.policyStore.updatePolicy(auth)
+	// the decisions of both the workloads the policy applied to and the ones it applies to now are stale
+	old := r.policyStore.get(auth.ResourceName())
+	err := r.policyStore.updatePolicy(auth)
+	r.decisionCache.invalidatePolicy(old, auth)
+	return err
 }
 
 func (r *Rbac) RemovePolicy(policyKey string) {
+	old := r.policyStore.get(policyKey)
 	r.policyStore.removePolicy(policyKey)
+	r.decisionCache.invalidatePolicy(old)
 }
 
 // GetAllPolicies returns all policy names in the policy store
@@ -188,41 +185,77 @@ func (r *Rbac) GetAllPolicies() map[string]string {
 }
 
 func (r *Rbac) doRbac(conn *rbacConnection) bool {
-	var networkAddress cache.NetworkAddress
-	networkAddress.Network = conn.dstNetwork
-	networkAddress.Address, _ = netip.AddrFromSlice(conn.dstIp)
-	dstWorkload := r.workloadCache.GetWorkloadByAddr(networkAddress)
+	dstWorkload := r.getDstWorkload(conn)
 	// If no workload found, deny
 	if dstWorkload == nil {
 		log.Debugf("denied for connection: %v because destination workload not found", conn)
+		r.report(conn, nil, decision{reason: ReasonNoWorkload})
 		return false
 	}
 
-	// TODO: maybe cache them for performance issue
+	key := newDecisionKey(conn)
+	d, ok, generation := r.decisionCache.get(key, dstWorkload)
+	if !ok {
+		d = r.evaluate(conn, dstWorkload)
+		r.decisionCache.put(key, dstWorkload, d, generation)
+	}
+	r.report(conn, dstWorkload, d)
+	return d.allow
+}
+
+func (r *Rbac) getDstWorkload(conn *rbacConnection) *workloadapi.Workload {
+	var networkAddress cache.NetworkAddress
+	networkAddress.Network = conn.dstNetwork
+	networkAddress.Address, _ = netip.AddrFromSlice(conn.dstIp)
+	return r.workloadCache.GetWorkloadByAddr(networkAddress)
+}
+
+// decision is the result of applying the policies of the destination workload to a connection.
+type decision struct {
+	allow bool
+	// policy is the policy the decision is made by, it is empty if no single policy is accountable
+	policy string
+	reason string
+	// dryRunPolicy is the dry-run policy which would have denied the connection if it were enforced
+	dryRunPolicy string
+	dryRunReason string
+}
+
+// evaluate applies the policies of the destination workload to the connection,
+// the dry-run policies are evaluated apart and never affect whether the connection is allowed.
+func (r *Rbac) evaluate(conn *rbacConnection, dstWorkload *workloadapi.Workload) decision {
 	allowPolicies, denyPolicies := r.aggregate(dstWorkload)
+	allowPolicies, dryRunAllowPolicies := r.splitDryRun(allowPolicies)
+	denyPolicies, dryRunDenyPolicies := r.splitDryRun(denyPolicies)
 
+	d := r.decide(conn, allowPolicies, denyPolicies)
+	d.dryRunPolicy, d.dryRunReason = r.dryRunDenyPolicy(conn, dryRunAllowPolicies, dryRunDenyPolicies)
+	return d
+}
+
+func (r *Rbac) decide(conn *rbacConnection, allowPolicies, denyPolicies []*security.Authorization) decision {
 	// 1. If there is ANY deny policy, deny the request
 	for _, denyPolicy := range denyPolicies {
-		if matches(conn, denyPolicy) {
-			log.Infof("Auth denied for connection: %+v because authorization policy", conn)
-			return false
+		if r.policyStore.matches(conn, denyPolicy) {
+			log.Debugf("Auth denied for connection: %+v because authorization policy %s", conn, denyPolicy.ResourceName())
+			return decision{policy: denyPolicy.ResourceName(), reason: ReasonDenyMatched}
 		}
 	}
 
 	// 2. If there is NO allow policy for the workload, allow the request
 	if len(allowPolicies) == 0 {
-		return true
+		return decision{allow: true, reason: ReasonNoPolicy}
 	}
 
 	// 3. If there is ANY allow policy matched, allow the request
 	for _, allowPolicy := range allowPolicies {
-		if matches(conn, allowPolicy) {
-			return true
+		if r.policyStore.matches(conn, allowPolicy) {
+			return decision{allow: true, policy: allowPolicy.ResourceName(), reason: ReasonAllowMatched}
 		}
 	}
 
 	// 4. If 1,2 and 3 unsatisfied, deny the request
-	return false
+	return decision{reason: ReasonNoAllowMatched}
 }
 
 func (r *Rbac) aggregate(workload *workloadapi.Workload) (allowPolicies, denyPolicies []*security.Authorization) {
@@ -235,7 +268,7 @@ func (r *Rbac) aggregate(workload *workloadapi.Workload) (allowPolicies, denyPol
 	policyNames = append(policyNames, r.policyStore.getByNamespace("")...)
 
 	for _, policyName := range policyNames {
-		if policy, ok := r.policyStore.byKey[policyName]; ok {
+		if policy := r.policyStore.get(policyName); policy != nil {
 			if policy.Action == security.Action_ALLOW {
 				allowPolicies = append(allowPolicies, policy)
 			} else if policy.Action == security.Action_DENY {
@@ -246,7 +279,7 @@ func (r *Rbac) aggregate(workload *workloadapi.Workload) (allowPolicies, denyPol
 	return
 }
 
-func matches(conn *rbacConnection, policy *security.Authorization) bool {
+func (ps *policyStore) matches(conn *rbacConnection, policy *security.Authorization) bool {
 	if policy.GetRules() == nil {
 		return false
 	}
@@ -265,7 +298,8 @@ func matches(conn *rbacConnection, policy *security.Authorization) bool {
 
 				// Values of specific type are OR-ed. If multiple types are set, they are AND-ed
 				// If one type fails to match, we do a short circuit
-				if matchDstIp(conn.dstIp, match) && matchSrcIp(conn.srcIp, match) &&
+				ips := ps.getMatchIps(match)
+				if matchDstIp(conn.dstIp, match, ips) && matchSrcIp(conn.srcIp, match, ips) &&
 					matchDstPort(conn.dstPort, match) && matchPrincipal(conn.srcIdentity.String(), match) &&
 					matchNamespace(conn.srcIdentity.namespace, match) {
 					clauseMatch = true
@@ -289,40 +323,42 @@ func matches(conn *rbacConnection, policy *security.Authorization) bool {
 	return false
 }
 
-func matchDstIp(dstIp []byte, match *security.Match) bool {
+// matchDstIp matches the destination ip with the compiled ips of the match, or the ips of the match if not compiled
+func matchDstIp(dstIp []byte, match *security.Match, ips *matchIps) bool {
 	var pm, nm bool
 	// Positive match means if ANY destination IP in destination_ips contains dstIp, it does match
 	// If there is no destination IP in destination_ips, it does match
 	if len(match.GetDestinationIps()) == 0 {
 		pm = true
 	} else {
-		pm = internalMatchDstIp(dstIp, match.GetDestinationIps())
+		pm = ips.containsDstIp(dstIp, match)
 	}
 	// Negative match means if ANY destination IP in not_destination_ips contains dstIp, it does NOT match
 	// If there is no destination IP in destination_ips, it does match
 	if len(match.GetNotDestinationIps()) == 0 {
 		nm = true
 	} else {
-		nm = !internalMatchDstIp(dstIp, match.GetNotDestinationIps())
+		nm = !ips.containsNotDstIp(dstIp, match)
 	}
 	return pm && nm
 }
 
-func matchSrcIp(srcIp []byte, match *security.Match) bool {
+// matchSrcIp matches the source ip with the compiled ips of the match, or the ips of the match if not compiled
+func matchSrcIp(srcIp []byte, match *security.Match, ips *matchIps) bool {
 	var pm, nm bool
 	// Positive match means if ANY source IP in source_ips contains srcIp, it does match
 	// If there is no source IP in source_ips, it does match
 	if len(match.GetSourceIps()) == 0 {
 		pm = true
 	} else {
-		pm = internalMatchSrcIp(srcIp, match.GetSourceIps())
+		pm = ips.containsSrcIp(srcIp, match)
 	}
 	// Negative match means if ANY source IP in not_source_ips contains srcIp, it does NOT match
 	// If there is no source IP in not_source_ips, it does match
 	if len(match.GetNotSourceIps()) == 0 {
 		nm = true
 	} else {
-		nm = !internalMatchSrcIp(srcIp, match.GetNotSourceIps())
+		nm = !ips.containsNotSrcIp(srcIp, match)
 	}
 	return pm && nm
 }
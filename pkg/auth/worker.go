@@ -0,0 +1,139 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"slices"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"istio.io/pkg/env"
+
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+var (
+	authzWorkers = env.Register("KMESH_AUTHZ_WORKERS", runtime.NumCPU(),
+		"Number of workers evaluating the authorization requests from km_auth_req concurrently").Get()
+	authzQueueSize = env.Register("KMESH_AUTHZ_QUEUE_SIZE", 1024,
+		"Max number of authorization requests read from km_auth_req waiting for a worker, "+
+			"the reading is blocked once it is full and the requests are left in the ring buffer").Get()
+)
+
+const (
+	// readRetryInterval is the interval to retry reading km_auth_req after a read error, doubled on each
+	// consecutive error up to maxReadRetryInterval, so a persistent error does not spin the reader.
+	readRetryInterval    = 10 * time.Millisecond
+	maxReadRetryInterval = time.Second
+)
+
+// authRequest is a record read from km_auth_req, waiting to be evaluated by a worker.
+type authRequest struct {
+	msgType uint32
+	// tuple is copied from the record, as the record buffer is reused by the next read
+	tuple []byte
+}
+
+// readRequests reads the records from km_auth_req and queues them for the workers, until the reader is closed.
+func (r *Rbac) readRequests(reader *ringbuf.Reader, requests chan<- authRequest) {
+	rec := ringbuf.Record{}
+	failures := 0
+	for {
+		if err := reader.ReadInto(&rec); err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			failures++
+			retryInterval := readRetryBackoff(failures)
+			log.Errorf("km_auth_req read failed: %v, retry in %v", err, retryInterval)
+			telemetry.IncAuthzRequestDropped("read_error")
+			time.Sleep(retryInterval)
+			continue
+		}
+		failures = 0
+		telemetry.SetAuthzRingbufLag(rec.Remaining)
+		if len(rec.RawSample) != MSG_LEN {
+			log.Errorf("wrong length %v of a msg, should be %v", len(rec.RawSample), MSG_LEN)
+			telemetry.IncAuthzRequestDropped("invalid_length")
+			continue
+		}
+
+		// RawSample is network order
+		msgType := binary.LittleEndian.Uint32(rec.RawSample)
+		req := authRequest{
+			msgType: msgType,
+			tuple:   slices.Clone(rec.RawSample[unsafe.Sizeof(msgType):]),
+		}
+		select {
+		case requests <- req:
+		default:
+			// all the workers are busy, wait rather than drop the request, which would let the connection through
+			telemetry.IncAuthzQueueFull()
+			requests <- req
+		}
+	}
+}
+
+// readRetryBackoff returns the interval to wait after the consecutive read errors.
+func readRetryBackoff(failures int) time.Duration {
+	interval := readRetryInterval
+	for i := 1; i < failures && interval < maxReadRetryInterval; i++ {
+		interval *= 2
+	}
+	return min(interval, maxReadRetryInterval)
+}
+
+// handleRequest evaluates the request, and notifies the bpf prog to reset the connection if denied.
+func (r *Rbac) handleRequest(req authRequest, authRes *ebpf.Map) {
+	var (
+		conn rbacConnection
+		err  error
+	)
+	buf := bytes.NewBuffer(req.tuple)
+	switch req.msgType {
+	case constants.MSG_TYPE_IPV4:
+		conn, err = r.buildConnV4(buf)
+	case constants.MSG_TYPE_IPV6:
+		conn, err = r.buildConnV6(buf)
+	default:
+		log.Error("invalid msg type: ", req.msgType)
+		telemetry.IncAuthzRequestDropped("invalid_type")
+		return
+	}
+	if err != nil {
+		telemetry.IncAuthzRequestDropped("decode_error")
+		return
+	}
+
+	start := time.Now()
+	allow := r.doRbac(&conn)
+	telemetry.ObserveAuthzEvaluationDuration(time.Since(start))
+	if !allow {
+		log.Debugf("Auth denied for connection: %+v", conn)
+		// If conn is denied, write tuples into XDP map, which includes source/destination IP/Port
+		if err = r.notifyFunc(authRes, req.msgType, req.tuple); err != nil {
+			log.Error("km_auth_res update FAILED, err: ", err)
+		}
+	}
+}
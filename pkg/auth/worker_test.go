@@ -0,0 +1,110 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestRbacRunShutdown(t *testing.T) {
+	authReq, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.RingBuf,
+		MaxEntries: 4096,
+	})
+	if err != nil {
+		t.Skipf("create ringbuf failed: %v", err)
+	}
+	defer authReq.Close()
+	authRes, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    uint32(TUPLE_LEN),
+		ValueSize:  4,
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Skipf("create hash map failed: %v", err)
+	}
+	defer authRes.Close()
+
+	r := NewRbac(cache.NewWorkloadCache())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, authReq, authRes)
+		close(done)
+	}()
+
+	// the blocking read is interrupted once the context is done
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run does not return after the context is done")
+	}
+}
+
+func TestHandleRequest(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:       "cluster0//Pod/ns1/dst",
+		Namespace: "ns1",
+		Addresses: [][]byte{{10, 0, 0, 1}},
+	})
+	var notified atomic.Int32
+	r := NewRbac(workloadCache)
+	r.notifyFunc = func(_ *ebpf.Map, _ uint32, _ []byte) error {
+		notified.Add(1)
+		return nil
+	}
+
+	tuple := func(dst byte) []byte {
+		b := make([]byte, TUPLE_LEN)
+		copy(b, []byte{10, 0, 0, 2, 10, 0, 0, dst})
+		nativeEndian.PutUint16(b[10:], 80)
+		return b
+	}
+
+	// allowed as no policy applies
+	r.handleRequest(authRequest{msgType: constants.MSG_TYPE_IPV4, tuple: tuple(1)}, nil)
+	assert.Equal(t, int32(0), notified.Load())
+
+	// denied as the destination workload is not found
+	r.handleRequest(authRequest{msgType: constants.MSG_TYPE_IPV4, tuple: tuple(3)}, nil)
+	assert.Equal(t, int32(1), notified.Load())
+
+	// dropped as the msg type is invalid
+	r.handleRequest(authRequest{msgType: 9, tuple: tuple(3)}, nil)
+	assert.Equal(t, int32(1), notified.Load())
+}
+
+func TestReadRetryBackoff(t *testing.T) {
+	assert.Equal(t, readRetryInterval, readRetryBackoff(1))
+	assert.Equal(t, 2*readRetryInterval, readRetryBackoff(2))
+	assert.Equal(t, 8*readRetryInterval, readRetryBackoff(4))
+	assert.Equal(t, maxReadRetryInterval, readRetryBackoff(100))
+}
@@ -20,6 +20,8 @@
 package cache_v2
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/cilium/ebpf"
@@ -27,6 +29,7 @@ import (
 
 	cluster_v2 "kmesh.net/kmesh/api/v2/cluster"
 	core_v2 "kmesh.net/kmesh/api/v2/core"
+	endpoint_v2 "kmesh.net/kmesh/api/v2/endpoint"
 	bpfads "kmesh.net/kmesh/pkg/bpf/ads"
 	"kmesh.net/kmesh/pkg/bpf/restart"
 	maps_v2 "kmesh.net/kmesh/pkg/cache/v2/maps"
@@ -115,6 +118,19 @@ func (cache *ClusterCache) UpdateApiClusterIfExists(key string, value *cluster_v
 	return true
 }
 
+// UpdateApiClusterLoadAssignment updates the load assignment and status of the api cluster if it exists
+func (cache *ClusterCache) UpdateApiClusterLoadAssignment(key string, status core_v2.ApiStatus, loadAssignment *endpoint_v2.ClusterLoadAssignment) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cluster := cache.apiClusterCache[key]
+	if cluster == nil {
+		return false
+	}
+	cluster.ApiStatus = status
+	cluster.LoadAssignment = loadAssignment
+	return true
+}
+
 func (cache *ClusterCache) UpdateApiClusterStatus(key string, status core_v2.ApiStatus) {
 	cache.mutex.Lock()
 	defer cache.mutex.Unlock()
@@ -185,37 +201,56 @@ func (cache *ClusterCache) clearClusterStats(clusterName string) {
 }
 
 // Flush flushes the cluster to bpf map.
-func (cache *ClusterCache) Flush() {
+func (cache *ClusterCache) Flush() error {
+	var errs []error
 	cache.mutex.Lock()
 	defer cache.mutex.Unlock()
 	for name, cluster := range cache.apiClusterCache {
-		if cluster.GetApiStatus() == core_v2.ApiStatus_UPDATE {
-			cluster.Id = cache.hashName.StrToNum(name)
-			err := maps_v2.ClusterUpdate(name, cluster)
-			if cluster.GetLbPolicy() == cluster_v2.Cluster_MAGLEV {
-				// create consistent lb here and update table to bpf map
-				if err := maglev.CreateLB(cluster); err != nil {
-					log.Errorf("maglev lb update %v cluster failed: %v", name, err)
-				}
-			}
-			if err == nil {
-				// reset api status after successfully updated
-				cluster.ApiStatus = core_v2.ApiStatus_NONE
-			} else {
-				log.Errorf("cluster %s %s flush failed: %v", name, cluster.ApiStatus, err)
-			}
-		} else if cluster.GetApiStatus() == core_v2.ApiStatus_DELETE {
-			cache.clearClusterStats(name)
-			err := maps_v2.ClusterDelete(name)
-			if err == nil {
-				delete(cache.apiClusterCache, name)
-				delete(cache.resourceHash, name)
-				cache.hashName.Delete(name)
-			} else {
-				log.Errorf("cluster %s delete failed: %v", name, err)
+		if err := cache.flushClusterLocked(name, cluster); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FlushCluster flushes a single cluster to bpf map, the other clusters are left as is.
+func (cache *ClusterCache) FlushCluster(name string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cluster, ok := cache.apiClusterCache[name]
+	if !ok {
+		return nil
+	}
+	return cache.flushClusterLocked(name, cluster)
+}
+
+func (cache *ClusterCache) flushClusterLocked(name string, cluster *cluster_v2.Cluster) error {
+	if cluster.GetApiStatus() == core_v2.ApiStatus_UPDATE {
+		cluster.Id = cache.hashName.StrToNum(name)
+		err := maps_v2.ClusterUpdate(name, cluster)
+		if cluster.GetLbPolicy() == cluster_v2.Cluster_MAGLEV {
+			// create consistent lb here and update table to bpf map
+			if err := maglev.CreateLB(cluster); err != nil {
+				log.Errorf("maglev lb update %v cluster failed: %v", name, err)
 			}
 		}
+		if err != nil {
+			log.Errorf("cluster %s %s flush failed: %v", name, cluster.ApiStatus, err)
+			return fmt.Errorf("cluster %s: %v", name, err)
+		}
+		// reset api status after successfully updated
+		cluster.ApiStatus = core_v2.ApiStatus_NONE
+	} else if cluster.GetApiStatus() == core_v2.ApiStatus_DELETE {
+		cache.clearClusterStats(name)
+		if err := maps_v2.ClusterDelete(name); err != nil {
+			log.Errorf("cluster %s delete failed: %v", name, err)
+			return fmt.Errorf("cluster %s: %v", name, err)
+		}
+		delete(cache.apiClusterCache, name)
+		delete(cache.resourceHash, name)
+		cache.hashName.Delete(name)
 	}
+	return nil
 }
 
 // Delete delete the clusters marked Delete status.
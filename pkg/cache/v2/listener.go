@@ -20,6 +20,8 @@
 package cache_v2
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -115,11 +117,12 @@ func (cache *ListenerCache) AddOrUpdateLdsHash(key string, value uint64) {
 	cache.resourceHash[key] = value
 }
 
-func (cache *ListenerCache) Flush() {
-	var err error
+func (cache *ListenerCache) Flush() error {
+	var errs []error
 	cache.mutex.Lock()
 	defer cache.mutex.Unlock()
 	for name, listener := range cache.apiListenerCache {
+		var err error
 		switch listener.GetApiStatus() {
 		case core_v2.ApiStatus_UPDATE:
 			err = maps_v2.ListenerUpdate(listener.GetAddress(), listener)
@@ -136,8 +139,10 @@ func (cache *ListenerCache) Flush() {
 		}
 		if err != nil {
 			log.Errorf("listener %s %s flush failed: %v", name, listener.ApiStatus, err)
+			errs = append(errs, fmt.Errorf("listener %s: %v", name, err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
 func (cache *ListenerCache) Dump() []*listener_v2.Listener {
@@ -17,6 +17,8 @@
 package cache_v2
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -96,11 +98,12 @@ func (cache *RouteConfigCache) SetRdsHash(key string, value uint64) {
 	cache.resourceHash[key] = value
 }
 
-func (cache *RouteConfigCache) Flush() {
-	var err error
+func (cache *RouteConfigCache) Flush() error {
+	var errs []error
 	cache.mutex.Lock()
 	defer cache.mutex.Unlock()
 	for name, route := range cache.apiRouteConfigCache {
+		var err error
 		switch route.GetApiStatus() {
 		case core_v2.ApiStatus_UPDATE:
 			// Skip routes with no VirtualHosts - they are invalid
@@ -122,8 +125,10 @@ func (cache *RouteConfigCache) Flush() {
 		}
 		if err != nil {
 			log.Errorf("routeConfig %s %s flush failed: %v", name, route.ApiStatus, err)
+			errs = append(errs, fmt.Errorf("route %s: %v", name, err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
 func (cache *RouteConfigCache) Dump() []*route_v2.RouteConfiguration {
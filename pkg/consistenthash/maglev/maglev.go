@@ -49,7 +49,6 @@ var (
 )
 
 type Backend struct {
-	ep     *endpoint.Endpoint
 	index  int
 	offset uint64
 	skip   uint64
@@ -66,6 +65,10 @@ func InitMaglevMap() error {
 	}
 	outer = outer_map
 
+	return initHashSeed()
+}
+
+func initHashSeed() error {
 	d, err := base64.StdEncoding.DecodeString(DefaultHashSeed)
 	if err != nil {
 		return fmt.Errorf("cannot decode base64 Maglev hash seed %q: %w", DefaultHashSeed, err)
@@ -74,7 +77,6 @@ func InitMaglevMap() error {
 		return fmt.Errorf("decoded hash seed is %d bytes (not 12 bytes)", len(d))
 	}
 	seedMurmur = uint32(d[0])<<24 | uint32(d[1])<<16 | uint32(d[2])<<8 | uint32(d[3])
-
 	return nil
 }
 
@@ -124,16 +126,6 @@ func updateMaglevTable(backendIDs []uint32, clusterName string) error {
 	if outer == nil {
 		return errors.New("outer maglev maps not yet initialized")
 	}
-	inner, err := createMaglevInnerMap(uint32(maglevTableSize))
-	if err != nil {
-		return err
-	}
-	defer inner.Close()
-
-	var key uint32 = 0
-	if err := inner.Update(key, backendIDs, 0); err != nil {
-		return fmt.Errorf("updating backends of cluster %v : %w", clusterName, err)
-	}
 
 	if len(clusterName) > ClusterNameMaxLen {
 		clusterName = clusterName[:ClusterNameMaxLen]
@@ -141,12 +133,31 @@ func updateMaglevTable(backendIDs []uint32, clusterName string) error {
 	var maglevKey [ClusterNameMaxLen]byte
 	copy(maglevKey[:], []byte(clusterName))
 
-	if err := outer.Update(maglevKey, uint32(inner.FD()), 0); err != nil {
+	if err := UpdateTable(outer, maglevKey, backendIDs); err != nil {
 		return fmt.Errorf("updating cluster %v: %w", clusterName, err)
 	}
 	return nil
 }
 
+// UpdateTable stores the lookup table into a new inner map, and replaces the inner map of key in the outer map with it.
+func UpdateTable(outerMap *ebpf.Map, key any, table []uint32) error {
+	inner, err := createMaglevInnerMap(uint32(len(table)))
+	if err != nil {
+		return err
+	}
+	defer inner.Close()
+
+	var innerKey uint32 = 0
+	if err := inner.Update(innerKey, table, 0); err != nil {
+		return fmt.Errorf("updating inner map: %w", err)
+	}
+
+	if err := outerMap.Update(key, uint32(inner.FD()), 0); err != nil {
+		return err
+	}
+	return nil
+}
+
 func getOffsetAndSkip(address string, m uint64) (uint64, uint64) {
 	h1, h2 := hash.Hash128([]byte(address), seedMurmur)
 	offset := h1 % m
@@ -155,8 +166,8 @@ func getOffsetAndSkip(address string, m uint64) (uint64, uint64) {
 	return offset, skip
 }
 
-func getPermutation(b Backend) uint64 {
-	return (b.offset + (b.skip * b.next)) % maglevTableSize
+func getPermutation(b Backend, m uint64) uint64 {
+	return (b.offset + (b.skip * b.next)) % m
 }
 
 func getLookupTable(cluster *cluster_v2.Cluster, tableSize uint64) ([]int, error) {
@@ -175,12 +186,34 @@ func getLookupTable(cluster *cluster_v2.Cluster, tableSize uint64) ([]int, error
 		eps := localityLbEp.GetLbEndpoints()
 		flatEps = append(flatEps, eps...)
 	}
-	backends := make([]Backend, 0, len(flatEps))
+	names := make([]string, 0, len(flatEps))
+	for _, ep := range flatEps {
+		names = append(names, ep.GetAddress().String())
+	}
 
-	for i, ep := range flatEps {
-		epOffset, epSkip := getOffsetAndSkip(ep.GetAddress().String(), maglevTableSize)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("current cluster:%v has no any lb backends", clusterName)
+	}
+
+	return NewLookupTable(names, tableSize)
+}
+
+// NewLookupTable builds the maglev lookup table of the backends identified by names,
+// each element of the table is the index of the selected backend in names.
+func NewLookupTable(names []string, tableSize uint64) ([]int, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no backends to build the lookup table")
+	}
+	if seedMurmur == 0 {
+		if err := initHashSeed(); err != nil {
+			return nil, err
+		}
+	}
+
+	backends := make([]Backend, 0, len(names))
+	for i, name := range names {
+		epOffset, epSkip := getOffsetAndSkip(name, tableSize)
 		b := Backend{
-			ep:     ep,
 			index:  i,
 			offset: epOffset,
 			skip:   epSkip,
@@ -189,10 +222,6 @@ func getLookupTable(cluster *cluster_v2.Cluster, tableSize uint64) ([]int, error
 		backends = append(backends, b)
 	}
 
-	if len(backends) == 0 {
-		return nil, fmt.Errorf("current cluster:%v has no any lb backends", clusterName)
-	}
-
 	length := len(backends)
 	lookUpTable := make([]int, tableSize)
 
@@ -202,12 +231,12 @@ func getLookupTable(cluster *cluster_v2.Cluster, tableSize uint64) ([]int, error
 
 	for n := uint64(0); n < tableSize; n++ {
 		j := int(n) % length
-		b := backends[j]
+		b := &backends[j]
 		for {
-			c := getPermutation(b)
+			c := getPermutation(*b, tableSize)
 			for lookUpTable[c] >= 0 {
 				b.next++
-				c = getPermutation(b)
+				c = getPermutation(*b, tableSize)
 			}
 			lookUpTable[c] = b.index
 			b.next++
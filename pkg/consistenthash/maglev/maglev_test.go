@@ -23,6 +23,7 @@ import (
 	"unsafe"
 
 	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
 	cluster_v2 "kmesh.net/kmesh/api/v2/cluster"
@@ -108,6 +109,63 @@ func (suite *MaglevTestSuite) TestGetLookupTable() {
 	}
 }
 
+func TestNewLookupTable(t *testing.T) {
+	names := []string{"backend-0", "backend-1", "backend-2", "backend-3"}
+	table, err := NewLookupTable(names, DefaultTableSize)
+	assert.NoError(t, err)
+	assert.Len(t, table, int(DefaultTableSize))
+
+	counts := make([]int, len(names))
+	for _, index := range table {
+		counts[index]++
+	}
+	// maglev spreads the table evenly among the backends
+	for _, count := range counts {
+		assert.InDelta(t, int(DefaultTableSize)/len(names), count, 1)
+	}
+
+	// removing a backend only moves the entries of the removed backend
+	newTable, err := NewLookupTable(names[:3], DefaultTableSize)
+	assert.NoError(t, err)
+	moved := 0
+	for i := range table {
+		if table[i] != 3 && table[i] != newTable[i] {
+			moved++
+		}
+	}
+	assert.Less(t, moved, int(DefaultTableSize)/20)
+
+	_, err = NewLookupTable(nil, DefaultTableSize)
+	assert.Error(t, err)
+}
+
+func TestNewLookupTableUnchanged(t *testing.T) {
+	// the table built before, which probed each permutation from its start on every turn
+	probeFromStart := func(names []string, tableSize uint64) []int {
+		table := make([]int, tableSize)
+		for i := range table {
+			table[i] = -1
+		}
+		for n := uint64(0); n < tableSize; n++ {
+			j := int(n) % len(names)
+			offset, skip := getOffsetAndSkip(names[j], tableSize)
+			b := Backend{index: j, offset: offset, skip: skip}
+			for table[getPermutation(b, tableSize)] >= 0 {
+				b.next++
+			}
+			table[getPermutation(b, tableSize)] = j
+		}
+		return table
+	}
+
+	names := []string{"10.244.0.1:80", "10.244.0.2:80", "10.244.1.1:80", "10.244.1.2:80", "10.244.2.1:80"}
+	for n := 1; n <= len(names); n++ {
+		table, err := NewLookupTable(names[:n], DefaultTableSize)
+		assert.NoError(t, err)
+		assert.Equal(t, probeFromStart(names[:n], DefaultTableSize), table)
+	}
+}
+
 func newCluster() *cluster_v2.Cluster {
 	var clusterName string = "outbound|5000||helloworld.default.svc.cluster.local"
 	lbEndpoints := make([]*endpoint.Endpoint, 0)
@@ -26,6 +26,10 @@ const (
 	DataPlaneModeKmesh = "kmesh"
 	// This annotation is used to indicate traffic redirection settings specific to Kmesh
 	KmeshRedirectionAnnotation = "kmesh.net/redirection"
+	// This annotation on a Service selects its load balancing algorithm in dual-engine mode
+	KmeshLbPolicyAnnotation = "kmesh.net/lb-policy"
+	// KmeshLbPolicyMaglev is the value of KmeshLbPolicyAnnotation to enable maglev consistent hash
+	KmeshLbPolicyMaglev = "maglev"
 
 	XDP_PROG_NAME = "xdp_authz"
 	ENABLED       = uint32(1)
@@ -25,6 +25,8 @@ import (
 	"istio.io/istio/pkg/channels"
 
 	bpfads "kmesh.net/kmesh/pkg/bpf/ads"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/config"
 	"kmesh.net/kmesh/pkg/logger"
 )
 
@@ -36,12 +38,18 @@ type Controller struct {
 	Processor             *processor
 	dnsResolverController *dnsController
 	con                   *connection
+	// use the incremental xds if enabled, unless the discovery server does not support it
+	enableDelta      bool
+	deltaUnsupported bool
 }
 
 type connection struct {
 	Stream       service_discovery_v3.AggregatedDiscoveryService_StreamAggregatedResourcesClient
 	requestsChan *channels.Unbounded[*service_discovery_v3.DiscoveryRequest]
 	stopCh       chan struct{}
+
+	DeltaStream       service_discovery_v3.AggregatedDiscoveryService_DeltaAggregatedResourcesClient
+	deltaRequestsChan *channels.Unbounded[*service_discovery_v3.DeltaDiscoveryRequest]
 }
 
 func NewController(bpfAds *bpfads.BpfAds) *Controller {
@@ -57,14 +65,22 @@ func NewController(bpfAds *bpfads.BpfAds) *Controller {
 	return &Controller{
 		dnsResolverController: dnsResolverController,
 		Processor:             processor,
+		enableDelta:           config.GetConfig(constants.KernelNativeMode).EnableDeltaAds,
 	}
 }
 
+func (c *Controller) isDelta() bool {
+	return c.enableDelta && !c.deltaUnsupported
+}
+
 func (c *Controller) AdsStreamCreateAndSend(client service_discovery_v3.AggregatedDiscoveryServiceClient, ctx context.Context) error {
 	if c.con != nil {
 		close(c.con.stopCh)
 	}
 
+	if c.isDelta() {
+		return c.deltaAdsStreamCreateAndSend(client, ctx)
+	}
 	stream, err := client.StreamAggregatedResources(ctx)
 	if err != nil {
 		return fmt.Errorf("StreamAggregatedResources failed, %s", err)
@@ -77,7 +93,7 @@ func (c *Controller) AdsStreamCreateAndSend(client service_discovery_v3.Aggregat
 	}
 
 	c.Processor.Reset()
-	if err := stream.Send(newAdsRequest(resource_v3.ClusterType, nil, "")); err != nil {
+	if err := stream.Send(c.Processor.newRequest(resource_v3.ClusterType, nil)); err != nil {
 		return fmt.Errorf("send request failed, %s", err)
 	}
 	go sendUpstream(c.con)
@@ -90,6 +106,9 @@ func (c *Controller) HandleAdsStream() error {
 		err error
 		rsp *service_discovery_v3.DiscoveryResponse
 	)
+	if c.con.DeltaStream != nil {
+		return c.handleDeltaAdsStream()
+	}
 	if rsp, err = c.con.Stream.Recv(); err != nil {
 		_ = c.con.Stream.CloseSend()
 		return fmt.Errorf("stream recv failed, %s", err)
@@ -108,6 +127,14 @@ func (c *Controller) HandleAdsStream() error {
 	return nil
 }
 
+// HandleResponse applies a response which is not received from the ads stream, such as the one loaded from local files.
+func (c *Controller) HandleResponse(rsp *service_discovery_v3.DiscoveryResponse) {
+	c.dnsResolverController.newClusterCache()
+	c.Processor.processAdsResponse(rsp)
+	// all the resources are pushed, no need to subscribe
+	c.Processor.req = nil
+}
+
 func sendUpstream(con *connection) {
 	for {
 		select {
@@ -124,10 +151,25 @@ func sendUpstream(con *connection) {
 }
 
 func (c *Controller) Close() {
+	if c.Processor != nil {
+		c.Processor.healthChecker.stop()
+	}
 	if c.con != nil {
 		close(c.con.stopCh)
-		_ = c.con.Stream.CloseSend()
+		if c.con.DeltaStream != nil {
+			_ = c.con.DeltaStream.CloseSend()
+		} else {
+			_ = c.con.Stream.CloseSend()
+		}
+	}
+}
+
+// EndpointHealth returns the health status of the endpoints probed by the active health check.
+func (c *Controller) EndpointHealth() []EndpointHealth {
+	if c.Processor == nil {
+		return nil
 	}
+	return c.Processor.healthChecker.dump()
 }
 
 func (c *Controller) StartDnsController(stopCh <-chan struct{}) {
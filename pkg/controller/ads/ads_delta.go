@@ -0,0 +1,423 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"istio.io/istio/pkg/channels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	core_v2 "kmesh.net/kmesh/api/v2/core"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/config"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	"kmesh.net/kmesh/pkg/utils/hash"
+)
+
+// deltaState is the state kept for the incremental xds, the resources are only pushed when changed,
+// so what a state of the world response carries is rebuilt from the resources received.
+type deltaState struct {
+	// all the clusters received, used to tell the eds and dns typed clusters
+	clusters map[string]*config_cluster_v3.Cluster
+	// the load assignment last applied of each eds cluster, an unchanged one is not pushed again
+	// after its cluster is changed, so it is restored from here
+	loadAssignments map[string]*deltaLoadAssignment
+	// route names referenced by each listener
+	listenerRouteNames map[string][]string
+	// versions of the resources received by type url, they are kept across streams
+	versions map[string]map[string]string
+	// names subscribed on the current stream by type url, the wildcard types subscribe nothing
+	subscribed map[string]sets.Set[string]
+}
+
+type deltaLoadAssignment struct {
+	loadAssignment *config_endpoint_v3.ClusterLoadAssignment
+	hash           uint64
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{
+		clusters:           make(map[string]*config_cluster_v3.Cluster),
+		loadAssignments:    make(map[string]*deltaLoadAssignment),
+		listenerRouteNames: make(map[string][]string),
+		versions:           make(map[string]map[string]string),
+		subscribed:         make(map[string]sets.Set[string]),
+	}
+}
+
+func newDeltaAdsRequest(typeUrl string) *service_discovery_v3.DeltaDiscoveryRequest {
+	return &service_discovery_v3.DeltaDiscoveryRequest{
+		TypeUrl: typeUrl,
+		Node:    config.GetConfig(constants.KernelNativeMode).GetNode(),
+	}
+}
+
+func newDeltaAckRequest(resp *service_discovery_v3.DeltaDiscoveryResponse) *service_discovery_v3.DeltaDiscoveryRequest {
+	return &service_discovery_v3.DeltaDiscoveryRequest{
+		TypeUrl:       resp.GetTypeUrl(),
+		ResponseNonce: resp.GetNonce(),
+		Node:          config.GetConfig(constants.KernelNativeMode).GetNode(),
+	}
+}
+
+func (d *deltaState) setVersion(typeUrl, name, version string) {
+	versions := d.versions[typeUrl]
+	if versions == nil {
+		versions = make(map[string]string)
+		d.versions[typeUrl] = versions
+	}
+	versions[name] = version
+}
+
+func (d *deltaState) deleteVersion(typeUrl, name string) {
+	delete(d.versions[typeUrl], name)
+}
+
+// subscribe returns the request to change the names subscribed of the type, or nil if nothing changes.
+// The first request of a type on a stream carries the versions of the resources received, so that the
+// server only pushes the changed ones.
+func (d *deltaState) subscribe(typeUrl string, names []string) *service_discovery_v3.DeltaDiscoveryRequest {
+	subscribed, ok := d.subscribed[typeUrl]
+	req := newDeltaAdsRequest(typeUrl)
+	if !ok {
+		subscribed = sets.New[string]()
+		req.InitialResourceVersions = make(map[string]string)
+		for name, version := range d.versions[typeUrl] {
+			if names == nil || slices.Contains(names, name) {
+				req.InitialResourceVersions[name] = version
+			}
+		}
+	}
+	if names == nil {
+		d.subscribed[typeUrl] = subscribed
+		if ok {
+			return nil
+		}
+		return req
+	}
+
+	current := sets.New(names...)
+	req.ResourceNamesSubscribe = sets.List(current.Difference(subscribed))
+	req.ResourceNamesUnsubscribe = sets.List(subscribed.Difference(current))
+	d.subscribed[typeUrl] = current
+	for _, name := range req.ResourceNamesUnsubscribe {
+		d.deleteVersion(typeUrl, name)
+	}
+	if ok && len(req.ResourceNamesSubscribe) == 0 && len(req.ResourceNamesUnsubscribe) == 0 {
+		return nil
+	}
+	return req
+}
+
+// startDeltaStream returns the initial requests of a new delta stream. Besides the wildcard cds, the resources
+// subscribed on the last stream are subscribed again with their versions, so a new server does not push them again.
+func (p *processor) startDeltaStream() []*service_discovery_v3.DeltaDiscoveryRequest {
+	p.lastNonce = &lastNonce{}
+	if p.delta == nil {
+		p.delta = newDeltaState()
+	}
+	p.delta.subscribed = make(map[string]sets.Set[string])
+	p.deltaReqs = nil
+
+	reqs := []*service_discovery_v3.DeltaDiscoveryRequest{p.delta.subscribe(resource_v3.ClusterType, nil)}
+	if len(p.Cache.edsClusterNames) > 0 {
+		reqs = append(reqs, p.delta.subscribe(resource_v3.EndpointType, p.Cache.edsClusterNames))
+	}
+	if len(p.delta.versions[resource_v3.ListenerType]) > 0 {
+		reqs = append(reqs, p.delta.subscribe(resource_v3.ListenerType, nil))
+	}
+	if len(p.Cache.routeNames) > 0 {
+		reqs = append(reqs, p.delta.subscribe(resource_v3.RouteType, p.Cache.routeNames))
+	}
+	return reqs
+}
+
+func (p *processor) processDeltaAdsResponse(resp *service_discovery_v3.DeltaDiscoveryResponse) {
+	var err error
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	log.Debugf("handle delta ads response, %#v\n", resp.GetTypeUrl())
+
+	p.deltaAck = newDeltaAckRequest(resp)
+	// the invalid resources are not applied, while the valid ones of the response still are
+	var validateErrs []error
+	resources := make([]*service_discovery_v3.Resource, 0, len(resp.GetResources()))
+	for _, resource := range resp.GetResources() {
+		if _, err := validateResource(resp.GetTypeUrl(), resource.GetResource()); err != nil {
+			validateErrs = append(validateErrs, err)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	validateErr := errors.Join(validateErrs...)
+	if validateErr != nil {
+		resp = proto.Clone(resp).(*service_discovery_v3.DeltaDiscoveryResponse)
+		resp.Resources = resources
+	}
+
+	switch resp.GetTypeUrl() {
+	case resource_v3.ClusterType:
+		err = p.handleDeltaCdsResponse(resp)
+	case resource_v3.EndpointType:
+		err = p.handleDeltaEdsResponse(resp)
+	case resource_v3.ListenerType:
+		err = p.handleDeltaLdsResponse(resp)
+	case resource_v3.RouteType:
+		err = p.handleDeltaRdsResponse(resp)
+	default:
+		err = fmt.Errorf("unsupported type url %s", resp.GetTypeUrl())
+	}
+
+	if err != nil {
+		// the resources failed to be flushed are not taken as received, so a new stream subscribes to them again
+		for _, name := range p.resetUnflushedHashes(resp.GetTypeUrl()) {
+			p.delta.deleteVersion(resp.GetTypeUrl(), name)
+		}
+	}
+	if err = errors.Join(validateErr, err); err != nil {
+		p.deltaNack(resp.GetTypeUrl(), err)
+	}
+}
+
+func (p *processor) deltaNack(typeUrl string, err error) {
+	log.Errorf("reject %s response: %v", typeUrl, err)
+	p.deltaAck.ErrorDetail = newErrorDetail(err)
+	telemetry.IncXdsRejection(typeUrl)
+}
+
+func (p *processor) handleDeltaCdsResponse(resp *service_discovery_v3.DeltaDiscoveryResponse) error {
+	p.lastNonce.cdsNonce = resp.GetNonce()
+	for _, resource := range resp.GetResources() {
+		cluster := &config_cluster_v3.Cluster{}
+		if err := resource.GetResource().UnmarshalTo(cluster); err != nil {
+			log.Errorf("unmarshal cluster error: %v", err)
+			continue
+		}
+		p.applyCluster(cluster, hash.Sum64String(resource.GetResource().String()))
+		p.delta.clusters[cluster.GetName()] = cluster
+		p.delta.setVersion(resp.GetTypeUrl(), cluster.GetName(), resource.GetVersion())
+	}
+
+	existing := p.Cache.ClusterCache.GetResourceNames()
+	removed := sets.New[string]()
+	for _, name := range resp.GetRemovedResources() {
+		delete(p.delta.clusters, name)
+		delete(p.delta.loadAssignments, name)
+		p.delta.deleteVersion(resp.GetTypeUrl(), name)
+		if existing.Has(name) {
+			removed.Insert(name)
+		}
+	}
+
+	clusters := make([]*config_cluster_v3.Cluster, 0, len(p.delta.clusters))
+	for _, cluster := range p.delta.clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].GetName() < clusters[j].GetName()
+	})
+	return p.updateClusters(clusters, removed)
+}
+
+// restoreLoadAssignments applies the load assignments last received to the eds clusters waiting for them
+// after a cds change. The server only pushes the load assignments changed, so an unchanged one would leave
+// its cluster waiting, and the cluster never flushed.
+func (p *processor) restoreLoadAssignments(clusters []*config_cluster_v3.Cluster) {
+	for _, cluster := range clusters {
+		if cluster.GetType() != config_cluster_v3.Cluster_EDS ||
+			p.Cache.ClusterCache.GetApiClusterStatus(cluster.GetName()) != core_v2.ApiStatus_WAITING {
+			continue
+		}
+		if last, ok := p.delta.loadAssignments[cluster.GetName()]; ok {
+			log.Debugf("restore the load assignment of cluster %s", cluster.GetName())
+			p.applyLoadAssignment(last.loadAssignment, last.hash)
+		}
+	}
+}
+
+func (p *processor) handleDeltaEdsResponse(resp *service_discovery_v3.DeltaDiscoveryResponse) error {
+	p.lastNonce.edsNonce = resp.GetNonce()
+	for _, resource := range resp.GetResources() {
+		loadAssignment := &config_endpoint_v3.ClusterLoadAssignment{}
+		if err := resource.GetResource().UnmarshalTo(loadAssignment); err != nil {
+			continue
+		}
+		newHash := hash.Sum64String(resource.GetResource().String())
+		p.applyLoadAssignment(loadAssignment, newHash)
+		p.delta.loadAssignments[loadAssignment.GetClusterName()] = &deltaLoadAssignment{
+			loadAssignment: loadAssignment,
+			hash:           newHash,
+		}
+		p.delta.setVersion(resp.GetTypeUrl(), loadAssignment.GetClusterName(), resource.GetVersion())
+	}
+	// the endpoints of a removed cluster are deleted along with the cluster
+	for _, name := range resp.GetRemovedResources() {
+		delete(p.delta.loadAssignments, name)
+		p.delta.deleteVersion(resp.GetTypeUrl(), name)
+	}
+
+	if p.lastNonce.ldsNonce == "" {
+		// subscribe to lds only once per stream
+		p.subscribe(resource_v3.ListenerType, nil)
+	}
+
+	return p.Cache.ClusterCache.Flush()
+}
+
+func (p *processor) handleDeltaLdsResponse(resp *service_discovery_v3.DeltaDiscoveryResponse) error {
+	p.lastNonce.ldsNonce = resp.GetNonce()
+	lastRouteNames := p.Cache.routeNames
+	existing := p.Cache.ListenerCache.GetResourceNames()
+	removed := sets.New[string]()
+
+	p.Cache.routeNames = nil
+	for _, resource := range resp.GetResources() {
+		listener := &config_listener_v3.Listener{}
+		if err := resource.GetResource().UnmarshalTo(listener); err != nil {
+			continue
+		}
+		p.delta.setVersion(resp.GetTypeUrl(), listener.GetName(), resource.GetVersion())
+		if listener.GetAddress() == nil {
+			// skip the listener without address, and remove the one it replaces
+			delete(p.delta.listenerRouteNames, listener.GetName())
+			if existing.Has(listener.GetName()) {
+				removed.Insert(listener.GetName())
+			}
+			continue
+		}
+		p.applyListener(listener, hash.Sum64String(resource.GetResource().String()))
+		p.delta.listenerRouteNames[listener.GetName()] = p.Cache.routeNames
+		p.Cache.routeNames = nil
+	}
+	for _, name := range resp.GetRemovedResources() {
+		delete(p.delta.listenerRouteNames, name)
+		p.delta.deleteVersion(resp.GetTypeUrl(), name)
+		if existing.Has(name) {
+			removed.Insert(name)
+		}
+	}
+
+	// rebuild the route names referenced by all the listeners
+	routeNames := sets.New[string]()
+	for _, names := range p.delta.listenerRouteNames {
+		routeNames.Insert(names...)
+	}
+	p.Cache.routeNames = sets.List(routeNames)
+	err := p.updateListeners(lastRouteNames, removed)
+
+	// the server does not notify the removal of the routes unsubscribed
+	unsubscribed := sets.New(lastRouteNames...).Difference(routeNames)
+	for name := range unsubscribed {
+		p.Cache.RouteCache.UpdateApiRouteStatus(name, core_v2.ApiStatus_DELETE)
+	}
+	if len(unsubscribed) > 0 {
+		err = errors.Join(err, p.Cache.RouteCache.Flush())
+	}
+	return err
+}
+
+func (p *processor) handleDeltaRdsResponse(resp *service_discovery_v3.DeltaDiscoveryResponse) error {
+	p.lastNonce.rdsNonce = resp.GetNonce()
+	for _, resource := range resp.GetResources() {
+		routeConfiguration := &config_route_v3.RouteConfiguration{}
+		if err := resource.GetResource().UnmarshalTo(routeConfiguration); err != nil {
+			continue
+		}
+		p.applyRouteConfiguration(routeConfiguration, hash.Sum64String(resource.GetResource().String()))
+		p.delta.setVersion(resp.GetTypeUrl(), routeConfiguration.GetName(), resource.GetVersion())
+	}
+	for _, name := range resp.GetRemovedResources() {
+		p.delta.deleteVersion(resp.GetTypeUrl(), name)
+		p.Cache.RouteCache.UpdateApiRouteStatus(name, core_v2.ApiStatus_DELETE)
+	}
+	return p.Cache.RouteCache.Flush()
+}
+
+func (c *Controller) deltaAdsStreamCreateAndSend(client service_discovery_v3.AggregatedDiscoveryServiceClient, ctx context.Context) error {
+	stream, err := client.DeltaAggregatedResources(ctx)
+	if err != nil {
+		return fmt.Errorf("DeltaAggregatedResources failed, %s", err)
+	}
+
+	c.con = &connection{
+		DeltaStream:       stream,
+		deltaRequestsChan: channels.NewUnbounded[*service_discovery_v3.DeltaDiscoveryRequest](),
+		stopCh:            make(chan struct{}),
+	}
+
+	for _, req := range c.Processor.startDeltaStream() {
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("send request failed, %s", err)
+		}
+	}
+	go sendDeltaUpstream(c.con)
+
+	return nil
+}
+
+func (c *Controller) handleDeltaAdsStream() error {
+	rsp, err := c.con.DeltaStream.Recv()
+	if err != nil {
+		_ = c.con.DeltaStream.CloseSend()
+		if status.Code(err) == codes.Unimplemented {
+			// reconnect with the state of the world xds
+			log.Warnf("delta xds is not supported by the discovery server, fall back to state of the world")
+			c.deltaUnsupported = true
+		}
+		return fmt.Errorf("stream recv failed, %s", err)
+	}
+
+	c.dnsResolverController.newClusterCache()
+	c.Processor.processDeltaAdsResponse(rsp)
+	c.con.deltaRequestsChan.Put(c.Processor.deltaAck)
+	for _, req := range c.Processor.deltaReqs {
+		c.con.deltaRequestsChan.Put(req)
+	}
+	c.Processor.deltaReqs = nil
+
+	return nil
+}
+
+func sendDeltaUpstream(con *connection) {
+	for {
+		select {
+		case req := <-con.deltaRequestsChan.Get():
+			con.deltaRequestsChan.Load()
+			if err := con.DeltaStream.Send(req); err != nil {
+				log.Errorf("send error for type url %s: %v", req.TypeUrl, err)
+				return
+			}
+		case <-con.stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"testing"
+	"time"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	core_v2 "kmesh.net/kmesh/api/v2/core"
+	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/utils/test"
+)
+
+func TestDeltaStateSubscribe(t *testing.T) {
+	d := newDeltaState()
+
+	// wildcard is subscribed only once per stream
+	req := d.subscribe(resource_v3.ClusterType, nil)
+	assert.NotNil(t, req)
+	assert.Empty(t, req.ResourceNamesSubscribe)
+	assert.Empty(t, req.InitialResourceVersions)
+	assert.Nil(t, d.subscribe(resource_v3.ClusterType, nil))
+
+	req = d.subscribe(resource_v3.EndpointType, []string{"b", "a"})
+	assert.Equal(t, []string{"a", "b"}, req.ResourceNamesSubscribe)
+	assert.Empty(t, req.ResourceNamesUnsubscribe)
+	d.setVersion(resource_v3.EndpointType, "a", "1")
+	d.setVersion(resource_v3.EndpointType, "b", "1")
+
+	// unchanged names send nothing
+	assert.Nil(t, d.subscribe(resource_v3.EndpointType, []string{"a", "b"}))
+
+	req = d.subscribe(resource_v3.EndpointType, []string{"a", "c"})
+	assert.Equal(t, []string{"c"}, req.ResourceNamesSubscribe)
+	assert.Equal(t, []string{"b"}, req.ResourceNamesUnsubscribe)
+	assert.Equal(t, map[string]string{"a": "1"}, d.versions[resource_v3.EndpointType])
+}
+
+func TestDeltaStateResubscribe(t *testing.T) {
+	d := newDeltaState()
+	d.setVersion(resource_v3.ClusterType, "outbound|80||a", "1")
+	d.setVersion(resource_v3.RouteType, "80", "2")
+	d.setVersion(resource_v3.RouteType, "8080", "3")
+
+	// a new stream carries the versions received on the last one
+	d.subscribed = make(map[string]sets.Set[string])
+	req := d.subscribe(resource_v3.ClusterType, nil)
+	assert.Equal(t, map[string]string{"outbound|80||a": "1"}, req.InitialResourceVersions)
+
+	// only the versions of the names still subscribed are carried
+	req = d.subscribe(resource_v3.RouteType, []string{"80"})
+	assert.Equal(t, []string{"80"}, req.ResourceNamesSubscribe)
+	assert.Equal(t, map[string]string{"80": "2"}, req.InitialResourceVersions)
+}
+
+func newTestDeltaResponse(t *testing.T, typeUrl, name, version string, resource proto.Message) *service_discovery_v3.DeltaDiscoveryResponse {
+	anyResource, err := anypb.New(resource)
+	assert.NoError(t, err)
+	return &service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl: typeUrl,
+		Nonce:   version,
+		Resources: []*service_discovery_v3.Resource{
+			{Name: name, Version: version, Resource: anyResource},
+		},
+	}
+}
+
+func TestHandleDeltaCdsChangeWithoutEds(t *testing.T) {
+	config := options.BpfConfig{
+		Mode:        constants.KernelNativeMode,
+		BpfFsPath:   "/sys/fs/bpf",
+		Cgroup2Path: "/mnt/kmesh_cgroup2",
+	}
+	cleanup, _ := test.InitBpfMap(t, config)
+	t.Cleanup(cleanup)
+
+	p := newProcessor(nil)
+	p.DnsResolverChan = make(chan []*config_cluster_v3.Cluster, 5)
+	p.delta = newDeltaState()
+	newCluster := func(connectTimeout time.Duration) *config_cluster_v3.Cluster {
+		return &config_cluster_v3.Cluster{
+			Name:                 "ut-cluster",
+			ClusterDiscoveryType: &config_cluster_v3.Cluster_Type{Type: config_cluster_v3.Cluster_EDS},
+			ConnectTimeout:       durationpb.New(connectTimeout),
+		}
+	}
+
+	assert.NoError(t, p.handleDeltaCdsResponse(newTestDeltaResponse(t, resource_v3.ClusterType, "ut-cluster", "1", newCluster(time.Second))))
+	assert.Equal(t, core_v2.ApiStatus_WAITING, p.Cache.ClusterCache.GetApiClusterStatus("ut-cluster"))
+	loadAssignment := newTestLoadAssignment("ut-cluster", "10.0.0.1:80", "10.0.0.2:80")
+	assert.NoError(t, p.handleDeltaEdsResponse(newTestDeltaResponse(t, resource_v3.EndpointType, "ut-cluster", "1", loadAssignment)))
+	assert.Equal(t, core_v2.ApiStatus_NONE, p.Cache.ClusterCache.GetApiClusterStatus("ut-cluster"))
+
+	// the cluster changes while its endpoints do not, so the server pushes no eds
+	assert.NoError(t, p.handleDeltaCdsResponse(newTestDeltaResponse(t, resource_v3.ClusterType, "ut-cluster", "2", newCluster(2*time.Second))))
+	cluster := p.Cache.ClusterCache.GetApiCluster("ut-cluster")
+	assert.Equal(t, core_v2.ApiStatus_NONE, cluster.GetApiStatus())
+	assert.Equal(t, uint32(2), cluster.GetConnectTimeout())
+	assert.Len(t, cluster.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints(), 2)
+
+	// a removed cluster forgets its load assignment
+	assert.NoError(t, p.handleDeltaCdsResponse(&service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl:          resource_v3.ClusterType,
+		Nonce:            "3",
+		RemovedResources: []string{"ut-cluster"},
+	}))
+	assert.NotContains(t, p.delta.loadAssignments, "ut-cluster")
+}
@@ -17,7 +17,9 @@
 package ads
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
@@ -49,21 +51,39 @@ type lastNonce struct {
 	rdsNonce string
 }
 type processor struct {
+	// mutex serializes applying the responses and the endpoint health changes to the Cache
+	mutex     sync.Mutex
 	Cache     *AdsCache
 	ack       *service_discovery_v3.DiscoveryRequest
 	req       *service_discovery_v3.DiscoveryRequest
 	lastNonce *lastNonce
 	// the channel used to send domains to dns resolver. key is domain name and value is refreshrate
 	DnsResolverChan chan []*config_cluster_v3.Cluster
+	// probes the endpoints of eds clusters configuring tcp health checks
+	healthChecker *healthChecker
+	// the versions last accepted by type url, they are kept across streams
+	versions map[string]string
+	// invalidResources is the names of the invalid resources of the response being handled,
+	// they are not applied and keep the config last accepted
+	invalidResources sets.Set[string]
+
+	// delta is the state of the delta xds stream, nil when the stream is state of the world
+	delta     *deltaState
+	deltaAck  *service_discovery_v3.DeltaDiscoveryRequest
+	deltaReqs []*service_discovery_v3.DeltaDiscoveryRequest
 }
 
 func newProcessor(bpfAds *bpfads.BpfAds) *processor {
-	return &processor{
-		Cache:     NewAdsCache(bpfAds),
+	cache := NewAdsCache(bpfAds)
+	p := &processor{
+		Cache:     cache,
 		ack:       nil,
 		req:       nil,
 		lastNonce: &lastNonce{},
+		versions:  make(map[string]string),
 	}
+	p.healthChecker = newHealthChecker(cache, &p.mutex)
+	return p
 }
 
 func newAdsRequest(typeUrl string, names []string, nonce string) *service_discovery_v3.DiscoveryRequest {
@@ -88,6 +108,27 @@ func newAckRequest(resp *service_discovery_v3.DiscoveryResponse) *service_discov
 	}
 }
 
+// newRequest creates a request carrying the version last accepted of the type, so that a discovery server
+// reconnected or failed over to knows the resources applied already.
+func (p *processor) newRequest(typeUrl string, names []string) *service_discovery_v3.DiscoveryRequest {
+	req := newAdsRequest(typeUrl, names, "")
+	if p != nil {
+		req.VersionInfo = p.versions[typeUrl]
+	}
+	return req
+}
+
+// subscribe requests the resources of the type, names is nil for the wildcard types.
+func (p *processor) subscribe(typeUrl string, names []string) {
+	if p.delta == nil {
+		p.req = p.newRequest(typeUrl, names)
+		return
+	}
+	if req := p.delta.subscribe(typeUrl, names); req != nil {
+		p.deltaReqs = append(p.deltaReqs, req)
+	}
+}
+
 // [Eventual consistency considerations](https://www.envoyproxy.io/docs/envoy/latest/api-docs/xds_protocol)
 // In general, to avoid traffic drop, sequencing of updates should follow a make before break model, wherein:
 // * CDS updates (if any) must always be pushed first.
@@ -98,6 +139,8 @@ func newAckRequest(resp *service_discovery_v3.DiscoveryResponse) *service_discov
 // * Stale CDS clusters and related EDS endpoints (ones no longer being referenced) can then be removed.
 func (p *processor) processAdsResponse(resp *service_discovery_v3.DiscoveryResponse) {
 	var err error
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
 	log.Debugf("handle ads response, %#v\n", resp.GetTypeUrl())
 
@@ -105,6 +148,13 @@ func (p *processor) processAdsResponse(resp *service_discovery_v3.DiscoveryRespo
 	if resp.GetResources() == nil {
 		return
 	}
+	// the invalid resources are not applied, while the valid ones of the response still are
+	resources, invalid, validateErr := validateResources(resp.GetTypeUrl(), resp.GetResources())
+	if validateErr != nil {
+		resp = proto.Clone(resp).(*service_discovery_v3.DiscoveryResponse)
+		resp.Resources = resources
+	}
+	p.invalidResources = invalid
 
 	switch resp.GetTypeUrl() {
 	case resource_v3.ClusterType:
@@ -120,16 +170,58 @@ func (p *processor) processAdsResponse(resp *service_discovery_v3.DiscoveryRespo
 	}
 
 	if err != nil {
-		log.Error(err)
+		p.resetUnflushedHashes(resp.GetTypeUrl())
+	}
+	if err = errors.Join(validateErr, err); err != nil {
+		p.nack(resp.GetTypeUrl(), err)
+		return
+	}
+	if p.versions == nil {
+		p.versions = make(map[string]string)
 	}
+	p.versions[resp.GetTypeUrl()] = resp.GetVersionInfo()
+}
+
+// resetUnflushedHashes resets the hashes of the resources of the type failed to be flushed, and returns their names.
+// The bpf maps keep their last good config, and the hashes reset make them applied and flushed again when
+// the response NACKed is resent, instead of being taken as unchanged.
+func (p *processor) resetUnflushedHashes(typeUrl string) []string {
+	var names []string
+	switch typeUrl {
+	case resource_v3.ClusterType, resource_v3.EndpointType:
+		for name := range p.Cache.ClusterCache.GetResourceNames() {
+			if p.Cache.ClusterCache.GetApiClusterStatus(name) != core_v2.ApiStatus_UPDATE {
+				continue
+			}
+			if typeUrl == resource_v3.ClusterType {
+				p.Cache.ClusterCache.SetCdsHash(name, 0)
+			} else {
+				p.Cache.ClusterCache.SetEdsHash(name, 0)
+			}
+			names = append(names, name)
+		}
+	case resource_v3.ListenerType:
+		for name := range p.Cache.ListenerCache.GetResourceNames() {
+			if p.Cache.ListenerCache.GetApiListener(name).GetApiStatus() == core_v2.ApiStatus_UPDATE {
+				p.Cache.ListenerCache.AddOrUpdateLdsHash(name, 0)
+				names = append(names, name)
+			}
+		}
+	case resource_v3.RouteType:
+		for name := range p.Cache.RouteCache.GetResourceNames() {
+			if p.Cache.RouteCache.GetApiRouteConfig(name).GetApiStatus() == core_v2.ApiStatus_UPDATE {
+				p.Cache.RouteCache.SetRdsHash(name, 0)
+				names = append(names, name)
+			}
+		}
+	}
+	return names
 }
 
 func (p *processor) handleCdsResponse(resp *service_discovery_v3.DiscoveryResponse) error {
 	p.lastNonce.cdsNonce = resp.Nonce
 	current := sets.New[string]()
-	lastEdsClusterNames := p.Cache.edsClusterNames
-	p.Cache.edsClusterNames = nil
-	dnsClusters := []*config_cluster_v3.Cluster{}
+	clusters := make([]*config_cluster_v3.Cluster, 0, len(resp.GetResources()))
 	for _, resource := range resp.GetResources() {
 		cluster := &config_cluster_v3.Cluster{}
 		if err := anypb.UnmarshalTo(resource, cluster, proto.UnmarshalOptions{}); err != nil {
@@ -137,41 +229,66 @@ func (p *processor) handleCdsResponse(resp *service_discovery_v3.DiscoveryRespon
 			continue
 		}
 		current.Insert(cluster.GetName())
+		clusters = append(clusters, cluster)
+		p.applyCluster(cluster, hash.Sum64String(resource.String()))
+	}
 
+	removed := p.Cache.ClusterCache.GetResourceNames().Difference(current).Difference(p.invalidResources)
+	return p.updateClusters(clusters, removed)
+}
+
+// applyCluster updates the api cluster if the cluster is changed.
+func (p *processor) applyCluster(cluster *config_cluster_v3.Cluster, newHash uint64) {
+	// compare part[0] CDS now
+	// Cluster_EDS need compare tow parts, compare part[1] EDS in EDS handler
+	if newHash == p.Cache.ClusterCache.GetCdsHash(cluster.GetName()) {
+		log.Debugf("unchanged cluster %s", cluster.GetName())
+		return
+	}
+
+	var status core_v2.ApiStatus
+	if cluster.GetType() == config_cluster_v3.Cluster_EDS {
+		status = core_v2.ApiStatus_WAITING
+	} else if cluster.GetType() == config_cluster_v3.Cluster_STRICT_DNS ||
+		cluster.GetType() == config_cluster_v3.Cluster_LOGICAL_DNS {
+		// dns typed cluster will be handled in dns module, skip update bpf map here
+		status = core_v2.ApiStatus_WAITING
+	} else {
+		status = core_v2.ApiStatus_UPDATE
+	}
+
+	log.Debugf("[CreateApiClusterByCds] update cluster %s, status %d, cluster.type %v",
+		cluster.GetName(), status, cluster.GetType())
+	p.Cache.ClusterCache.SetCdsHash(cluster.GetName(), newHash)
+	p.Cache.CreateApiClusterByCds(status, cluster)
+}
+
+// updateClusters flushes the clusters applied and the removed ones, clusters are all the clusters subscribed.
+func (p *processor) updateClusters(clusters []*config_cluster_v3.Cluster, removed sets.Set[string]) error {
+	lastEdsClusterNames := p.Cache.edsClusterNames
+	p.Cache.edsClusterNames = nil
+	dnsClusters := []*config_cluster_v3.Cluster{}
+	edsClusters := []*config_cluster_v3.Cluster{}
+	for _, cluster := range clusters {
 		if cluster.GetType() == config_cluster_v3.Cluster_EDS {
 			p.Cache.edsClusterNames = append(p.Cache.edsClusterNames, cluster.GetName())
+			edsClusters = append(edsClusters, cluster)
 		} else if cluster.GetType() == config_cluster_v3.Cluster_STRICT_DNS ||
 			cluster.GetType() == config_cluster_v3.Cluster_LOGICAL_DNS {
 			dnsClusters = append(dnsClusters, cluster)
 		}
-		// compare part[0] CDS now
-		// Cluster_EDS need compare tow parts, compare part[1] EDS in EDS handler
-		newHash := hash.Sum64String(resource.String())
-		if newHash != p.Cache.ClusterCache.GetCdsHash(cluster.GetName()) {
-			var status core_v2.ApiStatus
-			if cluster.GetType() == config_cluster_v3.Cluster_EDS {
-				status = core_v2.ApiStatus_WAITING
-			} else if cluster.GetType() == config_cluster_v3.Cluster_STRICT_DNS ||
-				cluster.GetType() == config_cluster_v3.Cluster_LOGICAL_DNS {
-				// dns typed cluster will be handled in dns module, skip update bpf map here
-				status = core_v2.ApiStatus_WAITING
-			} else {
-				status = core_v2.ApiStatus_UPDATE
-			}
-
-			log.Debugf("[CreateApiClusterByCds] update cluster %s, status %d, cluster.type %v",
-				cluster.GetName(), status, cluster.GetType())
-			p.Cache.ClusterCache.SetCdsHash(cluster.GetName(), newHash)
-			p.Cache.CreateApiClusterByCds(status, cluster)
-		} else {
-			log.Debugf("unchanged cluster %s", cluster.GetName())
-		}
 	}
 	// send dns clusters to dns resolver, even dnsClusters is empty, we need to send empty list to dns resolver to clear the cache
 	if p.DnsResolverChan != nil {
 		p.DnsResolverChan <- dnsClusters
 	}
-	removed := p.Cache.ClusterCache.GetResourceNames().Difference(current)
+	// The endpoints of a cluster no longer health checked are restored by the following eds response,
+	// as its cds change makes it wait for eds.
+	p.healthChecker.syncClusters(edsClusters)
+	if p.delta != nil {
+		p.restoreLoadAssignments(edsClusters)
+	}
+
 	for key := range removed {
 		p.Cache.UpdateApiClusterStatus(key, core_v2.ApiStatus_DELETE)
 	}
@@ -183,45 +300,26 @@ func (p *processor) handleCdsResponse(resp *service_discovery_v3.DiscoveryRespon
 	// 1. clusters need to be deleted
 	// 2. dns typed clusters update, we do not need to wait for eds update, because dns cluster has no eds following
 	// Note eds typed cluster, we do not flush to bpf map here, we need to wait for eds update.
-	p.Cache.ClusterCache.Flush()
+	err := p.Cache.ClusterCache.Flush()
 
 	// when the list of eds typed clusters subscribed changed, we should resubscrbe to new eds.
 	if !slices.EqualUnordered(p.Cache.edsClusterNames, lastEdsClusterNames) {
 		// we cannot set the nonce here.
 		// There is a race: when xds server has pushed eds, but kmesh hasn't a chance to receive and process
 		// Then it will lead to this request been ignored, we will lose the new eds resource
-		p.req = newAdsRequest(resource_v3.EndpointType, p.Cache.edsClusterNames, "")
+		p.subscribe(resource_v3.EndpointType, p.Cache.edsClusterNames)
 	}
-
-	return nil
+	return err
 }
 
 func (p *processor) handleEdsResponse(resp *service_discovery_v3.DiscoveryResponse) error {
-	var err error
-	var loadAssignment = &config_endpoint_v3.ClusterLoadAssignment{}
 	p.lastNonce.edsNonce = resp.Nonce
 	for _, resource := range resp.GetResources() {
+		loadAssignment := &config_endpoint_v3.ClusterLoadAssignment{}
 		if err := anypb.UnmarshalTo(resource, loadAssignment, proto.UnmarshalOptions{}); err != nil {
 			continue
 		}
-		cluster := p.Cache.ClusterCache.GetApiCluster(loadAssignment.GetClusterName())
-		// fix exceptional scenarios: receive eds push after cds has been deleted
-		if cluster == nil {
-			log.Debugf("cluster %s is deleted", loadAssignment.GetClusterName())
-			continue
-		}
-		apiStatus := cluster.ApiStatus
-		newHash := hash.Sum64String(resource.String())
-		// part[0] CDS is different or part[1] EDS is different
-		if apiStatus == core_v2.ApiStatus_WAITING ||
-			newHash != p.Cache.ClusterCache.GetEdsHash(loadAssignment.GetClusterName()) {
-			apiStatus = core_v2.ApiStatus_UPDATE
-			p.Cache.ClusterCache.SetEdsHash(loadAssignment.GetClusterName(), newHash)
-			log.Debugf("[CreateApiClusterByEds] update cluster %s", loadAssignment.GetClusterName())
-			p.Cache.CreateApiClusterByEds(apiStatus, loadAssignment)
-		} else {
-			log.Debugf("handleEdsResponse: unchanged cluster %s", loadAssignment.GetClusterName())
-		}
+		p.applyLoadAssignment(loadAssignment, hash.Sum64String(resource.String()))
 	}
 
 	// EDS ack should contain all the eds cluster names, and since istiod can send partial eds to us, we use those set by handleCdsResponse
@@ -230,26 +328,46 @@ func (p *processor) handleEdsResponse(resp *service_discovery_v3.DiscoveryRespon
 
 	if p.lastNonce.ldsNonce == "" {
 		// subscribe to lds only once per stream
-		p.req = newAdsRequest(resource_v3.ListenerType, nil, "")
+		p.subscribe(resource_v3.ListenerType, nil)
 	}
 
-	p.Cache.ClusterCache.Flush()
+	return p.Cache.ClusterCache.Flush()
+}
 
-	return err
+// applyLoadAssignment updates the endpoints of the api cluster if the cluster or its endpoints are changed.
+func (p *processor) applyLoadAssignment(loadAssignment *config_endpoint_v3.ClusterLoadAssignment, newHash uint64) {
+	cluster := p.Cache.ClusterCache.GetApiCluster(loadAssignment.GetClusterName())
+	// fix exceptional scenarios: receive eds push after cds has been deleted
+	if cluster == nil {
+		log.Debugf("cluster %s is deleted", loadAssignment.GetClusterName())
+		return
+	}
+	apiStatus := cluster.ApiStatus
+	// part[0] CDS is different or part[1] EDS is different
+	if apiStatus != core_v2.ApiStatus_WAITING &&
+		newHash == p.Cache.ClusterCache.GetEdsHash(loadAssignment.GetClusterName()) {
+		log.Debugf("handleEdsResponse: unchanged cluster %s", loadAssignment.GetClusterName())
+		return
+	}
+
+	apiStatus = core_v2.ApiStatus_UPDATE
+	p.Cache.ClusterCache.SetEdsHash(loadAssignment.GetClusterName(), newHash)
+	log.Debugf("[CreateApiClusterByEds] update cluster %s", loadAssignment.GetClusterName())
+	if p.healthChecker != nil {
+		p.healthChecker.CreateApiClusterByEds(apiStatus, loadAssignment)
+	} else {
+		p.Cache.CreateApiClusterByEds(apiStatus, loadAssignment)
+	}
 }
 
 func (p *processor) handleLdsResponse(resp *service_discovery_v3.DiscoveryResponse) error {
-	var (
-		err      error
-		listener = &config_listener_v3.Listener{}
-	)
-
 	p.lastNonce.ldsNonce = resp.Nonce
 	current := sets.New[string]()
 	lastRouteNames := p.Cache.routeNames
 	p.Cache.routeNames = []string{}
 	for _, resource := range resp.GetResources() {
-		if err = anypb.UnmarshalTo(resource, listener, proto.UnmarshalOptions{}); err != nil {
+		listener := &config_listener_v3.Listener{}
+		if err := anypb.UnmarshalTo(resource, listener, proto.UnmarshalOptions{}); err != nil {
 			continue
 		}
 		if listener.GetAddress() == nil {
@@ -257,65 +375,79 @@ func (p *processor) handleLdsResponse(resp *service_discovery_v3.DiscoveryRespon
 			continue
 		}
 		current.Insert(listener.GetName())
-		apiStatus := core_v2.ApiStatus_UPDATE
-		newHash := hash.Sum64String(resource.String())
-		if newHash != p.Cache.ListenerCache.GetLdsHash(listener.GetName()) {
-			p.Cache.ListenerCache.AddOrUpdateLdsHash(listener.GetName(), newHash)
-			log.Debugf("[CreateApiListenerByLds] update %s", listener.GetName())
-		} else {
-			log.Debugf("[CreateApiListenerByLds] unchanged %s", listener.GetName())
-			apiStatus = core_v2.ApiStatus_UNCHANGED
-		}
-		p.Cache.CreateApiListenerByLds(apiStatus, listener)
+		p.applyListener(listener, hash.Sum64String(resource.String()))
 	}
 
-	removed := p.Cache.ListenerCache.GetResourceNames().Difference(current)
+	removed := p.Cache.ListenerCache.GetResourceNames().Difference(current).Difference(p.invalidResources)
+	if p.invalidResources.Len() > 0 {
+		// the routes of the invalid listeners kept are still subscribed
+		p.Cache.routeNames = sets.List(sets.New(p.Cache.routeNames...).Insert(lastRouteNames...))
+	}
+	return p.updateListeners(lastRouteNames, removed)
+}
+
+// applyListener updates the api listener if the listener is changed, and collects the route names of it.
+func (p *processor) applyListener(listener *config_listener_v3.Listener, newHash uint64) {
+	apiStatus := core_v2.ApiStatus_UPDATE
+	if newHash != p.Cache.ListenerCache.GetLdsHash(listener.GetName()) {
+		p.Cache.ListenerCache.AddOrUpdateLdsHash(listener.GetName(), newHash)
+		log.Debugf("[CreateApiListenerByLds] update %s", listener.GetName())
+	} else {
+		log.Debugf("[CreateApiListenerByLds] unchanged %s", listener.GetName())
+		apiStatus = core_v2.ApiStatus_UNCHANGED
+	}
+	p.Cache.CreateApiListenerByLds(apiStatus, listener)
+}
+
+// updateListeners flushes the listeners applied and the removed ones, and resubscribes to the routes if changed.
+func (p *processor) updateListeners(lastRouteNames []string, removed sets.Set[string]) error {
 	for key := range removed {
 		p.Cache.UpdateApiListenerStatus(key, core_v2.ApiStatus_DELETE)
 	}
 
-	p.Cache.ListenerCache.Flush()
+	err := p.Cache.ListenerCache.Flush()
 
 	if !slices.EqualUnordered(p.Cache.routeNames, lastRouteNames) {
 		// we cannot set the nonce here.
 		// There is a race: when xds server has pushed rds, but kmesh hasn't a chance to receive and process
 		// Then it will lead to this request been ignored, we will lose the new rds resource
-		p.req = newAdsRequest(resource_v3.RouteType, p.Cache.routeNames, "")
+		p.subscribe(resource_v3.RouteType, p.Cache.routeNames)
 	}
 	return err
 }
 
 func (p *processor) handleRdsResponse(resp *service_discovery_v3.DiscoveryResponse) error {
-	var err error
-	routeConfiguration := &config_route_v3.RouteConfiguration{}
-
 	p.lastNonce.rdsNonce = resp.Nonce
 	current := sets.New[string]()
 	for _, resource := range resp.GetResources() {
+		routeConfiguration := &config_route_v3.RouteConfiguration{}
 		if err := anypb.UnmarshalTo(resource, routeConfiguration, proto.UnmarshalOptions{}); err != nil {
 			continue
 		}
 		current.Insert(routeConfiguration.GetName())
-		newHash := hash.Sum64String(resource.String())
-		if newHash != p.Cache.RouteCache.GetRdsHash(routeConfiguration.GetName()) {
-			p.Cache.RouteCache.SetRdsHash(routeConfiguration.GetName(), newHash)
-			log.Debugf("[CreateApiRouteByRds] update %s", routeConfiguration.GetName())
-			p.Cache.CreateApiRouteByRds(core_v2.ApiStatus_UPDATE, routeConfiguration)
-		} else {
-			log.Debugf("[CreateApiRouteByRds] unchanged %s", routeConfiguration.GetName())
-		}
+		p.applyRouteConfiguration(routeConfiguration, hash.Sum64String(resource.String()))
 		// if rds has no virtualhost, no need to subscribe this rds again in response
 		if routeConfiguration.GetVirtualHosts() != nil {
 			p.ack.ResourceNames = append(p.ack.ResourceNames, routeConfiguration.GetName())
 		}
 	}
 
-	removed := p.Cache.RouteCache.GetResourceNames().Difference(current)
+	removed := p.Cache.RouteCache.GetResourceNames().Difference(current).Difference(p.invalidResources)
 	for key := range removed {
 		p.Cache.RouteCache.UpdateApiRouteStatus(key, core_v2.ApiStatus_DELETE)
 	}
-	p.Cache.RouteCache.Flush()
-	return err
+	return p.Cache.RouteCache.Flush()
+}
+
+// applyRouteConfiguration updates the api route if the route configuration is changed.
+func (p *processor) applyRouteConfiguration(routeConfiguration *config_route_v3.RouteConfiguration, newHash uint64) {
+	if newHash == p.Cache.RouteCache.GetRdsHash(routeConfiguration.GetName()) {
+		log.Debugf("[CreateApiRouteByRds] unchanged %s", routeConfiguration.GetName())
+		return
+	}
+	p.Cache.RouteCache.SetRdsHash(routeConfiguration.GetName(), newHash)
+	log.Debugf("[CreateApiRouteByRds] update %s", routeConfiguration.GetName())
+	p.Cache.CreateApiRouteByRds(core_v2.ApiStatus_UPDATE, routeConfiguration)
 }
 
 func (p *processor) Reset() {
@@ -325,6 +457,8 @@ func (p *processor) Reset() {
 	p.lastNonce = &lastNonce{}
 	p.Cache.routeNames = nil
 	p.Cache.edsClusterNames = nil
+	// the incremental state is stale once the state of the world xds is used
+	p.delta = nil
 }
 
 func ConfigResourcesIsEmpty(resources *admin_v2.ConfigResources) bool {
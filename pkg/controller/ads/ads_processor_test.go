@@ -814,3 +814,30 @@ func TestHandleRdsResponse(t *testing.T) {
 	})
 	loader.Stop()
 }
+
+func TestResetUnflushedHashes(t *testing.T) {
+	p := newProcessor(nil)
+	p.Cache.ClusterCache.SetApiCluster("ut-failed", &cluster_v2.Cluster{
+		ApiStatus: core_v2.ApiStatus_UPDATE,
+		Name:      "ut-failed",
+	})
+	p.Cache.ClusterCache.SetApiCluster("ut-flushed", &cluster_v2.Cluster{
+		ApiStatus: core_v2.ApiStatus_NONE,
+		Name:      "ut-flushed",
+	})
+	for _, name := range []string{"ut-failed", "ut-flushed"} {
+		p.Cache.ClusterCache.SetCdsHash(name, 1)
+		p.Cache.ClusterCache.SetEdsHash(name, 2)
+	}
+
+	names := p.resetUnflushedHashes(resource_v3.ClusterType)
+	assert.Equal(t, []string{"ut-failed"}, names)
+	assert.Equal(t, uint64(0), p.Cache.ClusterCache.GetCdsHash("ut-failed"))
+	assert.Equal(t, uint64(2), p.Cache.ClusterCache.GetEdsHash("ut-failed"))
+	assert.Equal(t, uint64(1), p.Cache.ClusterCache.GetCdsHash("ut-flushed"))
+
+	names = p.resetUnflushedHashes(resource_v3.EndpointType)
+	assert.Equal(t, []string{"ut-failed"}, names)
+	assert.Equal(t, uint64(0), p.Cache.ClusterCache.GetEdsHash("ut-failed"))
+	assert.Equal(t, uint64(2), p.Cache.ClusterCache.GetEdsHash("ut-flushed"))
+}
@@ -103,12 +103,8 @@ func (load *AdsCache) GetApiClusterStatus(key string) core_v2.ApiStatus {
 func (load *AdsCache) CreateApiClusterByEds(status core_v2.ApiStatus,
 	loadAssignment *config_endpoint_v3.ClusterLoadAssignment,
 ) {
-	apiCluster := load.ClusterCache.GetApiCluster(loadAssignment.GetClusterName())
-	if apiCluster == nil {
-		return
-	}
-	apiCluster.ApiStatus = status
-	apiCluster.LoadAssignment = newApiClusterLoadAssignment(loadAssignment)
+	load.ClusterCache.UpdateApiClusterLoadAssignment(loadAssignment.GetClusterName(), status,
+		newApiClusterLoadAssignment(loadAssignment))
 }
 
 func newApiClusterLoadAssignment(
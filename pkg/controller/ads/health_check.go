@@ -0,0 +1,477 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/proto"
+
+	core_v2 "kmesh.net/kmesh/api/v2/core"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = time.Second
+)
+
+// EndpointHealth is the active health check status of an endpoint of a cluster.
+type EndpointHealth struct {
+	Cluster   string `json:"cluster"`
+	Address   string `json:"address"`
+	Healthy   bool   `json:"healthy"`
+	LastCheck string `json:"lastCheck,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// tcpHealthCheck is the tcp health check config of a cluster converted from `Cluster.health_checks`.
+type tcpHealthCheck struct {
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold uint32
+	healthyThreshold   uint32
+	altPort            uint32
+	send               []byte
+	receive            [][]byte
+}
+
+type hostHealth struct {
+	unhealthy bool
+	// consecutive results opposite to the current status
+	failures  uint32
+	successes uint32
+	lastCheck time.Time
+	lastError string
+}
+
+type clusterHealth struct {
+	config tcpHealthCheck
+	// the latest load assignment received by eds, including the unhealthy endpoints
+	loadAssignment *config_endpoint_v3.ClusterLoadAssignment
+	hosts          map[string]*hostHealth
+	cancel         context.CancelFunc
+}
+
+// healthChecker probes the endpoints of the eds clusters configuring a tcp health check,
+// and removes the unhealthy ones from the load assignments written into the ClusterCache.
+type healthChecker struct {
+	mutex sync.Mutex
+	cache *AdsCache
+	// processorLock is held while a health change is applied, so that it never interleaves with a response
+	// being applied by the ads processor. It must be acquired before mutex.
+	processorLock sync.Locker
+	clusters      map[string]*clusterHealth
+	dial          func(ctx context.Context, address string) (net.Conn, error)
+}
+
+func newHealthChecker(cache *AdsCache, processorLock sync.Locker) *healthChecker {
+	dialer := &net.Dialer{}
+	return &healthChecker{
+		cache:         cache,
+		processorLock: processorLock,
+		clusters:      make(map[string]*clusterHealth),
+		dial: func(ctx context.Context, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", address)
+		},
+	}
+}
+
+// newTcpHealthCheck returns the first tcp health check of the cluster, other kinds of health checks are not supported.
+func newTcpHealthCheck(cluster *config_cluster_v3.Cluster) (*tcpHealthCheck, error) {
+	for _, hc := range cluster.GetHealthChecks() {
+		tcp := hc.GetTcpHealthCheck()
+		if tcp == nil {
+			continue
+		}
+		config := &tcpHealthCheck{
+			interval:           hc.GetInterval().AsDuration(),
+			timeout:            hc.GetTimeout().AsDuration(),
+			unhealthyThreshold: max(hc.GetUnhealthyThreshold().GetValue(), 1),
+			healthyThreshold:   max(hc.GetHealthyThreshold().GetValue(), 1),
+			altPort:            hc.GetAltPort().GetValue(),
+		}
+		if config.interval <= 0 {
+			config.interval = defaultHealthCheckInterval
+		}
+		if config.timeout <= 0 {
+			config.timeout = defaultHealthCheckTimeout
+		}
+		var err error
+		if tcp.GetSend() != nil {
+			if config.send, err = decodeHealthCheckPayload(tcp.GetSend()); err != nil {
+				return nil, err
+			}
+		}
+		for _, payload := range tcp.GetReceive() {
+			receive, err := decodeHealthCheckPayload(payload)
+			if err != nil {
+				return nil, err
+			}
+			config.receive = append(config.receive, receive)
+		}
+		return config, nil
+	}
+	return nil, nil
+}
+
+func decodeHealthCheckPayload(payload *config_core_v3.HealthCheck_Payload) ([]byte, error) {
+	if _, ok := payload.GetPayload().(*config_core_v3.HealthCheck_Payload_Binary); ok {
+		return payload.GetBinary(), nil
+	}
+	data, err := hex.DecodeString(payload.GetText())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex payload %q: %v", payload.GetText(), err)
+	}
+	return data, nil
+}
+
+// syncClusters starts checking the eds clusters configuring a tcp health check,
+// and stops checking the others.
+func (hc *healthChecker) syncClusters(clusters []*config_cluster_v3.Cluster) {
+	if hc == nil {
+		return
+	}
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	current := make(map[string]struct{}, len(clusters))
+	for _, cluster := range clusters {
+		config, err := newTcpHealthCheck(cluster)
+		if err != nil {
+			log.Errorf("cluster %s health check is ignored: %v", cluster.GetName(), err)
+		}
+		if config == nil {
+			continue
+		}
+		current[cluster.GetName()] = struct{}{}
+
+		if c, ok := hc.clusters[cluster.GetName()]; ok {
+			if equalTcpHealthCheck(&c.config, config) {
+				continue
+			}
+			c.cancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		c := &clusterHealth{
+			config: *config,
+			hosts:  make(map[string]*hostHealth),
+			cancel: cancel,
+		}
+		if old, ok := hc.clusters[cluster.GetName()]; ok {
+			c.loadAssignment = old.loadAssignment
+		}
+		hc.clusters[cluster.GetName()] = c
+		go hc.run(ctx, cluster.GetName(), config.interval)
+		log.Infof("start tcp health check of cluster %s, interval %s", cluster.GetName(), config.interval)
+	}
+
+	for name, c := range hc.clusters {
+		if _, ok := current[name]; !ok {
+			c.cancel()
+			delete(hc.clusters, name)
+			log.Infof("stop health check of cluster %s", name)
+		}
+	}
+}
+
+func equalTcpHealthCheck(a, b *tcpHealthCheck) bool {
+	if a.interval != b.interval || a.timeout != b.timeout || a.altPort != b.altPort ||
+		a.unhealthyThreshold != b.unhealthyThreshold || a.healthyThreshold != b.healthyThreshold ||
+		!bytes.Equal(a.send, b.send) || len(a.receive) != len(b.receive) {
+		return false
+	}
+	for i := range a.receive {
+		if !bytes.Equal(a.receive[i], b.receive[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateApiClusterByEds creates the api cluster by the load assignment without the unhealthy endpoints.
+func (hc *healthChecker) CreateApiClusterByEds(status core_v2.ApiStatus, loadAssignment *config_endpoint_v3.ClusterLoadAssignment) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	if c, ok := hc.clusters[loadAssignment.GetClusterName()]; ok {
+		c.loadAssignment = proto.Clone(loadAssignment).(*config_endpoint_v3.ClusterLoadAssignment)
+		addresses := c.addresses()
+		for address := range c.hosts {
+			if _, ok := addresses[address]; !ok {
+				delete(c.hosts, address)
+			}
+		}
+		loadAssignment = c.healthyLoadAssignment()
+	}
+	hc.cache.CreateApiClusterByEds(status, loadAssignment)
+}
+
+// addresses returns the addresses to probe, keyed by the endpoint address.
+func (c *clusterHealth) addresses() map[string]string {
+	addresses := make(map[string]string)
+	for _, localityLb := range c.loadAssignment.GetEndpoints() {
+		for _, lbEndpoint := range localityLb.GetLbEndpoints() {
+			addr := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()
+			if addr == nil {
+				continue
+			}
+			port := addr.GetPortValue()
+			if c.config.altPort != 0 {
+				port = c.config.altPort
+			}
+			addresses[endpointAddress(addr)] = net.JoinHostPort(addr.GetAddress(), strconv.Itoa(int(port)))
+		}
+	}
+	return addresses
+}
+
+func endpointAddress(addr *config_core_v3.SocketAddress) string {
+	return net.JoinHostPort(addr.GetAddress(), strconv.Itoa(int(addr.GetPortValue())))
+}
+
+// healthyLoadAssignment returns the load assignment without the unhealthy endpoints. Like the panic mode of envoy,
+// all the endpoints are kept if none of them is healthy.
+func (c *clusterHealth) healthyLoadAssignment() *config_endpoint_v3.ClusterLoadAssignment {
+	out := proto.Clone(c.loadAssignment).(*config_endpoint_v3.ClusterLoadAssignment)
+	healthy := 0
+	for _, localityLb := range out.GetEndpoints() {
+		lbEndpoints := localityLb.LbEndpoints[:0]
+		for _, lbEndpoint := range localityLb.GetLbEndpoints() {
+			addr := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()
+			if addr != nil {
+				if h, ok := c.hosts[endpointAddress(addr)]; ok && h.unhealthy {
+					continue
+				}
+			}
+			lbEndpoints = append(lbEndpoints, lbEndpoint)
+		}
+		localityLb.LbEndpoints = lbEndpoints
+		healthy += len(lbEndpoints)
+	}
+	if healthy == 0 {
+		return c.loadAssignment
+	}
+	return out
+}
+
+func (hc *healthChecker) run(ctx context.Context, clusterName string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if hc.check(ctx, clusterName) {
+				hc.flush(clusterName)
+			}
+		}
+	}
+}
+
+// check probes all the endpoints of the cluster once, and returns whether any endpoint changes its health status.
+func (hc *healthChecker) check(ctx context.Context, clusterName string) bool {
+	hc.mutex.Lock()
+	c, ok := hc.clusters[clusterName]
+	if !ok || c.loadAssignment == nil {
+		hc.mutex.Unlock()
+		return false
+	}
+	config := c.config
+	addresses := c.addresses()
+	hc.mutex.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		resMu   sync.Mutex
+		results = make(map[string]error, len(addresses))
+	)
+	for address, probeAddress := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := hc.probe(ctx, &config, probeAddress)
+			resMu.Lock()
+			results[address] = err
+			resMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	// the cluster may be updated or removed while probing
+	if hc.clusters[clusterName] != c {
+		return false
+	}
+	now := time.Now()
+	changed := false
+	for address, err := range results {
+		h, ok := c.hosts[address]
+		if !ok {
+			// a new endpoint is healthy until it fails to be probed
+			h = &hostHealth{}
+			c.hosts[address] = h
+		}
+		h.lastCheck = now
+		if err == nil {
+			h.lastError = ""
+			h.failures = 0
+			if h.unhealthy {
+				h.successes++
+				if h.successes >= config.healthyThreshold {
+					h.unhealthy, h.successes = false, 0
+					changed = true
+					log.Infof("endpoint %s of cluster %s becomes healthy", address, clusterName)
+				}
+			}
+			continue
+		}
+
+		h.lastError = err.Error()
+		h.successes = 0
+		if !h.unhealthy {
+			h.failures++
+			if h.failures >= config.unhealthyThreshold {
+				h.unhealthy, h.failures = true, 0
+				changed = true
+				log.Infof("endpoint %s of cluster %s becomes unhealthy: %v", address, clusterName, err)
+			}
+		}
+	}
+	return changed
+}
+
+// probe connects to the address, sends the payload and checks the response contains all the receive payloads in order.
+func (hc *healthChecker) probe(ctx context.Context, config *tcpHealthCheck, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, config.timeout)
+	defer cancel()
+	conn, err := hc.dial(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(config.send) == 0 && len(config.receive) == 0 {
+		return nil
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if len(config.send) > 0 {
+		if _, err := conn.Write(config.send); err != nil {
+			return fmt.Errorf("send failed: %v", err)
+		}
+	}
+
+	size := 0
+	for _, receive := range config.receive {
+		size += len(receive)
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("receive failed: %v", err)
+	}
+	rest := response
+	for _, receive := range config.receive {
+		i := bytes.Index(rest, receive)
+		if i < 0 {
+			return fmt.Errorf("unexpected response %x", response)
+		}
+		rest = rest[i+len(receive):]
+	}
+	return nil
+}
+
+// flush writes the load assignment of the cluster without the unhealthy endpoints into the bpf map,
+// the other clusters are left to the ads processor.
+func (hc *healthChecker) flush(clusterName string) {
+	hc.processorLock.Lock()
+	defer hc.processorLock.Unlock()
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	c, ok := hc.clusters[clusterName]
+	if !ok || c.loadAssignment == nil {
+		return
+	}
+	// a cluster waiting for its load assignment after a cds change, or being deleted, is left as is,
+	// the health status is applied along with the next load assignment
+	cluster := hc.cache.ClusterCache.GetApiCluster(clusterName)
+	if cluster == nil || cluster.GetApiStatus() == core_v2.ApiStatus_WAITING ||
+		cluster.GetApiStatus() == core_v2.ApiStatus_DELETE {
+		return
+	}
+	hc.cache.CreateApiClusterByEds(core_v2.ApiStatus_UPDATE, c.healthyLoadAssignment())
+	if err := hc.cache.ClusterCache.FlushCluster(clusterName); err != nil {
+		log.Errorf("flush the endpoint health of cluster %s failed: %v", clusterName, err)
+	}
+}
+
+// dump returns the health status of the endpoints probed, sorted by cluster and address.
+func (hc *healthChecker) dump() []EndpointHealth {
+	if hc == nil {
+		return nil
+	}
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	var out []EndpointHealth
+	for name, c := range hc.clusters {
+		for address, h := range c.hosts {
+			out = append(out, EndpointHealth{
+				Cluster:   name,
+				Address:   address,
+				Healthy:   !h.unhealthy,
+				LastCheck: h.lastCheck.Format(time.RFC3339),
+				LastError: h.lastError,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Cluster != out[j].Cluster {
+			return out[i].Cluster < out[j].Cluster
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+// stop stops checking all the clusters.
+func (hc *healthChecker) stop() {
+	if hc == nil {
+		return
+	}
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	for name, c := range hc.clusters {
+		c.cancel()
+		delete(hc.clusters, name)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	cluster_v2 "kmesh.net/kmesh/api/v2/cluster"
+	core_v2 "kmesh.net/kmesh/api/v2/core"
+)
+
+func newTestLoadAssignment(clusterName string, addresses ...string) *config_endpoint_v3.ClusterLoadAssignment {
+	localityLb := &config_endpoint_v3.LocalityLbEndpoints{}
+	for _, address := range addresses {
+		host, port, _ := net.SplitHostPort(address)
+		portValue, _ := strconv.Atoi(port)
+		localityLb.LbEndpoints = append(localityLb.LbEndpoints, &config_endpoint_v3.LbEndpoint{
+			HostIdentifier: &config_endpoint_v3.LbEndpoint_Endpoint{
+				Endpoint: &config_endpoint_v3.Endpoint{
+					Address: &config_core_v3.Address{
+						Address: &config_core_v3.Address_SocketAddress{
+							SocketAddress: &config_core_v3.SocketAddress{
+								Address:       host,
+								PortSpecifier: &config_core_v3.SocketAddress_PortValue{PortValue: uint32(portValue)},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	return &config_endpoint_v3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   []*config_endpoint_v3.LocalityLbEndpoints{localityLb},
+	}
+}
+
+func TestNewTcpHealthCheck(t *testing.T) {
+	cluster := &config_cluster_v3.Cluster{Name: "c1"}
+	config, err := newTcpHealthCheck(cluster)
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	cluster.HealthChecks = []*config_core_v3.HealthCheck{
+		{
+			HealthChecker: &config_core_v3.HealthCheck_HttpHealthCheck_{},
+		},
+		{
+			Interval:           durationpb.New(3 * time.Second),
+			UnhealthyThreshold: wrapperspb.UInt32(2),
+			HealthChecker: &config_core_v3.HealthCheck_TcpHealthCheck_{
+				TcpHealthCheck: &config_core_v3.HealthCheck_TcpHealthCheck{
+					Send: &config_core_v3.HealthCheck_Payload{
+						Payload: &config_core_v3.HealthCheck_Payload_Text{Text: "70696e67"},
+					},
+					Receive: []*config_core_v3.HealthCheck_Payload{
+						{Payload: &config_core_v3.HealthCheck_Payload_Binary{Binary: []byte("pong")}},
+					},
+				},
+			},
+		},
+	}
+	config, err = newTcpHealthCheck(cluster)
+	assert.NoError(t, err)
+	assert.Equal(t, &tcpHealthCheck{
+		interval:           3 * time.Second,
+		timeout:            defaultHealthCheckTimeout,
+		unhealthyThreshold: 2,
+		healthyThreshold:   1,
+		send:               []byte("ping"),
+		receive:            [][]byte{[]byte("pong")},
+	}, config)
+
+	cluster.HealthChecks[1].GetTcpHealthCheck().Send.Payload = &config_core_v3.HealthCheck_Payload_Text{Text: "ping"}
+	_, err = newTcpHealthCheck(cluster)
+	assert.Error(t, err)
+}
+
+func TestHealthCheckerCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4)
+			if _, err := conn.Read(buf); err == nil && string(buf) == "ping" {
+				_, _ = conn.Write([]byte("pong"))
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	hc := newHealthChecker(nil, &sync.Mutex{})
+	c := &clusterHealth{
+		config: tcpHealthCheck{
+			interval:           time.Second,
+			timeout:            time.Second,
+			unhealthyThreshold: 2,
+			healthyThreshold:   1,
+			send:               []byte("ping"),
+			receive:            [][]byte{[]byte("pong")},
+		},
+		loadAssignment: newTestLoadAssignment("c1", listener.Addr().String(), closedAddress),
+		hosts:          make(map[string]*hostHealth),
+		cancel:         func() {},
+	}
+	hc.clusters["c1"] = c
+
+	// an endpoint becomes unhealthy after unhealthyThreshold failures
+	assert.False(t, hc.check(context.Background(), "c1"))
+	assert.True(t, hc.check(context.Background(), "c1"))
+
+	health := hc.dump()
+	assert.Len(t, health, 2)
+	for _, h := range health {
+		assert.Equal(t, h.Address == listener.Addr().String(), h.Healthy)
+	}
+
+	la := c.healthyLoadAssignment()
+	assert.Len(t, la.GetEndpoints()[0].GetLbEndpoints(), 1)
+	assert.Equal(t, listener.Addr().String(), endpointAddress(la.GetEndpoints()[0].GetLbEndpoints()[0].GetEndpoint().GetAddress().GetSocketAddress()))
+	// the load assignment received is untouched
+	assert.Len(t, c.loadAssignment.GetEndpoints()[0].GetLbEndpoints(), 2)
+
+	// all the endpoints are kept if none of them is healthy
+	c.hosts[listener.Addr().String()].unhealthy = true
+	assert.Len(t, c.healthyLoadAssignment().GetEndpoints()[0].GetLbEndpoints(), 2)
+
+	// the endpoint becomes healthy after healthyThreshold successes
+	assert.True(t, hc.check(context.Background(), "c1"))
+	assert.False(t, c.hosts[listener.Addr().String()].unhealthy)
+
+	// a wrong response fails the check
+	c.config.receive = [][]byte{[]byte("gone")}
+	hc.check(context.Background(), "c1")
+	assert.NotEmpty(t, c.hosts[listener.Addr().String()].lastError)
+}
+
+func TestHealthCheckerFlushWaitingCluster(t *testing.T) {
+	p := newProcessor(nil)
+	hc := p.healthChecker
+	hc.clusters["c1"] = &clusterHealth{
+		loadAssignment: newTestLoadAssignment("c1", "10.0.0.1:80"),
+		hosts:          map[string]*hostHealth{"10.0.0.1:80": {unhealthy: true}},
+		cancel:         func() {},
+	}
+	// the cluster is changed by cds and waits for its load assignment
+	p.Cache.ClusterCache.SetApiCluster("c1", &cluster_v2.Cluster{Name: "c1", ApiStatus: core_v2.ApiStatus_WAITING})
+
+	// the health change is applied once the processor finishes applying the response
+	p.mutex.Lock()
+	done := make(chan struct{})
+	go func() {
+		hc.flush("c1")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("health change is applied while a response is being applied")
+	case <-time.After(100 * time.Millisecond):
+	}
+	p.mutex.Unlock()
+	<-done
+
+	cluster := p.Cache.ClusterCache.GetApiCluster("c1")
+	assert.Equal(t, core_v2.ApiStatus_WAITING, cluster.GetApiStatus())
+	assert.Nil(t, cluster.GetLoadAssignment())
+}
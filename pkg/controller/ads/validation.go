@@ -0,0 +1,165 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+// maxResourceSize is the max size in bytes of a single resource accepted
+const maxResourceSize = 1 << 20
+
+// validateResources checks the resources of a response can be converted into the bpf maps. It returns the valid
+// ones to apply, the names of the invalid ones, which keep the config last accepted, and the error to reject
+// the response with.
+func validateResources(typeUrl string, resources []*anypb.Any) ([]*anypb.Any, sets.Set[string], error) {
+	var (
+		valid   = make([]*anypb.Any, 0, len(resources))
+		invalid = sets.New[string]()
+		errs    []error
+	)
+	for _, resource := range resources {
+		name, err := validateResource(typeUrl, resource)
+		if err != nil {
+			if name != "" {
+				invalid.Insert(name)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		valid = append(valid, resource)
+	}
+	return valid, invalid, errors.Join(errs...)
+}
+
+// validateResource returns the name of the resource, and the error if it is invalid.
+func validateResource(typeUrl string, resource *anypb.Any) (string, error) {
+	if resource.GetTypeUrl() != typeUrl {
+		return "", fmt.Errorf("unexpected resource type %s", resource.GetTypeUrl())
+	}
+
+	var name string
+	switch typeUrl {
+	case resource_v3.ClusterType:
+		cluster := &config_cluster_v3.Cluster{}
+		if err := resource.UnmarshalTo(cluster); err != nil {
+			return "", err
+		}
+		name = cluster.GetName()
+		if name == "" {
+			return "", fmt.Errorf("cluster name is empty")
+		}
+	case resource_v3.EndpointType:
+		loadAssignment := &config_endpoint_v3.ClusterLoadAssignment{}
+		if err := resource.UnmarshalTo(loadAssignment); err != nil {
+			return "", err
+		}
+		name = loadAssignment.GetClusterName()
+		for _, localityLbEndpoints := range loadAssignment.GetEndpoints() {
+			for _, lbEndpoint := range localityLbEndpoints.GetLbEndpoints() {
+				if err := validateAddress(lbEndpoint.GetEndpoint().GetAddress()); err != nil {
+					return name, fmt.Errorf("cluster %s: %v", name, err)
+				}
+			}
+		}
+	case resource_v3.ListenerType:
+		listener := &config_listener_v3.Listener{}
+		if err := resource.UnmarshalTo(listener); err != nil {
+			return "", err
+		}
+		name = listener.GetName()
+		if err := validateAddress(listener.GetAddress()); err != nil {
+			return name, fmt.Errorf("listener %s: %v", name, err)
+		}
+	case resource_v3.RouteType:
+		routeConfiguration := &config_route_v3.RouteConfiguration{}
+		if err := resource.UnmarshalTo(routeConfiguration); err != nil {
+			return "", err
+		}
+		name = routeConfiguration.GetName()
+		if name == "" {
+			return "", fmt.Errorf("route configuration name is empty")
+		}
+	}
+	if size := proto.Size(resource); size > maxResourceSize {
+		return name, fmt.Errorf("resource %s size %d exceeds %d", name, size, maxResourceSize)
+	}
+	return name, nil
+}
+
+// validateAddress rejects the malformed ip socket address only. Like newApiSocketAddress, the addresses
+// not supported, e.g. pipes, internal addresses and non TCP ones, are skipped, and hostnames are kept.
+func validateAddress(address *core_v3.Address) error {
+	socketAddress := address.GetSocketAddress()
+	if socketAddress == nil || socketAddress.GetProtocol() != core_v3.SocketAddress_TCP {
+		return nil
+	}
+	if socketAddress.GetPortValue() > 65535 {
+		return fmt.Errorf("invalid port %d", socketAddress.GetPortValue())
+	}
+	if _, err := netip.ParseAddr(socketAddress.GetAddress()); err != nil && isIpLiteral(socketAddress.GetAddress()) {
+		return fmt.Errorf("invalid address %q", socketAddress.GetAddress())
+	}
+	return nil
+}
+
+// isIpLiteral returns whether the address is meant to be an ip rather than a hostname.
+func isIpLiteral(address string) bool {
+	if strings.Contains(address, ":") {
+		return true
+	}
+	return strings.Trim(address, "0123456789.") == ""
+}
+
+func newErrorDetail(err error) *status.Status {
+	return &status.Status{
+		Code:    int32(codes.InvalidArgument),
+		Message: err.Error(),
+	}
+}
+
+// nack rejects the response, the version last accepted is sent back along with the error.
+func (p *processor) nack(typeUrl string, err error) {
+	log.Errorf("reject %s response: %v", typeUrl, err)
+	p.ack.VersionInfo = p.versions[typeUrl]
+	p.ack.ErrorDetail = newErrorDetail(err)
+	// the non wildcard request should contain all the names subscribed
+	switch typeUrl {
+	case resource_v3.EndpointType:
+		p.ack.ResourceNames = p.Cache.edsClusterNames
+	case resource_v3.RouteType:
+		p.ack.ResourceNames = p.Cache.routeNames
+	}
+	telemetry.IncXdsRejection(typeUrl)
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"testing"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func socketAddress(address string, port uint32) *core_v3.Address {
+	return &core_v3.Address{
+		Address: &core_v3.Address_SocketAddress{
+			SocketAddress: &core_v3.SocketAddress{
+				Address:       address,
+				PortSpecifier: &core_v3.SocketAddress_PortValue{PortValue: port},
+			},
+		},
+	}
+}
+
+func loadAssignment(name, address string, port uint32) *config_endpoint_v3.ClusterLoadAssignment {
+	return &config_endpoint_v3.ClusterLoadAssignment{
+		ClusterName: name,
+		Endpoints: []*config_endpoint_v3.LocalityLbEndpoints{{
+			LbEndpoints: []*config_endpoint_v3.LbEndpoint{{
+				HostIdentifier: &config_endpoint_v3.LbEndpoint_Endpoint{
+					Endpoint: &config_endpoint_v3.Endpoint{Address: socketAddress(address, port)},
+				},
+			}},
+		}},
+	}
+}
+
+func TestValidateResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeUrl  string
+		resource proto.Message
+		wantErr  string
+	}{
+		{
+			name:     "valid cluster",
+			typeUrl:  resource_v3.ClusterType,
+			resource: &config_cluster_v3.Cluster{Name: "outbound|80||svc"},
+		},
+		{
+			name:     "cluster without name",
+			typeUrl:  resource_v3.ClusterType,
+			resource: &config_cluster_v3.Cluster{},
+			wantErr:  "cluster name is empty",
+		},
+		{
+			name:     "unexpected type",
+			typeUrl:  resource_v3.EndpointType,
+			resource: &config_cluster_v3.Cluster{Name: "outbound|80||svc"},
+			wantErr:  "unexpected resource type",
+		},
+		{
+			name:     "valid endpoints",
+			typeUrl:  resource_v3.EndpointType,
+			resource: loadAssignment("outbound|80||svc", "10.244.0.1", 8080),
+		},
+		{
+			name:     "bad endpoint address",
+			typeUrl:  resource_v3.EndpointType,
+			resource: loadAssignment("outbound|80||svc", "10.244.0", 8080),
+			wantErr:  "invalid address",
+		},
+		{
+			name:     "bad endpoint port",
+			typeUrl:  resource_v3.EndpointType,
+			resource: loadAssignment("outbound|80||svc", "10.244.0.1", 70000),
+			wantErr:  "invalid port",
+		},
+		{
+			name:     "listener without address",
+			typeUrl:  resource_v3.ListenerType,
+			resource: &config_listener_v3.Listener{Name: "virtualOutbound"},
+		},
+		{
+			name:     "bad listener address",
+			typeUrl:  resource_v3.ListenerType,
+			resource: &config_listener_v3.Listener{Name: "0.0.0.0_80", Address: socketAddress("::1::2", 80)},
+			wantErr:  "invalid address",
+		},
+		{
+			name:     "hostname endpoint is kept",
+			typeUrl:  resource_v3.EndpointType,
+			resource: loadAssignment("outbound|80||svc", "httpbin.default.svc.cluster.local", 8080),
+		},
+		{
+			name:    "internal endpoint address is skipped",
+			typeUrl: resource_v3.EndpointType,
+			resource: &config_endpoint_v3.ClusterLoadAssignment{
+				ClusterName: "outbound|80||svc",
+				Endpoints: []*config_endpoint_v3.LocalityLbEndpoints{{
+					LbEndpoints: []*config_endpoint_v3.LbEndpoint{{
+						HostIdentifier: &config_endpoint_v3.LbEndpoint_Endpoint{
+							Endpoint: &config_endpoint_v3.Endpoint{Address: &core_v3.Address{
+								Address: &core_v3.Address_EnvoyInternalAddress{
+									EnvoyInternalAddress: &core_v3.EnvoyInternalAddress{
+										AddressNameSpecifier: &core_v3.EnvoyInternalAddress_ServerListenerName{ServerListenerName: "connect_originate"},
+									},
+								},
+							}},
+						},
+					}},
+				}},
+			},
+		},
+		{
+			name:    "udp listener address is skipped",
+			typeUrl: resource_v3.ListenerType,
+			resource: &config_listener_v3.Listener{Name: "0.0.0.0_53", Address: &core_v3.Address{
+				Address: &core_v3.Address_SocketAddress{
+					SocketAddress: &core_v3.SocketAddress{
+						Protocol:      core_v3.SocketAddress_UDP,
+						Address:       "0.0.0",
+						PortSpecifier: &core_v3.SocketAddress_PortValue{PortValue: 53},
+					},
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource, err := anypb.New(tt.resource)
+			assert.NoError(t, err)
+			_, err = validateResource(tt.typeUrl, resource)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessAdsResponseNack(t *testing.T) {
+	p := newProcessor(nil)
+	p.versions[resource_v3.EndpointType] = "v1"
+	p.Cache.edsClusterNames = []string{"outbound|80||svc"}
+
+	invalid, err := anypb.New(loadAssignment("outbound|80||svc", "10.244.0", 8080))
+	assert.NoError(t, err)
+	valid, err := anypb.New(loadAssignment("outbound|8080||svc", "10.244.0.1", 8080))
+	assert.NoError(t, err)
+	p.processAdsResponse(&service_discovery_v3.DiscoveryResponse{
+		TypeUrl:     resource_v3.EndpointType,
+		VersionInfo: "v2",
+		Nonce:       "nonce",
+		Resources:   []*anypb.Any{invalid, valid},
+	})
+
+	// the version last accepted is kept
+	assert.Equal(t, "v1", p.versions[resource_v3.EndpointType])
+	assert.Equal(t, "v1", p.ack.VersionInfo)
+	assert.Equal(t, "nonce", p.ack.ResponseNonce)
+	assert.Equal(t, []string{"outbound|80||svc"}, p.ack.ResourceNames)
+	assert.Equal(t, int32(codes.InvalidArgument), p.ack.ErrorDetail.GetCode())
+	assert.Contains(t, p.ack.ErrorDetail.GetMessage(), "invalid address")
+	// the invalid resource alone is rejected, and keeps the config last accepted
+	assert.Equal(t, "nonce", p.lastNonce.edsNonce)
+	assert.True(t, p.invalidResources.Has("outbound|80||svc"))
+	assert.False(t, p.invalidResources.Has("outbound|8080||svc"))
+}
+
+func TestValidateResources(t *testing.T) {
+	var resources []*anypb.Any
+	for _, cluster := range []*config_cluster_v3.Cluster{{Name: "outbound|80||svc"}, {}, {Name: "outbound|8080||svc"}} {
+		resource, err := anypb.New(cluster)
+		assert.NoError(t, err)
+		resources = append(resources, resource)
+	}
+	listener, err := anypb.New(&config_listener_v3.Listener{Name: "0.0.0.0_80", Address: socketAddress("10.244.0", 80)})
+	assert.NoError(t, err)
+
+	valid, invalid, err := validateResources(resource_v3.ClusterType, resources)
+	assert.ErrorContains(t, err, "cluster name is empty")
+	assert.Equal(t, []*anypb.Any{resources[0], resources[2]}, valid)
+	assert.Equal(t, 0, invalid.Len())
+
+	valid, invalid, err = validateResources(resource_v3.ListenerType, []*anypb.Any{listener})
+	assert.ErrorContains(t, err, "invalid address")
+	assert.Empty(t, valid)
+	assert.True(t, invalid.Has("0.0.0.0_80"))
+}
@@ -18,6 +18,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -48,6 +49,9 @@ type XdsClient struct {
 	AdsController      *ads.Controller
 	WorkloadController *workload.Controller
 	xdsConfig          *config.XdsConfig
+	servers            *discoveryServers
+	// the resources are loaded from the files of the directory instead of the discovery servers if not empty
+	staticConfigDir string
 }
 
 func NewXdsClient(mode string, bpfAds *bpfads.BpfAds, bpfWorkload *bpfwl.BpfWorkload, enableMonitoring, enableProfiling bool) (*XdsClient, error) {
@@ -55,6 +59,7 @@ func NewXdsClient(mode string, bpfAds *bpfads.BpfAds, bpfWorkload *bpfwl.BpfWork
 		mode:      mode,
 		xdsConfig: config.GetConfig(mode),
 	}
+	client.servers = newDiscoveryServers(client.xdsConfig.DiscoveryAddresses)
 
 	switch mode {
 	case constants.DualEngineMode:
@@ -72,22 +77,45 @@ func NewXdsClient(mode string, bpfAds *bpfads.BpfAds, bpfWorkload *bpfwl.BpfWork
 	return client, nil
 }
 
+// createGrpcStreamClient connects to the discovery servers in the order of priority, until one of them succeeds.
 func (c *XdsClient) createGrpcStreamClient() error {
+	var errs []error
+	// release the context of the previous stream, which is done once the connection is lost
+	c.servers.cancelCurrent()
+	for i, address := range c.servers.addresses {
+		// the stream context is canceled to fail back to a server with higher priority
+		streamCtx, cancel := context.WithCancel(c.ctx)
+		if err := c.connectServer(streamCtx, address); err != nil {
+			cancel()
+			log.Warnf("connect to discovery server %s failed: %v", address, err)
+			errs = append(errs, fmt.Errorf("%s: %v", address, err))
+			continue
+		}
+		c.servers.setConnected(i, cancel)
+		log.Infof("connected to discovery server %s", address)
+		return nil
+	}
+	err := errors.Join(errs...)
+	c.servers.setError(err)
+	return err
+}
+
+func (c *XdsClient) connectServer(ctx context.Context, address string) error {
 	var err error
 
-	if c.grpcConn, err = nets.GrpcConnect(c.xdsConfig.DiscoveryAddress); err != nil {
+	if c.grpcConn, err = nets.GrpcConnect(address); err != nil {
 		return fmt.Errorf("grpc connect failed: %s", err)
 	}
 
 	c.client = discoveryv3.NewAggregatedDiscoveryServiceClient(c.grpcConn)
 
 	if c.mode == constants.DualEngineMode {
-		if err = c.WorkloadController.WorkloadStreamCreateAndSend(c.client, c.ctx); err != nil {
+		if err = c.WorkloadController.WorkloadStreamCreateAndSend(c.client, ctx); err != nil {
 			_ = c.grpcConn.Close()
 			return fmt.Errorf("create workload stream failed, %s", err)
 		}
 	} else if c.mode == constants.KernelNativeMode {
-		if err = c.AdsController.AdsStreamCreateAndSend(c.client, c.ctx); err != nil {
+		if err = c.AdsController.AdsStreamCreateAndSend(c.client, ctx); err != nil {
 			_ = c.grpcConn.Close()
 			return fmt.Errorf("create ads stream failed, %s", err)
 		}
@@ -102,6 +130,7 @@ func (c *XdsClient) recoverConnection() {
 		interval = time.Second
 	)
 
+	c.servers.incReconnects()
 	for {
 		if err = c.createGrpcStreamClient(); err == nil {
 			log.Infof("grpc reconnect succeed")
@@ -139,6 +168,7 @@ func (c *XdsClient) handleUpstream(ctx context.Context) {
 				if istioGrpc.GRPCErrorType(err) == istioGrpc.UnexpectedError {
 					log.Errorf("Failed to establish grpc link to control plane: %v", err)
 				}
+				c.servers.setError(err)
 				_ = c.grpcConn.Close()
 				reconnect = true
 			}
@@ -147,11 +177,32 @@ func (c *XdsClient) handleUpstream(ctx context.Context) {
 }
 
 func (c *XdsClient) Run(stopCh <-chan struct{}) error {
+	if c.staticConfigDir != "" {
+		return c.runStatic(stopCh)
+	}
+
 	if err := c.createGrpcStreamClient(); err != nil {
 		return fmt.Errorf("create client and stream failed, %s", err)
 	}
 
 	go c.handleUpstream(c.ctx)
+	go c.servers.runFailBack(c.ctx)
+
+	go func() {
+		<-stopCh
+		c.closeStreamClient()
+		if c.cancel != nil {
+			c.cancel()
+		}
+	}()
+
+	return nil
+}
+
+func (c *XdsClient) runStatic(stopCh <-chan struct{}) error {
+	if err := newStaticSource(c.staticConfigDir, c).start(c.ctx); err != nil {
+		return fmt.Errorf("start static config source failed, %s", err)
+	}
 
 	go func() {
 		<-stopCh
@@ -177,6 +228,11 @@ func (c *XdsClient) closeStreamClient() {
 	}
 }
 
+// ConnectionState returns the state of the connection to the discovery servers.
+func (c *XdsClient) ConnectionState() ConnectionState {
+	return c.servers.state()
+}
+
 func (c *XdsClient) Close() error {
 	return nil
 }
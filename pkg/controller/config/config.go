@@ -51,10 +51,15 @@ var (
 )
 
 type XdsConfig struct {
-	ServiceNode      string
+	ServiceNode string
+	// DiscoveryAddress is the discovery server with the highest priority
 	DiscoveryAddress string
-	Metadata         *model.BootstrapNodeMetadata
-	Node             *corev3.Node
+	// DiscoveryAddresses are the discovery servers in the order of priority
+	DiscoveryAddresses []string
+	// EnableDeltaAds subscribes the kernel-native resources by the incremental xds
+	EnableDeltaAds bool
+	Metadata       *model.BootstrapNodeMetadata
+	Node           *corev3.Node
 }
 
 func NewXDSConfig(mode string) *XdsConfig {
@@ -64,7 +69,11 @@ func NewXDSConfig(mode string) *XdsConfig {
 	podIP := env.Register("INSTANCE_IP", "", "").Get()
 	podName := env.Register("POD_NAME", "", "").Get()
 	podNamespace := env.Register("POD_NAMESPACE", "", "").Get()
-	c.DiscoveryAddress = env.Register("XDS_ADDRESS", "istiod.istio-system.svc:15012", "").Get()
+	c.DiscoveryAddresses = parseDiscoveryAddresses(env.Register("XDS_ADDRESS", "istiod.istio-system.svc:15012",
+		"Comma separated discovery servers in the order of priority").Get())
+	c.DiscoveryAddress = c.DiscoveryAddresses[0]
+	c.EnableDeltaAds = env.Register("KMESH_ENABLE_DELTA_ADS", false,
+		"Use the incremental xds in kernel-native mode, fall back to state of the world if not supported").Get()
 	clusterID := env.Register("CLUSTER_ID", "Kubernetes", "").Get()
 	sa := env.Register("SERVICE_ACCOUNT", "", "").Get()
 	nodeName := env.Register("NODE_NAME", "", "").Get()
@@ -81,7 +90,7 @@ func NewXDSConfig(mode string) *XdsConfig {
 
 	c.ServiceNode = strings.Join([]string{getNodeRole(mode), ip, id, dnsDomain}, serviceNodeSeparator)
 
-	log.Infof("proxy %v connect to discovery address %v", c.ServiceNode, c.DiscoveryAddress)
+	log.Infof("proxy %v connect to discovery addresses %v", c.ServiceNode, c.DiscoveryAddresses)
 
 	c.Metadata.Namespace = podNamespace
 	c.Metadata.ClusterID = cluster.ID(clusterID)
@@ -96,6 +105,20 @@ func NewXDSConfig(mode string) *XdsConfig {
 	return c
 }
 
+func parseDiscoveryAddresses(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		// keep the behavior of an empty address
+		addresses = []string{""}
+	}
+	return addresses
+}
+
 func GetConfig(mode string) *XdsConfig {
 	if config != nil {
 		return config
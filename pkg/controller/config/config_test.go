@@ -32,3 +32,9 @@ func TestInit(t *testing.T) {
 	assert.Equal(t, "sidecar~10.244.0.81~test.testNs~testNs.svc.cluster.local", config.ServiceNode)
 	assert.Equal(t, "istiod.istio-system.svc:15012", config.DiscoveryAddress)
 }
+
+func TestParseDiscoveryAddresses(t *testing.T) {
+	assert.Equal(t, []string{"istiod.istio-system.svc:15012"}, parseDiscoveryAddresses("istiod.istio-system.svc:15012"))
+	assert.Equal(t, []string{"istiod-a:15012", "istiod-b:15012"}, parseDiscoveryAddresses(" istiod-a:15012, istiod-b:15012,"))
+	assert.Equal(t, []string{""}, parseDiscoveryAddresses(""))
+}
@@ -19,6 +19,7 @@ package controller
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/cilium/ebpf"
@@ -35,6 +36,7 @@ import (
 	"kmesh.net/kmesh/pkg/controller/encryption/ipsec"
 	manage "kmesh.net/kmesh/pkg/controller/manage"
 	"kmesh.net/kmesh/pkg/controller/security"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 	"kmesh.net/kmesh/pkg/controller/workload"
 	"kmesh.net/kmesh/pkg/dns"
 	"kmesh.net/kmesh/pkg/kolog"
@@ -64,6 +66,13 @@ type Controller struct {
 	enableByPass        bool
 	enableSecretManager bool
 	bpfConfig           *options.BpfConfig
+	outlierDetection    *options.OutlierDetectionConfig
+	otlpConfig          *options.OtlpConfig
+	accesslogConfig     *options.AccesslogConfig
+	metricConfig        *options.MetricConfig
+	ipfixConfig         *options.IpfixConfig
+	mapCapacityConfig   *options.MapCapacityConfig
+	staticConfigDir     string
 	loader              *bpf.BpfLoader
 	dnsServer           *dnsclient.LocalDNSServer
 }
@@ -76,6 +85,13 @@ func NewController(opts *options.BootstrapConfigs, bpfLoader *bpf.BpfLoader) *Co
 		bpfWorkloadObj:      bpfLoader.GetBpfWorkload(),
 		enableSecretManager: opts.SecretManagerConfig.Enable,
 		bpfConfig:           opts.BpfConfig,
+		outlierDetection:    opts.OutlierDetection,
+		otlpConfig:          opts.Otlp,
+		accesslogConfig:     opts.Accesslog,
+		metricConfig:        opts.Metric,
+		ipfixConfig:         opts.Ipfix,
+		mapCapacityConfig:   opts.MapCapacity,
+		staticConfigDir:     opts.StaticConfig.Dir,
 		loader:              bpfLoader,
 	}
 }
@@ -166,15 +182,43 @@ func (c *Controller) Start(stopCh <-chan struct{}) error {
 		}
 	}
 
+	// the histograms are registered when the metric controller is run by the xds client
+	if c.metricConfig != nil {
+		telemetry.SetLatencyBuckets(c.metricConfig.DurationBuckets, c.metricConfig.SrttBuckets)
+		if err := telemetry.ConfigureCardinality(c.metricConfig); err != nil {
+			return fmt.Errorf("failed to configure metric cardinality: %w", err)
+		}
+	}
 	c.client, err = NewXdsClient(c.mode, c.bpfAdsObj, c.bpfWorkloadObj, c.bpfConfig.EnableMonitoring, c.bpfConfig.EnableProfiling)
 	if err != nil {
 		return fmt.Errorf("failed to create XDS client: %w", err)
 	}
+	c.client.staticConfigDir = c.staticConfigDir
 
 	if c.client.WorkloadController != nil {
+		if c.mapCapacityConfig.Enabled() {
+			recorder := kube.NewEventRecorder(clientset, "kmesh-daemon", os.Getenv("NODE_NAME"))
+			c.client.WorkloadController.EnableMapCapacityAlerts(c.mapCapacityConfig, recorder)
+		}
 		if err := c.client.WorkloadController.Run(ctx, stopCh); err != nil {
 			return fmt.Errorf("failed to start workload controller: %+v", err)
 		}
+		go c.client.WorkloadController.WatchServiceLbPolicy(clientset, stopCh)
+		metadataClient, err := kube.CreateMetadataClient("")
+		if err != nil {
+			return fmt.Errorf("failed to create kube metadata client: %v", err)
+		}
+		go c.client.WorkloadController.WatchAuthorizationPolicyDryRun(metadataClient, stopCh)
+		c.client.WorkloadController.StartOutlierDetection(ctx, c.outlierDetection)
+		if err := c.client.WorkloadController.MetricController.StartOtlpExport(ctx, c.otlpConfig); err != nil {
+			return fmt.Errorf("failed to start otlp export: %v", err)
+		}
+		if err := c.client.WorkloadController.MetricController.StartIpfixExport(ctx, c.ipfixConfig); err != nil {
+			return fmt.Errorf("failed to start ipfix export: %v", err)
+		}
+		if err := c.client.WorkloadController.MetricController.ConfigureAccesslog(ctx, c.accesslogConfig); err != nil {
+			return fmt.Errorf("failed to configure accesslog: %v", err)
+		}
 		if err := c.setupDNSProxy(); err != nil {
 			return fmt.Errorf("failed to start dns proxy: %+v", err)
 		}
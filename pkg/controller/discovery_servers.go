@@ -0,0 +1,166 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// the interval to check whether a discovery server with higher priority is back
+	failBackInterval = 30 * time.Second
+	failBackTimeout  = 3 * time.Second
+)
+
+// ConnectionState is the state of the connection to the discovery servers.
+type ConnectionState struct {
+	Servers        []string `json:"servers"`
+	CurrentServer  string   `json:"currentServer,omitempty"`
+	Connected      bool     `json:"connected"`
+	ConnectedSince string   `json:"connectedSince,omitempty"`
+	Reconnects     uint64   `json:"reconnects"`
+	LastError      string   `json:"lastError,omitempty"`
+	LastErrorTime  string   `json:"lastErrorTime,omitempty"`
+}
+
+// discoveryServers tracks the discovery servers in the order of priority and the one connected.
+type discoveryServers struct {
+	mutex          sync.RWMutex
+	addresses      []string
+	current        int
+	connected      bool
+	connectedSince time.Time
+	reconnects     uint64
+	lastError      string
+	lastErrorTime  time.Time
+	// cancels the stream to the current server
+	cancelStream context.CancelFunc
+}
+
+func newDiscoveryServers(addresses []string) *discoveryServers {
+	return &discoveryServers{
+		addresses: addresses,
+		current:   -1,
+	}
+}
+
+func (s *discoveryServers) setConnected(index int, cancelStream context.CancelFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.current = index
+	s.connected = true
+	s.connectedSince = time.Now()
+	s.cancelStream = cancelStream
+}
+
+// cancelCurrent cancels the stream to the current server, if any.
+func (s *discoveryServers) cancelCurrent() {
+	s.mutex.Lock()
+	cancelStream := s.cancelStream
+	s.cancelStream = nil
+	s.mutex.Unlock()
+	if cancelStream != nil {
+		cancelStream()
+	}
+}
+
+func (s *discoveryServers) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = false
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+func (s *discoveryServers) incReconnects() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reconnects++
+}
+
+func (s *discoveryServers) state() ConnectionState {
+	if s == nil {
+		return ConnectionState{}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state := ConnectionState{
+		Servers:    s.addresses,
+		Connected:  s.connected,
+		Reconnects: s.reconnects,
+		LastError:  s.lastError,
+	}
+	if s.current >= 0 {
+		state.CurrentServer = s.addresses[s.current]
+	}
+	if s.connected {
+		state.ConnectedSince = s.connectedSince.Format(time.RFC3339)
+	}
+	if !s.lastErrorTime.IsZero() {
+		state.LastErrorTime = s.lastErrorTime.Format(time.RFC3339)
+	}
+	return state
+}
+
+// failBack cancels the stream to the current server if a server with higher priority is reachable,
+// then the client reconnects to the servers from the highest priority.
+func (s *discoveryServers) failBack(ctx context.Context, reachable func(ctx context.Context, address string) bool) {
+	s.mutex.RLock()
+	current, connected, cancelStream := s.current, s.connected, s.cancelStream
+	s.mutex.RUnlock()
+	if !connected || current <= 0 || cancelStream == nil {
+		return
+	}
+
+	for i := 0; i < current; i++ {
+		if reachable(ctx, s.addresses[i]) {
+			log.Infof("discovery server %s is back, fail back from %s", s.addresses[i], s.addresses[current])
+			cancelStream()
+			return
+		}
+	}
+}
+
+func (s *discoveryServers) runFailBack(ctx context.Context) {
+	if len(s.addresses) < 2 {
+		return
+	}
+	ticker := time.NewTicker(failBackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.failBack(ctx, tcpReachable)
+		}
+	}
+}
+
+func tcpReachable(ctx context.Context, address string) bool {
+	ctx, cancel := context.WithTimeout(ctx, failBackTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	bpfads "kmesh.net/kmesh/pkg/bpf/ads"
+	bpfwl "kmesh.net/kmesh/pkg/bpf/workload"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/xdstest"
+	"kmesh.net/kmesh/pkg/nets"
+)
+
+func TestCreateGrpcStreamClientFailover(t *testing.T) {
+	utClient, err := NewXdsClient(constants.KernelNativeMode, &bpfads.BpfAds{}, &bpfwl.BpfWorkload{}, false, false)
+	assert.NoError(t, err)
+	utClient.servers = newDiscoveryServers([]string{"istiod-a:15012", "istiod-b:15012"})
+
+	down := map[string]bool{"istiod-a:15012": true}
+	netPatches := gomonkey.NewPatches()
+	defer netPatches.Reset()
+	netPatches.ApplyFunc(nets.GrpcConnect, func(addr string) (*grpc.ClientConn, error) {
+		if down[addr] {
+			return nil, errors.New("failed to create grpc connect")
+		}
+		mockDiscovery := xdstest.NewXdsServer(t)
+		return grpc.Dial("buffcon",
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return mockDiscovery.Listener.Dial()
+			}))
+	})
+
+	// fail over to the server with lower priority
+	assert.NoError(t, utClient.createGrpcStreamClient())
+	state := utClient.ConnectionState()
+	assert.Equal(t, "istiod-b:15012", state.CurrentServer)
+	assert.True(t, state.Connected)
+
+	// fail back once the server with higher priority is reachable
+	canceled := false
+	utClient.servers.cancelStream = func() { canceled = true }
+	utClient.servers.failBack(context.Background(), func(_ context.Context, address string) bool {
+		return !down[address]
+	})
+	assert.False(t, canceled)
+	down["istiod-a:15012"] = false
+	utClient.servers.failBack(context.Background(), func(_ context.Context, address string) bool {
+		return !down[address]
+	})
+	assert.True(t, canceled)
+
+	// the previous stream is canceled before reconnecting
+	canceled = false
+	assert.NoError(t, utClient.createGrpcStreamClient())
+	assert.True(t, canceled)
+	assert.Equal(t, "istiod-a:15012", utClient.ConnectionState().CurrentServer)
+
+	// all the servers are down
+	down["istiod-a:15012"], down["istiod-b:15012"] = true, true
+	assert.Error(t, utClient.createGrpcStreamClient())
+	state = utClient.ConnectionState()
+	assert.False(t, state.Connected)
+	assert.Contains(t, state.LastError, "istiod-b:15012")
+}
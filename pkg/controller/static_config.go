@@ -0,0 +1,311 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"sigs.k8s.io/yaml"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload"
+	"kmesh.net/kmesh/pkg/utils/hash"
+)
+
+const staticConfigDebounce = 100 * time.Millisecond
+
+// staticResources are the resources loaded from the files by type url and name.
+type staticResources map[string]map[string]*anypb.Any
+
+// staticSource loads the xds resources from the yaml or json files of a directory, and applies the changes through
+// the same processors as the discovery server. Each yaml document or json file is a single resource with its `@type`:
+//
+//	"@type": type.googleapis.com/istio.workload.Workload
+//	uid: ...
+//
+// Workload, Service, Address and Authorization are loaded in dual-engine mode, and Cluster, ClusterLoadAssignment,
+// Listener and RouteConfiguration in kernel-native mode.
+type staticSource struct {
+	dir     string
+	mode    string
+	client  *XdsClient
+	last    staticResources
+	version int
+}
+
+func newStaticSource(dir string, client *XdsClient) *staticSource {
+	return &staticSource{
+		dir:    dir,
+		mode:   client.mode,
+		client: client,
+	}
+}
+
+// load reads all the resources of the directory, an invalid file fails the whole load.
+func (s *staticSource) load() (staticResources, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(staticResources)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		// skip the hidden files, such as the `..data` of configmap volume
+		if entry.IsDir() || entry.Name()[0] == '.' || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range splitYamlDocuments(data) {
+			typeUrl, name, resource, err := s.parseResource(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s document %d: %v", path, i, err)
+			}
+			if resources[typeUrl] == nil {
+				resources[typeUrl] = make(map[string]*anypb.Any)
+			}
+			if _, ok := resources[typeUrl][name]; ok {
+				return nil, fmt.Errorf("%s document %d: duplicate resource %s", path, i, name)
+			}
+			resources[typeUrl][name] = resource
+		}
+	}
+	return resources, nil
+}
+
+func splitYamlDocuments(data []byte) [][]byte {
+	var docs [][]byte
+	for _, doc := range bytes.Split(data, []byte("\n---")) {
+		if len(bytes.TrimSpace(doc)) != 0 {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// parseResource returns the type url subscribed, the name and the resource of a yaml or json document.
+func (s *staticSource) parseResource(doc []byte) (string, string, *anypb.Any, error) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return "", "", nil, err
+	}
+	resource := &anypb.Any{}
+	if err := protojson.Unmarshal(data, resource); err != nil {
+		return "", "", nil, err
+	}
+	msg, err := resource.UnmarshalNew()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var (
+		typeUrl string
+		name    string
+	)
+	switch m := msg.(type) {
+	case *workloadapi.Workload:
+		typeUrl, name = workload.AddressType, m.ResourceName()
+		msg = &workloadapi.Address{Type: &workloadapi.Address_Workload{Workload: m}}
+	case *workloadapi.Service:
+		typeUrl, name = workload.AddressType, m.ResourceName()
+		msg = &workloadapi.Address{Type: &workloadapi.Address_Service{Service: m}}
+	case *workloadapi.Address:
+		typeUrl = workload.AddressType
+		if m.GetWorkload() != nil {
+			name = m.GetWorkload().ResourceName()
+		} else {
+			name = m.GetService().ResourceName()
+		}
+	case *security.Authorization:
+		typeUrl, name = workload.AuthorizationType, m.ResourceName()
+	case *config_cluster_v3.Cluster:
+		typeUrl, name = resource_v3.ClusterType, m.GetName()
+	case *config_endpoint_v3.ClusterLoadAssignment:
+		typeUrl, name = resource_v3.EndpointType, m.GetClusterName()
+	case *config_listener_v3.Listener:
+		typeUrl, name = resource_v3.ListenerType, m.GetName()
+	case *config_route_v3.RouteConfiguration:
+		typeUrl, name = resource_v3.RouteType, m.GetName()
+	default:
+		return "", "", nil, fmt.Errorf("unsupported type %s", resource.GetTypeUrl())
+	}
+
+	dualEngine := typeUrl == workload.AddressType || typeUrl == workload.AuthorizationType
+	if dualEngine != (s.mode == constants.DualEngineMode) {
+		return "", "", nil, fmt.Errorf("type %s is not supported in %s mode", resource.GetTypeUrl(), s.mode)
+	}
+	if name == "" || name == "/" {
+		return "", "", nil, fmt.Errorf("%s has no name", resource.GetTypeUrl())
+	}
+	if resource, err = anypb.New(msg); err != nil {
+		return "", "", nil, err
+	}
+	return typeUrl, name, resource, nil
+}
+
+// apply pushes the resources changed since the last load to the processors.
+func (s *staticSource) apply(resources staticResources) {
+	s.version++
+	if s.mode == constants.DualEngineMode {
+		for _, typeUrl := range []string{workload.AddressType, workload.AuthorizationType} {
+			rsp := s.deltaResponse(typeUrl, resources[typeUrl])
+			// the first response of each type is always pushed, as the controller waits for it
+			if s.last == nil || len(rsp.GetResources()) != 0 || len(rsp.GetRemovedResources()) != 0 {
+				s.client.WorkloadController.HandleResponse(rsp)
+			}
+		}
+	} else if s.mode == constants.KernelNativeMode {
+		// kernel-native mode is full update, and unchanged resources are skipped by the processor
+		for _, typeUrl := range []string{resource_v3.ClusterType, resource_v3.EndpointType, resource_v3.ListenerType, resource_v3.RouteType} {
+			s.client.AdsController.HandleResponse(s.sotwResponse(typeUrl, resources[typeUrl]))
+		}
+	}
+	s.last = resources
+}
+
+func (s *staticSource) deltaResponse(typeUrl string, current map[string]*anypb.Any) *discoveryv3.DeltaDiscoveryResponse {
+	rsp := &discoveryv3.DeltaDiscoveryResponse{
+		TypeUrl:           typeUrl,
+		SystemVersionInfo: strconv.Itoa(s.version),
+		Nonce:             strconv.Itoa(s.version),
+	}
+	last := s.last[typeUrl]
+	for _, name := range sortedNames(current) {
+		if proto.Equal(current[name], last[name]) {
+			continue
+		}
+		rsp.Resources = append(rsp.Resources, &discoveryv3.Resource{
+			Name:     name,
+			Version:  strconv.FormatUint(hash.Sum64String(current[name].String()), 10),
+			Resource: current[name],
+		})
+	}
+	for _, name := range sortedNames(last) {
+		if _, ok := current[name]; !ok {
+			rsp.RemovedResources = append(rsp.RemovedResources, name)
+		}
+	}
+	return rsp
+}
+
+func (s *staticSource) sotwResponse(typeUrl string, current map[string]*anypb.Any) *discoveryv3.DiscoveryResponse {
+	rsp := &discoveryv3.DiscoveryResponse{
+		TypeUrl:     typeUrl,
+		VersionInfo: strconv.Itoa(s.version),
+		Nonce:       strconv.Itoa(s.version),
+		// empty but not nil, so that all the resources of the type are removed
+		Resources: make([]*anypb.Any, 0, len(current)),
+	}
+	for _, name := range sortedNames(current) {
+		rsp.Resources = append(rsp.Resources, current[name])
+	}
+	return rsp
+}
+
+func sortedNames(resources map[string]*anypb.Any) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reload loads the directory and applies the changes, the last resources are kept if the files are invalid.
+func (s *staticSource) reload() error {
+	resources, err := s.load()
+	if err != nil {
+		return fmt.Errorf("load static config from %s failed: %v", s.dir, err)
+	}
+	s.apply(resources)
+	return nil
+}
+
+// run watches the directory and reloads it on changes until ctx is done.
+func (s *staticSource) run(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	var timerC <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timerC:
+			timerC = nil
+			if err := s.reload(); err != nil {
+				log.Error(err)
+				continue
+			}
+			log.Infof("static config reloaded from %s", s.dir)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			log.Debugf("got event %s", event.String())
+			if timerC == nil {
+				timerC = time.After(staticConfigDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("error from static config watcher: %v", err)
+		}
+	}
+}
+
+// start applies the resources of the directory, and then watches it for changes.
+func (s *staticSource) start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create static config watcher: %v", err)
+	}
+	if err := watcher.Add(s.dir); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("failed to watch %s: %v", s.dir, err)
+	}
+	if err := s.reload(); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	log.Infof("static config loaded from %s", s.dir)
+	go s.run(ctx, watcher)
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload"
+)
+
+const staticWorkloads = `
+"@type": type.googleapis.com/istio.workload.Workload
+uid: cluster0//v1/pod/default/pod-1
+name: pod-1
+namespace: default
+addresses: [CgAAAQ==]
+---
+"@type": type.googleapis.com/istio.workload.Service
+name: svc
+namespace: default
+hostname: svc.default.svc.cluster.local
+`
+
+const staticAuthorization = `{
+  "@type": "type.googleapis.com/istio.security.Authorization",
+  "name": "deny-all",
+  "namespace": "default",
+  "action": "DENY"
+}`
+
+func writeStaticFile(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+
+func TestStaticSourceLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFile(t, dir, "workloads.yaml", staticWorkloads)
+	writeStaticFile(t, dir, "authz.json", staticAuthorization)
+	writeStaticFile(t, dir, "README.md", "not a resource")
+
+	s := &staticSource{dir: dir, mode: constants.DualEngineMode}
+	resources, err := s.load()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cluster0//v1/pod/default/pod-1", "default/svc.default.svc.cluster.local"},
+		sortedNames(resources[workload.AddressType]))
+	assert.Equal(t, []string{"default/deny-all"}, sortedNames(resources[workload.AuthorizationType]))
+
+	// kernel-native resources are rejected in dual-engine mode
+	writeStaticFile(t, dir, "cluster.yaml", `
+"@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+name: outbound|80||svc.default.svc.cluster.local
+`)
+	_, err = s.load()
+	assert.Error(t, err)
+
+	s.mode = constants.KernelNativeMode
+	require.NoError(t, os.Remove(filepath.Join(dir, "workloads.yaml")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "authz.json")))
+	resources, err = s.load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outbound|80||svc.default.svc.cluster.local"}, sortedNames(resources[resource_v3.ClusterType]))
+}
+
+func TestStaticSourceDeltaResponse(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFile(t, dir, "workloads.yaml", staticWorkloads)
+
+	s := &staticSource{dir: dir, mode: constants.DualEngineMode}
+	resources, err := s.load()
+	require.NoError(t, err)
+	rsp := s.deltaResponse(workload.AddressType, resources[workload.AddressType])
+	assert.Len(t, rsp.GetResources(), 2)
+	assert.Empty(t, rsp.GetRemovedResources())
+	s.last = resources
+
+	// only the changed and removed resources are pushed
+	writeStaticFile(t, dir, "workloads.yaml", `
+"@type": type.googleapis.com/istio.workload.Workload
+uid: cluster0//v1/pod/default/pod-1
+name: pod-1
+namespace: default
+addresses: [CgAAAg==]
+`)
+	resources, err = s.load()
+	require.NoError(t, err)
+	rsp = s.deltaResponse(workload.AddressType, resources[workload.AddressType])
+	require.Len(t, rsp.GetResources(), 1)
+	assert.Equal(t, "cluster0//v1/pod/default/pod-1", rsp.GetResources()[0].GetName())
+	assert.Equal(t, []string{"default/svc.default.svc.cluster.local"}, rsp.GetRemovedResources())
+
+	// nothing changes
+	s.last = resources
+	rsp = s.deltaResponse(workload.AddressType, resources[workload.AddressType])
+	assert.Empty(t, rsp.GetResources())
+	assert.Empty(t, rsp.GetRemovedResources())
+}
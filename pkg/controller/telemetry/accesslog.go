@@ -30,11 +30,13 @@ type logInfo struct {
 	sourceAddress   string
 	sourceWorkload  string
 	sourceNamespace string
+	sourceIdentity  string
 
 	destinationAddress   string
 	destinationService   string
 	destinationWorkload  string
 	destinationNamespace string
+	destinationIdentity  string
 }
 
 func NewLogInfo() *logInfo {
@@ -43,10 +45,12 @@ func NewLogInfo() *logInfo {
 		sourceAddress:        DEFAULT_UNKNOWN,
 		sourceWorkload:       DEFAULT_UNKNOWN,
 		sourceNamespace:      DEFAULT_UNKNOWN,
+		sourceIdentity:       DEFAULT_UNKNOWN,
 		destinationAddress:   DEFAULT_UNKNOWN,
 		destinationService:   DEFAULT_UNKNOWN,
 		destinationWorkload:  DEFAULT_UNKNOWN,
 		destinationNamespace: DEFAULT_UNKNOWN,
+		destinationIdentity:  DEFAULT_UNKNOWN,
 	}
 }
 
@@ -57,6 +61,9 @@ func (l *logInfo) withSource(workload *workloadapi.Workload) *logInfo {
 	if workload.GetName() != "" {
 		l.sourceWorkload = workload.GetName()
 	}
+	if workload != nil {
+		l.sourceIdentity = buildPrincipal(workload)
+	}
 	return l
 }
 
@@ -64,6 +71,9 @@ func (l *logInfo) withDestination(workload *workloadapi.Workload) *logInfo {
 	if workload.GetName() != "" {
 		l.destinationWorkload = workload.GetName()
 	}
+	if workload != nil {
+		l.destinationIdentity = buildPrincipal(workload)
+	}
 	return l
 }
 
@@ -77,13 +87,10 @@ func (l *logInfo) withDestinationService(service *workloadapi.Service) *logInfo
 	return l
 }
 
-func outputAccesslog(data requestMetric, connMetrics connMetric, accesslog logInfo) {
-	// Skip output access log on connection establishment
-	if data.state == TCP_ESTABLISHED && connMetrics.totalReports == 1 {
-		return
-	}
-	logStr := buildAccesslog(data, connMetrics, accesslog)
-	fmt.Println("accesslog:", logStr)
+// skipAccesslog returns true on connection establishment, the access log is output at intervals
+// during the connection lifecycle and at close of the connection.
+func skipAccesslog(data requestMetric, connMetrics connMetric) bool {
+	return data.state == TCP_ESTABLISHED && connMetrics.totalReports == 1
 }
 
 func buildAccesslog(reqMetric requestMetric, connMetrics connMetric, accesslog logInfo) string {
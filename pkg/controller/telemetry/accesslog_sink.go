@@ -0,0 +1,425 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/syslog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"kmesh.net/kmesh/daemon/options"
+)
+
+// AccesslogRecord is the structured access log of a connection report.
+type AccesslogRecord struct {
+	Timestamp            time.Time `json:"timestamp"`
+	StartTime            time.Time `json:"start_time"`
+	Direction            string    `json:"direction"`
+	State                string    `json:"state"`
+	SourceAddress        string    `json:"src_addr"`
+	SourceWorkload       string    `json:"src_workload"`
+	SourceNamespace      string    `json:"src_namespace"`
+	SourceIdentity       string    `json:"src_identity"`
+	DestinationAddress   string    `json:"dst_addr"`
+	DestinationService   string    `json:"dst_service"`
+	DestinationWorkload  string    `json:"dst_workload"`
+	DestinationNamespace string    `json:"dst_namespace"`
+	DestinationIdentity  string    `json:"dst_identity"`
+	ConnectionSuccess    bool      `json:"connection_success"`
+	// SentBytes and ReceivedBytes are the totals of the connection, the Report ones
+	// are counted since the previous report of the connection
+	SentBytes             uint32  `json:"sent_bytes"`
+	ReceivedBytes         uint32  `json:"received_bytes"`
+	ReportSentBytes       uint32  `json:"report_sent_bytes"`
+	ReportReceivedBytes   uint32  `json:"report_received_bytes"`
+	PacketLoss            uint32  `json:"packet_loss"`
+	Retransmissions       uint32  `json:"retransmissions"`
+	ReportPacketLoss      uint32  `json:"report_packet_loss"`
+	ReportRetransmissions uint32  `json:"report_retransmissions"`
+	SrttUs                uint32  `json:"srtt_us"`
+	MinRttUs              uint32  `json:"min_rtt_us"`
+	DurationMs            float64 `json:"duration_ms"`
+}
+
+func buildAccesslogRecord(data requestMetric, connMetrics connMetric, accesslog logInfo) AccesslogRecord {
+	return AccesslogRecord{
+		Timestamp:             calculateUptime(osStartTime, data.lastReportTime),
+		StartTime:             calculateUptime(osStartTime, data.startTime),
+		Direction:             accesslog.direction,
+		State:                 accesslog.state,
+		SourceAddress:         accesslog.sourceAddress,
+		SourceWorkload:        accesslog.sourceWorkload,
+		SourceNamespace:       accesslog.sourceNamespace,
+		SourceIdentity:        accesslog.sourceIdentity,
+		DestinationAddress:    accesslog.destinationAddress,
+		DestinationService:    accesslog.destinationService,
+		DestinationWorkload:   accesslog.destinationWorkload,
+		DestinationNamespace:  accesslog.destinationNamespace,
+		DestinationIdentity:   accesslog.destinationIdentity,
+		ConnectionSuccess:     data.success == connection_success,
+		SentBytes:             connMetrics.sentBytes,
+		ReceivedBytes:         connMetrics.receivedBytes,
+		ReportSentBytes:       data.sentBytes,
+		ReportReceivedBytes:   data.receivedBytes,
+		PacketLoss:            connMetrics.packetLost,
+		Retransmissions:       connMetrics.totalRetrans,
+		ReportPacketLoss:      data.packetLost,
+		ReportRetransmissions: data.totalRetrans,
+		SrttUs:                srttMicros(data.srtt),
+		MinRttUs:              data.minRtt,
+		DurationMs:            float64(data.duration) / 1000000.0,
+	}
+}
+
+// AccesslogFilter selects the connections whose access logs are output.
+type AccesslogFilter struct {
+	// Namespaces matches the namespace of the source workload or the destination, empty matches all
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Services matches the hostname or the name of the destination service, empty matches all
+	Services []string `json:"services,omitempty"`
+	// SamplingRatio is the ratio of connections logged, in [0, 1]. The sampling is made per
+	// connection, so all the reports of a sampled connection are logged.
+	SamplingRatio float64 `json:"sampling_ratio"`
+}
+
+func defaultAccesslogFilter() *AccesslogFilter {
+	return &AccesslogFilter{SamplingRatio: 1}
+}
+
+func (f *AccesslogFilter) Validate() error {
+	if f.SamplingRatio < 0 || f.SamplingRatio > 1 {
+		return fmt.Errorf("sampling ratio %v is not in [0, 1]", f.SamplingRatio)
+	}
+	return nil
+}
+
+func (f *AccesslogFilter) match(conn connectionSrcDst, accesslog logInfo) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Namespaces) > 0 && !slices.Contains(f.Namespaces, accesslog.sourceNamespace) &&
+		!slices.Contains(f.Namespaces, accesslog.destinationNamespace) {
+		return false
+	}
+	if len(f.Services) > 0 && !slices.ContainsFunc(f.Services, func(service string) bool {
+		return service == accesslog.destinationService || strings.HasPrefix(accesslog.destinationService, service+".")
+	}) {
+		return false
+	}
+	return f.sampled(conn)
+}
+
+func (f *AccesslogFilter) sampled(conn connectionSrcDst) bool {
+	if f.SamplingRatio >= 1 {
+		return true
+	}
+	if f.SamplingRatio <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	_ = binary.Write(h, binary.LittleEndian, conn)
+	return float64(h.Sum64()%10000) < f.SamplingRatio*10000
+}
+
+// accesslogSink is where the formatted access logs are written to.
+type accesslogSink interface {
+	write(line []byte) error
+	close() error
+}
+
+type writerSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *writerSink) write(line []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *writerSink) close() error {
+	return nil
+}
+
+// fileSink writes the access logs to a file, which is rotated when it exceeds maxSize.
+// The rotated files are named path.1 to path.maxBackups, path.1 being the latest.
+type fileSink struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	s := &fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create accesslog dir failed: %v", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open accesslog file failed: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat accesslog file failed: %v", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		log.Warnf("close accesslog file failed: %v", err)
+	}
+	s.file = nil
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) write(line []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		// the previous rotation failed, retry opening the file
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line))+1 > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate accesslog file failed: %v", err)
+		}
+	}
+	n, err := s.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// newSyslogSink connects to the syslog socket at address, formatted as network://address,
+// e.g. unixgram:///dev/log or udp://127.0.0.1:514.
+func newSyslogSink(address string) (*syslogSink, error) {
+	var network, raddr string
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address %s: %v", address, err)
+		}
+		network = u.Scheme
+		switch network {
+		case "unix", "unixgram":
+			raddr = u.Path
+		case "udp", "tcp":
+			raddr = u.Host
+		default:
+			return nil, fmt.Errorf("unsupported syslog network %q", network)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "kmesh-accesslog")
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog failed: %v", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) write(line []byte) error {
+	return s.w.Info(string(line))
+}
+
+func (s *syslogSink) close() error {
+	return s.w.Close()
+}
+
+// accesslogWriter formats the access logs and writes them to a sink.
+type accesslogWriter struct {
+	format string
+	sink   accesslogSink
+	name   string
+}
+
+// defaultAccesslogWriter is used until the access log is configured
+var defaultAccesslogWriter = &accesslogWriter{
+	format: options.AccesslogFormatText,
+	sink:   &writerSink{w: os.Stdout},
+	name:   options.AccesslogSinkStdout,
+}
+
+func newAccesslogWriter(config *options.AccesslogConfig) (*accesslogWriter, error) {
+	var (
+		sink accesslogSink
+		err  error
+	)
+	switch config.Sink {
+	case options.AccesslogSinkStdout:
+		sink = &writerSink{w: os.Stdout}
+	case options.AccesslogSinkFile:
+		sink, err = newFileSink(config.FilePath, int64(config.FileMaxSizeMB)<<20, config.FileMaxBackups)
+	case options.AccesslogSinkSyslog:
+		sink, err = newSyslogSink(config.SyslogAddress)
+	default:
+		err = fmt.Errorf("unknown accesslog sink %s", config.Sink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &accesslogWriter{format: config.Format, sink: sink, name: config.Sink}, nil
+}
+
+func (w *accesslogWriter) write(data requestMetric, connMetrics connMetric, accesslog logInfo) {
+	var line []byte
+	if w.format == options.AccesslogFormatJSON {
+		var err error
+		line, err = json.Marshal(buildAccesslogRecord(data, connMetrics, accesslog))
+		if err != nil {
+			log.Errorf("marshal accesslog failed: %v", err)
+			return
+		}
+	} else {
+		line = []byte("accesslog: " + buildAccesslog(data, connMetrics, accesslog))
+	}
+	if err := w.sink.write(line); err != nil {
+		log.Errorf("write accesslog to %s failed: %v", w.name, err)
+	}
+}
+
+// AccesslogStatus is the current configuration of the access log.
+type AccesslogStatus struct {
+	Enabled bool            `json:"enabled"`
+	Format  string          `json:"format"`
+	Sink    string          `json:"sink"`
+	Filter  AccesslogFilter `json:"filter"`
+}
+
+// ConfigureAccesslog replaces the stdout text access log with the format and the sink configured,
+// the sink is closed when ctx is done.
+func (m *MetricController) ConfigureAccesslog(ctx context.Context, config *options.AccesslogConfig) error {
+	if m == nil || config == nil {
+		return nil
+	}
+	w, err := newAccesslogWriter(config)
+	if err != nil {
+		return err
+	}
+	m.accesslogWriter.Store(w)
+	go func() {
+		<-ctx.Done()
+		if err := w.sink.close(); err != nil {
+			log.Errorf("close accesslog %s sink failed: %v", w.name, err)
+		}
+	}()
+	return nil
+}
+
+// SetAccesslogFilter sets the filter selecting the connections whose access logs are output.
+func (m *MetricController) SetAccesslogFilter(filter AccesslogFilter) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	m.accesslogFilter.Store(&filter)
+	return nil
+}
+
+func (m *MetricController) GetAccesslogFilter() AccesslogFilter {
+	if filter := m.accesslogFilter.Load(); filter != nil {
+		return *filter
+	}
+	return *defaultAccesslogFilter()
+}
+
+func (m *MetricController) GetAccesslogStatus() AccesslogStatus {
+	w := m.accesslogWriter.Load()
+	if w == nil {
+		w = defaultAccesslogWriter
+	}
+	return AccesslogStatus{
+		Enabled: m.EnableAccesslog.Load(),
+		Format:  w.format,
+		Sink:    w.name,
+		Filter:  m.GetAccesslogFilter(),
+	}
+}
+
+// outputAccesslog writes the access log of a connection report to the sink, and exports it over OTLP if enabled.
+func (m *MetricController) outputAccesslog(ctx context.Context, data requestMetric, connMetrics connMetric, accesslog logInfo) {
+	if skipAccesslog(data, connMetrics) {
+		return
+	}
+	if !m.accesslogFilter.Load().match(data.conSrcDstInfo, accesslog) {
+		return
+	}
+	w := m.accesslogWriter.Load()
+	if w == nil {
+		w = defaultAccesslogWriter
+	}
+	w.write(data, connMetrics, accesslog)
+	m.otlp.Load().emitAccesslog(ctx, data, connMetrics, accesslog)
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kmesh.net/kmesh/daemon/options"
+)
+
+func testAccesslogInfo() logInfo {
+	accesslog := *NewLogInfo()
+	accesslog.direction = "OUTBOUND"
+	accesslog.state = "BPF_TCP_CLOSE"
+	accesslog.sourceAddress = "10.244.0.10:47667"
+	accesslog.sourceWorkload = "sleep"
+	accesslog.sourceNamespace = "default"
+	accesslog.sourceIdentity = "spiffe://cluster.local/ns/default/sa/sleep"
+	accesslog.destinationAddress = "10.244.0.7:8080"
+	accesslog.destinationService = "httpbin.foo.svc.cluster.local"
+	accesslog.destinationWorkload = "httpbin"
+	accesslog.destinationNamespace = "foo"
+	accesslog.destinationIdentity = "spiffe://cluster.local/ns/foo/sa/httpbin"
+	return accesslog
+}
+
+func TestAccesslogFilterMatch(t *testing.T) {
+	accesslog := testAccesslogInfo()
+	conn := connectionSrcDst{src: [4]uint32{1}, dst: [4]uint32{2}, srcPort: 47667, dstPort: 8080}
+
+	tests := []struct {
+		name   string
+		filter *AccesslogFilter
+		want   bool
+	}{
+		{"nil filter", nil, true},
+		{"default filter", defaultAccesslogFilter(), true},
+		{"source namespace", &AccesslogFilter{Namespaces: []string{"default"}, SamplingRatio: 1}, true},
+		{"destination namespace", &AccesslogFilter{Namespaces: []string{"bar", "foo"}, SamplingRatio: 1}, true},
+		{"namespace mismatch", &AccesslogFilter{Namespaces: []string{"bar"}, SamplingRatio: 1}, false},
+		{"service hostname", &AccesslogFilter{Services: []string{"httpbin.foo.svc.cluster.local"}, SamplingRatio: 1}, true},
+		{"service name", &AccesslogFilter{Services: []string{"httpbin"}, SamplingRatio: 1}, true},
+		{"service prefix is not a match", &AccesslogFilter{Services: []string{"http"}, SamplingRatio: 1}, false},
+		{"namespace and service", &AccesslogFilter{Namespaces: []string{"bar"}, Services: []string{"httpbin"}, SamplingRatio: 1}, false},
+		{"sampled out", &AccesslogFilter{SamplingRatio: 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.match(conn, accesslog))
+		})
+	}
+}
+
+func TestAccesslogFilterSampling(t *testing.T) {
+	filter := &AccesslogFilter{SamplingRatio: 0.3}
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		conn := connectionSrcDst{src: [4]uint32{uint32(i)}, dst: [4]uint32{2}, srcPort: uint16(i), dstPort: 8080}
+		if filter.sampled(conn) {
+			sampled++
+		}
+		// all the reports of a connection are sampled the same way
+		assert.Equal(t, filter.sampled(conn), filter.sampled(conn))
+	}
+	assert.InDelta(t, 3000, sampled, 300)
+
+	assert.Error(t, (&AccesslogFilter{SamplingRatio: 1.5}).Validate())
+	assert.Error(t, (&AccesslogFilter{SamplingRatio: -0.1}).Validate())
+	assert.NoError(t, (&AccesslogFilter{SamplingRatio: 0}).Validate())
+}
+
+func TestAccesslogWriterJSON(t *testing.T) {
+	osStartTime = time.Date(2024, 7, 4, 20, 14, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	w := &accesslogWriter{format: options.AccesslogFormatJSON, sink: &writerSink{w: &buf}, name: "test"}
+
+	data := requestMetric{
+		sentBytes:      10,
+		receivedBytes:  20,
+		success:        connection_success,
+		duration:       uint64(2236000),
+		startTime:      uint64(3506247005837715),
+		lastReportTime: uint64(3506247005837715),
+		srtt:           240, // 8 times the smoothed RTT in microseconds
+		minRtt:         25,
+		totalRetrans:   1,
+		packetLost:     2,
+	}
+	w.write(data, connMetric{sentBytes: 60, receivedBytes: 172, totalRetrans: 3, packetLost: 4}, testAccesslogInfo())
+
+	assert.Contains(t, buf.String(), `"srtt_us":30,`)
+	var record AccesslogRecord
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, AccesslogRecord{
+		Timestamp:             time.Date(2024, 8, 14, 10, 11, 27, 5837715, time.UTC),
+		StartTime:             time.Date(2024, 8, 14, 10, 11, 27, 5837715, time.UTC),
+		Direction:             "OUTBOUND",
+		State:                 "BPF_TCP_CLOSE",
+		SourceAddress:         "10.244.0.10:47667",
+		SourceWorkload:        "sleep",
+		SourceNamespace:       "default",
+		SourceIdentity:        "spiffe://cluster.local/ns/default/sa/sleep",
+		DestinationAddress:    "10.244.0.7:8080",
+		DestinationService:    "httpbin.foo.svc.cluster.local",
+		DestinationWorkload:   "httpbin",
+		DestinationNamespace:  "foo",
+		DestinationIdentity:   "spiffe://cluster.local/ns/foo/sa/httpbin",
+		ConnectionSuccess:     true,
+		SentBytes:             60,
+		ReceivedBytes:         172,
+		ReportSentBytes:       10,
+		ReportReceivedBytes:   20,
+		PacketLoss:            4,
+		Retransmissions:       3,
+		ReportPacketLoss:      2,
+		ReportRetransmissions: 1,
+		SrttUs:                30,
+		MinRttUs:              25,
+		DurationMs:            2.236,
+	}, record)
+}
+
+func TestOutputAccesslog(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMetric(nil, nil, true)
+	m.accesslogWriter.Store(&accesslogWriter{format: options.AccesslogFormatText, sink: &writerSink{w: &buf}, name: "test"})
+
+	data := requestMetric{state: TCP_CLOSED}
+	// the report on connection establishment is skipped
+	m.outputAccesslog(context.Background(), requestMetric{state: TCP_ESTABLISHED}, connMetric{totalReports: 1}, testAccesslogInfo())
+	assert.Empty(t, buf.String())
+
+	m.outputAccesslog(context.Background(), data, connMetric{totalReports: 2}, testAccesslogInfo())
+	assert.True(t, strings.HasPrefix(buf.String(), "accesslog: "))
+	assert.Contains(t, buf.String(), "dst.workload=httpbin")
+
+	buf.Reset()
+	require.NoError(t, m.SetAccesslogFilter(AccesslogFilter{Namespaces: []string{"bar"}, SamplingRatio: 1}))
+	m.outputAccesslog(context.Background(), data, connMetric{totalReports: 2}, testAccesslogInfo())
+	assert.Empty(t, buf.String())
+
+	assert.Error(t, m.SetAccesslogFilter(AccesslogFilter{SamplingRatio: 2}))
+	m.EnableAccesslog.Store(true)
+	assert.Equal(t, AccesslogStatus{
+		Enabled: true,
+		Format:  options.AccesslogFormatText,
+		Sink:    "test",
+		Filter:  AccesslogFilter{Namespaces: []string{"bar"}, SamplingRatio: 1},
+	}, m.GetAccesslogStatus())
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kmesh", "accesslog.log")
+	// each line takes 10 bytes with the newline, so a file holds 2 lines
+	sink, err := newFileSink(path, 25, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"line00001", "line00002", "line00003", "line00004", "line00005", "line00006", "line00007"} {
+		require.NoError(t, sink.write([]byte(line)))
+	}
+	require.NoError(t, sink.close())
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "line00007\n", read(path))
+	assert.Equal(t, "line00005\nline00006\n", read(path+".1"))
+	assert.Equal(t, "line00003\nline00004\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// the size of the existing file is taken into account when it is reopened
+	sink, err = newFileSink(path, 25, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.write([]byte("line00008")))
+	require.NoError(t, sink.write([]byte("line00009")))
+	require.NoError(t, sink.close())
+	assert.Equal(t, "line00009\n", read(path))
+	assert.Equal(t, "line00005\nline00006\n", read(path+".1"))
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = newSyslogSink("http://" + conn.LocalAddr().String())
+	assert.Error(t, err)
+
+	sink, err := newSyslogSink("udp://" + conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer sink.close()
+	require.NoError(t, sink.write([]byte(`{"src_workload":"sleep"}`)))
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// priority is LOG_LOCAL0|LOG_INFO
+	assert.True(t, strings.HasPrefix(msg, "<134>"), msg)
+	assert.Contains(t, msg, "kmesh-accesslog")
+	assert.Contains(t, msg, `{"src_workload":"sleep"}`)
+}
+
+func TestConfigureAccesslog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "accesslog.log")
+	m := NewMetric(nil, nil, true)
+	assert.Error(t, m.ConfigureAccesslog(ctx, &options.AccesslogConfig{Format: options.AccesslogFormatJSON, Sink: "unknown"}))
+	require.NoError(t, m.ConfigureAccesslog(ctx, &options.AccesslogConfig{
+		Format:         options.AccesslogFormatJSON,
+		Sink:           options.AccesslogSinkFile,
+		FilePath:       path,
+		FileMaxSizeMB:  1,
+		FileMaxBackups: 1,
+	}))
+
+	m.outputAccesslog(ctx, requestMetric{state: TCP_CLOSED}, connMetric{sentBytes: 60}, testAccesslogInfo())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var record AccesslogRecord
+	require.NoError(t, json.Unmarshal(data, &record))
+	assert.Equal(t, uint32(60), record.SentBytes)
+	assert.Equal(t, "httpbin", record.DestinationWorkload)
+
+	status := m.GetAccesslogStatus()
+	assert.Equal(t, options.AccesslogFormatJSON, status.Format)
+	assert.Equal(t, options.AccesslogSinkFile, status.Sink)
+	out, err := json.Marshal(status)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"sampling_ratio":1`)
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"kmesh.net/kmesh/daemon/options"
+)
+
+// metricVec is implemented by both the gauge and the histogram vecs of a metric family.
+type metricVec interface {
+	Delete(labels prometheus.Labels) bool
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// seriesFamily controls the cardinality of the metrics sharing the same label names.
+// Labels which are not kept are set to empty, so the series only differing in them are aggregated,
+// and once the number of live series reaches maxSeries new series are aggregated into an overflow
+// series whose labels are all empty.
+type seriesFamily struct {
+	name       string
+	labelNames []string
+	// vecs is looked up on use since the histograms can be replaced by SetLatencyBuckets
+	vecs func() []metricVec
+
+	mutex sync.Mutex
+	// kept is nil when all the labels are kept
+	kept      map[string]bool
+	maxSeries int
+	series    map[string]prometheus.Labels
+	overflow  bool
+}
+
+var (
+	workloadSeries = newSeriesFamily("workload", workloadLabels, func() []metricVec {
+		return []metricVec{tcpConnectionOpenedInWorkload, tcpConnectionClosedInWorkload, tcpReceivedBytesInWorkload, tcpSentBytesInWorkload,
+			tcpConnectionFailedInWorkload, tcpConnectionTotalRetransInWorkload, tcpConnectionPacketLostInWorkload,
+			tcpConnectionDurationInWorkload, tcpSrttInWorkload}
+	})
+	serviceSeries = newSeriesFamily("service", serviceLabels, func() []metricVec {
+		return []metricVec{tcpConnectionOpenedInService, tcpConnectionClosedInService, tcpReceivedBytesInService, tcpSentBytesInService,
+			tcpConnectionFailedInService, tcpConnectionDurationInService, tcpSrttInService}
+	})
+	connectionSeries = newSeriesFamily("connection", connectionLabels, func() []metricVec {
+		return []metricVec{tcpConnectionTotalSendBytes, tcpConnectionTotalReceivedBytes, tcpConnectionTotalPacketLost, tcpConnectionTotalRetrans}
+	})
+
+	// seriesExpiryInterval is how often the series of the workloads no longer existing are removed, 0 disables it
+	seriesExpiryInterval = time.Minute
+)
+
+func newSeriesFamily(name string, labelNames []string, vecs func() []metricVec) *seriesFamily {
+	return &seriesFamily{
+		name:       name,
+		labelNames: labelNames,
+		vecs:       vecs,
+		series:     make(map[string]prometheus.Labels),
+	}
+}
+
+// configure sets the labels kept and the max number of live series, empty keptLabels keeps all the labels
+// and 0 maxSeries is unlimited.
+func (f *seriesFamily) configure(keptLabels []string, maxSeries int) error {
+	if maxSeries < 0 {
+		return fmt.Errorf("invalid max series %d of %s metrics", maxSeries, f.name)
+	}
+
+	var kept map[string]bool
+	if len(keptLabels) > 0 {
+		kept = make(map[string]bool, len(keptLabels))
+		for _, name := range keptLabels {
+			if !f.hasLabel(name) {
+				return fmt.Errorf("unknown label %q of %s metrics, valid labels are: %s", name, f.name, strings.Join(f.labelNames, ","))
+			}
+			kept[name] = true
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.kept = kept
+	f.maxSeries = maxSeries
+	return nil
+}
+
+func (f *seriesFamily) hasLabel(name string) bool {
+	for _, labelName := range f.labelNames {
+		if labelName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mask empties the values of the labels not kept.
+func (f *seriesFamily) mask(raw map[string]string) prometheus.Labels {
+	f.mutex.Lock()
+	kept := f.kept
+	f.mutex.Unlock()
+
+	if kept == nil {
+		return raw
+	}
+	labels := make(prometheus.Labels, len(raw))
+	for name, value := range raw {
+		if kept[name] {
+			labels[name] = value
+		} else {
+			labels[name] = ""
+		}
+	}
+	return labels
+}
+
+// labels returns the labels the sample of raw should be recorded with, and tracks the returned series as live.
+func (f *seriesFamily) labels(raw map[string]string) prometheus.Labels {
+	labels := f.mask(raw)
+	key := seriesKey(f.labelNames, labels)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.series[key]; ok {
+		return labels
+	}
+	if f.maxSeries > 0 && len(f.series) >= f.maxSeries {
+		metricSeriesOverflow.With(prometheus.Labels{
+			"node_name": os.Getenv("NODE_NAME"),
+			"family":    f.name,
+		}).Inc()
+		if !f.overflow {
+			f.overflow = true
+			f.updateSeriesCount()
+		}
+		return f.overflowLabels()
+	}
+	f.series[key] = labels
+	f.updateSeriesCount()
+	return labels
+}
+
+func (f *seriesFamily) overflowLabels() prometheus.Labels {
+	labels := make(prometheus.Labels, len(f.labelNames))
+	for _, name := range f.labelNames {
+		labels[name] = ""
+	}
+	return labels
+}
+
+// deletePartialMatch deletes the series matching all the given labels.
+func (f *seriesFamily) deletePartialMatch(match prometheus.Labels) {
+	for _, value := range match {
+		// an empty value only matches the labels not kept, which are aggregated from many series
+		if value == "" {
+			return
+		}
+	}
+	for _, vec := range f.vecs() {
+		_ = vec.DeletePartialMatch(match)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for key, labels := range f.series {
+		if matchLabels(labels, match) {
+			delete(f.series, key)
+		}
+	}
+	f.updateSeriesCount()
+}
+
+// expire deletes the live series for which alive returns false.
+func (f *seriesFamily) expire(alive func(labels prometheus.Labels) bool) {
+	vecs := f.vecs()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for key, labels := range f.series {
+		if alive(labels) {
+			continue
+		}
+		for _, vec := range vecs {
+			_ = vec.Delete(labels)
+		}
+		delete(f.series, key)
+	}
+	f.updateSeriesCount()
+}
+
+// updateSeriesCount must be called with the mutex held.
+func (f *seriesFamily) updateSeriesCount() {
+	count := len(f.series)
+	if f.overflow {
+		count++
+	}
+	metricSeriesCount.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"family":    f.name,
+	}).Set(float64(count))
+}
+
+func seriesKey(labelNames []string, labels prometheus.Labels) string {
+	var b strings.Builder
+	for _, name := range labelNames {
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func matchLabels(labels, match prometheus.Labels) bool {
+	for name, value := range match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfigureCardinality sets the labels kept and the max number of live series of the workload, service
+// and connection metrics. It must be called before the metric controller is run.
+func ConfigureCardinality(config *options.MetricConfig) error {
+	if config == nil {
+		return nil
+	}
+	if err := workloadSeries.configure(config.WorkloadLabels, config.WorkloadMaxSeries); err != nil {
+		return err
+	}
+	if err := serviceSeries.configure(config.ServiceLabels, config.ServiceMaxSeries); err != nil {
+		return err
+	}
+	if err := connectionSeries.configure(config.ConnectionLabels, config.ConnectionMaxSeries); err != nil {
+		return err
+	}
+	seriesExpiryInterval = config.SeriesExpiryInterval
+	return nil
+}
+
+// expireSeries deletes the series of the workloads which no longer exist in the workload cache.
+func (m *MetricController) expireSeries() {
+	if m.workloadCache == nil {
+		return
+	}
+
+	pods := map[string]bool{}
+	workloads := map[string]bool{}
+	for _, workload := range m.workloadCache.List() {
+		pods[workload.GetNamespace()+"/"+workload.GetName()] = true
+		workloads[workload.GetNamespace()+"/"+workload.GetWorkloadName()] = true
+	}
+	alive := func(labels prometheus.Labels) bool {
+		return exists(workloads, labels["source_workload_namespace"], labels["source_workload"]) &&
+			exists(pods, labels["destination_pod_namespace"], labels["destination_pod_name"]) &&
+			exists(workloads, labels["destination_workload_namespace"], labels["destination_workload"])
+	}
+
+	workloadSeries.expire(alive)
+	serviceSeries.expire(alive)
+	connectionSeries.expire(alive)
+}
+
+// exists returns true if the name is unknown or dropped, as the series can not be matched with a workload.
+func exists(names map[string]bool, namespace, name string) bool {
+	if namespace == "" || namespace == DEFAULT_UNKNOWN || name == "" || name == DEFAULT_UNKNOWN {
+		return true
+	}
+	return names[namespace+"/"+name]
+}
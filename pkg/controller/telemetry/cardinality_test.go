@@ -0,0 +1,112 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
+)
+
+func TestSeriesFamilyAggregation(t *testing.T) {
+	assert.Error(t, workloadSeries.configure([]string{"source_pod"}, 0))
+	assert.NoError(t, workloadSeries.configure([]string{"source_workload", "source_workload_namespace", "destination_workload"}, 0))
+	defer func() {
+		_ = workloadSeries.configure(nil, 0)
+		workloadSeries.deletePartialMatch(prometheus.Labels{"source_workload": "sleep-aggregated"})
+	}()
+
+	m := &MetricController{
+		workloadMetricCache: map[workloadMetricLabels]*workloadMetricInfo{
+			{sourceWorkload: "sleep-aggregated", sourceWorkloadNamespace: "default", sourcePrincipal: "spiffe://a", destinationWorkload: "httpbin"}: {WorkloadConnOpened: 1},
+			{sourceWorkload: "sleep-aggregated", sourceWorkloadNamespace: "default", sourcePrincipal: "spiffe://b", destinationWorkload: "httpbin"}: {WorkloadConnOpened: 2},
+		},
+		serviceMetricCache:    map[serviceMetricLabels]*serviceMetricInfo{},
+		connectionMetricCache: map[connectionMetricLabels]*connectionMetricInfo{},
+	}
+	m.updatePrometheusMetric()
+
+	labels := workloadSeries.mask(struct2map(workloadMetricLabels{sourceWorkload: "sleep-aggregated", sourceWorkloadNamespace: "default", destinationWorkload: "httpbin"}))
+	assert.Equal(t, "", labels["source_principal"])
+	assert.Equal(t, "sleep-aggregated", labels["source_workload"])
+	assert.Equal(t, float64(3), testutil.ToFloat64(tcpConnectionOpenedInWorkload.With(labels)))
+
+	// the aggregated series is removed with the workload
+	deleteWorkloadMetricInPrometheus(&workloadapi.Workload{Name: "sleep-aggregated", Namespace: "default"})
+	assert.Equal(t, 0, tcpConnectionOpenedInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": "sleep-aggregated"}))
+}
+
+func TestSeriesFamilyOverflow(t *testing.T) {
+	labelNames := []string{"source_workload", "destination_workload"}
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_series_overflow"}, labelNames)
+	family := newSeriesFamily("test-overflow", labelNames, func() []metricVec { return []metricVec{vec} })
+	assert.Error(t, family.configure(nil, -1))
+	assert.NoError(t, family.configure(nil, 2))
+
+	for _, destination := range []string{"a", "b", "c", "d", "a"} {
+		vec.With(family.labels(map[string]string{"source_workload": "sleep", "destination_workload": destination})).Inc()
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(vec.With(prometheus.Labels{"source_workload": "sleep", "destination_workload": "a"})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(vec.With(prometheus.Labels{"source_workload": "sleep", "destination_workload": "b"})))
+	assert.Equal(t, float64(2), testutil.ToFloat64(vec.With(family.overflowLabels())))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metricSeriesOverflow.With(prometheus.Labels{"node_name": "", "family": "test-overflow"})))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metricSeriesCount.With(prometheus.Labels{"node_name": "", "family": "test-overflow"})))
+
+	// deleting a series makes room for a new one
+	family.deletePartialMatch(prometheus.Labels{"destination_workload": "b"})
+	// an empty value does not match the aggregated series
+	family.deletePartialMatch(prometheus.Labels{"destination_workload": ""})
+	assert.Equal(t, 2, testutil.CollectAndCount(vec))
+	assert.Equal(t, "c", family.labels(map[string]string{"source_workload": "sleep", "destination_workload": "c"})["destination_workload"])
+	assert.Equal(t, "", family.labels(map[string]string{"source_workload": "sleep", "destination_workload": "d"})["destination_workload"])
+}
+
+func TestExpireSeries(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{Uid: "1", Name: "sleep-expiry-1", Namespace: "default", WorkloadName: "sleep-expiry"})
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{Uid: "2", Name: "httpbin-expiry-1", Namespace: "default", WorkloadName: "httpbin-expiry"})
+	m := &MetricController{workloadCache: workloadCache}
+
+	alive := workloadMetricLabels{sourceWorkload: "sleep-expiry", sourceWorkloadNamespace: "default",
+		destinationPodName: "httpbin-expiry-1", destinationPodNamespace: "default", destinationWorkload: "httpbin-expiry", destinationWorkloadNamespace: "default"}
+	// the destination pod has been replaced
+	stale := workloadMetricLabels{sourceWorkload: "sleep-expiry", sourceWorkloadNamespace: "default",
+		destinationPodName: "httpbin-expiry-0", destinationPodNamespace: "default", destinationWorkload: "httpbin-expiry", destinationWorkloadNamespace: "default"}
+	// the destination is not a known workload
+	external := workloadMetricLabels{sourceWorkload: "sleep-expiry", sourceWorkloadNamespace: "default", destinationPodAddress: "1.1.1.1"}
+	for _, labels := range []workloadMetricLabels{alive, stale, external} {
+		tcpConnectionOpenedInWorkload.With(workloadSeries.labels(struct2map(labels))).Inc()
+	}
+	defer workloadSeries.deletePartialMatch(prometheus.Labels{"source_workload": "sleep-expiry"})
+
+	m.expireSeries()
+	assert.Equal(t, 2, tcpConnectionOpenedInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": "sleep-expiry"}))
+
+	// all the series are expired once the source workload is removed
+	workloadCache.DeleteWorkload("1")
+	m.expireSeries()
+	workloadSeries.mutex.Lock()
+	defer workloadSeries.mutex.Unlock()
+	for _, labels := range workloadSeries.series {
+		assert.NotEqual(t, "sleep-expiry", labels["source_workload"])
+	}
+}
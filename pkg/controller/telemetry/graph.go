@@ -0,0 +1,319 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// serviceGraphWindow is how long the edges of the service graph are aggregated for
+	serviceGraphWindow = 5 * time.Minute
+	// serviceGraphBuckets is the number of buckets the window is divided into, edges are rolled out a bucket at a time
+	serviceGraphBuckets = 10
+)
+
+// GraphNode is a service, or the canonical service of a workload when the source is not a service.
+type GraphNode struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (n GraphNode) String() string {
+	if n.Namespace == "" {
+		return n.Name
+	}
+	return n.Name + "." + n.Namespace
+}
+
+// GraphEdge holds the traffic from the source to the destination within the window of the graph.
+type GraphEdge struct {
+	Source        GraphNode `json:"source"`
+	Destination   GraphNode `json:"destination"`
+	Connections   uint64    `json:"connections"`
+	Failures      uint64    `json:"failures"`
+	SentBytes     uint64    `json:"sent_bytes"`
+	ReceivedBytes uint64    `json:"received_bytes"`
+	// MeanRttMs is the mean of the RttSamples smoothed RTTs in milliseconds
+	MeanRttMs  float64 `json:"mean_rtt_ms"`
+	RttSamples uint64  `json:"rtt_samples"`
+	// Reporter is the end reporting the connections, source or destination, it is cleared by Deduplicate
+	Reporter string `json:"reporter,omitempty"`
+}
+
+// ServiceGraph is the source to destination service graph built from the connections reported by a node,
+// or merged from several nodes.
+type ServiceGraph struct {
+	Nodes  []string    `json:"nodes,omitempty"`
+	Window string      `json:"window"`
+	Edges  []GraphEdge `json:"edges"`
+}
+
+type graphEdgeKey struct {
+	source      GraphNode
+	destination GraphNode
+	reporter    string
+}
+
+type graphEdgeCounters struct {
+	connections   uint64
+	failures      uint64
+	sentBytes     uint64
+	receivedBytes uint64
+	rttSum        float64
+	rttSamples    uint64
+}
+
+func (c *graphEdgeCounters) add(other *graphEdgeCounters) {
+	c.connections += other.connections
+	c.failures += other.failures
+	c.sentBytes += other.sentBytes
+	c.receivedBytes += other.receivedBytes
+	c.rttSum += other.rttSum
+	c.rttSamples += other.rttSamples
+}
+
+type graphBucket struct {
+	start time.Time
+	edges map[graphEdgeKey]*graphEdgeCounters
+}
+
+// serviceGraph is a rolling service graph, the zero value is ready to use.
+type serviceGraph struct {
+	mutex   sync.Mutex
+	buckets []*graphBucket
+}
+
+// record adds the service metrics flushed at now to the graph.
+func (g *serviceGraph) record(now time.Time, serviceInfoCache map[serviceMetricLabels]*serviceMetricInfo) {
+	if len(serviceInfoCache) == 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	bucket := g.currentBucket(now)
+	for labels, info := range serviceInfoCache {
+		key := graphEdgeKey{source: graphSource(labels), destination: graphDestination(labels), reporter: labels.reporter}
+		counters := bucket.edges[key]
+		if counters == nil {
+			counters = &graphEdgeCounters{}
+			bucket.edges[key] = counters
+		}
+		counters.add(&graphEdgeCounters{
+			connections:   uint64(info.ServiceConnOpened),
+			failures:      uint64(info.ServiceConnFailed),
+			sentBytes:     uint64(info.ServiceConnSentBytes),
+			receivedBytes: uint64(info.ServiceConnReceivedBytes),
+			rttSum:        sum(info.ServiceConnSrtts),
+			rttSamples:    uint64(len(info.ServiceConnSrtts)),
+		})
+	}
+}
+
+// currentBucket must be called with the mutex held.
+func (g *serviceGraph) currentBucket(now time.Time) *graphBucket {
+	start := now.Truncate(serviceGraphWindow / serviceGraphBuckets)
+	if n := len(g.buckets); n > 0 && g.buckets[n-1].start.Equal(start) {
+		return g.buckets[n-1]
+	}
+	g.expire(now)
+	bucket := &graphBucket{start: start, edges: map[graphEdgeKey]*graphEdgeCounters{}}
+	g.buckets = append(g.buckets, bucket)
+	return bucket
+}
+
+// expire drops the buckets out of the window, it must be called with the mutex held.
+func (g *serviceGraph) expire(now time.Time) {
+	i := 0
+	for i < len(g.buckets) && now.Sub(g.buckets[i].start) >= serviceGraphWindow {
+		i++
+	}
+	g.buckets = g.buckets[i:]
+}
+
+// snapshot returns the graph of the edges within the window at now.
+func (g *serviceGraph) snapshot(now time.Time) *ServiceGraph {
+	g.mutex.Lock()
+	g.expire(now)
+	edges := map[graphEdgeKey]*graphEdgeCounters{}
+	for _, bucket := range g.buckets {
+		for key, counters := range bucket.edges {
+			if edges[key] == nil {
+				edges[key] = &graphEdgeCounters{}
+			}
+			edges[key].add(counters)
+		}
+	}
+	g.mutex.Unlock()
+
+	graph := &ServiceGraph{Window: serviceGraphWindow.String(), Edges: []GraphEdge{}}
+	for key, counters := range edges {
+		edge := GraphEdge{
+			Source:        key.source,
+			Destination:   key.destination,
+			Connections:   counters.connections,
+			Failures:      counters.failures,
+			SentBytes:     counters.sentBytes,
+			ReceivedBytes: counters.receivedBytes,
+			RttSamples:    counters.rttSamples,
+			Reporter:      key.reporter,
+		}
+		if counters.rttSamples > 0 {
+			edge.MeanRttMs = counters.rttSum / float64(counters.rttSamples) * 1000
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+	graph.sortEdges()
+	return graph
+}
+
+func graphSource(labels serviceMetricLabels) GraphNode {
+	name := labels.sourceCanonicalService
+	if name == "" {
+		name = labels.sourceWorkload
+	}
+	if name == "" {
+		name = DEFAULT_UNKNOWN
+	}
+	return GraphNode{Namespace: labels.sourceWorkloadNamespace, Name: name}
+}
+
+func graphDestination(labels serviceMetricLabels) GraphNode {
+	if labels.destinationServiceName != "" {
+		return GraphNode{Namespace: labels.destinationServiceNamespace, Name: labels.destinationServiceName}
+	}
+	// the destination is not a known service, e.g. a workload accessed by its address
+	name := labels.destinationService
+	if name == "" {
+		name = DEFAULT_UNKNOWN
+	}
+	return GraphNode{Namespace: labels.destinationWorkloadNamespace, Name: name}
+}
+
+func sum(values []float64) float64 {
+	var total float64
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func (g *ServiceGraph) sortEdges() {
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source.String() < g.Edges[j].Source.String()
+		}
+		if g.Edges[i].Destination != g.Edges[j].Destination {
+			return g.Edges[i].Destination.String() < g.Edges[j].Destination.String()
+		}
+		return g.Edges[i].Reporter < g.Edges[j].Reporter
+	})
+}
+
+// Merge adds the edges of other into the graph, the mean RTT of the same edge is weighted by the samples.
+func (g *ServiceGraph) Merge(other *ServiceGraph) {
+	if other == nil {
+		return
+	}
+	if g.Window == "" {
+		g.Window = other.Window
+	}
+	g.Nodes = append(g.Nodes, other.Nodes...)
+
+	index := map[graphEdgeKey]int{}
+	for i, edge := range g.Edges {
+		index[graphEdgeKey{source: edge.Source, destination: edge.Destination, reporter: edge.Reporter}] = i
+	}
+	for _, edge := range other.Edges {
+		key := graphEdgeKey{source: edge.Source, destination: edge.Destination, reporter: edge.Reporter}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(g.Edges)
+			g.Edges = append(g.Edges, edge)
+			continue
+		}
+		merged := &g.Edges[i]
+		if samples := merged.RttSamples + edge.RttSamples; samples > 0 {
+			merged.MeanRttMs = (merged.MeanRttMs*float64(merged.RttSamples) + edge.MeanRttMs*float64(edge.RttSamples)) / float64(samples)
+		}
+		merged.RttSamples += edge.RttSamples
+		merged.Connections += edge.Connections
+		merged.Failures += edge.Failures
+		merged.SentBytes += edge.SentBytes
+		merged.ReceivedBytes += edge.ReceivedBytes
+	}
+	g.sortEdges()
+}
+
+// Deduplicate counts each connection once, it is called once the graphs of all the nodes are merged.
+// A connection between two workloads managed by kmesh is reported by both of them, while the workloads
+// not managed, e.g. in a namespace not enrolled or on a node without kmesh, report nothing. So the end
+// reporting more connections of an edge is kept, which is the destination one on a tie.
+func (g *ServiceGraph) Deduplicate() {
+	index := map[graphEdgeKey]int{}
+	edges := make([]GraphEdge, 0, len(g.Edges))
+	for _, edge := range g.Edges {
+		key := graphEdgeKey{source: edge.Source, destination: edge.Destination}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(edges)
+			edges = append(edges, edge)
+			continue
+		}
+		if edge.Connections > edges[i].Connections ||
+			edge.Connections == edges[i].Connections && edge.Reporter == "destination" {
+			edges[i] = edge
+		}
+	}
+	for i := range edges {
+		edges[i].Reporter = ""
+	}
+	g.Edges = edges
+}
+
+// DOT renders the graph in Graphviz DOT format, edges with failures are colored red.
+func (g *ServiceGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph kmesh {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, edge := range g.Edges {
+		attrs := fmt.Sprintf(`label="conns=%d failed=%d\nsent=%dB recv=%dB\nrtt=%.3fms"`,
+			edge.Connections, edge.Failures, edge.SentBytes, edge.ReceivedBytes, edge.MeanRttMs)
+		if edge.Failures > 0 {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [%s];\n", edge.Source.String(), edge.Destination.String(), attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// GetServiceGraph returns the service graph of the connections reported within the last window.
+func (m *MetricController) GetServiceGraph() *ServiceGraph {
+	graph := m.graph.snapshot(time.Now())
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		graph.Nodes = []string{node}
+	}
+	return graph
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceGraphRolling(t *testing.T) {
+	sleepToHttpbin := serviceMetricLabels{
+		reporter:                    "destination",
+		sourceWorkload:              "sleep-v1",
+		sourceCanonicalService:      "sleep",
+		sourceWorkloadNamespace:     "default",
+		destinationService:          "httpbin.default.svc.cluster.local",
+		destinationServiceName:      "httpbin",
+		destinationServiceNamespace: "default",
+	}
+	// the destination is accessed by its address
+	sleepToExternal := serviceMetricLabels{
+		reporter:                "source",
+		sourceWorkload:          "sleep-v1",
+		sourceWorkloadNamespace: "default",
+		destinationService:      "10.0.0.1",
+	}
+
+	g := serviceGraph{}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.record(start, map[serviceMetricLabels]*serviceMetricInfo{
+		sleepToHttpbin: {ServiceConnOpened: 2, ServiceConnSentBytes: 100, ServiceConnReceivedBytes: 200, ServiceConnSrtts: []float64{0.5, 1.5}},
+	})
+	g.record(start.Add(time.Minute), map[serviceMetricLabels]*serviceMetricInfo{
+		sleepToHttpbin:  {ServiceConnOpened: 1, ServiceConnFailed: 1, ServiceConnSrtts: []float64{2.5}},
+		sleepToExternal: {ServiceConnOpened: 1},
+	})
+
+	graph := g.snapshot(start.Add(2 * time.Minute))
+	assert.Equal(t, []GraphEdge{
+		{
+			Source:      GraphNode{Namespace: "default", Name: "sleep-v1"},
+			Destination: GraphNode{Name: "10.0.0.1"},
+			Connections: 1,
+			Reporter:    "source",
+		},
+		{
+			Source:        GraphNode{Namespace: "default", Name: "sleep"},
+			Destination:   GraphNode{Namespace: "default", Name: "httpbin"},
+			Connections:   3,
+			Failures:      1,
+			SentBytes:     100,
+			ReceivedBytes: 200,
+			MeanRttMs:     1500,
+			RttSamples:    3,
+			Reporter:      "destination",
+		},
+	}, graph.Edges)
+
+	// the first report is rolled out of the window
+	graph = g.snapshot(start.Add(serviceGraphWindow + 30*time.Second))
+	assert.Len(t, graph.Edges, 2)
+	assert.Equal(t, uint64(1), graph.Edges[1].Connections)
+	assert.Equal(t, float64(2500), graph.Edges[1].MeanRttMs)
+
+	graph = g.snapshot(start.Add(serviceGraphWindow + time.Minute))
+	assert.Empty(t, graph.Edges)
+}
+
+func TestServiceGraphReporter(t *testing.T) {
+	// the connections to httpbin are reported by both the source and the destination
+	sleepToHttpbin := serviceMetricLabels{
+		reporter:                     "source",
+		sourceWorkload:               "sleep-v1",
+		sourceWorkloadNamespace:      "default",
+		destinationService:           "httpbin.default.svc.cluster.local",
+		destinationServiceName:       "httpbin",
+		destinationServiceNamespace:  "default",
+		destinationWorkload:          "httpbin-v1",
+		destinationWorkloadNamespace: "default",
+	}
+	httpbinReported := sleepToHttpbin
+	httpbinReported.reporter = "destination"
+	// details is a known workload not managed by kmesh, so only the source reports the connections to it
+	sleepToDetails := serviceMetricLabels{
+		reporter:                     "source",
+		sourceWorkload:               "sleep-v1",
+		sourceWorkloadNamespace:      "default",
+		destinationService:           "details.default.svc.cluster.local",
+		destinationServiceName:       "details",
+		destinationServiceNamespace:  "default",
+		destinationWorkload:          "details-v1",
+		destinationWorkloadNamespace: "default",
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sourceNode := serviceGraph{}
+	sourceNode.record(now, map[serviceMetricLabels]*serviceMetricInfo{
+		sleepToHttpbin: {ServiceConnOpened: 2, ServiceConnSentBytes: 100},
+		sleepToDetails: {ServiceConnOpened: 1, ServiceConnSentBytes: 50},
+	})
+	destinationNode := serviceGraph{}
+	destinationNode.record(now, map[serviceMetricLabels]*serviceMetricInfo{
+		httpbinReported: {ServiceConnOpened: 2, ServiceConnSentBytes: 100},
+	})
+
+	sleep := GraphNode{Namespace: "default", Name: "sleep-v1"}
+	httpbin := GraphNode{Namespace: "default", Name: "httpbin"}
+	details := GraphNode{Namespace: "default", Name: "details"}
+	graph := &ServiceGraph{}
+	graph.Merge(sourceNode.snapshot(now))
+	graph.Merge(destinationNode.snapshot(now))
+	assert.Equal(t, []GraphEdge{
+		{Source: sleep, Destination: details, Connections: 1, SentBytes: 50, Reporter: "source"},
+		{Source: sleep, Destination: httpbin, Connections: 2, SentBytes: 100, Reporter: "destination"},
+		{Source: sleep, Destination: httpbin, Connections: 2, SentBytes: 100, Reporter: "source"},
+	}, graph.Edges)
+
+	graph.Deduplicate()
+	assert.Equal(t, []GraphEdge{
+		{Source: sleep, Destination: details, Connections: 1, SentBytes: 50},
+		{Source: sleep, Destination: httpbin, Connections: 2, SentBytes: 100},
+	}, graph.Edges)
+}
+
+func TestServiceGraphMerge(t *testing.T) {
+	sleep := GraphNode{Namespace: "default", Name: "sleep"}
+	httpbin := GraphNode{Namespace: "default", Name: "httpbin"}
+	details := GraphNode{Namespace: "default", Name: "details"}
+
+	graph := &ServiceGraph{}
+	graph.Merge(&ServiceGraph{
+		Nodes:  []string{"node1"},
+		Window: "5m0s",
+		Edges:  []GraphEdge{{Source: sleep, Destination: httpbin, Connections: 1, MeanRttMs: 1, RttSamples: 1}},
+	})
+	graph.Merge(&ServiceGraph{
+		Nodes:  []string{"node2"},
+		Window: "5m0s",
+		Edges: []GraphEdge{
+			{Source: sleep, Destination: httpbin, Connections: 2, Failures: 1, MeanRttMs: 4, RttSamples: 3},
+			{Source: sleep, Destination: details, Connections: 1},
+		},
+	})
+	graph.Merge(nil)
+
+	assert.Equal(t, []string{"node1", "node2"}, graph.Nodes)
+	assert.Equal(t, "5m0s", graph.Window)
+	assert.Equal(t, []GraphEdge{
+		{Source: sleep, Destination: details, Connections: 1},
+		{Source: sleep, Destination: httpbin, Connections: 3, Failures: 1, MeanRttMs: 3.25, RttSamples: 4},
+	}, graph.Edges)
+
+	assert.Equal(t, `digraph kmesh {
+  rankdir=LR;
+  node [shape=box];
+  "sleep.default" -> "details.default" [label="conns=1 failed=0\nsent=0B recv=0B\nrtt=0.000ms"];
+  "sleep.default" -> "httpbin.default" [label="conns=3 failed=1\nsent=0B recv=0B\nrtt=3.250ms", color=red];
+}
+`, graph.DOT())
+}
@@ -0,0 +1,423 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/constants"
+)
+
+// IPFIX message layout and information elements, see RFC 7011 and the IANA IPFIX registry.
+const (
+	ipfixVersion          = 10
+	ipfixTemplateSetId    = 2
+	ipfixTemplateIdV4     = 256
+	ipfixTemplateIdV6     = 257
+	ipfixMessageHeaderLen = 16
+	ipfixSetHeaderLen     = 4
+	// ipfixMaxMessageSize keeps a message within the usual MTU so that it is not fragmented
+	ipfixMaxMessageSize = 1400
+	// ipfixVarLen is the field length of the variable-length information elements
+	ipfixVarLen = 0xffff
+	// ipfixReverseEnterpriseNumber is the enterprise number of the reverse information elements of RFC 5103
+	ipfixReverseEnterpriseNumber = 29305
+
+	ipfixOctetDeltaCount          = 1
+	ipfixProtocolIdentifier       = 4
+	ipfixSourceTransportPort      = 7
+	ipfixSourceIPv4Address        = 8
+	ipfixDestinationTransportPort = 11
+	ipfixDestinationIPv4Address   = 12
+	ipfixSourceIPv6Address        = 27
+	ipfixDestinationIPv6Address   = 28
+	ipfixFlowDirection            = 61
+	ipfixFlowEndReason            = 136
+	ipfixFlowStartMilliseconds    = 152
+	ipfixFlowEndMilliseconds      = 153
+
+	// the enterprise-specific information elements of kmesh
+	ipfixRetransDeltaCount    = 1
+	ipfixPacketLossDeltaCount = 2
+	ipfixSrttMicroseconds     = 3
+	ipfixMinRttMicroseconds   = 4
+	ipfixSourceWorkload       = 5
+	ipfixSourceNamespace      = 6
+	ipfixDestinationWorkload  = 7
+	ipfixDestinationNamespace = 8
+	ipfixDestinationService   = 9
+	ipfixConnectionSuccess    = 10
+
+	ipfixEnterpriseBit          = 0x8000
+	ipfixShortVarLenMax         = 255
+	ipfixProtocolTcp            = 6
+	ipfixFlowDirectionIngress   = 0
+	ipfixFlowDirectionEgress    = 1
+	ipfixFlowEndReasonActive    = 2
+	ipfixFlowEndReasonEndOfFlow = 3
+	ipfixTrue                   = 1
+	ipfixFalse                  = 2
+)
+
+// ipfixFlow is the flow record of a connection report, the counters are the deltas since the previous report.
+type ipfixFlow struct {
+	srcIp, dstIp     []byte
+	srcPort, dstPort uint16
+	direction        uint8
+	endReason        uint8
+	start, end       time.Time
+	sentBytes        uint64
+	receivedBytes    uint64
+	retrans          uint32
+	packetLost       uint32
+	srttUs           uint32
+	minRttUs         uint32
+	success          bool
+
+	srcWorkload, srcNamespace string
+	dstWorkload, dstNamespace string
+	dstService                string
+}
+
+func newIpfixFlow(data requestMetric, accesslog logInfo) *ipfixFlow {
+	flow := &ipfixFlow{
+		srcIp:         ipfixAddress(data.conSrcDstInfo.src),
+		dstIp:         ipfixAddress(data.conSrcDstInfo.dst),
+		srcPort:       data.conSrcDstInfo.srcPort,
+		dstPort:       data.conSrcDstInfo.dstPort,
+		direction:     ipfixFlowDirectionIngress,
+		endReason:     ipfixFlowEndReasonActive,
+		start:         calculateUptime(osStartTime, data.startTime),
+		end:           calculateUptime(osStartTime, data.lastReportTime),
+		sentBytes:     uint64(data.sentBytes),
+		receivedBytes: uint64(data.receivedBytes),
+		retrans:       data.totalRetrans,
+		packetLost:    data.packetLost,
+		srttUs:        srttMicros(data.srtt),
+		minRttUs:      data.minRtt,
+		success:       data.success == connection_success,
+		srcWorkload:   knownOrEmpty(accesslog.sourceWorkload),
+		srcNamespace:  knownOrEmpty(accesslog.sourceNamespace),
+		dstWorkload:   knownOrEmpty(accesslog.destinationWorkload),
+		dstNamespace:  knownOrEmpty(accesslog.destinationNamespace),
+		dstService:    knownOrEmpty(accesslog.destinationService),
+	}
+	if data.conSrcDstInfo.direction == constants.OUTBOUND {
+		flow.direction = ipfixFlowDirectionEgress
+	}
+	if data.state == TCP_CLOSED {
+		flow.endReason = ipfixFlowEndReasonEndOfFlow
+	}
+	// an IPv4 connection is reported in IPv6 form, both ends are of the same family
+	if len(flow.srcIp) != len(flow.dstIp) {
+		flow.srcIp = ipfixAddressV6(data.conSrcDstInfo.src)
+		flow.dstIp = ipfixAddressV6(data.conSrcDstInfo.dst)
+	}
+	return flow
+}
+
+func ipfixAddressV6(addr [4]uint32) []byte {
+	var b []byte
+	for i := range addr {
+		b = binary.LittleEndian.AppendUint32(b, addr[i])
+	}
+	return b
+}
+
+func ipfixAddress(addr [4]uint32) []byte {
+	return restoreIPv4(ipfixAddressV6(addr))
+}
+
+// ipfixElement is a field of a template, and how it is encoded in a data record.
+type ipfixElement struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+	encode     func(b []byte, flow *ipfixFlow) []byte
+}
+
+func ipfixElements(v6 bool, enterpriseNumber uint32) []ipfixElement {
+	srcIp, dstIp, ipLen := uint16(ipfixSourceIPv4Address), uint16(ipfixDestinationIPv4Address), uint16(net.IPv4len)
+	if v6 {
+		srcIp, dstIp, ipLen = ipfixSourceIPv6Address, ipfixDestinationIPv6Address, net.IPv6len
+	}
+	str := func(value func(flow *ipfixFlow) string) func(b []byte, flow *ipfixFlow) []byte {
+		return func(b []byte, flow *ipfixFlow) []byte {
+			return appendIpfixString(b, value(flow))
+		}
+	}
+
+	return []ipfixElement{
+		{id: srcIp, length: ipLen, encode: func(b []byte, flow *ipfixFlow) []byte { return append(b, flow.srcIp...) }},
+		{id: dstIp, length: ipLen, encode: func(b []byte, flow *ipfixFlow) []byte { return append(b, flow.dstIp...) }},
+		{id: ipfixSourceTransportPort, length: 2, encode: func(b []byte, flow *ipfixFlow) []byte { return binary.BigEndian.AppendUint16(b, flow.srcPort) }},
+		{id: ipfixDestinationTransportPort, length: 2, encode: func(b []byte, flow *ipfixFlow) []byte { return binary.BigEndian.AppendUint16(b, flow.dstPort) }},
+		{id: ipfixProtocolIdentifier, length: 1, encode: func(b []byte, flow *ipfixFlow) []byte { return append(b, ipfixProtocolTcp) }},
+		{id: ipfixFlowDirection, length: 1, encode: func(b []byte, flow *ipfixFlow) []byte { return append(b, flow.direction) }},
+		{id: ipfixFlowEndReason, length: 1, encode: func(b []byte, flow *ipfixFlow) []byte { return append(b, flow.endReason) }},
+		{id: ipfixFlowStartMilliseconds, length: 8, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint64(b, uint64(flow.start.UnixMilli()))
+		}},
+		{id: ipfixFlowEndMilliseconds, length: 8, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint64(b, uint64(flow.end.UnixMilli()))
+		}},
+		{id: ipfixOctetDeltaCount, length: 8, encode: func(b []byte, flow *ipfixFlow) []byte { return binary.BigEndian.AppendUint64(b, flow.sentBytes) }},
+		{id: ipfixOctetDeltaCount, length: 8, enterprise: ipfixReverseEnterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint64(b, flow.receivedBytes)
+		}},
+		{id: ipfixRetransDeltaCount, length: 4, enterprise: enterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint32(b, flow.retrans)
+		}},
+		{id: ipfixPacketLossDeltaCount, length: 4, enterprise: enterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint32(b, flow.packetLost)
+		}},
+		{id: ipfixSrttMicroseconds, length: 4, enterprise: enterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint32(b, flow.srttUs)
+		}},
+		{id: ipfixMinRttMicroseconds, length: 4, enterprise: enterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			return binary.BigEndian.AppendUint32(b, flow.minRttUs)
+		}},
+		{id: ipfixConnectionSuccess, length: 1, enterprise: enterpriseNumber, encode: func(b []byte, flow *ipfixFlow) []byte {
+			if flow.success {
+				return append(b, ipfixTrue)
+			}
+			return append(b, ipfixFalse)
+		}},
+		{id: ipfixSourceWorkload, length: ipfixVarLen, enterprise: enterpriseNumber, encode: str(func(flow *ipfixFlow) string { return flow.srcWorkload })},
+		{id: ipfixSourceNamespace, length: ipfixVarLen, enterprise: enterpriseNumber, encode: str(func(flow *ipfixFlow) string { return flow.srcNamespace })},
+		{id: ipfixDestinationWorkload, length: ipfixVarLen, enterprise: enterpriseNumber, encode: str(func(flow *ipfixFlow) string { return flow.dstWorkload })},
+		{id: ipfixDestinationNamespace, length: ipfixVarLen, enterprise: enterpriseNumber, encode: str(func(flow *ipfixFlow) string { return flow.dstNamespace })},
+		{id: ipfixDestinationService, length: ipfixVarLen, enterprise: enterpriseNumber, encode: str(func(flow *ipfixFlow) string { return flow.dstService })},
+	}
+}
+
+// appendIpfixString encodes a variable-length string, the length takes 1 byte, or 3 bytes if it is not less than 255.
+func appendIpfixString(b []byte, value string) []byte {
+	if len(value) > ipfixVarLen {
+		value = value[:ipfixVarLen]
+	}
+	if len(value) < ipfixShortVarLenMax {
+		b = append(b, byte(len(value)))
+	} else {
+		b = append(b, ipfixShortVarLenMax)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	}
+	return append(b, value...)
+}
+
+// appendIpfixTemplate encodes the template record of the elements.
+func appendIpfixTemplate(b []byte, templateId uint16, elements []ipfixElement) []byte {
+	b = binary.BigEndian.AppendUint16(b, templateId)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(elements)))
+	for _, element := range elements {
+		if element.enterprise == 0 {
+			b = binary.BigEndian.AppendUint16(b, element.id)
+			b = binary.BigEndian.AppendUint16(b, element.length)
+			continue
+		}
+		b = binary.BigEndian.AppendUint16(b, element.id|ipfixEnterpriseBit)
+		b = binary.BigEndian.AppendUint16(b, element.length)
+		b = binary.BigEndian.AppendUint32(b, element.enterprise)
+	}
+	return b
+}
+
+// ipfixExporter buffers the flow records and sends them to an IPFIX collector over UDP.
+// As UDP is unreliable, the templates are resent periodically.
+type ipfixExporter struct {
+	conn            net.Conn
+	domainId        uint32
+	templateRefresh time.Duration
+	templates       map[uint16][]ipfixElement
+	templateSet     []byte
+
+	mutex sync.Mutex
+	// sequence is the number of the data records sent so far, modulo 2^32
+	sequence         uint32
+	lastTemplateSent time.Time
+	// pending is the data records buffered of each template
+	pending        map[uint16][]byte
+	pendingRecords uint32
+	failing        bool
+}
+
+func newIpfixExporter(config *options.IpfixConfig) (*ipfixExporter, error) {
+	conn, err := net.Dial("udp", config.CollectorAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial ipfix collector %s: %v", config.CollectorAddress, err)
+	}
+
+	e := &ipfixExporter{
+		conn:            conn,
+		domainId:        config.ObservationDomainId,
+		templateRefresh: config.TemplateRefreshInterval,
+		templates: map[uint16][]ipfixElement{
+			ipfixTemplateIdV4: ipfixElements(false, config.EnterpriseNumber),
+			ipfixTemplateIdV6: ipfixElements(true, config.EnterpriseNumber),
+		},
+		pending: map[uint16][]byte{},
+	}
+	records := appendIpfixTemplate(nil, ipfixTemplateIdV4, e.templates[ipfixTemplateIdV4])
+	records = appendIpfixTemplate(records, ipfixTemplateIdV6, e.templates[ipfixTemplateIdV6])
+	e.templateSet = appendIpfixSet(nil, ipfixTemplateSetId, records)
+	return e, nil
+}
+
+func appendIpfixSet(b []byte, setId uint16, records []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, setId)
+	b = binary.BigEndian.AppendUint16(b, uint16(ipfixSetHeaderLen+len(records)))
+	return append(b, records...)
+}
+
+// exportFlow buffers the flow record, the buffered records are sent once they fill up a message.
+func (e *ipfixExporter) exportFlow(flow *ipfixFlow) {
+	if e == nil {
+		return
+	}
+	templateId := uint16(ipfixTemplateIdV4)
+	if len(flow.srcIp) == net.IPv6len {
+		templateId = ipfixTemplateIdV6
+	}
+	var record []byte
+	for _, element := range e.templates[templateId] {
+		record = element.encode(record, flow)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	size := e.messageSize(time.Now()) + len(record)
+	if len(e.pending[templateId]) == 0 {
+		size += ipfixSetHeaderLen
+	}
+	if size > ipfixMaxMessageSize {
+		e.send(time.Now())
+	}
+	e.pending[templateId] = append(e.pending[templateId], record...)
+	e.pendingRecords++
+}
+
+// flush sends the buffered records, and the templates if they are due to be refreshed.
+func (e *ipfixExporter) flush(now time.Time) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pendingRecords > 0 || e.templateDue(now) {
+		e.send(now)
+	}
+}
+
+// templateDue must be called with the mutex held.
+func (e *ipfixExporter) templateDue(now time.Time) bool {
+	return e.lastTemplateSent.IsZero() || now.Sub(e.lastTemplateSent) >= e.templateRefresh
+}
+
+// messageSize returns the size of the message of the buffered records, it must be called with the mutex held.
+func (e *ipfixExporter) messageSize(now time.Time) int {
+	size := ipfixMessageHeaderLen
+	if e.templateDue(now) {
+		size += len(e.templateSet)
+	}
+	for _, records := range e.pending {
+		if len(records) > 0 {
+			size += ipfixSetHeaderLen + len(records)
+		}
+	}
+	return size
+}
+
+// send sends the buffered records in a message, it must be called with the mutex held.
+func (e *ipfixExporter) send(now time.Time) {
+	message := make([]byte, ipfixMessageHeaderLen, e.messageSize(now))
+	binary.BigEndian.PutUint16(message[0:], ipfixVersion)
+	binary.BigEndian.PutUint32(message[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(message[8:], e.sequence)
+	binary.BigEndian.PutUint32(message[12:], e.domainId)
+	templateSent := e.templateDue(now)
+	if templateSent {
+		message = append(message, e.templateSet...)
+	}
+	for _, templateId := range []uint16{ipfixTemplateIdV4, ipfixTemplateIdV6} {
+		if records := e.pending[templateId]; len(records) > 0 {
+			message = appendIpfixSet(message, templateId, records)
+		}
+	}
+	binary.BigEndian.PutUint16(message[2:], uint16(len(message)))
+
+	// the records are dropped if they can not be sent, as the collector may be temporarily unreachable
+	e.sequence += e.pendingRecords
+	e.pending = map[uint16][]byte{}
+	e.pendingRecords = 0
+	if _, err := e.conn.Write(message); err != nil {
+		if !e.failing {
+			log.Warnf("send ipfix message to %s failed: %v", e.conn.RemoteAddr(), err)
+		}
+		e.failing = true
+		return
+	}
+	e.failing = false
+	if templateSent {
+		e.lastTemplateSent = now
+	}
+}
+
+func (e *ipfixExporter) close() error {
+	e.flush(time.Now())
+	return e.conn.Close()
+}
+
+// StartIpfixExport starts exporting a flow record of each connection report to the IPFIX collector,
+// until ctx is done.
+func (m *MetricController) StartIpfixExport(ctx context.Context, config *options.IpfixConfig) error {
+	if m == nil || !config.Enabled() {
+		return nil
+	}
+	if !m.EnableMonitoring.Load() {
+		log.Warnf("ipfix export requires monitoring to be enabled")
+	}
+
+	exporter, err := newIpfixExporter(config)
+	if err != nil {
+		return err
+	}
+	m.ipfix.Store(exporter)
+	go func() {
+		ticker := time.NewTicker(config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				m.ipfix.Store(nil)
+				if err := exporter.close(); err != nil {
+					log.Errorf("close ipfix exporter failed: %v", err)
+				}
+				return
+			case now := <-ticker.C:
+				exporter.flush(now)
+			}
+		}
+	}()
+	log.Infof("ipfix export to %s is started", config.CollectorAddress)
+	return nil
+}
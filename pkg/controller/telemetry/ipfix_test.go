@@ -0,0 +1,315 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/constants"
+)
+
+// ipfixTestField is a field specifier of a template received by the test collector.
+type ipfixTestField struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+}
+
+type ipfixTestMessage struct {
+	length    uint16
+	sequence  uint32
+	domainId  uint32
+	templates map[uint16][]ipfixTestField
+	// records are the values of the data records by the field, the kmesh fields are keyed by the id plus 1000
+	records []map[uint32][]byte
+}
+
+func fieldKey(field ipfixTestField) uint32 {
+	switch field.enterprise {
+	case 0:
+		return uint32(field.id)
+	case ipfixReverseEnterpriseNumber:
+		return uint32(field.id) + 2000
+	default:
+		return uint32(field.id) + 1000
+	}
+}
+
+// decodeIpfixMessage decodes the message with the templates of the previous messages.
+func decodeIpfixMessage(t *testing.T, b []byte, templates map[uint16][]ipfixTestField) *ipfixTestMessage {
+	require.GreaterOrEqual(t, len(b), ipfixMessageHeaderLen)
+	require.Equal(t, uint16(ipfixVersion), binary.BigEndian.Uint16(b[0:]))
+	msg := &ipfixTestMessage{
+		length:    binary.BigEndian.Uint16(b[2:]),
+		sequence:  binary.BigEndian.Uint32(b[8:]),
+		domainId:  binary.BigEndian.Uint32(b[12:]),
+		templates: map[uint16][]ipfixTestField{},
+	}
+	require.Equal(t, len(b), int(msg.length))
+
+	for b = b[ipfixMessageHeaderLen:]; len(b) > 0; {
+		setId := binary.BigEndian.Uint16(b[0:])
+		setLen := int(binary.BigEndian.Uint16(b[2:]))
+		set := b[ipfixSetHeaderLen:setLen]
+		b = b[setLen:]
+
+		if setId == ipfixTemplateSetId {
+			for len(set) > 0 {
+				templateId := binary.BigEndian.Uint16(set[0:])
+				count := int(binary.BigEndian.Uint16(set[2:]))
+				set = set[4:]
+				var fields []ipfixTestField
+				for i := 0; i < count; i++ {
+					field := ipfixTestField{id: binary.BigEndian.Uint16(set[0:]), length: binary.BigEndian.Uint16(set[2:])}
+					set = set[4:]
+					if field.id&ipfixEnterpriseBit != 0 {
+						field.id &^= ipfixEnterpriseBit
+						field.enterprise = binary.BigEndian.Uint32(set)
+						set = set[4:]
+					}
+					fields = append(fields, field)
+				}
+				msg.templates[templateId] = fields
+				templates[templateId] = fields
+			}
+			continue
+		}
+
+		fields, ok := templates[setId]
+		require.True(t, ok, "unknown template %d", setId)
+		for len(set) > 0 {
+			record := map[uint32][]byte{}
+			for _, field := range fields {
+				length := int(field.length)
+				if field.length == ipfixVarLen {
+					length = int(set[0])
+					set = set[1:]
+					if length == ipfixShortVarLenMax {
+						length = int(binary.BigEndian.Uint16(set))
+						set = set[2:]
+					}
+				}
+				record[fieldKey(field)] = set[:length]
+				set = set[length:]
+			}
+			msg.records = append(msg.records, record)
+		}
+	}
+	return msg
+}
+
+func newIpfixTestCollector(t *testing.T) (*net.UDPConn, func() []byte) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { collector.Close() })
+
+	buf := make([]byte, 65535)
+	return collector, func() []byte {
+		require.NoError(t, collector.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		n, err := collector.Read(buf)
+		if err != nil {
+			return nil
+		}
+		return append([]byte{}, buf[:n]...)
+	}
+}
+
+func TestIpfixExport(t *testing.T) {
+	collector, receive := newIpfixTestCollector(t)
+	exporter, err := newIpfixExporter(&options.IpfixConfig{
+		CollectorAddress:        collector.LocalAddr().String(),
+		ObservationDomainId:     7,
+		EnterpriseNumber:        options.IpfixDocumentationEnterpriseNumber,
+		TemplateRefreshInterval: time.Minute,
+	})
+	require.NoError(t, err)
+	defer exporter.close()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	v4 := &ipfixFlow{
+		srcIp:         net.ParseIP("10.244.0.10").To4(),
+		dstIp:         net.ParseIP("10.244.0.7").To4(),
+		srcPort:       47667,
+		dstPort:       8080,
+		direction:     ipfixFlowDirectionEgress,
+		endReason:     ipfixFlowEndReasonEndOfFlow,
+		start:         start,
+		end:           start.Add(1500 * time.Millisecond),
+		sentBytes:     100,
+		receivedBytes: 200,
+		retrans:       1,
+		packetLost:    2,
+		srttUs:        300,
+		minRttUs:      250,
+		success:       true,
+		srcWorkload:   "sleep-7d5f9b9c4-x2x5z",
+		srcNamespace:  "default",
+		dstWorkload:   "httpbin-5c5f9d7b8-9bq2m",
+		dstNamespace:  "default",
+		dstService:    "httpbin.default.svc.cluster.local",
+	}
+	v6 := &ipfixFlow{
+		srcIp:       net.ParseIP("fd00::10"),
+		dstIp:       net.ParseIP("fd00::7"),
+		srcPort:     47668,
+		dstPort:     8080,
+		start:       start,
+		end:         start,
+		dstWorkload: strings.Repeat("a", 300),
+	}
+	exporter.exportFlow(v4)
+	exporter.exportFlow(v6)
+	now := time.Now()
+	exporter.flush(now)
+
+	templates := map[uint16][]ipfixTestField{}
+	msg := decodeIpfixMessage(t, receive(), templates)
+	assert.Equal(t, uint32(0), msg.sequence)
+	assert.Equal(t, uint32(7), msg.domainId)
+	require.Len(t, msg.templates, 2)
+	assert.Contains(t, msg.templates[ipfixTemplateIdV4], ipfixTestField{id: ipfixSourceIPv4Address, length: 4})
+	assert.Contains(t, msg.templates[ipfixTemplateIdV6], ipfixTestField{id: ipfixSourceIPv6Address, length: 16})
+	assert.Contains(t, msg.templates[ipfixTemplateIdV4], ipfixTestField{id: ipfixOctetDeltaCount, length: 8, enterprise: ipfixReverseEnterpriseNumber})
+	assert.Contains(t, msg.templates[ipfixTemplateIdV4], ipfixTestField{id: ipfixSourceWorkload, length: ipfixVarLen, enterprise: options.IpfixDocumentationEnterpriseNumber})
+	require.Len(t, msg.records, 2)
+
+	record := msg.records[0]
+	assert.Equal(t, []byte{10, 244, 0, 10}, record[ipfixSourceIPv4Address])
+	assert.Equal(t, []byte{10, 244, 0, 7}, record[ipfixDestinationIPv4Address])
+	assert.Equal(t, uint16(47667), binary.BigEndian.Uint16(record[ipfixSourceTransportPort]))
+	assert.Equal(t, uint16(8080), binary.BigEndian.Uint16(record[ipfixDestinationTransportPort]))
+	assert.Equal(t, []byte{ipfixProtocolTcp}, record[ipfixProtocolIdentifier])
+	assert.Equal(t, []byte{ipfixFlowDirectionEgress}, record[ipfixFlowDirection])
+	assert.Equal(t, []byte{ipfixFlowEndReasonEndOfFlow}, record[ipfixFlowEndReason])
+	assert.Equal(t, uint64(start.UnixMilli()), binary.BigEndian.Uint64(record[ipfixFlowStartMilliseconds]))
+	assert.Equal(t, uint64(start.UnixMilli()+1500), binary.BigEndian.Uint64(record[ipfixFlowEndMilliseconds]))
+	assert.Equal(t, uint64(100), binary.BigEndian.Uint64(record[ipfixOctetDeltaCount]))
+	assert.Equal(t, uint64(200), binary.BigEndian.Uint64(record[ipfixOctetDeltaCount+2000]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(record[ipfixRetransDeltaCount+1000]))
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(record[ipfixPacketLossDeltaCount+1000]))
+	assert.Equal(t, uint32(300), binary.BigEndian.Uint32(record[ipfixSrttMicroseconds+1000]))
+	assert.Equal(t, uint32(250), binary.BigEndian.Uint32(record[ipfixMinRttMicroseconds+1000]))
+	assert.Equal(t, []byte{ipfixTrue}, record[ipfixConnectionSuccess+1000])
+	assert.Equal(t, "sleep-7d5f9b9c4-x2x5z", string(record[ipfixSourceWorkload+1000]))
+	assert.Equal(t, "default", string(record[ipfixSourceNamespace+1000]))
+	assert.Equal(t, "httpbin-5c5f9d7b8-9bq2m", string(record[ipfixDestinationWorkload+1000]))
+	assert.Equal(t, "default", string(record[ipfixDestinationNamespace+1000]))
+	assert.Equal(t, "httpbin.default.svc.cluster.local", string(record[ipfixDestinationService+1000]))
+
+	record = msg.records[1]
+	assert.Equal(t, []byte(net.ParseIP("fd00::10")), record[ipfixSourceIPv6Address])
+	assert.Equal(t, []byte{ipfixFalse}, record[ipfixConnectionSuccess+1000])
+	assert.Equal(t, "", string(record[ipfixSourceWorkload+1000]))
+	assert.Equal(t, strings.Repeat("a", 300), string(record[ipfixDestinationWorkload+1000]))
+
+	// nothing is sent without records until the templates are due to be refreshed
+	exporter.flush(now.Add(time.Second))
+	assert.Nil(t, receive())
+	exporter.flush(now.Add(time.Minute))
+	msg = decodeIpfixMessage(t, receive(), templates)
+	assert.Len(t, msg.templates, 2)
+	assert.Empty(t, msg.records)
+
+	// the records filling up a message are sent without the templates, and the sequence counts the records sent before
+	for i := 0; i < 20; i++ {
+		exporter.exportFlow(v4)
+	}
+	sequence, sent, messages := uint32(2), 0, 0
+	exporter.flush(now.Add(time.Minute + time.Second))
+	for b := receive(); b != nil; b = receive() {
+		msg = decodeIpfixMessage(t, b, templates)
+		assert.LessOrEqual(t, int(msg.length), ipfixMaxMessageSize)
+		assert.Empty(t, msg.templates)
+		assert.Equal(t, sequence, msg.sequence)
+		assert.NotEmpty(t, msg.records)
+		sequence += uint32(len(msg.records))
+		sent += len(msg.records)
+		messages++
+	}
+	assert.Equal(t, 20, sent)
+	assert.Greater(t, messages, 1)
+}
+
+func TestNewIpfixFlow(t *testing.T) {
+	data := requestMetric{
+		conSrcDstInfo: connectionSrcDst{
+			// 10.244.0.10 and 10.244.0.7 reported in IPv6 form
+			src:       [4]uint32{binary.LittleEndian.Uint32([]byte{10, 244, 0, 10}), 0, 0, 0},
+			dst:       [4]uint32{binary.LittleEndian.Uint32([]byte{10, 244, 0, 7}), 0, 0, 0},
+			srcPort:   47667,
+			dstPort:   8080,
+			direction: constants.INBOUND,
+		},
+		state:         TCP_ESTABLISHED,
+		sentBytes:     100,
+		receivedBytes: 200,
+		srtt:          2400,
+		minRtt:        250,
+	}
+	accesslog := *NewLogInfo()
+	accesslog.sourceWorkload = "sleep"
+
+	flow := newIpfixFlow(data, accesslog)
+	assert.Equal(t, []byte{10, 244, 0, 10}, flow.srcIp)
+	assert.Equal(t, []byte{10, 244, 0, 7}, flow.dstIp)
+	assert.Equal(t, uint8(ipfixFlowDirectionIngress), flow.direction)
+	assert.Equal(t, uint8(ipfixFlowEndReasonActive), flow.endReason)
+	assert.Equal(t, uint32(300), flow.srttUs)
+	assert.Equal(t, "sleep", flow.srcWorkload)
+	assert.Equal(t, "", flow.dstWorkload)
+	assert.False(t, flow.success)
+
+	data.state = TCP_CLOSED
+	data.success = connection_success
+	data.conSrcDstInfo.direction = constants.OUTBOUND
+	flow = newIpfixFlow(data, accesslog)
+	assert.Equal(t, uint8(ipfixFlowEndReasonEndOfFlow), flow.endReason)
+	assert.Equal(t, uint8(ipfixFlowDirectionEgress), flow.direction)
+	assert.True(t, flow.success)
+}
+
+func TestStartIpfixExport(t *testing.T) {
+	m := NewMetric(nil, nil, true)
+	assert.NoError(t, m.StartIpfixExport(context.Background(), &options.IpfixConfig{}))
+	assert.Nil(t, m.ipfix.Load())
+
+	collector, receive := newIpfixTestCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, m.StartIpfixExport(ctx, &options.IpfixConfig{
+		CollectorAddress:        collector.LocalAddr().String(),
+		EnterpriseNumber:        options.IpfixDocumentationEnterpriseNumber,
+		TemplateRefreshInterval: time.Minute,
+		FlushInterval:           time.Hour,
+	}))
+	require.NotNil(t, m.ipfix.Load())
+	m.ipfix.Load().exportFlow(&ipfixFlow{srcIp: net.IPv4(10, 0, 0, 1).To4(), dstIp: net.IPv4(10, 0, 0, 2).To4()})
+
+	// the buffered records are sent once the export is stopped
+	cancel()
+	msg := decodeIpfixMessage(t, receive(), map[uint16][]ipfixTestField{})
+	assert.Len(t, msg.records, 1)
+	assert.Eventually(t, func() bool { return m.ipfix.Load() == nil }, time.Second, 10*time.Millisecond)
+}
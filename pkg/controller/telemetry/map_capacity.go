@@ -0,0 +1,145 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// MapNearFullReason is the reason of the event raised when a map crosses the warning threshold
+	MapNearFullReason = "BpfMapNearFull"
+	// MapCriticalReason is the reason of the event raised when a map crosses the critical threshold
+	MapCriticalReason = "BpfMapCriticallyFull"
+	// MapRecoveredReason is the reason of the event raised when a map falls back below the warning threshold
+	MapRecoveredReason = "BpfMapUtilizationNormal"
+)
+
+type mapAlertLevel int
+
+const (
+	mapAlertNone mapAlertLevel = iota
+	mapAlertWarning
+	mapAlertCritical
+)
+
+// mapCapacityAlerts raises a log and a Kubernetes event each time the utilization of a map
+// crosses a threshold, the alerts are edge triggered so a full map is not reported on every flush.
+type mapCapacityAlerts struct {
+	warning  float64
+	critical float64
+	recorder record.EventRecorder
+	node     *corev1.ObjectReference
+	levels   map[string]mapAlertLevel
+}
+
+func newMapCapacityAlerts(warning, critical float64, recorder record.EventRecorder) *mapCapacityAlerts {
+	a := &mapCapacityAlerts{
+		warning:  warning,
+		critical: critical,
+		levels:   make(map[string]mapAlertLevel),
+	}
+	// events are attached to the node, as the maps are shared by all the pods running on it
+	if nodeName := os.Getenv("NODE_NAME"); recorder != nil && nodeName != "" {
+		a.recorder = recorder
+		a.node = &corev1.ObjectReference{Kind: "Node", Name: nodeName, UID: types.UID(nodeName)}
+	}
+	return a
+}
+
+func (a *mapCapacityAlerts) level(utilization float64) mapAlertLevel {
+	if a.critical > 0 && utilization >= a.critical {
+		return mapAlertCritical
+	}
+	if a.warning > 0 && utilization >= a.warning {
+		return mapAlertWarning
+	}
+	return mapAlertNone
+}
+
+func (a *mapCapacityAlerts) check(mapName string, entryCount, maxEntries uint32) {
+	if a == nil || maxEntries == 0 {
+		return
+	}
+	utilization := float64(entryCount) / float64(maxEntries)
+	level := a.level(utilization)
+	prev := a.levels[mapName]
+	if level == prev {
+		return
+	}
+	if level == mapAlertNone {
+		delete(a.levels, mapName)
+	} else {
+		a.levels[mapName] = level
+	}
+
+	switch {
+	case level == mapAlertCritical:
+		log.Errorf("bpf map %s is %.1f%% full (%d/%d entries), updates fail once it is full", mapName, utilization*100, entryCount, maxEntries)
+		a.event(corev1.EventTypeWarning, MapCriticalReason, "bpf map %s is %.1f%% full (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+	case level == mapAlertWarning && level > prev:
+		log.Warnf("bpf map %s is %.1f%% full (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+		a.event(corev1.EventTypeWarning, MapNearFullReason, "bpf map %s is %.1f%% full (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+	case level == mapAlertWarning:
+		log.Warnf("bpf map %s is back below the critical threshold, %.1f%% full (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+		a.event(corev1.EventTypeWarning, MapNearFullReason, "bpf map %s is back below the critical threshold, %.1f%% full (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+	case level == mapAlertNone:
+		log.Infof("bpf map %s utilization is back to %.1f%% (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+		a.event(corev1.EventTypeNormal, MapRecoveredReason, "bpf map %s utilization is back to %.1f%% (%d/%d entries)", mapName, utilization*100, entryCount, maxEntries)
+	}
+}
+
+func (a *mapCapacityAlerts) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if a.recorder == nil {
+		return
+	}
+	a.recorder.Eventf(a.node, eventType, reason, messageFmt, args...)
+}
+
+// mapFullReason returns the reason label of a map update failed as the map is full, or "" for any other failure.
+// Hash maps fail with E2BIG once max entries is reached, while other map types, e.g. LPM tries, fail with ENOSPC.
+func mapFullReason(err error) string {
+	switch {
+	case errors.Is(err, unix.E2BIG):
+		return "e2big"
+	case errors.Is(err, unix.ENOSPC):
+		return "enospc"
+	default:
+		return ""
+	}
+}
+
+// RecordMapUpdateFailure counts the update of the map failed with err if it is due to the map being full,
+// it returns whether it is.
+func RecordMapUpdateFailure(mapName string, err error) bool {
+	reason := mapFullReason(err)
+	if reason == "" {
+		return false
+	}
+	mapUpdateFailures.With(map[string]string{
+		"node_name": os.Getenv("NODE_NAME"),
+		"map_name":  mapName,
+		"reason":    reason,
+	}).Inc()
+	return true
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMapCapacityAlerts(t *testing.T) {
+	os.Setenv("NODE_NAME", "test-node")
+	defer os.Unsetenv("NODE_NAME")
+
+	recorder := record.NewFakeRecorder(10)
+	alerts := newMapCapacityAlerts(0.8, 0.95, recorder)
+
+	steps := []struct {
+		entries uint32
+		event   string
+	}{
+		{entries: 50},
+		{entries: 80, event: "Warning BpfMapNearFull bpf map km_endpoint is 80.0% full (80/100 entries)"},
+		// alerts are only raised when a threshold is crossed
+		{entries: 90},
+		{entries: 100, event: "Warning BpfMapCriticallyFull bpf map km_endpoint is 100.0% full (100/100 entries)"},
+		{entries: 85, event: "Warning BpfMapNearFull bpf map km_endpoint is back below the critical threshold, 85.0% full (85/100 entries)"},
+		{entries: 10, event: "Normal BpfMapUtilizationNormal bpf map km_endpoint utilization is back to 10.0% (10/100 entries)"},
+	}
+	for i, step := range steps {
+		alerts.check("km_endpoint", step.entries, 100)
+		if step.event == "" {
+			assert.Empty(t, recorder.Events, "step %d", i)
+			continue
+		}
+		select {
+		case event := <-recorder.Events:
+			assert.Equal(t, step.event, event, "step %d", i)
+		default:
+			t.Fatalf("step %d: no event raised", i)
+		}
+	}
+	assert.Empty(t, alerts.levels)
+
+	// a disabled warning threshold only alerts at the critical one
+	alerts = newMapCapacityAlerts(0, 0.95, nil)
+	alerts.check("km_backend", 90, 100)
+	assert.Empty(t, alerts.levels)
+	alerts.check("km_backend", 96, 100)
+	assert.Equal(t, mapAlertCritical, alerts.levels["km_backend"])
+
+	// nil alerts are disabled
+	var disabled *mapCapacityAlerts
+	disabled.check("km_backend", 100, 100)
+}
+
+func TestRecordMapUpdateFailure(t *testing.T) {
+	os.Setenv("NODE_NAME", "test-node")
+	defer os.Unsetenv("NODE_NAME")
+
+	counter := func(reason string) float64 {
+		return testutil.ToFloat64(mapUpdateFailures.WithLabelValues("test-node", "km_backend", reason))
+	}
+	e2big, enospc := counter("e2big"), counter("enospc")
+
+	assert.True(t, RecordMapUpdateFailure("km_backend", fmt.Errorf("update: %w", unix.E2BIG)))
+	assert.True(t, RecordMapUpdateFailure("km_backend", unix.ENOSPC))
+	assert.False(t, RecordMapUpdateFailure("km_backend", unix.EINVAL))
+
+	assert.Equal(t, e2big+1, counter("e2big"))
+	assert.Equal(t, enospc+1, counter("enospc"))
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/cilium/ebpf"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -31,6 +32,7 @@ const (
 )
 
 type MapMetricController struct {
+	alerts *mapCapacityAlerts
 }
 
 type MapInfo struct {
@@ -47,6 +49,13 @@ func NewMapMetric() *MapMetricController {
 	return &MapMetricController{}
 }
 
+// SetCapacityAlerts enables the alerts raised when the utilization of a map crosses the warning or critical threshold,
+// a threshold of 0 disables its alert. Events are emitted through the recorder if it is not nil.
+// It must be called before Run.
+func (m *MapMetricController) SetCapacityAlerts(warning, critical float64, recorder record.EventRecorder) {
+	m.alerts = newMapCapacityAlerts(warning, critical, recorder)
+}
+
 func (m *MapMetricController) Run(ctx context.Context) {
 	if m == nil {
 		return
@@ -73,8 +82,9 @@ func buildMapMetricLabel(data *MapInfo) mapMetricLabels {
 	return labels
 }
 
+// isKmeshMap reports whether the map is created by kmesh, the maps of the dual-engine mode are prefixed with km_.
 func isKmeshMap(mapName string) bool {
-	return strings.HasPrefix(mapName, "kmesh_")
+	return strings.HasPrefix(mapName, "kmesh_") || strings.HasPrefix(mapName, "km_")
 }
 func (m *MapMetricController) updatePrometheusMetric() {
 	var startID ebpf.MapID
@@ -108,6 +118,11 @@ func (m *MapMetricController) updatePrometheusMetric() {
 		metricLabels := buildMapMetricLabel(&mapData)
 		commonLabels := struct2map(metricLabels)
 		mapEntryCount.With(commonLabels).Set(float64(entryCount))
+		if info.MaxEntries > 0 {
+			mapMaxEntries.With(commonLabels).Set(float64(info.MaxEntries))
+			mapUtilization.With(commonLabels).Set(float64(entryCount) / float64(info.MaxEntries))
+			m.alerts.check(info.Name, entryCount, info.MaxEntries)
+		}
 		mapInfo.Close()
 	}
 	mapCountLabels := map[string]string{"node_name": os.Getenv("NODE_NAME")}
@@ -55,6 +55,7 @@ func TestIsKmeshMap(t *testing.T) {
 		expected bool
 	}{
 		{name: "valid kmesh map", mapName: "kmesh_test_map", expected: true},
+		{name: "valid dual-engine map", mapName: "km_endpoint", expected: true},
 		{name: "invalid map name", mapName: "other_map", expected: false},
 	}
 	for _, tt := range tests {
@@ -30,10 +30,12 @@ import (
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/ringbuf"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller/workload/cache"
+	"kmesh.net/kmesh/pkg/utils"
 )
 
 const (
@@ -80,8 +82,28 @@ type MetricController struct {
 	serviceMetricCache     map[serviceMetricLabels]*serviceMetricInfo
 	connectionMetricCache  map[connectionMetricLabels]*connectionMetricInfo
 	mutex                  sync.RWMutex
+	// connectionObserver is notified of the outcome of the outbound connections
+	connectionObserver atomic.Pointer[ConnectionObserver]
+	// otlp is set when the metrics and access logs are exported over OTLP as well
+	otlp atomic.Pointer[otlpExporter]
+	// ipfix is set when the connection flows are exported to an IPFIX collector
+	ipfix atomic.Pointer[ipfixExporter]
+	// accesslogWriter and accesslogFilter are nil until configured, the access logs of all
+	// connections are written to stdout in text format by default
+	accesslogWriter atomic.Pointer[accesslogWriter]
+	accesslogFilter atomic.Pointer[AccesslogFilter]
+	// events fans the connection events out to the subscribers of the trace
+	events utils.Broadcaster[ConnectionEvent]
+	// graph is the service graph of the connections reported within the last window
+	graph serviceGraph
+	// lastSeriesExpiry is only accessed by updatePrometheusMetric
+	lastSeriesExpiry time.Time
 }
 
+// ConnectionObserver is called with the uid of the destination workload once the outcome of
+// an outbound connection is known. It is called by the ringbuf consumer, so it must not block.
+type ConnectionObserver func(workloadUid string, success bool)
+
 type workloadMetricInfo struct {
 	WorkloadConnOpened        float64
 	WorkloadConnClosed        float64
@@ -90,6 +112,9 @@ type workloadMetricInfo struct {
 	WorkloadConnFailed        float64
 	WorkloadConnTotalRetrans  float64
 	WorkloadConnPacketLost    float64
+	// WorkloadConnDurations and WorkloadConnSrtts are the latency samples in seconds observed since the last flush
+	WorkloadConnDurations []float64
+	WorkloadConnSrtts     []float64
 }
 
 type serviceMetricInfo struct {
@@ -98,6 +123,9 @@ type serviceMetricInfo struct {
 	ServiceConnSentBytes     float64
 	ServiceConnReceivedBytes float64
 	ServiceConnFailed        float64
+	// ServiceConnDurations and ServiceConnSrtts are the latency samples in seconds observed since the last flush
+	ServiceConnDurations []float64
+	ServiceConnSrtts     []float64
 }
 
 type connectionMetricInfo struct {
@@ -512,7 +540,7 @@ func (m *MetricController) Run(ctx context.Context, mapOfTcpInfo *ebpf.Map) {
 			}
 			if m.EnableAccesslog.Load() {
 				// accesslogs at interval of 5 sec during connection lifecycle if connectionMetrics is enabled and at close of connection
-				outputAccesslog(reqMetric, tcpConns[reqMetric.conSrcDstInfo], accesslog)
+				m.outputAccesslog(ctx, reqMetric, tcpConns[reqMetric.conSrcDstInfo], accesslog)
 			}
 
 			m.mutex.Lock()
@@ -525,6 +553,12 @@ func (m *MetricController) Run(ctx context.Context, mapOfTcpInfo *ebpf.Map) {
 			}
 			m.mutex.Unlock()
 
+			m.notifyConnectionObserver(&reqMetric, tcpConns[reqMetric.conSrcDstInfo])
+			m.publishConnectionEvent(reqMetric, tcpConns[reqMetric.conSrcDstInfo], accesslog)
+			if exporter := m.ipfix.Load(); exporter != nil {
+				exporter.exportFlow(newIpfixFlow(reqMetric, accesslog))
+			}
+
 			if reqMetric.state == TCP_CLOSED {
 				delete(tcpConns, reqMetric.conSrcDstInfo)
 			}
@@ -532,6 +566,33 @@ func (m *MetricController) Run(ctx context.Context, mapOfTcpInfo *ebpf.Map) {
 	}
 }
 
+// SetConnectionObserver sets the observer notified of the outcome of the outbound connections.
+func (m *MetricController) SetConnectionObserver(observer ConnectionObserver) {
+	m.connectionObserver.Store(&observer)
+}
+
+func (m *MetricController) notifyConnectionObserver(reqMetric *requestMetric, conn connMetric) {
+	observer := m.connectionObserver.Load()
+	if observer == nil || reqMetric.conSrcDstInfo.direction != constants.OUTBOUND {
+		return
+	}
+	// a connection succeeded is reported once it is established, and a failed one is reported once it is closed
+	success := reqMetric.success == connection_success
+	if success && conn.totalReports != 1 {
+		return
+	}
+
+	var dstAddr []byte
+	for i := range reqMetric.conSrcDstInfo.dst {
+		dstAddr = binary.LittleEndian.AppendUint32(dstAddr, reqMetric.conSrcDstInfo.dst[i])
+	}
+	dstWorkload, _ := m.getWorkloadByAddress(restoreIPv4(dstAddr))
+	if dstWorkload == nil {
+		return
+	}
+	(*observer)(dstWorkload.GetUid(), success)
+}
+
 func buildV4Metric(buf *bytes.Buffer, tcpConns map[connectionSrcDst]connMetric) (requestMetric, error) {
 	reqMetric := requestMetric{}
 	rawStats := connectionDataV4{}
@@ -872,8 +933,10 @@ func (m *MetricController) updateWorkloadMetricCache(reqMetric requestMetric, la
 		newWorkloadMetricInfo.WorkloadConnSentBytes = float64(reqMetric.sentBytes)
 		newWorkloadMetricInfo.WorkloadConnTotalRetrans = float64(reqMetric.totalRetrans)
 		newWorkloadMetricInfo.WorkloadConnPacketLost = float64(reqMetric.packetLost)
-		m.workloadMetricCache[labels] = &newWorkloadMetricInfo
+		v = &newWorkloadMetricInfo
+		m.workloadMetricCache[labels] = v
 	}
+	v.WorkloadConnDurations, v.WorkloadConnSrtts = appendLatencySamples(reqMetric, v.WorkloadConnDurations, v.WorkloadConnSrtts)
 }
 
 func (m *MetricController) updateServiceMetricCache(reqMetric requestMetric, labels serviceMetricLabels, metric connMetric) {
@@ -903,8 +966,27 @@ func (m *MetricController) updateServiceMetricCache(reqMetric requestMetric, lab
 		}
 		newServiceMetricInfo.ServiceConnReceivedBytes = float64(reqMetric.receivedBytes)
 		newServiceMetricInfo.ServiceConnSentBytes = float64(reqMetric.sentBytes)
-		m.serviceMetricCache[labels] = &newServiceMetricInfo
+		v = &newServiceMetricInfo
+		m.serviceMetricCache[labels] = v
+	}
+	v.ServiceConnDurations, v.ServiceConnSrtts = appendLatencySamples(reqMetric, v.ServiceConnDurations, v.ServiceConnSrtts)
+}
+
+// appendLatencySamples appends the duration of a closed connection and the smoothed RTT of each report.
+func appendLatencySamples(reqMetric requestMetric, durations, srtts []float64) ([]float64, []float64) {
+	if reqMetric.state == TCP_CLOSED {
+		durations = append(durations, float64(reqMetric.duration)/float64(time.Second))
 	}
+	if reqMetric.srtt > 0 {
+		srtts = append(srtts, float64(srttMicros(reqMetric.srtt))/float64(time.Second/time.Microsecond))
+	}
+	return durations, srtts
+}
+
+// srttMicros returns the smoothed RTT in microseconds. The srtt reported by bpf/kmesh/probes/tcp_probe.h
+// is the srtt_us of the tcp_sock, which the kernel keeps left shifted by 3.
+func srttMicros(srtt uint32) uint32 {
+	return srtt >> 3
 }
 
 func (m *MetricController) updateConnectionMetricCache(reqMetric requestMetric, labels connectionMetricLabels) {
@@ -939,8 +1021,10 @@ func (m *MetricController) updatePrometheusMetric() {
 	m.connectionMetricCache = map[connectionMetricLabels]*connectionMetricInfo{}
 	m.mutex.Unlock()
 
+	ctx := context.Background()
+	otlp := m.otlp.Load()
 	for k, v := range workloadInfoCache {
-		workloadLabels := struct2map(k)
+		workloadLabels := workloadSeries.labels(struct2map(k))
 		tcpConnectionOpenedInWorkload.With(workloadLabels).Add(v.WorkloadConnOpened)
 		tcpConnectionClosedInWorkload.With(workloadLabels).Add(v.WorkloadConnClosed)
 		tcpSentBytesInWorkload.With(workloadLabels).Add(v.WorkloadConnSentBytes)
@@ -948,23 +1032,31 @@ func (m *MetricController) updatePrometheusMetric() {
 		tcpConnectionFailedInWorkload.With(workloadLabels).Add(v.WorkloadConnFailed)
 		tcpConnectionTotalRetransInWorkload.With(workloadLabels).Add(v.WorkloadConnTotalRetrans)
 		tcpConnectionPacketLostInWorkload.With(workloadLabels).Add(v.WorkloadConnPacketLost)
+		observeSamples(tcpConnectionDurationInWorkload.With(workloadLabels), v.WorkloadConnDurations)
+		observeSamples(tcpSrttInWorkload.With(workloadLabels), v.WorkloadConnSrtts)
+		otlp.recordWorkload(ctx, workloadLabels, v)
 	}
 
 	for k, v := range serviceInfoCache {
-		serviceLabels := struct2map(k)
+		serviceLabels := serviceSeries.labels(struct2map(k))
 		tcpConnectionOpenedInService.With(serviceLabels).Add(v.ServiceConnOpened)
 		tcpConnectionClosedInService.With(serviceLabels).Add(v.ServiceConnClosed)
 		tcpConnectionFailedInService.With(serviceLabels).Add(v.ServiceConnFailed)
 		tcpReceivedBytesInService.With(serviceLabels).Add(v.ServiceConnReceivedBytes)
 		tcpSentBytesInService.With(serviceLabels).Add(v.ServiceConnSentBytes)
+		observeSamples(tcpConnectionDurationInService.With(serviceLabels), v.ServiceConnDurations)
+		observeSamples(tcpSrttInService.With(serviceLabels), v.ServiceConnSrtts)
+		otlp.recordService(ctx, serviceLabels, v)
 	}
+	m.graph.record(time.Now(), serviceInfoCache)
 
 	for k, v := range connectionInfoCache {
-		connectionLabels := struct2map(k)
+		connectionLabels := connectionSeries.labels(struct2map(k))
 		tcpConnectionTotalSendBytes.With(connectionLabels).Add(v.ConnSentBytes)
 		tcpConnectionTotalReceivedBytes.With(connectionLabels).Add(v.ConnReceivedBytes)
 		tcpConnectionTotalPacketLost.With(connectionLabels).Add(v.ConnPacketLost)
 		tcpConnectionTotalRetrans.With(connectionLabels).Add(v.ConnTotalRetrans)
+		otlp.recordConnection(ctx, connectionLabels, v)
 	}
 
 	// delete metrics
@@ -987,6 +1079,21 @@ func (m *MetricController) updatePrometheusMetric() {
 	for i := 0; i < len(connReplica); i++ {
 		deleteConnectionMetricInPrometheus(connReplica[i])
 	}
+
+	if seriesExpiryInterval > 0 {
+		if m.lastSeriesExpiry.IsZero() {
+			m.lastSeriesExpiry = time.Now()
+		} else if time.Since(m.lastSeriesExpiry) >= seriesExpiryInterval {
+			m.expireSeries()
+			m.lastSeriesExpiry = time.Now()
+		}
+	}
+}
+
+func observeSamples(observer prometheus.Observer, samples []float64) {
+	for _, sample := range samples {
+		observer.Observe(sample)
+	}
 }
 
 func struct2map(labels interface{}) map[string]string {
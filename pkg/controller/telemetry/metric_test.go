@@ -22,10 +22,13 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/pkg/constants"
@@ -975,10 +978,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:8000",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "10.19.25.31:8000",
 				destinationService:   "kmesh.kmesh-system.svc.cluster.local",
 				destinationWorkload:  "kmesh",
 				destinationNamespace: "kmesh-system",
+				destinationIdentity:  "spiffe://cluster.local/ns/kmesh-system/sa/default",
 			},
 		},
 		{
@@ -1031,10 +1036,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:8000",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "10.19.25.31:8000",
 				destinationService:   "kmesh.kmesh-system.svc.cluster.local",
 				destinationWorkload:  "kmesh",
 				destinationNamespace: "kmesh-system",
+				destinationIdentity:  "spiffe://cluster.local/ns/kmesh-system/sa/default",
 			},
 		},
 		{
@@ -1087,10 +1094,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:8000",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "191.168.224.22:80",
 				destinationService:   "191.168.224.22",
 				destinationWorkload:  "-",
 				destinationNamespace: "-",
+				destinationIdentity:  "-",
 			},
 		},
 		{
@@ -1143,10 +1152,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:49875",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "10.19.25.32:80",
 				destinationService:   "httpbin.default.svc.cluster.local",
 				destinationWorkload:  "waypoint",
 				destinationNamespace: "default",
+				destinationIdentity:  "spiffe://cluster.local/ns/default/sa/default",
 			},
 		},
 		{
@@ -1199,10 +1210,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:49875",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "10.19.25.32:80",
 				destinationService:   "10.19.25.34",
 				destinationWorkload:  "waypoint",
 				destinationNamespace: "default",
+				destinationIdentity:  "spiffe://cluster.local/ns/default/sa/default",
 			},
 		},
 		{
@@ -1255,10 +1268,12 @@ func TestBuildServiceMetric(t *testing.T) {
 				sourceAddress:        "10.19.25.33:49875",
 				sourceWorkload:       "sleep",
 				sourceNamespace:      "default",
+				sourceIdentity:       "spiffe://cluster.local/ns/default/sa/default",
 				destinationAddress:   "10.19.25.34:80",
 				destinationService:   "10.19.25.34",
 				destinationWorkload:  "solelyWorkload",
 				destinationNamespace: "default",
+				destinationIdentity:  "spiffe://cluster.local/ns/default/sa/default",
 			},
 		},
 	}
@@ -1699,6 +1714,62 @@ func TestMetricController_updatePrometheusMetric(t *testing.T) {
 	}
 }
 
+func TestLatencyHistograms(t *testing.T) {
+	SetLatencyBuckets([]float64{1, 10}, []float64{0.001, 0.01})
+	defer SetLatencyBuckets(DefaultConnectionDurationBuckets, DefaultSrttBuckets)
+
+	workloadLabels := workloadMetricLabels{sourceWorkload: "sleep", sourceWorkloadNamespace: "default", destinationWorkload: "httpbin-latency"}
+	serviceLabels := serviceMetricLabels{sourceWorkload: "sleep", destinationService: "httpbin-latency.default.svc.cluster.local"}
+	m := &MetricController{
+		workloadMetricCache: map[workloadMetricLabels]*workloadMetricInfo{},
+		serviceMetricCache:  map[serviceMetricLabels]*serviceMetricInfo{},
+	}
+
+	reports := []requestMetric{
+		// srtt is 8 times the smoothed RTT in microseconds: 0.5ms
+		{state: TCP_ESTABLISHED, srtt: 4000, duration: uint64(5 * time.Second)},
+		// 5ms, and the connection is closed after 20s
+		{state: TCP_CLOSED, srtt: 40000, duration: uint64(20 * time.Second)},
+		// the report without srtt is not sampled, the connection is closed after 0.5s
+		{state: TCP_CLOSED, duration: uint64(500 * time.Millisecond)},
+	}
+	for _, report := range reports {
+		m.updateWorkloadMetricCache(report, workloadLabels, connMetric{totalReports: 2})
+		m.updateServiceMetricCache(report, serviceLabels, connMetric{totalReports: 2})
+	}
+	assert.Equal(t, []float64{20, 0.5}, m.workloadMetricCache[workloadLabels].WorkloadConnDurations)
+	assert.Equal(t, []float64{0.0005, 0.005}, m.workloadMetricCache[workloadLabels].WorkloadConnSrtts)
+	assert.Equal(t, []float64{20, 0.5}, m.serviceMetricCache[serviceLabels].ServiceConnDurations)
+	assert.Equal(t, []float64{0.0005, 0.005}, m.serviceMetricCache[serviceLabels].ServiceConnSrtts)
+
+	m.updatePrometheusMetric()
+
+	histogram := func(vec *prometheus.HistogramVec, labels map[string]string) *dto.Histogram {
+		var metric dto.Metric
+		require.NoError(t, vec.With(labels).(prometheus.Metric).Write(&metric))
+		return metric.GetHistogram()
+	}
+	for _, h := range []*dto.Histogram{
+		histogram(tcpConnectionDurationInWorkload, struct2map(workloadLabels)),
+		histogram(tcpConnectionDurationInService, struct2map(serviceLabels)),
+	} {
+		assert.Equal(t, uint64(2), h.GetSampleCount())
+		assert.Equal(t, 20.5, h.GetSampleSum())
+		assert.Equal(t, []uint64{1, 1}, []uint64{h.GetBucket()[0].GetCumulativeCount(), h.GetBucket()[1].GetCumulativeCount()})
+	}
+	for _, h := range []*dto.Histogram{
+		histogram(tcpSrttInWorkload, struct2map(workloadLabels)),
+		histogram(tcpSrttInService, struct2map(serviceLabels)),
+	} {
+		assert.Equal(t, uint64(2), h.GetSampleCount())
+		assert.Equal(t, []uint64{1, 2}, []uint64{h.GetBucket()[0].GetCumulativeCount(), h.GetBucket()[1].GetCumulativeCount()})
+	}
+
+	// the histograms of a deleted workload are removed
+	deleteWorkloadMetricInPrometheus(&workloadapi.Workload{Name: "sleep", Namespace: "default"})
+	assert.Equal(t, 0, testutil.CollectAndCount(tcpConnectionDurationInWorkload))
+}
+
 func TestBuildV4Metric(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{10, 244, 1, 13, 10, 244, 1, 12, 34, 208, 144, 31, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10, 96, 46, 224, 144, 31, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -0,0 +1,303 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/metric"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	"kmesh.net/kmesh/daemon/options"
+)
+
+const otlpScopeName = "kmesh.net/kmesh/telemetry"
+
+// otlpCounter is an OTLP counter fed with one field of the metric info cached between two flushes.
+type otlpCounter[T any] struct {
+	name        string
+	description string
+	value       func(*T) float64
+	counter     metric.Float64Counter
+}
+
+var (
+	otlpWorkloadCounters = []otlpCounter[workloadMetricInfo]{
+		{name: "kmesh_tcp_workload_connections_opened_total", description: "The total number of TCP connections opened to a workload",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnOpened }},
+		{name: "kmesh_tcp_workload_connections_closed_total", description: "The total number of TCP connections closed to a workload",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnClosed }},
+		{name: "kmesh_tcp_workload_received_bytes_total", description: "The size of the total number of bytes received in response to a workload over a TCP connection",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnReceivedBytes }},
+		{name: "kmesh_tcp_workload_sent_bytes_total", description: "The size of the total number of bytes sent in response to a workload over a TCP connection",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnSentBytes }},
+		{name: "kmesh_tcp_workload_conntections_failed_total", description: "The total number of TCP connections failed to a workload",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnFailed }},
+		{name: "kmesh_tcp_retrans_total", description: "Total number of retransmissions of the workload over the TCP connection",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnTotalRetrans }},
+		{name: "kmesh_tcp_packet_loss_total", description: "Tracks the total number of TCP packets lost between source and destination",
+			value: func(v *workloadMetricInfo) float64 { return v.WorkloadConnPacketLost }},
+	}
+
+	otlpServiceCounters = []otlpCounter[serviceMetricInfo]{
+		{name: "kmesh_tcp_connections_opened_total", description: "The total number of TCP connections opened to a service",
+			value: func(v *serviceMetricInfo) float64 { return v.ServiceConnOpened }},
+		{name: "kmesh_tcp_connections_closed_total", description: "The total number of TCP connections closed to a service",
+			value: func(v *serviceMetricInfo) float64 { return v.ServiceConnClosed }},
+		{name: "kmesh_tcp_received_bytes_total", description: "The size of the total number of bytes received in response to a service over a TCP connection",
+			value: func(v *serviceMetricInfo) float64 { return v.ServiceConnReceivedBytes }},
+		{name: "kmesh_tcp_sent_bytes_total", description: "The size of the total number of bytes sent in response to a service over a TCP connection",
+			value: func(v *serviceMetricInfo) float64 { return v.ServiceConnSentBytes }},
+		{name: "kmesh_tcp_conntections_failed_total", description: "The total number of TCP connections failed to a service",
+			value: func(v *serviceMetricInfo) float64 { return v.ServiceConnFailed }},
+	}
+
+	otlpConnectionCounters = []otlpCounter[connectionMetricInfo]{
+		{name: "kmesh_tcp_connection_sent_bytes_total", description: "The total number of bytes sent over established TCP connection",
+			value: func(v *connectionMetricInfo) float64 { return v.ConnSentBytes }},
+		{name: "kmesh_tcp_connection_received_bytes_total", description: "The total number of bytes received over established TCP connection",
+			value: func(v *connectionMetricInfo) float64 { return v.ConnReceivedBytes }},
+		{name: "kmesh_tcp_connection_packet_lost_total", description: "Total number of packets lost during transmission in a TCP connection",
+			value: func(v *connectionMetricInfo) float64 { return v.ConnPacketLost }},
+		{name: "kmesh_tcp_connection_retrans_total", description: "The total number of retransmits over established TCP connection",
+			value: func(v *connectionMetricInfo) float64 { return v.ConnTotalRetrans }},
+	}
+)
+
+func newOtlpCounters[T any](meter metric.Meter, templates []otlpCounter[T]) ([]otlpCounter[T], error) {
+	counters := make([]otlpCounter[T], 0, len(templates))
+	for _, c := range templates {
+		counter, err := meter.Float64Counter(c.name, metric.WithDescription(c.description))
+		if err != nil {
+			return nil, fmt.Errorf("create counter %s failed: %v", c.name, err)
+		}
+		c.counter = counter
+		counters = append(counters, c)
+	}
+	return counters, nil
+}
+
+func recordOtlpCounters[T any](ctx context.Context, counters []otlpCounter[T], labels prometheus.Labels, v *T) {
+	if len(counters) == 0 {
+		return
+	}
+	attrs := metric.WithAttributeSet(labelsToAttributeSet(labels))
+	for _, c := range counters {
+		c.counter.Add(ctx, c.value(v), attrs)
+	}
+}
+
+func labelsToAttributeSet(labels map[string]string) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return attribute.NewSet(kvs...)
+}
+
+// otlpExporter pushes the workload, service and connection metrics and the access logs to an OTLP collector.
+type otlpExporter struct {
+	meterProvider  *sdkmetric.MeterProvider
+	loggerProvider *sdklog.LoggerProvider
+	logger         otellog.Logger
+
+	workloadCounters   []otlpCounter[workloadMetricInfo]
+	serviceCounters    []otlpCounter[serviceMetricInfo]
+	connectionCounters []otlpCounter[connectionMetricInfo]
+}
+
+func newOtlpExporter(ctx context.Context, config *options.OtlpConfig) (*otlpExporter, error) {
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "kmesh"),
+		attribute.String("k8s.node.name", os.Getenv("NODE_NAME")),
+	)
+	e := &otlpExporter{}
+
+	if config.ExportMetrics {
+		metricOpts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(config.Endpoint),
+			otlpmetricgrpc.WithTimeout(config.Timeout),
+		}
+		if config.Insecure {
+			metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
+		}
+		// the connection metrics of the closed connections are no longer recorded,
+		// delta temporality lets the sdk forget them after the next export
+		delta := config.Temporality == options.OtlpTemporalityDelta
+		if delta {
+			metricOpts = append(metricOpts, otlpmetricgrpc.WithTemporalitySelector(func(sdkmetric.InstrumentKind) metricdata.Temporality {
+				return metricdata.DeltaTemporality
+			}))
+		}
+		metricExporter, err := otlpmetricgrpc.New(ctx, metricOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create otlp metric exporter failed: %v", err)
+		}
+		e.meterProvider = sdkmetric.NewMeterProvider(
+			sdkmetric.WithResource(res),
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(config.ExportInterval))),
+		)
+
+		meter := e.meterProvider.Meter(otlpScopeName)
+		if e.workloadCounters, err = newOtlpCounters(meter, otlpWorkloadCounters); err == nil {
+			e.serviceCounters, err = newOtlpCounters(meter, otlpServiceCounters)
+		}
+		// with cumulative temporality the sdk keeps every series exported, which never stops growing
+		// for the connection metrics as each connection has its own series
+		if err == nil && delta {
+			e.connectionCounters, err = newOtlpCounters(meter, otlpConnectionCounters)
+		} else if err == nil {
+			log.Warnf("the connection metrics are not exported to otlp with %s temporality", config.Temporality)
+		}
+		if err != nil {
+			_ = e.shutdown(ctx)
+			return nil, err
+		}
+	}
+
+	if config.ExportAccesslog {
+		logOpts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(config.Endpoint),
+			otlploggrpc.WithTimeout(config.Timeout),
+		}
+		if config.Insecure {
+			logOpts = append(logOpts, otlploggrpc.WithInsecure())
+		}
+		logExporter, err := otlploggrpc.New(ctx, logOpts...)
+		if err != nil {
+			_ = e.shutdown(ctx)
+			return nil, fmt.Errorf("create otlp log exporter failed: %v", err)
+		}
+		e.loggerProvider = sdklog.NewLoggerProvider(
+			sdklog.WithResource(res),
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
+		)
+		e.logger = e.loggerProvider.Logger(otlpScopeName)
+	}
+
+	return e, nil
+}
+
+// recordWorkload records the metrics of a workload series, with the labels limited by workloadSeries.
+func (e *otlpExporter) recordWorkload(ctx context.Context, labels prometheus.Labels, v *workloadMetricInfo) {
+	if e == nil {
+		return
+	}
+	recordOtlpCounters(ctx, e.workloadCounters, labels, v)
+}
+
+// recordService records the metrics of a service series, with the labels limited by serviceSeries.
+func (e *otlpExporter) recordService(ctx context.Context, labels prometheus.Labels, v *serviceMetricInfo) {
+	if e == nil {
+		return
+	}
+	recordOtlpCounters(ctx, e.serviceCounters, labels, v)
+}
+
+// recordConnection records the metrics of a connection series, with the labels limited by connectionSeries.
+func (e *otlpExporter) recordConnection(ctx context.Context, labels prometheus.Labels, v *connectionMetricInfo) {
+	if e == nil {
+		return
+	}
+	recordOtlpCounters(ctx, e.connectionCounters, labels, v)
+}
+
+func (e *otlpExporter) emitAccesslog(ctx context.Context, data requestMetric, connMetrics connMetric, accesslog logInfo) {
+	if e == nil || e.logger == nil {
+		return
+	}
+
+	var record otellog.Record
+	record.SetTimestamp(calculateUptime(osStartTime, data.lastReportTime))
+	record.SetSeverity(otellog.SeverityInfo)
+	record.SetSeverityText("INFO")
+	record.SetBody(otellog.StringValue(buildAccesslog(data, connMetrics, accesslog)))
+	record.AddAttributes(
+		otellog.String("src.addr", accesslog.sourceAddress),
+		otellog.String("src.workload", accesslog.sourceWorkload),
+		otellog.String("src.namespace", accesslog.sourceNamespace),
+		otellog.String("dst.addr", accesslog.destinationAddress),
+		otellog.String("dst.service", accesslog.destinationService),
+		otellog.String("dst.workload", accesslog.destinationWorkload),
+		otellog.String("dst.namespace", accesslog.destinationNamespace),
+		otellog.String("direction", accesslog.direction),
+		otellog.String("state", accesslog.state),
+		otellog.Int64("start_time", calculateUptime(osStartTime, data.startTime).UnixNano()),
+		otellog.Int64("sent_bytes", int64(connMetrics.sentBytes)),
+		otellog.Int64("received_bytes", int64(connMetrics.receivedBytes)),
+		otellog.Int64("packet_loss", int64(connMetrics.packetLost)),
+		otellog.Int64("retransmissions", int64(connMetrics.totalRetrans)),
+		otellog.Int64("srtt_us", int64(srttMicros(data.srtt))),
+		otellog.Int64("min_rtt_us", int64(data.minRtt)),
+		otellog.Float64("duration_ms", float64(data.duration)/1000000.0),
+	)
+	e.logger.Emit(ctx, record)
+}
+
+// shutdown flushes the pending metrics and logs and closes the connections to the collector.
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	var errs []error
+	if e.meterProvider != nil {
+		errs = append(errs, e.meterProvider.Shutdown(ctx))
+	}
+	if e.loggerProvider != nil {
+		errs = append(errs, e.loggerProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// StartOtlpExport exports the metrics and the access logs of the controller to the OTLP collector
+// configured, until ctx is done.
+func (m *MetricController) StartOtlpExport(ctx context.Context, config *options.OtlpConfig) error {
+	if m == nil || !config.Enabled() {
+		return nil
+	}
+	if !m.EnableMonitoring.Load() {
+		log.Warnf("otlp export requires monitoring to be enabled")
+	}
+
+	exporter, err := newOtlpExporter(ctx, config)
+	if err != nil {
+		return err
+	}
+	m.otlp.Store(exporter)
+	go func() {
+		<-ctx.Done()
+		m.otlp.Store(nil)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		defer cancel()
+		if err := exporter.shutdown(shutdownCtx); err != nil {
+			log.Errorf("shutdown otlp exporter failed: %v", err)
+		}
+	}()
+	log.Infof("otlp export to %s is started", config.Endpoint)
+	return nil
+}
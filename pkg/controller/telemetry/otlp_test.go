@@ -0,0 +1,267 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+
+	"kmesh.net/kmesh/daemon/options"
+)
+
+// fakeCollector is an in-process OTLP/gRPC collector recording the metrics and logs it receives.
+type fakeCollector struct {
+	mutex sync.Mutex
+	// metrics records the last value of each data point, keyed by metric name and source_workload
+	metrics map[string]map[string]float64
+	logs    []map[string]*commonv1.AnyValue
+}
+
+type fakeMetricsService struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	c *fakeCollector
+}
+
+type fakeLogsService struct {
+	collectorlogs.UnimplementedLogsServiceServer
+	c *fakeCollector
+}
+
+func startFakeCollector(t *testing.T) (*fakeCollector, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := &fakeCollector{metrics: map[string]map[string]float64{}}
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, &fakeMetricsService{c: c})
+	collectorlogs.RegisterLogsServiceServer(server, &fakeLogsService{c: c})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return c, lis.Addr().String()
+}
+
+func (s *fakeMetricsService) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	s.c.mutex.Lock()
+	defer s.c.mutex.Unlock()
+	for _, rm := range req.GetResourceMetrics() {
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				if s.c.metrics[metric.GetName()] == nil {
+					s.c.metrics[metric.GetName()] = map[string]float64{}
+				}
+				for _, dp := range metric.GetSum().GetDataPoints() {
+					key := ""
+					for _, attr := range dp.GetAttributes() {
+						if attr.GetKey() == "source_workload" {
+							key = attr.GetValue().GetStringValue()
+						}
+					}
+					s.c.metrics[metric.GetName()][key] = dp.GetAsDouble()
+				}
+			}
+		}
+	}
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (s *fakeLogsService) Export(_ context.Context, req *collectorlogs.ExportLogsServiceRequest) (*collectorlogs.ExportLogsServiceResponse, error) {
+	s.c.mutex.Lock()
+	defer s.c.mutex.Unlock()
+	for _, rl := range req.GetResourceLogs() {
+		for _, sl := range rl.GetScopeLogs() {
+			for _, record := range sl.GetLogRecords() {
+				fields := map[string]*commonv1.AnyValue{"body": record.GetBody()}
+				for _, attr := range record.GetAttributes() {
+					fields[attr.GetKey()] = attr.GetValue()
+				}
+				s.c.logs = append(s.c.logs, fields)
+			}
+		}
+	}
+	return &collectorlogs.ExportLogsServiceResponse{}, nil
+}
+
+func (c *fakeCollector) metric(name, sourceWorkload string) (float64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.metrics[name][sourceWorkload]
+	return v, ok
+}
+
+func (c *fakeCollector) accesslogs() []map[string]*commonv1.AnyValue {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]map[string]*commonv1.AnyValue{}, c.logs...)
+}
+
+func newTestOtlpConfig(endpoint string) *options.OtlpConfig {
+	return &options.OtlpConfig{
+		Endpoint:        endpoint,
+		Insecure:        true,
+		ExportInterval:  100 * time.Millisecond,
+		Timeout:         5 * time.Second,
+		Temporality:     options.OtlpTemporalityDelta,
+		ExportMetrics:   true,
+		ExportAccesslog: true,
+	}
+}
+
+func TestOtlpExportMetrics(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := newTestOtlpConfig(endpoint)
+	config.Temporality = options.OtlpTemporalityCumulative
+	m := NewMetric(nil, nil, true)
+	require.NoError(t, m.StartOtlpExport(ctx, config))
+
+	workloadLabels := workloadMetricLabels{sourceWorkload: "sleep", destinationWorkload: "httpbin"}
+	serviceLabels := serviceMetricLabels{sourceWorkload: "sleep", destinationService: "httpbin.default.svc.cluster.local"}
+	connectionLabels := connectionMetricLabels{sourceWorkload: "sleep", destinationWorkload: "httpbin"}
+	flush := func(opened, sentBytes float64) {
+		m.mutex.Lock()
+		m.workloadMetricCache[workloadLabels] = &workloadMetricInfo{WorkloadConnOpened: opened, WorkloadConnSentBytes: sentBytes}
+		m.serviceMetricCache[serviceLabels] = &serviceMetricInfo{ServiceConnOpened: opened}
+		m.connectionMetricCache[connectionLabels] = &connectionMetricInfo{ConnSentBytes: sentBytes}
+		m.mutex.Unlock()
+		m.updatePrometheusMetric()
+	}
+
+	// the counters accumulate the deltas of each flush
+	flush(1, 100)
+	flush(2, 50)
+	assert.Eventually(t, func() bool {
+		opened, _ := collector.metric("kmesh_tcp_workload_connections_opened_total", "sleep")
+		sent, _ := collector.metric("kmesh_tcp_workload_sent_bytes_total", "sleep")
+		serviceOpened, _ := collector.metric("kmesh_tcp_connections_opened_total", "sleep")
+		return opened == 3 && sent == 150 && serviceOpened == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	// the connection metrics are not exported with cumulative temporality
+	assert.Nil(t, m.otlp.Load().connectionCounters)
+}
+
+func TestOtlpExportDeltaTemporality(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := newTestOtlpConfig(endpoint)
+	config.ExportAccesslog = false
+	m := NewMetric(nil, nil, true)
+	require.NoError(t, m.StartOtlpExport(ctx, config))
+
+	m.mutex.Lock()
+	m.connectionMetricCache[connectionMetricLabels{sourceWorkload: "sleep"}] = &connectionMetricInfo{ConnSentBytes: 100}
+	m.mutex.Unlock()
+	m.updatePrometheusMetric()
+
+	assert.Eventually(t, func() bool {
+		sent, _ := collector.metric("kmesh_tcp_connection_sent_bytes_total", "sleep")
+		return sent == 100
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Nil(t, m.otlp.Load().logger)
+}
+
+func TestOtlpExportMaxSeries(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	noneAlive := func(prometheus.Labels) bool { return false }
+	connectionSeries.expire(noneAlive)
+	require.NoError(t, connectionSeries.configure(nil, 1))
+	defer func() {
+		_ = connectionSeries.configure(nil, 0)
+		connectionSeries.expire(noneAlive)
+	}()
+
+	config := newTestOtlpConfig(endpoint)
+	config.ExportAccesslog = false
+	m := NewMetric(nil, nil, true)
+	require.NoError(t, m.StartOtlpExport(ctx, config))
+
+	m.mutex.Lock()
+	m.connectionMetricCache[connectionMetricLabels{sourceWorkload: "sleep"}] = &connectionMetricInfo{ConnSentBytes: 100}
+	m.connectionMetricCache[connectionMetricLabels{sourceWorkload: "curl"}] = &connectionMetricInfo{ConnSentBytes: 200}
+	m.mutex.Unlock()
+	m.updatePrometheusMetric()
+
+	// the series over the limit is exported as the overflow series, like the prometheus one
+	assert.Eventually(t, func() bool {
+		overflow, ok := collector.metric("kmesh_tcp_connection_sent_bytes_total", "")
+		sleep, _ := collector.metric("kmesh_tcp_connection_sent_bytes_total", "sleep")
+		curl, _ := collector.metric("kmesh_tcp_connection_sent_bytes_total", "curl")
+		return ok && overflow+sleep+curl == 300 && (sleep == 0) != (curl == 0)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestOtlpExportAccesslog(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := newTestOtlpConfig(endpoint)
+	config.ExportMetrics = false
+	m := NewMetric(nil, nil, true)
+	require.NoError(t, m.StartOtlpExport(ctx, config))
+
+	accesslog := *NewLogInfo()
+	accesslog.sourceWorkload = "sleep"
+	accesslog.destinationWorkload = "httpbin"
+	accesslog.direction = "OUTBOUND"
+	accesslog.state = "BPF_TCP_CLOSE"
+
+	exporter := m.otlp.Load()
+	require.NotNil(t, exporter)
+	exporter.emitAccesslog(ctx, requestMetric{state: TCP_CLOSED, srtt: 80}, connMetric{sentBytes: 20, receivedBytes: 30, totalReports: 2}, accesslog)
+
+	// logs are flushed when the export is stopped
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(collector.accesslogs()) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	logs := collector.accesslogs()
+	require.Len(t, logs, 1)
+	assert.Contains(t, logs[0]["body"].GetStringValue(), "src.workload=sleep")
+	assert.Equal(t, "httpbin", logs[0]["dst.workload"].GetStringValue())
+	assert.Equal(t, "OUTBOUND", logs[0]["direction"].GetStringValue())
+	assert.Equal(t, int64(20), logs[0]["sent_bytes"].GetIntValue())
+	assert.Equal(t, int64(30), logs[0]["received_bytes"].GetIntValue())
+	assert.Equal(t, int64(10), logs[0]["srtt_us"].GetIntValue())
+	assert.Nil(t, m.otlp.Load())
+}
+
+func TestStartOtlpExportDisabled(t *testing.T) {
+	m := NewMetric(nil, nil, true)
+	assert.NoError(t, m.StartOtlpExport(context.Background(), &options.OtlpConfig{}))
+	assert.Nil(t, m.otlp.Load())
+	// recording without an exporter is a no-op
+	m.updatePrometheusMetric()
+}
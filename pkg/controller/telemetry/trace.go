@@ -0,0 +1,140 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types of a connection event.
+const (
+	ConnectionEventOpen  = "open"
+	ConnectionEventClose = "close"
+	ConnectionEventDeny  = "deny"
+)
+
+// ConnectionEvent is the structured event of a connection opened, closed or denied by authorization.
+type ConnectionEvent struct {
+	Time                 time.Time `json:"time"`
+	Type                 string    `json:"type"`
+	Direction            string    `json:"direction,omitempty"`
+	SourceIp             string    `json:"src_ip"`
+	SourcePort           uint32    `json:"src_port,omitempty"`
+	SourcePod            string    `json:"src_pod,omitempty"`
+	SourceNamespace      string    `json:"src_namespace,omitempty"`
+	DestinationIp        string    `json:"dst_ip"`
+	DestinationPort      uint32    `json:"dst_port"`
+	DestinationPod       string    `json:"dst_pod,omitempty"`
+	DestinationNamespace string    `json:"dst_namespace,omitempty"`
+	DestinationService   string    `json:"dst_service,omitempty"`
+	// Success, SentBytes, ReceivedBytes and DurationMs are only set for the close events
+	Success       bool   `json:"success,omitempty"`
+	SentBytes     uint32 `json:"sent_bytes,omitempty"`
+	ReceivedBytes uint32 `json:"received_bytes,omitempty"`
+	DurationMs    uint64 `json:"duration_ms,omitempty"`
+	// Policy, Reason and DryRun are only set for the deny events
+	Policy string `json:"policy,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// ConnectionEventFilter selects the events of which either end matches all the non-empty fields.
+type ConnectionEventFilter struct {
+	Pod       string
+	Namespace string
+	Ip        string
+	Port      uint32
+}
+
+func (f *ConnectionEventFilter) Match(event *ConnectionEvent) bool {
+	if f == nil {
+		return true
+	}
+	if f.Pod != "" && event.SourcePod != f.Pod && event.DestinationPod != f.Pod {
+		return false
+	}
+	if f.Namespace != "" && event.SourceNamespace != f.Namespace && event.DestinationNamespace != f.Namespace {
+		return false
+	}
+	if f.Ip != "" && event.SourceIp != f.Ip && event.DestinationIp != f.Ip {
+		return false
+	}
+	if f.Port != 0 && event.SourcePort != f.Port && event.DestinationPort != f.Port {
+		return false
+	}
+	return true
+}
+
+// SubscribeConnectionEvents returns the stream of the connection open and close events from now on,
+// and the func to cancel the subscription. The events are only built while there is any subscriber.
+func (m *MetricController) SubscribeConnectionEvents(bufferSize int) (<-chan ConnectionEvent, func()) {
+	return m.events.Subscribe(bufferSize)
+}
+
+// publishConnectionEvent publishes the event of a connection established or closed, the intermediate
+// reports of a connection are not published.
+func (m *MetricController) publishConnectionEvent(data requestMetric, connMetrics connMetric, accesslog logInfo) {
+	if !m.events.Enabled() {
+		return
+	}
+
+	var event ConnectionEvent
+	switch {
+	case data.state == TCP_CLOSED:
+		event.Type = ConnectionEventClose
+		event.Success = data.success == connection_success
+		event.SentBytes = connMetrics.sentBytes
+		event.ReceivedBytes = connMetrics.receivedBytes
+		event.DurationMs = data.duration / uint64(time.Millisecond)
+	case data.state == TCP_ESTABLISHED && connMetrics.totalReports == 1:
+		event.Type = ConnectionEventOpen
+	default:
+		return
+	}
+
+	event.Time = time.Now()
+	event.Direction = knownOrEmpty(accesslog.direction)
+	event.SourceIp = addressIp(accesslog.sourceAddress)
+	event.SourcePort = uint32(data.conSrcDstInfo.srcPort)
+	event.SourcePod = knownOrEmpty(accesslog.sourceWorkload)
+	event.SourceNamespace = knownOrEmpty(accesslog.sourceNamespace)
+	event.DestinationIp = addressIp(accesslog.destinationAddress)
+	event.DestinationPort = uint32(data.conSrcDstInfo.dstPort)
+	event.DestinationPod = knownOrEmpty(accesslog.destinationWorkload)
+	event.DestinationNamespace = knownOrEmpty(accesslog.destinationNamespace)
+	event.DestinationService = knownOrEmpty(accesslog.destinationService)
+	m.events.Publish(event)
+}
+
+// addressIp returns the ip of an ip:port address, the ip is not bracketed even if it is an IPv6 one.
+func addressIp(address string) string {
+	if i := strings.LastIndex(address, ":"); i >= 0 {
+		if _, err := strconv.ParseUint(address[i+1:], 10, 16); err == nil {
+			return address[:i]
+		}
+	}
+	return knownOrEmpty(address)
+}
+
+func knownOrEmpty(value string) string {
+	if value == DEFAULT_UNKNOWN {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/pkg/constants"
+)
+
+func TestPublishConnectionEvent(t *testing.T) {
+	m := &MetricController{}
+	accesslog := logInfo{
+		direction:            "OUTBOUND",
+		sourceAddress:        "10.244.0.10:47667",
+		sourceWorkload:       "sleep-7d5f9b9c4-x2x5z",
+		sourceNamespace:      "default",
+		destinationAddress:   "10.244.0.7:8080",
+		destinationService:   "httpbin.default.svc.cluster.local",
+		destinationWorkload:  "httpbin-5c5f9d7b8-9bq2m",
+		destinationNamespace: "default",
+	}
+	established := requestMetric{
+		conSrcDstInfo: connectionSrcDst{direction: constants.OUTBOUND, srcPort: 47667, dstPort: 8080},
+		state:         TCP_ESTABLISHED,
+		success:       connection_success,
+	}
+
+	// nothing is built without subscribers
+	m.publishConnectionEvent(established, connMetric{totalReports: 1}, accesslog)
+
+	events, cancel := m.SubscribeConnectionEvents(10)
+	defer cancel()
+
+	m.publishConnectionEvent(established, connMetric{totalReports: 1}, accesslog)
+	// the intermediate reports are not published
+	m.publishConnectionEvent(established, connMetric{totalReports: 2}, accesslog)
+	closed := established
+	closed.state = TCP_CLOSED
+	closed.duration = uint64(1500 * time.Millisecond)
+	m.publishConnectionEvent(closed, connMetric{totalReports: 3, sentBytes: 100, receivedBytes: 200}, accesslog)
+
+	open := <-events
+	assert.Equal(t, ConnectionEventOpen, open.Type)
+	assert.Equal(t, "OUTBOUND", open.Direction)
+	assert.Equal(t, "10.244.0.10", open.SourceIp)
+	assert.Equal(t, uint32(47667), open.SourcePort)
+	assert.Equal(t, "sleep-7d5f9b9c4-x2x5z", open.SourcePod)
+	assert.Equal(t, "10.244.0.7", open.DestinationIp)
+	assert.Equal(t, uint32(8080), open.DestinationPort)
+	assert.Equal(t, "httpbin.default.svc.cluster.local", open.DestinationService)
+	assert.False(t, open.Success)
+
+	closeEvent := <-events
+	assert.Equal(t, ConnectionEventClose, closeEvent.Type)
+	assert.True(t, closeEvent.Success)
+	assert.Equal(t, uint32(100), closeEvent.SentBytes)
+	assert.Equal(t, uint32(200), closeEvent.ReceivedBytes)
+	assert.Equal(t, uint64(1500), closeEvent.DurationMs)
+	assert.Empty(t, events)
+
+	// no event is sent after the subscription is canceled
+	cancel()
+	m.publishConnectionEvent(established, connMetric{totalReports: 1}, accesslog)
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestConnectionEventFilter(t *testing.T) {
+	event := &ConnectionEvent{
+		SourceIp:             "10.244.0.10",
+		SourcePort:           47667,
+		SourcePod:            "sleep",
+		SourceNamespace:      "default",
+		DestinationIp:        "10.244.0.7",
+		DestinationPort:      8080,
+		DestinationPod:       "httpbin",
+		DestinationNamespace: "foo",
+	}
+
+	tests := []struct {
+		name   string
+		filter *ConnectionEventFilter
+		want   bool
+	}{
+		{name: "nil filter", filter: nil, want: true},
+		{name: "empty filter", filter: &ConnectionEventFilter{}, want: true},
+		{name: "source pod", filter: &ConnectionEventFilter{Pod: "sleep", Namespace: "default"}, want: true},
+		{name: "destination pod", filter: &ConnectionEventFilter{Pod: "httpbin", Namespace: "foo"}, want: true},
+		{name: "other pod", filter: &ConnectionEventFilter{Pod: "details"}, want: false},
+		{name: "other namespace", filter: &ConnectionEventFilter{Pod: "sleep", Namespace: "bar"}, want: false},
+		{name: "ip and port", filter: &ConnectionEventFilter{Ip: "10.244.0.7", Port: 8080}, want: true},
+		{name: "other ip", filter: &ConnectionEventFilter{Ip: "10.244.0.8"}, want: false},
+		{name: "other port", filter: &ConnectionEventFilter{Port: 80}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(event))
+		})
+	}
+}
+
+func TestAddressIp(t *testing.T) {
+	assert.Equal(t, "10.244.0.7", addressIp("10.244.0.7:8080"))
+	assert.Equal(t, "fd00::7", addressIp("fd00::7:8080"))
+	assert.Equal(t, "", addressIp(DEFAULT_UNKNOWN))
+}
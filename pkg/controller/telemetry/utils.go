@@ -19,8 +19,10 @@ package telemetry
 import (
 	"context"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -169,9 +171,66 @@ var (
 		"node_name",
 		"map_name",
 	}
+	mapUpdateFailureLabels = []string{
+		"node_name",
+		"map_name",
+		"reason",
+	}
 	totalMapLabels = []string{
 		"node_name",
 	}
+	xdsResponseLabels = []string{
+		"node_name",
+		"type_url",
+	}
+
+	servicePortsDroppedLabels = []string{
+		"node_name",
+		"service",
+	}
+
+	outlierEjectionLabels = []string{
+		"node_name",
+		"destination_workload",
+		"destination_workload_namespace",
+	}
+
+	metricSeriesLabels = []string{
+		"node_name",
+		"family",
+	}
+
+	outlierEjectedLabels = []string{
+		"node_name",
+	}
+
+	authzDecisionCacheLabels = []string{
+		"node_name",
+		"result",
+	}
+
+	authzDryRunDenyLabels = []string{
+		"node_name",
+		"policy",
+	}
+
+	authzRingbufLabels = []string{
+		"node_name",
+	}
+
+	authzRequestDroppedLabels = []string{
+		"node_name",
+		"reason",
+	}
+
+	authzDecisionLabels = []string{
+		"node_name",
+		"destination_workload",
+		"destination_workload_namespace",
+		"policy",
+		"decision",
+		"reason",
+	}
 )
 
 var (
@@ -292,14 +351,160 @@ var (
 			Help: "The total entry used by an eBPF map.",
 		}, kmeshMapLabels,
 	)
+	mapMaxEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_map_max_entries",
+			Help: "The max number of entries of an eBPF map.",
+		}, kmeshMapLabels,
+	)
+	mapUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_map_utilization_ratio",
+			Help: "The ratio of the entries used by an eBPF map to its max entries.",
+		}, kmeshMapLabels,
+	)
+	mapUpdateFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_map_update_failures_total",
+			Help: "The total number of eBPF map updates failed as the map is full.",
+		}, mapUpdateFailureLabels,
+	)
 	mapCountInNode = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "kmesh_map_count_total",
 			Help: "Count of map created by kmesh-daemon.",
 		}, totalMapLabels,
 	)
+	xdsResponseApplyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kmesh_xds_response_apply_duration_seconds",
+			Help:    "Duration of applying a xds response to kmesh caches and bpf maps in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, xdsResponseLabels,
+	)
+	xdsRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_xds_rejections_total",
+			Help: "The total number of xds responses rejected by kmesh.",
+		}, xdsResponseLabels,
+	)
+	authzDecisionCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_decision_cache_lookups_total",
+			Help: "The total number of lookups of the authorization decision cache, by hit or miss.",
+		}, authzDecisionCacheLabels,
+	)
+	authzDryRunDenies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_dry_run_denies_total",
+			Help: "The total number of connections which would have been denied by a dry-run authorization policy.",
+		}, authzDryRunDenyLabels,
+	)
+	authzRingbufLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_authz_ringbuf_lag_bytes",
+			Help: "The number of bytes of the authorization requests left in the km_auth_req ring buffer after the last read.",
+		}, authzRingbufLabels,
+	)
+	authzQueueFull = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_queue_full_total",
+			Help: "The total number of times reading km_auth_req is blocked as all the authorization workers are busy.",
+		}, authzRingbufLabels,
+	)
+	authzRequestsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_requests_dropped_total",
+			Help: "The total number of records of km_auth_req dropped by the userspace reader without evaluation, by reason. " +
+				"The records the bpf prog fails to reserve in the full ring buffer are not counted.",
+		}, authzRequestDroppedLabels,
+	)
+	authzEvaluationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kmesh_authz_evaluation_duration_seconds",
+			Help:    "Duration of evaluating an authorization request in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 12),
+		}, authzRingbufLabels,
+	)
+	authzDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_authz_decisions_total",
+			Help: "The total number of authorization decisions made in userspace, by decision and reason.",
+		}, authzDecisionLabels,
+	)
+	servicePortsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_service_ports_dropped_total",
+			Help: "The total number of service ports which can not be stored into bpf maps.",
+		}, servicePortsDroppedLabels,
+	)
+	outlierEjections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_outlier_ejections_total",
+			Help: "The total number of times a workload is ejected by outlier detection.",
+		}, outlierEjectionLabels,
+	)
+	outlierEjectedEndpoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_outlier_ejected_endpoints",
+			Help: "The number of workloads currently ejected by outlier detection.",
+		}, outlierEjectedLabels,
+	)
+	metricSeriesOverflow = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kmesh_metric_series_overflow_total",
+			Help: "The total number of samples aggregated into the overflow series as the max number of series of a metric family is reached.",
+		}, metricSeriesLabels,
+	)
+	metricSeriesCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kmesh_metric_series",
+			Help: "The number of live series of a metric family.",
+		}, metricSeriesLabels,
+	)
 )
 
+var (
+	// DefaultConnectionDurationBuckets are the default buckets of the connection duration histograms, in seconds
+	DefaultConnectionDurationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+	// DefaultSrttBuckets are the default buckets of the smoothed RTT histograms, in seconds
+	DefaultSrttBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+	tcpConnectionDurationInWorkload = newConnectionDurationHistogram("kmesh_tcp_workload_connection_duration_seconds", "workload", workloadLabels, DefaultConnectionDurationBuckets)
+	tcpSrttInWorkload               = newSrttHistogram("kmesh_tcp_workload_srtt_seconds", "workload", workloadLabels, DefaultSrttBuckets)
+	tcpConnectionDurationInService  = newConnectionDurationHistogram("kmesh_tcp_connection_duration_seconds", "service", serviceLabels, DefaultConnectionDurationBuckets)
+	tcpSrttInService                = newSrttHistogram("kmesh_tcp_srtt_seconds", "service", serviceLabels, DefaultSrttBuckets)
+)
+
+func newConnectionDurationHistogram(name, destination string, labels []string, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    name,
+		Help:    "The duration of the TCP connections closed to a " + destination + " in seconds.",
+		Buckets: buckets,
+	}, labels)
+}
+
+func newSrttHistogram(name, destination string, labels []string, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    name,
+		Help:    "The smoothed RTT of the TCP connections to a " + destination + " in seconds, sampled at each report of a connection.",
+		Buckets: buckets,
+	}, labels)
+}
+
+// SetLatencyBuckets replaces the buckets of the connection duration and smoothed RTT histograms,
+// empty buckets keep the current ones. It must be called before the metric controller is run.
+func SetLatencyBuckets(durationBuckets, srttBuckets []float64) {
+	if len(durationBuckets) > 0 {
+		tcpConnectionDurationInWorkload = newConnectionDurationHistogram("kmesh_tcp_workload_connection_duration_seconds", "workload", workloadLabels, durationBuckets)
+		tcpConnectionDurationInService = newConnectionDurationHistogram("kmesh_tcp_connection_duration_seconds", "service", serviceLabels, durationBuckets)
+	}
+	if len(srttBuckets) > 0 {
+		tcpSrttInWorkload = newSrttHistogram("kmesh_tcp_workload_srtt_seconds", "workload", workloadLabels, srttBuckets)
+		tcpSrttInService = newSrttHistogram("kmesh_tcp_srtt_seconds", "service", serviceLabels, srttBuckets)
+	}
+}
+
 func RunPrometheusClient(ctx context.Context) {
 	registry := prometheus.NewRegistry()
 	for {
@@ -319,8 +524,18 @@ func runPrometheusClient(registry *prometheus.Registry) {
 	registry.MustRegister(tcpConnectionOpenedInWorkload, tcpConnectionClosedInWorkload, tcpReceivedBytesInWorkload, tcpSentBytesInWorkload, tcpConnectionTotalRetransInWorkload, tcpConnectionPacketLostInWorkload)
 	registry.MustRegister(tcpConnectionOpenedInService, tcpConnectionClosedInService, tcpReceivedBytesInService, tcpSentBytesInService)
 	registry.MustRegister(tcpConnectionTotalSendBytes, tcpConnectionTotalReceivedBytes, tcpConnectionTotalPacketLost, tcpConnectionTotalRetrans)
+	registry.MustRegister(tcpConnectionDurationInWorkload, tcpSrttInWorkload, tcpConnectionDurationInService, tcpSrttInService)
 	registry.MustRegister(bpfProgOpDuration, bpfProgOpCount)
 	registry.MustRegister(mapEntryCount, mapCountInNode)
+	registry.MustRegister(mapMaxEntries, mapUtilization, mapUpdateFailures)
+	registry.MustRegister(xdsResponseApplyDuration)
+	registry.MustRegister(xdsRejections)
+	registry.MustRegister(servicePortsDropped)
+	registry.MustRegister(authzDecisions, authzDecisionCacheLookups, authzDryRunDenies)
+	registry.MustRegister(authzRingbufLag, authzQueueFull, authzRequestsDropped, authzEvaluationDuration)
+	registry.MustRegister(outlierEjections)
+	registry.MustRegister(outlierEjectedEndpoints)
+	registry.MustRegister(metricSeriesOverflow, metricSeriesCount)
 
 	http.Handle("/status/metric", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
 		Registry: registry,
@@ -330,6 +545,108 @@ func runPrometheusClient(registry *prometheus.Registry) {
 	}
 }
 
+// ObserveXdsResponseApplyDuration records how long it took to apply a xds response of the given type
+func ObserveXdsResponseApplyDuration(typeUrl string, duration time.Duration) {
+	xdsResponseApplyDuration.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"type_url":  typeUrl,
+	}).Observe(duration.Seconds())
+}
+
+// IncXdsRejection records a xds response of the given type is rejected
+func IncXdsRejection(typeUrl string) {
+	xdsRejections.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"type_url":  typeUrl,
+	}).Inc()
+}
+
+// IncAuthzDecisionCacheLookup records a lookup of the authorization decision cache
+func IncAuthzDecisionCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	authzDecisionCacheLookups.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"result":    result,
+	}).Inc()
+}
+
+// SetAuthzRingbufLag records the number of bytes left in the km_auth_req ring buffer
+func SetAuthzRingbufLag(bytes int) {
+	authzRingbufLag.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+	}).Set(float64(bytes))
+}
+
+// IncAuthzQueueFull records the reading of km_auth_req is blocked by the busy workers
+func IncAuthzQueueFull() {
+	authzQueueFull.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+	}).Inc()
+}
+
+// IncAuthzRequestDropped records a record of km_auth_req dropped for the reason
+func IncAuthzRequestDropped(reason string) {
+	authzRequestsDropped.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"reason":    reason,
+	}).Inc()
+}
+
+// ObserveAuthzEvaluationDuration records how long it took to evaluate an authorization request
+func ObserveAuthzEvaluationDuration(duration time.Duration) {
+	authzEvaluationDuration.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+	}).Observe(duration.Seconds())
+}
+
+// IncAuthzDecision records an authorization decision made for a connection to the destination workload
+func IncAuthzDecision(workload, namespace, policy string, allow bool, reason string) {
+	decision := "deny"
+	if allow {
+		decision = "allow"
+	}
+	authzDecisions.With(prometheus.Labels{
+		"node_name":                      os.Getenv("NODE_NAME"),
+		"destination_workload":           workload,
+		"destination_workload_namespace": namespace,
+		"policy":                         policy,
+		"decision":                       decision,
+		"reason":                         reason,
+	}).Inc()
+}
+
+// IncAuthzDryRunDeny records a connection which would have been denied by the dry-run authorization policy
+func IncAuthzDryRunDeny(policy string) {
+	authzDryRunDenies.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"policy":    policy,
+	}).Inc()
+}
+
+func AddServicePortsDropped(service string, count int) {
+	servicePortsDropped.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+		"service":   service,
+	}).Add(float64(count))
+}
+
+func IncOutlierEjection(workload *workloadapi.Workload) {
+	outlierEjections.With(prometheus.Labels{
+		"node_name":                      os.Getenv("NODE_NAME"),
+		"destination_workload":           workload.GetWorkloadName(),
+		"destination_workload_namespace": workload.GetNamespace(),
+	}).Inc()
+}
+
+func SetOutlierEjectedEndpoints(count int) {
+	outlierEjectedEndpoints.With(prometheus.Labels{
+		"node_name": os.Getenv("NODE_NAME"),
+	}).Set(float64(count))
+}
+
 func DeleteWorkloadMetric(workload *workloadapi.Workload) {
 	if workload == nil {
 		return
@@ -341,21 +658,9 @@ func DeleteWorkloadMetric(workload *workloadapi.Workload) {
 
 func deleteWorkloadMetricInPrometheus(workload *workloadapi.Workload) {
 	// delete destination workload metric labels
-	_ = tcpConnectionClosedInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpConnectionFailedInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpConnectionOpenedInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpReceivedBytesInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpSentBytesInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpConnectionTotalRetransInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
-	_ = tcpConnectionPacketLostInWorkload.DeletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
+	workloadSeries.deletePartialMatch(prometheus.Labels{"destination_pod_name": workload.Name, "destination_pod_namespace": workload.Namespace})
 	// delete source workload metric labels
-	_ = tcpConnectionClosedInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpConnectionFailedInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpConnectionOpenedInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpReceivedBytesInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpSentBytesInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpConnectionTotalRetransInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
-	_ = tcpConnectionPacketLostInWorkload.DeletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
+	workloadSeries.deletePartialMatch(prometheus.Labels{"source_workload": workload.Name, "source_workload_namespace": workload.Namespace})
 }
 
 func DeleteServiceMetric(serviceName string) {
@@ -378,16 +683,9 @@ func deleteServiceMetricInPrometheus(serviceName string) {
 		svcHost = strings.Split(serviceName, "/")[1]
 	}
 
-	_ = tcpConnectionClosedInService.DeletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
-	_ = tcpConnectionFailedInService.DeletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
-	_ = tcpConnectionOpenedInService.DeletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
-	_ = tcpReceivedBytesInService.DeletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
-	_ = tcpSentBytesInService.DeletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
+	serviceSeries.deletePartialMatch(prometheus.Labels{"destination_service_name": svcHost, "destination_service_namespace": svcNamespace})
 }
 
 func deleteConnectionMetricInPrometheus(connLabels *connectionMetricLabels) {
-	_ = tcpConnectionTotalSendBytes.DeletePartialMatch(prometheus.Labels{"source_address": connLabels.sourceAddress, "destination_address": connLabels.destinationAddress})
-	_ = tcpConnectionTotalReceivedBytes.DeletePartialMatch(prometheus.Labels{"source_address": connLabels.sourceAddress, "destination_address": connLabels.destinationAddress})
-	_ = tcpConnectionTotalPacketLost.DeletePartialMatch(prometheus.Labels{"source_address": connLabels.sourceAddress, "destination_address": connLabels.destinationAddress})
-	_ = tcpConnectionTotalRetrans.DeletePartialMatch(prometheus.Labels{"source_address": connLabels.sourceAddress, "destination_address": connLabels.destinationAddress})
+	connectionSeries.deletePartialMatch(prometheus.Labels{"source_address": connLabels.sourceAddress, "destination_address": connLabels.destinationAddress})
 }
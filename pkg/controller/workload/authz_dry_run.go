@@ -0,0 +1,127 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+
+	security_v2 "kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/auth"
+	maps_v2 "kmesh.net/kmesh/pkg/cache/v2/maps"
+)
+
+var authorizationPolicyResource = schema.GroupVersionResource{
+	Group:    "security.istio.io",
+	Version:  "v1",
+	Resource: "authorizationpolicies",
+}
+
+// isDryRun returns whether the istio authorization policy is annotated as dry-run.
+func isDryRun(meta metav1.Object) bool {
+	return meta.GetAnnotations()[auth.DryRunAnnotation] == "true"
+}
+
+// setAuthorizationDryRun marks the policy as dry-run or enforced, dry-run policies are evaluated in userspace only,
+// so they are removed from the bpf map, and written back once enforced.
+func (c *Controller) setAuthorizationDryRun(policyKey string, dryRun bool) {
+	c.processorMutex.Lock()
+	defer c.processorMutex.Unlock()
+
+	if !c.Rbac.SetDryRun(policyKey, dryRun) {
+		return
+	}
+	log.Infof("authorization policy %s dry-run: %v", policyKey, dryRun)
+	// the policy is never listed for a workload while it is not in km_authz_policy
+	if dryRun {
+		c.Processor.refreshWorkloadPolicies(policyKey)
+	}
+	if policy := c.Rbac.GetPolicy(policyKey); policy != nil {
+		if err := c.Processor.syncAuthorization(policyKey, policy, dryRun); err != nil {
+			log.Errorf("sync authorization policy %s failed: %v", policyKey, err)
+		}
+	}
+	if !dryRun {
+		c.Processor.refreshWorkloadPolicies(policyKey)
+	}
+}
+
+// WatchAuthorizationPolicyDryRun watches the istio authorization policies, and applies their dry-run annotation.
+func (c *Controller) WatchAuthorizationPolicyDryRun(client metadata.Interface, stopCh <-chan struct{}) {
+	informerFactory := metadatainformer.NewSharedInformerFactory(client, 0)
+	policyInformer := informerFactory.ForResource(authorizationPolicyResource).Informer()
+
+	onChange := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, ok := obj.(*metav1.PartialObjectMetadata)
+		if !ok {
+			log.Errorf("expected *metav1.PartialObjectMetadata but got %T", obj)
+			return
+		}
+		c.setAuthorizationDryRun(meta.GetNamespace()+"/"+meta.GetName(), !deleted && isDryRun(meta))
+	}
+	_, _ = policyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			onChange(obj, false)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			onChange(newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			onChange(obj, true)
+		},
+	})
+
+	informerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, policyInformer.HasSynced) {
+		log.Error("failed to sync authorization policies")
+		return
+	}
+	log.Info("start watching dry-run authorization policies")
+	<-stopCh
+}
+
+// refreshWorkloadPolicies rewrites the policies of the local workloads the policy applies to,
+// after the policy is marked or unmarked as dry-run.
+func (p *Processor) refreshWorkloadPolicies(policyKey string) {
+	for _, workload := range p.WorkloadCache.List() {
+		if workload.GetNode() != p.nodeName || !slices.Contains(workload.GetAuthorizationPolicies(), policyKey) {
+			continue
+		}
+		p.storeWorkloadPolicies(workload.GetUid(), workload.GetAuthorizationPolicies())
+	}
+}
+
+// syncAuthorization writes the enforced policy into the bpf map, or removes the dry-run one from it.
+func (p *Processor) syncAuthorization(policyKey string, policy *security_v2.Authorization, dryRun bool) error {
+	hash := p.hashName.Hash(policyKey)
+	if !dryRun {
+		return maps_v2.AuthorizationUpdate(hash, policy)
+	}
+	if err := maps_v2.AuthorizationLookup(hash, &security_v2.Authorization{}); err != nil {
+		// not written yet
+		return nil
+	}
+	return maps_v2.AuthorizationDelete(hash)
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metadatafake "k8s.io/client-go/metadata/fake"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/auth"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+func newAuthorizationPolicyMeta(name string, annotations map[string]string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "security.istio.io/v1", Kind: "AuthorizationPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "ns1",
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestWatchAuthorizationPolicyDryRun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		newAuthorizationPolicyMeta("dry-run", map[string]string{auth.DryRunAnnotation: "true"}),
+		newAuthorizationPolicyMeta("enforced", map[string]string{auth.DryRunAnnotation: "false"}),
+	)
+
+	p := NewProcessor(bpfcache.NewFakeWorkloadMap(t))
+	c := &Controller{Processor: p, Rbac: auth.NewRbac(p.WorkloadCache)}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.WatchAuthorizationPolicyDryRun(client, stopCh)
+
+	assert.Eventually(t, func() bool {
+		return c.Rbac.IsDryRun("ns1/dry-run")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, c.Rbac.IsDryRun("ns1/enforced"))
+
+	// a deleted policy is not dry-run any more
+	err := client.Resource(authorizationPolicyResource).Namespace("ns1").Delete(context.TODO(), "dry-run", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !c.Rbac.IsDryRun("ns1/dry-run")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDryRunWorkloadPolicies(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	p := NewProcessor(workloadMap)
+	c := &Controller{Processor: p, Rbac: auth.NewRbac(p.WorkloadCache)}
+	p.isDryRun = c.Rbac.IsDryRun
+
+	wl := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil)
+	wl.AuthorizationPolicies = []string{"ns1/dry-run", "ns1/deny", "ns1/allow"}
+	require.NoError(t, p.handleWorkload(wl))
+
+	policyIds := func() [4]uint32 {
+		var value bpfcache.WorkloadPolicyValue
+		key := bpfcache.WorkloadPolicyKey{WorklodId: p.hashName.Hash(wl.GetUid())}
+		if err := p.bpf.WorkloadPolicyLookup(&key, &value); err != nil {
+			return [4]uint32{}
+		}
+		return value.PolicyIds
+	}
+	dryRun, deny, allow := p.hashName.Hash("ns1/dry-run"), p.hashName.Hash("ns1/deny"), p.hashName.Hash("ns1/allow")
+	assert.Equal(t, [4]uint32{dryRun, deny, allow}, policyIds())
+
+	// the dry-run policy is left out, so the enforced ones after it are still walked by the xdp prog
+	c.setAuthorizationDryRun("ns1/dry-run", true)
+	assert.Equal(t, [4]uint32{deny, allow}, policyIds())
+
+	// the workload updated keeps the dry-run policy out
+	require.NoError(t, p.handleWorkload(wl))
+	assert.Equal(t, [4]uint32{deny, allow}, policyIds())
+
+	c.setAuthorizationDryRun("ns1/dry-run", false)
+	assert.Equal(t, [4]uint32{dryRun, deny, allow}, policyIds())
+
+	// the workload with only dry-run policies has no policy entry
+	wl.AuthorizationPolicies = []string{"ns1/dry-run"}
+	require.NoError(t, p.handleWorkload(wl))
+	c.setAuthorizationDryRun("ns1/dry-run", true)
+	assert.Equal(t, [4]uint32{}, policyIds())
+}
@@ -32,7 +32,7 @@ type WorkloadPolicyValue struct {
 
 func (c *Cache) WorkloadPolicyUpdate(key *WorkloadPolicyKey, value *WorkloadPolicyValue) error {
 	log.Debugf("workload policy update: [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmWlpolicy.Update(key, value, ebpf.UpdateAny)
+	return mapUpdate(c.bpfMap.KmWlpolicy, key, value)
 }
 
 func (c *Cache) WorkloadPolicyDelete(key *WorkloadPolicyKey) error {
@@ -42,11 +42,19 @@ type BackendValue struct {
 
 func (c *Cache) BackendUpdate(key *BackendKey, value *BackendValue) error {
 	log.Debugf("BackendUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmBackend.Update(key, value, ebpf.UpdateAny)
+	if c.batch != nil {
+		c.batch.backend.update(*key, *value)
+		return nil
+	}
+	return mapUpdate(c.bpfMap.KmBackend, key, value)
 }
 
 func (c *Cache) BackendDelete(key *BackendKey) error {
 	log.Debugf("BackendDelete [%#v]", *key)
+	if c.batch != nil {
+		c.batch.backend.delete(*key)
+		return nil
+	}
 	err := c.bpfMap.KmBackend.Delete(key)
 	if err != nil && errors.Is(err, ebpf.ErrKeyNotExist) {
 		return nil
@@ -56,6 +64,13 @@ func (c *Cache) BackendDelete(key *BackendKey) error {
 
 func (c *Cache) BackendLookup(key *BackendKey, value *BackendValue) error {
 	log.Debugf("BackendLookup [%#v]", *key)
+	if op, ok := c.batch.backendLookup(*key); ok {
+		if op.deleted {
+			return ebpf.ErrKeyNotExist
+		}
+		*value = op.value
+		return nil
+	}
 	return c.bpfMap.KmBackend.Lookup(key, value)
 }
 
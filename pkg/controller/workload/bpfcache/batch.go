@@ -0,0 +1,249 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cilium/ebpf"
+)
+
+// pendingOp is the last write recorded for a key while a batch is open.
+type pendingOp[V any] struct {
+	value   V
+	deleted bool
+}
+
+// pendingWrites buffers the writes of one bpf map, only the last write of each key is kept.
+type pendingWrites[K comparable, V any] struct {
+	order []K
+	ops   map[K]*pendingOp[V]
+}
+
+func newPendingWrites[K comparable, V any]() *pendingWrites[K, V] {
+	return &pendingWrites[K, V]{
+		ops: make(map[K]*pendingOp[V]),
+	}
+}
+
+func (w *pendingWrites[K, V]) update(key K, value V) {
+	if op, ok := w.ops[key]; ok {
+		op.value = value
+		op.deleted = false
+		return
+	}
+	w.order = append(w.order, key)
+	w.ops[key] = &pendingOp[V]{value: value}
+}
+
+func (w *pendingWrites[K, V]) delete(key K) {
+	if op, ok := w.ops[key]; ok {
+		var zero V
+		op.value = zero
+		op.deleted = true
+		return
+	}
+	w.order = append(w.order, key)
+	w.ops[key] = &pendingOp[V]{deleted: true}
+}
+
+// forget drops the buffered write of key, if any.
+func (w *pendingWrites[K, V]) forget(key K) {
+	if _, ok := w.ops[key]; !ok {
+		return
+	}
+	delete(w.ops, key)
+	w.order = slices.DeleteFunc(w.order, func(k K) bool { return k == key })
+}
+
+// lookup returns the buffered write of key, ok is false if the key has not been written in this batch.
+func (w *pendingWrites[K, V]) lookup(key K) (op *pendingOp[V], ok bool) {
+	op, ok = w.ops[key]
+	return op, ok
+}
+
+// split returns the buffered updates and deletes in the order the keys were first written.
+func (w *pendingWrites[K, V]) split() ([]K, []V, []K) {
+	var (
+		updateKeys   []K
+		updateValues []V
+		deleteKeys   []K
+	)
+
+	for _, key := range w.order {
+		op := w.ops[key]
+		if op.deleted {
+			deleteKeys = append(deleteKeys, key)
+		} else {
+			updateKeys = append(updateKeys, key)
+			updateValues = append(updateValues, op.value)
+		}
+	}
+	return updateKeys, updateValues, deleteKeys
+}
+
+// batchWrites holds the buffered writes of the maps used for service load balancing.
+type batchWrites struct {
+	backend  *pendingWrites[BackendKey, BackendValue]
+	endpoint *pendingWrites[EndpointKey, EndpointValue]
+	frontend *pendingWrites[FrontendKey, FrontendValue]
+	service  *pendingWrites[ServiceKey, ServiceValue]
+	// servicePort only buffers the deletes, the updates are written at once
+	servicePort *pendingWrites[ServicePortKey, ServicePortValue]
+}
+
+func newBatchWrites() *batchWrites {
+	return &batchWrites{
+		backend:     newPendingWrites[BackendKey, BackendValue](),
+		endpoint:    newPendingWrites[EndpointKey, EndpointValue](),
+		frontend:    newPendingWrites[FrontendKey, FrontendValue](),
+		service:     newPendingWrites[ServiceKey, ServiceValue](),
+		servicePort: newPendingWrites[ServicePortKey, ServicePortValue](),
+	}
+}
+
+func (b *batchWrites) backendLookup(key BackendKey) (*pendingOp[BackendValue], bool) {
+	if b == nil {
+		return nil, false
+	}
+	return b.backend.lookup(key)
+}
+
+func (b *batchWrites) endpointLookup(key EndpointKey) (*pendingOp[EndpointValue], bool) {
+	if b == nil {
+		return nil, false
+	}
+	return b.endpoint.lookup(key)
+}
+
+func (b *batchWrites) frontendLookup(key FrontendKey) (*pendingOp[FrontendValue], bool) {
+	if b == nil {
+		return nil, false
+	}
+	return b.frontend.lookup(key)
+}
+
+func (b *batchWrites) serviceLookup(key ServiceKey) (*pendingOp[ServiceValue], bool) {
+	if b == nil {
+		return nil, false
+	}
+	return b.service.lookup(key)
+}
+
+// BeginBatch starts buffering the writes to the backend, endpoint, frontend and service maps, and the deletes
+// from the service port map.
+// Lookups made through the Cache observe the buffered writes, the bpf prog only observes them
+// after FlushBatch is called.
+func (c *Cache) BeginBatch() {
+	if c != nil && c.batch == nil {
+		c.batch = newBatchWrites()
+	}
+}
+
+// FlushBatch writes all the buffered writes into the bpf maps and ends the batch.
+// In order to make sure the bpf prog never reaches a missing record, the maps are written in the following order:
+// 1. update backends and endpoints, which are referenced by services, the service ports are already updated
+// 2. update services, which are referenced by frontends
+// 3. update frontends
+// 4. delete frontends
+// 5. delete services, service ports, endpoints and backends
+func (c *Cache) FlushBatch() error {
+	if c == nil || c.batch == nil {
+		return nil
+	}
+	b := c.batch
+	c.batch = nil
+
+	backendKeys, backendValues, backendDeletes := b.backend.split()
+	endpointKeys, endpointValues, endpointDeletes := b.endpoint.split()
+	frontendKeys, frontendValues, frontendDeletes := b.frontend.split()
+	serviceKeys, serviceValues, serviceDeletes := b.service.split()
+	_, _, servicePortDeletes := b.servicePort.split()
+
+	log.Debugf("FlushBatch backend: %d/%d, endpoint: %d/%d, frontend: %d/%d, service: %d/%d, service port: 0/%d",
+		len(backendKeys), len(backendDeletes), len(endpointKeys), len(endpointDeletes),
+		len(frontendKeys), len(frontendDeletes), len(serviceKeys), len(serviceDeletes),
+		len(servicePortDeletes))
+
+	return errors.Join(
+		batchUpdate(c.bpfMap.KmBackend, backendKeys, backendValues),
+		batchUpdate(c.bpfMap.KmEndpoint, endpointKeys, endpointValues),
+		batchUpdate(c.bpfMap.KmService, serviceKeys, serviceValues),
+		batchUpdate(c.bpfMap.KmFrontend, frontendKeys, frontendValues),
+		batchDelete(c.bpfMap.KmFrontend, frontendDeletes),
+		batchDelete(c.bpfMap.KmService, serviceDeletes),
+		batchDelete(c.servicePortMap, servicePortDeletes),
+		batchDelete(c.bpfMap.KmEndpoint, endpointDeletes),
+		batchDelete(c.bpfMap.KmBackend, backendDeletes),
+	)
+}
+
+// batchUpdate updates the map with a single syscall, and falls back to updating
+// the keys one by one when the kernel does not support map batch ops.
+func batchUpdate[K any, V any](m *ebpf.Map, keys []K, values []V) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	n, err := m.BatchUpdate(keys, values, &ebpf.BatchOptions{ElemFlags: uint64(ebpf.UpdateAny)})
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		log.Warnf("batch update map %s failed after %d entries: %v, fall back to update one by one", m.String(), n, err)
+	}
+
+	var errs []error
+	for i := n; i < len(keys); i++ {
+		if err := mapUpdate(m, &keys[i], &values[i]); err != nil {
+			errs = append(errs, fmt.Errorf("update %s [%#v] failed: %w", m.String(), keys[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// batchDelete deletes the keys from the map with as few syscalls as possible,
+// keys not existing in the map are ignored.
+func batchDelete[K any](m *ebpf.Map, keys []K) error {
+	var errs []error
+	for len(keys) > 0 {
+		n, err := m.BatchDelete(keys, nil)
+		if err == nil {
+			return errors.Join(errs...)
+		}
+		if errors.Is(err, ebpf.ErrNotSupported) {
+			break
+		}
+		// batch delete stops at the first failed key, skip it and go on with the rest
+		if n >= len(keys) {
+			break
+		}
+		if !errors.Is(err, ebpf.ErrKeyNotExist) {
+			errs = append(errs, fmt.Errorf("delete %s [%#v] failed: %w", m.String(), keys[n], err))
+		}
+		keys = keys[n+1:]
+	}
+
+	for i := range keys {
+		if err := m.Delete(&keys[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			errs = append(errs, fmt.Errorf("delete %s [%#v] failed: %w", m.String(), keys[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchWrites(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+
+	c := NewCache(workloadMap)
+
+	// existing records before the batch
+	assert.NoError(t, c.BackendUpdate(&BackendKey{BackendUid: 1}, &BackendValue{ServiceCount: 1}))
+	assert.NoError(t, c.FrontendUpdate(&FrontendKey{Ip: [16]byte{1}}, &FrontendValue{UpstreamId: 1}))
+	assert.NoError(t, c.ServiceUpdate(&ServiceKey{ServiceId: 100}, &ServiceValue{EndpointCount: [PrioCount]uint32{1}}))
+
+	c.BeginBatch()
+	assert.NoError(t, c.BackendUpdate(&BackendKey{BackendUid: 2}, &BackendValue{ServiceCount: 2}))
+	assert.NoError(t, c.BackendDelete(&BackendKey{BackendUid: 1}))
+	assert.NoError(t, c.EndpointUpdate(&EndpointKey{ServiceId: 100, BackendIndex: 1}, &EndpointValue{BackendUid: 2}))
+	assert.NoError(t, c.ServiceUpdate(&ServiceKey{ServiceId: 100}, &ServiceValue{EndpointCount: [PrioCount]uint32{2}}))
+	assert.NoError(t, c.FrontendUpdate(&FrontendKey{Ip: [16]byte{2}}, &FrontendValue{UpstreamId: 1}))
+	assert.NoError(t, c.FrontendDelete(&FrontendKey{Ip: [16]byte{1}}))
+	// deleting a key which does not exist in the map should be ignored
+	assert.NoError(t, c.FrontendDelete(&FrontendKey{Ip: [16]byte{3}}))
+	// the last write of a key wins
+	assert.NoError(t, c.FrontendDelete(&FrontendKey{Ip: [16]byte{4}}))
+	assert.NoError(t, c.FrontendUpdate(&FrontendKey{Ip: [16]byte{4}}, &FrontendValue{UpstreamId: 4}))
+
+	// lookups observe the buffered writes
+	bv := BackendValue{}
+	assert.NoError(t, c.BackendLookup(&BackendKey{BackendUid: 2}, &bv))
+	assert.Equal(t, uint32(2), bv.ServiceCount)
+	assert.Error(t, c.BackendLookup(&BackendKey{BackendUid: 1}, &bv))
+	sv := ServiceValue{}
+	assert.NoError(t, c.ServiceLookup(&ServiceKey{ServiceId: 100}, &sv))
+	assert.Equal(t, uint32(2), sv.EndpointCount[0])
+	assert.ElementsMatch(t, []FrontendKey{{Ip: [16]byte{2}}}, c.FrontendIterFindKey(1))
+
+	// bpf maps are untouched until the batch is flushed
+	assert.Equal(t, 1, c.BackendCount())
+	assert.Equal(t, 0, c.EndpointCount())
+	assert.Equal(t, 1, c.FrontendCount())
+
+	assert.NoError(t, c.FlushBatch())
+
+	assert.Equal(t, []BackendValue{{ServiceCount: 2}}, c.BackendLookupAll())
+	assert.Equal(t, []EndpointValue{{BackendUid: 2}}, c.EndpointLookupAll())
+	assert.Equal(t, []ServiceValue{{EndpointCount: [PrioCount]uint32{2}}}, c.ServiceLookupAll())
+	assert.ElementsMatch(t, []FrontendValue{{UpstreamId: 1}, {UpstreamId: 4}}, c.FrontendLookupAll())
+
+	// writes after flush go to the bpf maps directly
+	assert.NoError(t, c.BackendDelete(&BackendKey{BackendUid: 2}))
+	assert.Equal(t, 0, c.BackendCount())
+}
+
+func TestBatchDeleteEndpoints(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+
+	c := NewCache(workloadMap)
+	for i := uint32(1); i <= 5; i++ {
+		assert.NoError(t, c.EndpointUpdate(&EndpointKey{ServiceId: 1, BackendIndex: i}, &EndpointValue{BackendUid: i}))
+	}
+
+	c.BeginBatch()
+	// delete the third endpoint: 1 2 5 4
+	assert.NoError(t, c.EndpointSwap(3, 3, 5, 1, 0))
+	assert.NoError(t, c.EndpointDelete(&EndpointKey{ServiceId: 1, BackendIndex: 5}))
+	// delete the first endpoint: 4 2 5
+	assert.NoError(t, c.EndpointSwap(1, 1, 4, 1, 0))
+	assert.NoError(t, c.EndpointDelete(&EndpointKey{ServiceId: 1, BackendIndex: 4}))
+	assert.NoError(t, c.FlushBatch())
+
+	assert.Equal(t, 3, c.EndpointCount())
+	for index, uid := range map[uint32]uint32{1: 4, 2: 2, 3: 5} {
+		ev := EndpointValue{}
+		assert.NoError(t, c.EndpointLookup(&EndpointKey{ServiceId: 1, BackendIndex: index}, &ev))
+		assert.Equal(t, uid, ev.BackendUid)
+	}
+	assert.Nil(t, c.GetEndpointKeys(1))
+	assert.Nil(t, c.GetEndpointKeys(3))
+	assert.Equal(t, 1, c.GetEndpointKeys(4).Len())
+}
+
+func TestBatchServicePorts(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+	servicePortMap := NewFakeServicePortMap(t)
+	defer servicePortMap.Close()
+
+	c := NewCache(workloadMap)
+	c.SetServicePortMap(servicePortMap)
+	_, err := c.ServicePortsUpdate(1, map[uint32]uint32{100: 1000, 101: 1001})
+	assert.NoError(t, err)
+
+	c.BeginBatch()
+	_, err = c.ServicePortsUpdate(1, map[uint32]uint32{101: 1011, 102: 1002})
+	assert.NoError(t, err)
+	// the ports are updated at once, while the stale ones are deleted once the batch is flushed
+	keys, values := c.ServicePortLookupAll()
+	assert.ElementsMatch(t, []ServicePortKey{{ServiceId: 1, ServicePort: 100}, {ServiceId: 1, ServicePort: 101}, {ServiceId: 1, ServicePort: 102}}, keys)
+	assert.ElementsMatch(t, []ServicePortValue{{TargetPort: 1000}, {TargetPort: 1011}, {TargetPort: 1002}}, values)
+
+	assert.NoError(t, c.FlushBatch())
+	keys, values = c.ServicePortLookupAll()
+	assert.ElementsMatch(t, []ServicePortKey{{ServiceId: 1, ServicePort: 101}, {ServiceId: 1, ServicePort: 102}}, keys)
+	assert.ElementsMatch(t, []ServicePortValue{{TargetPort: 1011}, {TargetPort: 1002}}, values)
+
+	c.BeginBatch()
+	assert.NoError(t, c.ServicePortsDelete(1))
+	assert.NoError(t, c.FlushBatch())
+	keys, _ = c.ServicePortLookupAll()
+	assert.Empty(t, keys)
+}
+
+func TestBatchServicePortsFull(t *testing.T) {
+	workloadMap := NewFakeWorkloadMap(t)
+	defer CleanupFakeWorkloadMap(workloadMap)
+	servicePortMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "km_svc_port",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(ServicePortKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(ServicePortValue{})),
+		MaxEntries: 2,
+	})
+	assert.NoError(t, err)
+	defer servicePortMap.Close()
+
+	c := NewCache(workloadMap)
+	c.SetServicePortMap(servicePortMap)
+
+	c.BeginBatch()
+	_, err = c.ServicePortsUpdate(1, map[uint32]uint32{100: 1000, 101: 1001})
+	assert.NoError(t, err)
+	// the map is full, so the port is dropped even though the batch is open
+	dropped, err := c.ServicePortsUpdate(2, map[uint32]uint32{200: 2000})
+	assert.Error(t, err)
+	assert.Equal(t, []uint32{200}, dropped)
+	assert.NoError(t, c.FlushBatch())
+
+	// the port deleted and then stored again within a batch is kept
+	c.BeginBatch()
+	assert.NoError(t, c.ServicePortsDelete(1))
+	_, err = c.ServicePortsUpdate(1, map[uint32]uint32{100: 1000})
+	assert.NoError(t, err)
+	assert.NoError(t, c.FlushBatch())
+	keys, _ := c.ServicePortLookupAll()
+	assert.Equal(t, []ServicePortKey{{ServiceId: 1, ServicePort: 100}}, keys)
+}
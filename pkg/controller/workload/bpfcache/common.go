@@ -17,9 +17,14 @@
 package bpfcache
 
 import (
+	"fmt"
+
 	"github.com/cilium/ebpf"
+
+	"kmesh.net/kmesh/pkg/controller/telemetry"
 )
 
+// LookupAll returns all the values stored in the bpf map, writes buffered in an open batch are not included
 func LookupAll[K any, V any](bpfMap *ebpf.Map) []V {
 	var (
 		key   K
@@ -33,3 +38,41 @@ func LookupAll[K any, V any](bpfMap *ebpf.Map) []V {
 	}
 	return ret
 }
+
+// LookupAllWithKey returns all the keys and values stored in the bpf map, values[i] is the value of keys[i]
+func LookupAllWithKey[K any, V any](bpfMap *ebpf.Map) ([]K, []V) {
+	var (
+		key    K
+		value  V
+		keys   []K
+		values []V
+	)
+
+	iter := bpfMap.Iterate()
+	for iter.Next(&key, &value) {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values
+}
+
+// mapUpdate updates the key of the bpf map. A failure due to the map being full is counted per map
+// and reported with the max entries of the map, as the errno alone gives no hint of the cause.
+func mapUpdate(bpfMap *ebpf.Map, key, value any) error {
+	err := bpfMap.Update(key, value, ebpf.UpdateAny)
+	if err == nil {
+		return nil
+	}
+	name := mapName(bpfMap)
+	if telemetry.RecordMapUpdateFailure(name, err) {
+		return fmt.Errorf("bpf map %s is full (max entries %d): %w", name, bpfMap.MaxEntries(), err)
+	}
+	return err
+}
+
+func mapName(bpfMap *ebpf.Map) string {
+	if info, err := bpfMap.Info(); err == nil && info.Name != "" {
+		return info.Name
+	}
+	return bpfMap.String()
+}
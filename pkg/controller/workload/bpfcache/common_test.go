@@ -0,0 +1,46 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMapUpdateFull(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "km_test",
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	assert.NoError(t, mapUpdate(m, uint32(1), uint32(1)))
+	// updating an existing key does not need a new entry
+	assert.NoError(t, mapUpdate(m, uint32(1), uint32(2)))
+
+	err = mapUpdate(m, uint32(2), uint32(2))
+	assert.ErrorIs(t, err, unix.E2BIG)
+	assert.ErrorContains(t, err, "bpf map km_test is full (max entries 1)")
+}
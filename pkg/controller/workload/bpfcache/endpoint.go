@@ -26,12 +26,14 @@ import (
 
 const (
 	PrioCount = 7
+	// MaxEndpointWeight is the max number of endpoint slots a workload occupies in a priority group
+	MaxEndpointWeight = 128
 )
 
 type EndpointKey struct {
 	ServiceId    uint32 // service id
 	Prio         uint32
-	BackendIndex uint32 // if endpoint_count = 3, then backend_index = 1/2/3
+	BackendIndex uint32 // if endpoint_count = 3, then backend_index = 1/2/3, a workload with capacity n occupies n backend indexes
 }
 
 type EndpointValue struct {
@@ -47,14 +49,22 @@ func (c *Cache) EndpointUpdate(key *EndpointKey, value *EndpointValue) error {
 		c.endpointKeys[value.BackendUid].Insert(*key)
 	}
 
-	return c.bpfMap.KmEndpoint.Update(key, value, ebpf.UpdateAny)
+	return c.endpointUpdate(key, value)
+}
+
+func (c *Cache) endpointUpdate(key *EndpointKey, value *EndpointValue) error {
+	if c.batch != nil {
+		c.batch.endpoint.update(*key, *value)
+		return nil
+	}
+	return mapUpdate(c.bpfMap.KmEndpoint, key, value)
 }
 
 func (c *Cache) EndpointDelete(key *EndpointKey) error {
 	log.Debugf("EndpointDelete [%#v]", *key)
 	value := &EndpointValue{}
 	// update endpointKeys index
-	if err := c.bpfMap.KmEndpoint.Lookup(key, value); err != nil {
+	if err := c.EndpointLookup(key, value); err != nil {
 		log.Infof("endpoint [%#v] does not exist", key)
 		return nil
 	}
@@ -63,6 +73,10 @@ func (c *Cache) EndpointDelete(key *EndpointKey) error {
 		delete(c.endpointKeys, value.BackendUid)
 	}
 
+	if c.batch != nil {
+		c.batch.endpoint.delete(*key)
+		return nil
+	}
 	err := c.bpfMap.KmEndpoint.Delete(key)
 	if err != nil && errors.Is(err, ebpf.ErrKeyNotExist) {
 		return nil
@@ -108,7 +122,7 @@ func (c *Cache) EndpointSwap(currentIndex, backendUid, lastIndex uint32, service
 	}
 
 	// replace the current endpoint with the last endpoint
-	if err := c.bpfMap.KmEndpoint.Update(currentKey, lastValue, ebpf.UpdateAny); err != nil {
+	if err := c.endpointUpdate(currentKey, lastValue); err != nil {
 		return err
 	}
 
@@ -125,6 +139,13 @@ func (c *Cache) EndpointSwap(currentIndex, backendUid, lastIndex uint32, service
 
 func (c *Cache) EndpointLookup(key *EndpointKey, value *EndpointValue) error {
 	log.Debugf("EndpointLookup [%#v]", *key)
+	if op, ok := c.batch.endpointLookup(*key); ok {
+		if op.deleted {
+			return ebpf.ErrKeyNotExist
+		}
+		*value = op.value
+		return nil
+	}
 	return c.bpfMap.KmEndpoint.Lookup(key, value)
 }
 
@@ -177,3 +198,9 @@ func (c *Cache) EndpointLookupAll() []EndpointValue {
 	log.Debugf("EndpointLookupAll")
 	return LookupAll[EndpointKey, EndpointValue](c.bpfMap.KmEndpoint)
 }
+
+// EndpointLookupAllWithKey returns all the keys and values stored in the endpoint map, values[i] is the value of keys[i]
+func (c *Cache) EndpointLookupAllWithKey() ([]EndpointKey, []EndpointValue) {
+	log.Debugf("EndpointLookupAllWithKey")
+	return LookupAllWithKey[EndpointKey, EndpointValue](c.bpfMap.KmEndpoint)
+}
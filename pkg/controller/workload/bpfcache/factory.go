@@ -17,6 +17,7 @@
 package bpfcache
 
 import (
+	"github.com/cilium/ebpf"
 	"istio.io/istio/pkg/util/sets"
 
 	bpf2go "kmesh.net/kmesh/bpf/kmesh/bpf2go/dualengine"
@@ -29,12 +30,21 @@ type Cache struct {
 	bpfMap bpf2go.KmeshCgroupSockWorkloadMaps
 	// endpointKeys by workload uid
 	endpointKeys map[uint32]sets.Set[EndpointKey]
+	// batch buffers the map writes between BeginBatch and FlushBatch, nil if no batch is open
+	batch *batchWrites
+	// maglevMap stores the maglev lookup tables by service id, nil if consistent hash is unavailable
+	maglevMap *ebpf.Map
+	// servicePortMap stores the service ports exceeding MaxPortNum
+	servicePortMap *ebpf.Map
+	// service ports stored in servicePortMap by service id
+	servicePorts map[uint32]map[uint32]struct{}
 }
 
 func NewCache(workloadMap bpf2go.KmeshCgroupSockWorkloadMaps) *Cache {
 	return &Cache{
 		bpfMap:       workloadMap,
 		endpointKeys: make(map[uint32]sets.Set[EndpointKey]),
+		servicePorts: make(map[uint32]map[uint32]struct{}),
 	}
 }
 
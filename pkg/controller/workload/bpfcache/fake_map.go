@@ -100,3 +100,40 @@ func CleanupFakeWorkloadMap(maps bpf2go.KmeshCgroupSockWorkloadMaps) {
 	maps.KmService.Close()
 	maps.KmWlpolicy.Close()
 }
+
+func NewFakeMaglevMap(t *testing.T) *ebpf.Map {
+	_ = rlimit.RemoveMemlock()
+	maglevMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "km_wl_maglev",
+		Type:       ebpf.HashOfMaps,
+		KeySize:    uint32(unsafe.Sizeof(uint32(0))),
+		ValueSize:  uint32(unsafe.Sizeof(uint32(0))),
+		MaxEntries: 1024,
+		InnerMap: &ebpf.MapSpec{
+			Name:       "inner_of_maglev",
+			Type:       ebpf.Array,
+			KeySize:    uint32(unsafe.Sizeof(uint32(0))),
+			ValueSize:  uint32(unsafe.Sizeof(uint32(0))) * uint32(MaglevTableSize),
+			MaxEntries: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("create maglevMap map failed, err is %v", err)
+	}
+	return maglevMap
+}
+
+func NewFakeServicePortMap(t *testing.T) *ebpf.Map {
+	_ = rlimit.RemoveMemlock()
+	servicePortMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "km_svc_port",
+		Type:       ebpf.Hash,
+		KeySize:    uint32(unsafe.Sizeof(ServicePortKey{})),
+		ValueSize:  uint32(unsafe.Sizeof(ServicePortValue{})),
+		MaxEntries: 1024,
+	})
+	if err != nil {
+		t.Fatalf("create servicePortMap map failed, err is %v", err)
+	}
+	return servicePortMap
+}
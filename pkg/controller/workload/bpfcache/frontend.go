@@ -32,11 +32,19 @@ type FrontendValue struct {
 
 func (c *Cache) FrontendUpdate(key *FrontendKey, value *FrontendValue) error {
 	log.Debugf("FrontendUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmFrontend.Update(key, value, ebpf.UpdateAny)
+	if c.batch != nil {
+		c.batch.frontend.update(*key, *value)
+		return nil
+	}
+	return mapUpdate(c.bpfMap.KmFrontend, key, value)
 }
 
 func (c *Cache) FrontendDelete(key *FrontendKey) error {
 	log.Debugf("FrontendDelete [%#v]", *key)
+	if c.batch != nil {
+		c.batch.frontend.delete(*key)
+		return nil
+	}
 	err := c.bpfMap.KmFrontend.Delete(key)
 	if err != nil && errors.Is(err, ebpf.ErrKeyNotExist) {
 		return nil
@@ -46,6 +54,13 @@ func (c *Cache) FrontendDelete(key *FrontendKey) error {
 
 func (c *Cache) FrontendLookup(key *FrontendKey, value *FrontendValue) error {
 	log.Debugf("FrontendLookup [%#v]", *key)
+	if op, ok := c.batch.frontendLookup(*key); ok {
+		if op.deleted {
+			return ebpf.ErrKeyNotExist
+		}
+		*value = op.value
+		return nil
+	}
 	return c.bpfMap.KmFrontend.
 		Lookup(key, value)
 }
@@ -60,10 +75,21 @@ func (c *Cache) FrontendIterFindKey(upstreamId uint32) []FrontendKey {
 
 	res := make([]FrontendKey, 0)
 	for iter.Next(&key, &value) {
+		if _, ok := c.batch.frontendLookup(key); ok {
+			// overridden by the open batch, checked below
+			continue
+		}
 		if value.UpstreamId == upstreamId {
 			res = append(res, key)
 		}
 	}
+	if c.batch != nil {
+		for k, op := range c.batch.frontend.ops {
+			if !op.deleted && op.value.UpstreamId == upstreamId {
+				res = append(res, k)
+			}
+		}
+	}
 
 	log.Debugf("res:[%#v]", res)
 	return res
@@ -0,0 +1,104 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+
+	"kmesh.net/kmesh/pkg/consistenthash/maglev"
+)
+
+const (
+	// MaglevMapName is the pinned name of the map holding the maglev lookup tables of the services
+	MaglevMapName = "km_wl_maglev"
+	// MaglevTableSize must be consistent with MAGLEV_TABLE_SIZE in the bpf prog
+	MaglevTableSize uint64 = 16381
+)
+
+// LoadMaglevMap loads the pinned maglev map from the bpf map path of dual-engine mode.
+func LoadMaglevMap(mapPath string) (*ebpf.Map, error) {
+	m, err := ebpf.LoadPinnedMap(filepath.Join(mapPath, MaglevMapName), &ebpf.LoadPinOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("load maglev map failed: %v", err)
+	}
+	return m, nil
+}
+
+// SetMaglevMap sets the map used to store the maglev lookup tables of the services.
+func (c *Cache) SetMaglevMap(m *ebpf.Map) {
+	c.maglevMap = m
+}
+
+// MaglevUpdate builds the maglev lookup table of the backends and stores it for the service.
+// names identify the backends when building the table, they must be stable so that the table
+// changes as little as possible when backends are added or removed.
+func (c *Cache) MaglevUpdate(serviceId uint32, names []string, backendUids []uint32) error {
+	if c.maglevMap == nil {
+		return errors.New("maglev map is not initialized")
+	}
+	if len(names) != len(backendUids) {
+		return fmt.Errorf("mismatched backend names %d and uids %d", len(names), len(backendUids))
+	}
+
+	indexes, err := maglev.NewLookupTable(names, MaglevTableSize)
+	if err != nil {
+		return err
+	}
+	table := make([]uint32, len(indexes))
+	for i, index := range indexes {
+		table[i] = backendUids[index]
+	}
+
+	log.Debugf("MaglevUpdate service %d with %d backends", serviceId, len(names))
+	return maglev.UpdateTable(c.maglevMap, serviceId, table)
+}
+
+// MaglevDelete deletes the maglev lookup table of the service, it is fine if the table does not exist.
+func (c *Cache) MaglevDelete(serviceId uint32) error {
+	if c.maglevMap == nil {
+		return nil
+	}
+
+	log.Debugf("MaglevDelete service %d", serviceId)
+	if err := c.maglevMap.Delete(serviceId); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MaglevLookup returns the maglev lookup table of the service.
+func (c *Cache) MaglevLookup(serviceId uint32) ([]uint32, error) {
+	if c.maglevMap == nil {
+		return nil, errors.New("maglev map is not initialized")
+	}
+
+	var inner *ebpf.Map
+	if err := c.maglevMap.Lookup(serviceId, &inner); err != nil {
+		return nil, err
+	}
+	defer inner.Close()
+
+	table := make([]uint32, MaglevTableSize)
+	if err := inner.Lookup(uint32(0), &table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
@@ -23,7 +23,10 @@ import (
 )
 
 const (
+	// MaxPortNum is the number of ports stored in ServiceValue, the rest are stored in the service port map
 	MaxPortNum = 10
+	// LbPolicyMaglev is the lb policy of the services using maglev consistent hash, it follows the modes of workloadapi.LoadBalancing
+	LbPolicyMaglev = 3
 )
 
 type ServiceKey struct {
@@ -35,7 +38,7 @@ type TargetPorts [MaxPortNum]uint32
 
 type ServiceValue struct {
 	EndpointCount [PrioCount]uint32 // endpoint count of current service
-	LbPolicy      uint32            // load balancing algorithm, currently only supports random algorithm
+	LbPolicy      uint32            // load balancing algorithm, random, locality strict/failover or maglev
 	ServicePort   ServicePorts      // ServicePort[i] and TargetPort[i] are a pair, i starts from 0 and max value is MaxPortNum-1
 	TargetPort    TargetPorts
 	WaypointAddr  [16]byte
@@ -44,11 +47,19 @@ type ServiceValue struct {
 
 func (c *Cache) ServiceUpdate(key *ServiceKey, value *ServiceValue) error {
 	log.Debugf("ServiceUpdate [%#v], [%#v]", *key, *value)
-	return c.bpfMap.KmService.Update(key, value, ebpf.UpdateAny)
+	if c.batch != nil {
+		c.batch.service.update(*key, *value)
+		return nil
+	}
+	return mapUpdate(c.bpfMap.KmService, key, value)
 }
 
 func (c *Cache) ServiceDelete(key *ServiceKey) error {
 	log.Debugf("ServiceDelete [%#v]", *key)
+	if c.batch != nil {
+		c.batch.service.delete(*key)
+		return nil
+	}
 	err := c.bpfMap.KmService.Delete(key)
 	if err != nil && errors.Is(err, ebpf.ErrKeyNotExist) {
 		return nil
@@ -58,6 +69,13 @@ func (c *Cache) ServiceDelete(key *ServiceKey) error {
 
 func (c *Cache) ServiceLookup(key *ServiceKey, value *ServiceValue) error {
 	log.Debugf("ServiceLookup [%#v]", *key)
+	if op, ok := c.batch.serviceLookup(*key); ok {
+		if op.deleted {
+			return ebpf.ErrKeyNotExist
+		}
+		*value = op.value
+		return nil
+	}
 	return c.bpfMap.KmService.Lookup(key, value)
 }
 
@@ -71,3 +89,9 @@ func (c *Cache) ServiceLookupAll() []ServiceValue {
 	log.Debugf("ServiceLookupAll")
 	return LookupAll[ServiceKey, ServiceValue](c.bpfMap.KmService)
 }
+
+// ServiceLookupAllWithKey returns all the keys and values stored in the service map, values[i] is the value of keys[i]
+func (c *Cache) ServiceLookupAllWithKey() ([]ServiceKey, []ServiceValue) {
+	log.Debugf("ServiceLookupAllWithKey")
+	return LookupAllWithKey[ServiceKey, ServiceValue](c.bpfMap.KmService)
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bpfcache
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+)
+
+// ServicePortMapName is the pinned name of the map holding the service ports exceeding MaxPortNum
+const ServicePortMapName = "km_svc_port"
+
+type ServicePortKey struct {
+	ServiceId   uint32
+	ServicePort uint32 // big endian
+}
+
+type ServicePortValue struct {
+	TargetPort uint32 // big endian
+}
+
+// LoadServicePortMap loads the pinned service port map from the bpf map path of dual-engine mode.
+func LoadServicePortMap(mapPath string) (*ebpf.Map, error) {
+	m, err := ebpf.LoadPinnedMap(filepath.Join(mapPath, ServicePortMapName), &ebpf.LoadPinOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("load service port map failed: %v", err)
+	}
+	return m, nil
+}
+
+// SetServicePortMap sets the map used to store the service ports exceeding MaxPortNum.
+func (c *Cache) SetServicePortMap(m *ebpf.Map) {
+	c.servicePortMap = m
+}
+
+// ServicePortsUpdate stores the service ports exceeding MaxPortNum, ports maps service port to target port.
+// The ports stored for the service before but not in ports are deleted.
+// It returns the service ports which can not be stored.
+func (c *Cache) ServicePortsUpdate(serviceId uint32, ports map[uint32]uint32) ([]uint32, error) {
+	log.Debugf("ServicePortsUpdate service %d: %v", serviceId, ports)
+	var (
+		errs    []error
+		dropped []uint32
+		stored  = make(map[uint32]struct{}, len(ports))
+	)
+
+	for servicePort, targetPort := range ports {
+		if c.servicePortMap == nil {
+			dropped = append(dropped, servicePort)
+			continue
+		}
+		key := ServicePortKey{ServiceId: serviceId, ServicePort: servicePort}
+		value := ServicePortValue{TargetPort: targetPort}
+		if err := c.servicePortUpdate(&key, &value); err != nil {
+			errs = append(errs, fmt.Errorf("update service port [%#v] failed: %w", key, err))
+			dropped = append(dropped, servicePort)
+			continue
+		}
+		stored[servicePort] = struct{}{}
+	}
+	if c.servicePortMap == nil && len(dropped) > 0 {
+		errs = append(errs, errors.New("service port map is not initialized"))
+	}
+
+	for servicePort := range c.servicePorts[serviceId] {
+		if _, ok := stored[servicePort]; ok || c.servicePortMap == nil {
+			continue
+		}
+		key := ServicePortKey{ServiceId: serviceId, ServicePort: servicePort}
+		if err := c.servicePortDelete(&key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			errs = append(errs, fmt.Errorf("delete service port [%#v] failed: %w", key, err))
+		}
+	}
+
+	if len(stored) == 0 {
+		delete(c.servicePorts, serviceId)
+	} else {
+		c.servicePorts[serviceId] = stored
+	}
+	return dropped, errors.Join(errs...)
+}
+
+// servicePortUpdate writes the service port at once even if a batch is open, so that a full map is reported
+// as the port dropped. The port is still stored before the service referencing it is flushed.
+func (c *Cache) servicePortUpdate(key *ServicePortKey, value *ServicePortValue) error {
+	if c.batch != nil {
+		// the port deleted earlier in the batch must not be deleted on flush
+		c.batch.servicePort.forget(*key)
+	}
+	return mapUpdate(c.servicePortMap, key, value)
+}
+
+func (c *Cache) servicePortDelete(key *ServicePortKey) error {
+	if c.batch != nil {
+		c.batch.servicePort.delete(*key)
+		return nil
+	}
+	return c.servicePortMap.Delete(key)
+}
+
+// ServicePortsDelete deletes all the service ports stored for the service.
+func (c *Cache) ServicePortsDelete(serviceId uint32) error {
+	_, err := c.ServicePortsUpdate(serviceId, nil)
+	return err
+}
+
+// ServicePortLookupAll returns all the keys and values stored in the service port map, values[i] is the value of keys[i]
+func (c *Cache) ServicePortLookupAll() ([]ServicePortKey, []ServicePortValue) {
+	log.Debugf("ServicePortLookupAll")
+	if c.servicePortMap == nil {
+		return nil, nil
+	}
+	return LookupAllWithKey[ServicePortKey, ServicePortValue](c.servicePortMap)
+}
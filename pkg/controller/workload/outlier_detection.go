@@ -0,0 +1,341 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/util/sets"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+)
+
+const (
+	outlierDetectionInterval = time.Second
+	// outlierOutcomeBufferSize bounds the connection outcomes waiting to be recorded
+	outlierOutcomeBufferSize = 1024
+)
+
+// OutlierEjection describes a workload ejected by outlier detection.
+type OutlierEjection struct {
+	WorkloadUid         string
+	ConsecutiveFailures uint32
+	EjectionCount       uint32
+	EjectedAt           time.Time
+	EjectedUntil        time.Time
+}
+
+type outlierHost struct {
+	consecutiveFailures uint32
+	// ejectionCount multiplies the ejection time, it decreases when the host keeps healthy for a base ejection time
+	ejectionCount uint32
+	ejected       bool
+	ejectedAt     time.Time
+	// ejectedUntil is the time the host is readmitted, or the last time ejectionCount decreased
+	ejectedUntil time.Time
+}
+
+type connectionOutcome struct {
+	workloadUid string
+	success     bool
+}
+
+// outlierDetector counts the consecutive connect failures of the workloads, and ejects them from their services
+// through eject once the threshold is reached, they are readmitted through readmit after the ejection time.
+type outlierDetector struct {
+	config options.OutlierDetectionConfig
+	mutex  sync.Mutex
+	hosts  map[string]*outlierHost
+	// outcomes queues the observed connection outcomes, they are recorded by run
+	outcomes chan connectionOutcome
+
+	eject   func(workloadUid string) bool
+	readmit func(workloadUid string)
+	now     func() time.Time
+}
+
+func newOutlierDetector(config options.OutlierDetectionConfig, eject func(string) bool, readmit func(string)) *outlierDetector {
+	return &outlierDetector{
+		config:   config,
+		hosts:    make(map[string]*outlierHost),
+		outcomes: make(chan connectionOutcome, outlierOutcomeBufferSize),
+		eject:    eject,
+		readmit:  readmit,
+		now:      time.Now,
+	}
+}
+
+// observe queues the outcome of a connection to the workload. It is called by the ringbuf consumer of the
+// metric controller, so it never blocks: the outcome is dropped if run falls behind.
+func (d *outlierDetector) observe(workloadUid string, success bool) {
+	select {
+	case d.outcomes <- connectionOutcome{workloadUid: workloadUid, success: success}:
+	default:
+		log.Debugf("outlier detection falls behind, drop the connection outcome of workload %s", workloadUid)
+	}
+}
+
+// record records the outcome of a connection to the workload, and ejects it once the threshold is reached.
+func (d *outlierDetector) record(workloadUid string, success bool) {
+	d.mutex.Lock()
+	now := d.now()
+	h, ok := d.hosts[workloadUid]
+	if !ok {
+		if success {
+			d.mutex.Unlock()
+			return
+		}
+		h = &outlierHost{}
+		d.hosts[workloadUid] = h
+	}
+
+	if h.ejected {
+		// connections set up before the ejection
+		d.mutex.Unlock()
+		return
+	}
+	if success {
+		h.consecutiveFailures = 0
+		if h.ejectionCount > 0 && now.Sub(h.ejectedUntil) > d.config.BaseEjectionTime {
+			h.ejectionCount--
+			h.ejectedUntil = now
+		}
+		d.mutex.Unlock()
+		return
+	}
+
+	h.consecutiveFailures++
+	if h.consecutiveFailures < d.config.ConsecutiveFailures {
+		d.mutex.Unlock()
+		return
+	}
+	// mark it ejected before calling eject, so that the failures observed meanwhile are ignored
+	h.ejected = true
+	d.mutex.Unlock()
+
+	ejected := d.eject(workloadUid)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !ejected {
+		// the max ejection percent is reached, try again on the next failure
+		h.ejected = false
+		return
+	}
+	h.ejectionCount++
+	h.ejectedAt = now
+	h.ejectedUntil = now.Add(min(d.config.BaseEjectionTime*time.Duration(h.ejectionCount), d.config.MaxEjectionTime))
+	log.Infof("workload %s is ejected until %s after %d consecutive connect failures",
+		workloadUid, h.ejectedUntil.Format(time.RFC3339), h.consecutiveFailures)
+	telemetry.SetOutlierEjectedEndpoints(d.ejectedCountLocked())
+}
+
+// readmitExpired readmits the workloads whose ejection time is over.
+func (d *outlierDetector) readmitExpired() {
+	d.mutex.Lock()
+	now := d.now()
+	var expired []string
+	for uid, h := range d.hosts {
+		if h.ejected && !h.ejectedAt.IsZero() && !now.Before(h.ejectedUntil) {
+			expired = append(expired, uid)
+			h.ejected = false
+			h.consecutiveFailures = 0
+		} else if !h.ejected && h.ejectionCount == 0 && h.consecutiveFailures == 0 {
+			delete(d.hosts, uid)
+		}
+	}
+	telemetry.SetOutlierEjectedEndpoints(d.ejectedCountLocked())
+	d.mutex.Unlock()
+
+	for _, uid := range expired {
+		log.Infof("workload %s is readmitted by outlier detection", uid)
+		d.readmit(uid)
+	}
+}
+
+func (d *outlierDetector) ejectedCountLocked() int {
+	count := 0
+	for _, h := range d.hosts {
+		if h.ejected && !h.ejectedAt.IsZero() {
+			count++
+		}
+	}
+	return count
+}
+
+func (d *outlierDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(outlierDetectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case outcome := <-d.outcomes:
+			d.record(outcome.workloadUid, outcome.success)
+		case <-ticker.C:
+			d.readmitExpired()
+		}
+	}
+}
+
+// ejections returns the workloads currently ejected, sorted by uid.
+func (d *outlierDetector) ejections() []OutlierEjection {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var out []OutlierEjection
+	for uid, h := range d.hosts {
+		if !h.ejected || h.ejectedAt.IsZero() {
+			continue
+		}
+		out = append(out, OutlierEjection{
+			WorkloadUid:         uid,
+			ConsecutiveFailures: h.consecutiveFailures,
+			EjectionCount:       h.ejectionCount,
+			EjectedAt:           h.ejectedAt,
+			EjectedUntil:        h.ejectedUntil,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].WorkloadUid < out[j].WorkloadUid
+	})
+	return out
+}
+
+// StartOutlierDetection ejects the workloads failing to be connected consecutively, based on the outcome
+// of the connections observed by the metric controller, so it requires monitoring to be enabled.
+func (c *Controller) StartOutlierDetection(ctx context.Context, config *options.OutlierDetectionConfig) {
+	if config == nil || !config.Enable {
+		return
+	}
+	if !c.MetricController.EnableMonitoring.Load() {
+		log.Warnf("outlier detection requires monitoring to be enabled")
+	}
+
+	c.outlierDetector = newOutlierDetector(*config,
+		func(workloadUid string) bool {
+			c.processorMutex.Lock()
+			defer c.processorMutex.Unlock()
+			ejected, err := c.Processor.ejectWorkload(workloadUid, config.MaxEjectionPercent)
+			if err != nil {
+				log.Errorf("eject workload %s failed: %v", workloadUid, err)
+			}
+			return ejected
+		},
+		func(workloadUid string) {
+			c.processorMutex.Lock()
+			defer c.processorMutex.Unlock()
+			if err := c.Processor.readmitWorkload(workloadUid); err != nil {
+				log.Errorf("readmit workload %s failed: %v", workloadUid, err)
+			}
+		})
+	c.MetricController.SetConnectionObserver(c.outlierDetector.observe)
+	go c.outlierDetector.run(ctx)
+	log.Infof("outlier detection is started: %+v", *config)
+}
+
+// OutlierEjections returns the workloads currently ejected by outlier detection.
+func (c *Controller) OutlierEjections() []OutlierEjection {
+	if c.outlierDetector == nil {
+		return nil
+	}
+	return c.outlierDetector.ejections()
+}
+
+func (p *Processor) isWorkloadEjected(workloadUid string) bool {
+	_, ok := p.ejectedWorkloads[workloadUid]
+	return ok
+}
+
+// ejectWorkload removes the endpoints of the workload from all its services, unless it makes the ejected
+// workloads of a service exceed maxEjectionPercent. It returns whether the workload is ejected.
+func (p *Processor) ejectWorkload(workloadUid string, maxEjectionPercent uint32) (bool, error) {
+	if p.isWorkloadEjected(workloadUid) {
+		return false, nil
+	}
+	workload := p.WorkloadCache.GetWorkloadByUid(workloadUid)
+	if workload == nil {
+		return false, nil
+	}
+
+	services := sets.New[uint32]()
+	for ek := range p.bpf.GetEndpointKeys(p.hashName.Hash(workloadUid)) {
+		services.Insert(ek.ServiceId)
+	}
+	if services.Len() == 0 {
+		return false, nil
+	}
+	for serviceId := range services {
+		if !p.canEjectFromService(serviceId, maxEjectionPercent) {
+			log.Infof("skip ejecting workload %s, max ejection percent of service %s is reached",
+				workloadUid, p.hashName.NumToStr(serviceId))
+			return false, nil
+		}
+	}
+
+	if err := p.handleUnhealthyWorkload(workload); err != nil {
+		return false, err
+	}
+	if p.ejectedWorkloads == nil {
+		p.ejectedWorkloads = make(map[string][]uint32)
+	}
+	p.ejectedWorkloads[workloadUid] = services.UnsortedList()
+	p.syncMaglevTables()
+	telemetry.IncOutlierEjection(workload)
+	return true, nil
+}
+
+func (p *Processor) canEjectFromService(serviceId, maxEjectionPercent uint32) bool {
+	if maxEjectionPercent == 0 {
+		return false
+	}
+	ejected := 0
+	for _, serviceIds := range p.ejectedWorkloads {
+		if slices.Contains(serviceIds, serviceId) {
+			ejected++
+		}
+	}
+	total := len(p.EndpointCache.List(serviceId)) + ejected
+	// always keep an endpoint for the service
+	if total-ejected <= 1 {
+		return false
+	}
+	return ejected < max(total*int(maxEjectionPercent)/100, 1)
+}
+
+// readmitWorkload adds the endpoints of an ejected workload back to its services.
+func (p *Processor) readmitWorkload(workloadUid string) error {
+	if !p.isWorkloadEjected(workloadUid) {
+		return nil
+	}
+	delete(p.ejectedWorkloads, workloadUid)
+
+	workload := p.WorkloadCache.GetWorkloadByUid(workloadUid)
+	if workload == nil || workload.GetStatus() == workloadapi.WorkloadStatus_UNHEALTHY {
+		return nil
+	}
+	if err := p.updateWorkloadEndpoints(workload); err != nil {
+		return err
+	}
+	p.syncMaglevTables()
+	return nil
+}
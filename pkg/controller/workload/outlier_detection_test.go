@@ -0,0 +1,214 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+	"kmesh.net/kmesh/pkg/controller/workload/common"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	config := options.OutlierDetectionConfig{
+		Enable:              true,
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     25 * time.Second,
+		MaxEjectionPercent:  50,
+	}
+	allowEject := true
+	ejected := map[string]bool{}
+	d := newOutlierDetector(config,
+		func(uid string) bool {
+			if allowEject {
+				ejected[uid] = true
+			}
+			return allowEject
+		},
+		func(uid string) {
+			delete(ejected, uid)
+		})
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	// a success resets the consecutive failures
+	d.record("wl1", false)
+	d.record("wl1", false)
+	d.record("wl1", true)
+	d.record("wl1", false)
+	d.record("wl1", false)
+	assert.Empty(t, ejected)
+
+	d.record("wl1", false)
+	assert.True(t, ejected["wl1"])
+	ejections := d.ejections()
+	assert.Len(t, ejections, 1)
+	assert.Equal(t, "wl1", ejections[0].WorkloadUid)
+	assert.Equal(t, uint32(3), ejections[0].ConsecutiveFailures)
+	assert.Equal(t, now.Add(10*time.Second), ejections[0].EjectedUntil)
+
+	// the failures of the connections set up before the ejection are ignored
+	d.record("wl1", false)
+	assert.Equal(t, uint32(3), d.ejections()[0].ConsecutiveFailures)
+
+	now = now.Add(5 * time.Second)
+	d.readmitExpired()
+	assert.True(t, ejected["wl1"])
+	now = now.Add(5 * time.Second)
+	d.readmitExpired()
+	assert.False(t, ejected["wl1"])
+	assert.Empty(t, d.ejections())
+
+	// the ejection time grows with the ejection count, bounded by the max ejection time
+	for i := 0; i < 3; i++ {
+		d.record("wl1", false)
+	}
+	assert.Equal(t, now.Add(20*time.Second), d.ejections()[0].EjectedUntil)
+	now = now.Add(20 * time.Second)
+	d.readmitExpired()
+	for i := 0; i < 3; i++ {
+		d.record("wl1", false)
+	}
+	assert.Equal(t, now.Add(25*time.Second), d.ejections()[0].EjectedUntil)
+	now = now.Add(25 * time.Second)
+	d.readmitExpired()
+
+	// the ejection is retried on the next failure if it is refused
+	allowEject = false
+	for i := 0; i < 3; i++ {
+		d.record("wl2", false)
+	}
+	assert.Empty(t, d.ejections())
+	allowEject = true
+	d.record("wl2", false)
+	assert.True(t, ejected["wl2"])
+	assert.Equal(t, uint32(4), d.ejections()[0].ConsecutiveFailures)
+}
+
+func TestOutlierDetectorObserve(t *testing.T) {
+	config := options.OutlierDetectionConfig{
+		Enable:              true,
+		ConsecutiveFailures: 1,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     10 * time.Second,
+		MaxEjectionPercent:  50,
+	}
+	ejected := make(chan string, 1)
+	d := newOutlierDetector(config,
+		func(uid string) bool {
+			ejected <- uid
+			return true
+		},
+		func(uid string) {})
+
+	// observe never blocks, the outcomes exceeding the buffer are dropped
+	for i := 0; i < outlierOutcomeBufferSize+10; i++ {
+		d.observe("wl1", true)
+	}
+	assert.Len(t, d.outcomes, outlierOutcomeBufferSize)
+	for len(d.outcomes) > 0 {
+		<-d.outcomes
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.run(ctx)
+	d.observe("wl2", false)
+	select {
+	case uid := <-ejected:
+		assert.Equal(t, "wl2", uid)
+	case <-time.After(5 * time.Second):
+		t.Fatal("workload is not ejected")
+	}
+}
+
+func TestProcessorEjectWorkload(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+	svc := common.CreateFakeService("svc1", "10.240.10.1", "", nil)
+	wl1 := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil, "svc1")
+	wl2 := createWorkload("wl2", "10.244.0.2", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil, "svc1")
+	wl3 := createWorkload("wl3", "10.244.0.3", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil, "svc1")
+	wl4 := createWorkload("wl4", "10.244.0.4", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil, "svc1")
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc}, []*workloadapi.Workload{wl1, wl2, wl3, wl4})
+
+	svcId := p.hashName.Hash(svc.ResourceName())
+	endpointCount := func() uint32 {
+		sv := bpfcache.ServiceValue{}
+		assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcId}, &sv))
+		return sv.EndpointCount[0]
+	}
+	assert.Equal(t, uint32(4), endpointCount())
+
+	// no endpoint is ejected with the max ejection percent of 0
+	ejected, err := p.ejectWorkload(wl1.ResourceName(), 0)
+	assert.NoError(t, err)
+	assert.False(t, ejected)
+	assert.Equal(t, uint32(4), endpointCount())
+
+	ejected, err = p.ejectWorkload(wl1.ResourceName(), 50)
+	assert.NoError(t, err)
+	assert.True(t, ejected)
+	assert.Equal(t, uint32(3), endpointCount())
+	assert.Empty(t, p.bpf.GetEndpointKeys(p.hashName.Hash(wl1.ResourceName())))
+
+	// an update of the ejected workload does not add it back
+	p.handleServicesAndWorkloads(nil, []*workloadapi.Workload{wl1})
+	assert.Equal(t, uint32(3), endpointCount())
+
+	ejected, err = p.ejectWorkload(wl2.ResourceName(), 50)
+	assert.NoError(t, err)
+	assert.True(t, ejected)
+	assert.Equal(t, uint32(2), endpointCount())
+
+	// the max ejection percent is reached
+	ejected, err = p.ejectWorkload(wl3.ResourceName(), 50)
+	assert.NoError(t, err)
+	assert.False(t, ejected)
+	assert.Equal(t, uint32(2), endpointCount())
+
+	assert.NoError(t, p.readmitWorkload(wl1.ResourceName()))
+	assert.Equal(t, uint32(3), endpointCount())
+	assert.Equal(t, 1, p.bpf.GetEndpointKeys(p.hashName.Hash(wl1.ResourceName())).Len())
+
+	// the last endpoint of a service is never ejected
+	ejected, err = p.ejectWorkload(wl3.ResourceName(), 100)
+	assert.NoError(t, err)
+	assert.True(t, ejected)
+	ejected, err = p.ejectWorkload(wl4.ResourceName(), 100)
+	assert.NoError(t, err)
+	assert.True(t, ejected)
+	ejected, err = p.ejectWorkload(wl1.ResourceName(), 100)
+	assert.NoError(t, err)
+	assert.False(t, ejected)
+	assert.Equal(t, uint32(1), endpointCount())
+
+	p.removeWorkloadResources([]string{wl2.ResourceName()})
+	assert.False(t, p.isWorkloadEjected(wl2.ResourceName()))
+
+	hashNameClean(p)
+}
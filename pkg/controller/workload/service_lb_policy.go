@@ -0,0 +1,212 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
+	bpf "kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+// serviceLbPolicies records the lb policies selected by the annotation of kubernetes services, by namespace/name.
+// They take precedence over the load balancing mode of the workload api service.
+type serviceLbPolicies struct {
+	mutex    sync.RWMutex
+	policies map[string]uint32
+}
+
+func newServiceLbPolicies() *serviceLbPolicies {
+	return &serviceLbPolicies{
+		policies: make(map[string]uint32),
+	}
+}
+
+func (s *serviceLbPolicies) get(namespace, name string) (uint32, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	policy, ok := s.policies[namespace+"/"+name]
+	return policy, ok
+}
+
+// set records the lb policy of the service, ok is false if the service does not select any lb policy.
+// It returns whether the lb policy of the service is changed.
+func (s *serviceLbPolicies) set(namespace, name string, policy uint32, ok bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := namespace + "/" + name
+	oldPolicy, oldOk := s.policies[key]
+	if ok {
+		s.policies[key] = policy
+	} else {
+		delete(s.policies, key)
+	}
+	return oldOk != ok || oldPolicy != policy
+}
+
+// lbPolicyFromAnnotation returns the lb policy selected by the annotation of the kubernetes service.
+func lbPolicyFromAnnotation(svc *corev1.Service) (uint32, bool) {
+	switch svc.GetAnnotations()[constants.KmeshLbPolicyAnnotation] {
+	case constants.KmeshLbPolicyMaglev:
+		return bpf.LbPolicyMaglev, true
+	default:
+		return 0, false
+	}
+}
+
+// isLocalityLbPolicy returns whether the endpoints of a service are stored by locality priority under the lb policy,
+// otherwise all the endpoints are stored with the highest priority.
+func isLocalityLbPolicy(policy uint32) bool {
+	return policy == uint32(workloadapi.LoadBalancing_STRICT) || policy == uint32(workloadapi.LoadBalancing_FAILOVER)
+}
+
+func (p *Processor) serviceLbPolicy(service *workloadapi.Service) uint32 {
+	if policy, ok := p.lbPolicies.get(service.GetNamespace(), service.GetName()); ok {
+		return policy
+	}
+	return uint32(service.GetLoadBalancing().GetMode())
+}
+
+// updateServiceLbPolicy applies the lb policy selected by the annotation of a kubernetes service to the bpf maps.
+func (p *Processor) updateServiceLbPolicy(namespace, name string) {
+	for _, service := range p.ServiceCache.List() {
+		if service.GetNamespace() != namespace || service.GetName() != name {
+			continue
+		}
+		log.Infof("update lb policy of service %s to %d", service.ResourceName(), p.serviceLbPolicy(service))
+		if err := p.updateServiceMap(service, service); err != nil {
+			log.Errorf("update lb policy of service %s failed: %v", service.ResourceName(), err)
+		}
+	}
+	p.syncMaglevTables()
+}
+
+// markMaglevDirty records the service whose maglev lookup table needs to be rebuilt.
+func (p *Processor) markMaglevDirty(serviceId uint32) {
+	if p.maglevDirty == nil {
+		p.maglevDirty = make(map[uint32]struct{})
+	}
+	p.maglevDirty[serviceId] = struct{}{}
+}
+
+// syncMaglevTables rebuilds the maglev lookup tables of the services whose endpoints changed,
+// it should be called after the endpoint and backend maps are written.
+func (p *Processor) syncMaglevTables() {
+	for serviceId := range p.maglevDirty {
+		if err := p.syncMaglevTable(serviceId); err != nil {
+			log.Errorf("sync maglev table of service %d failed: %v", serviceId, err)
+		}
+	}
+	clear(p.maglevDirty)
+}
+
+func (p *Processor) syncMaglevTable(serviceId uint32) error {
+	sk := bpf.ServiceKey{ServiceId: serviceId}
+	sv := bpf.ServiceValue{}
+	if err := p.bpf.ServiceLookup(&sk, &sv); err != nil || sv.LbPolicy != bpf.LbPolicyMaglev {
+		return p.bpf.MaglevDelete(serviceId)
+	}
+
+	type backend struct {
+		name string
+		uid  uint32
+	}
+	var backends []backend
+	for workloadUid := range p.EndpointCache.List(serviceId) {
+		name := p.hashName.NumToStr(workloadUid)
+		// a workload occupies as many backends as its endpoint slots, so that capacity is respected
+		slot := 0
+		for ek := range p.bpf.GetEndpointKeys(workloadUid) {
+			if ek.ServiceId != serviceId {
+				continue
+			}
+			if slot == 0 {
+				backends = append(backends, backend{name: name, uid: workloadUid})
+			} else {
+				backends = append(backends, backend{name: fmt.Sprintf("%s#%d", name, slot), uid: workloadUid})
+			}
+			slot++
+		}
+	}
+	if len(backends) == 0 {
+		return p.bpf.MaglevDelete(serviceId)
+	}
+
+	// the lookup table depends on the order of the backends
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].name < backends[j].name
+	})
+	names := make([]string, 0, len(backends))
+	uids := make([]uint32, 0, len(backends))
+	for _, b := range backends {
+		names = append(names, b.name)
+		uids = append(uids, b.uid)
+	}
+	return p.bpf.MaglevUpdate(serviceId, names, uids)
+}
+
+// WatchServiceLbPolicy watches the kubernetes services, and applies the lb policy selected by their annotation.
+func (c *Controller) WatchServiceLbPolicy(client kubernetes.Interface, stopCh <-chan struct{}) {
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	serviceInformer := informerFactory.Core().V1().Services().Informer()
+
+	onChange := func(obj interface{}) {
+		svc, ok := obj.(*corev1.Service)
+		if !ok {
+			log.Errorf("expected *corev1.Service but got %T", obj)
+			return
+		}
+		policy, ok := lbPolicyFromAnnotation(svc)
+		if !c.Processor.lbPolicies.set(svc.GetNamespace(), svc.GetName(), policy, ok) {
+			return
+		}
+		c.processorMutex.Lock()
+		defer c.processorMutex.Unlock()
+		c.Processor.updateServiceLbPolicy(svc.GetNamespace(), svc.GetName())
+	}
+	_, _ = serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(_, newObj interface{}) {
+			onChange(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if svc, ok := obj.(*corev1.Service); ok {
+				// the service itself is removed through the workload api
+				c.Processor.lbPolicies.set(svc.GetNamespace(), svc.GetName(), 0, false)
+			}
+		},
+	})
+
+	informerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, serviceInformer.HasSynced) {
+		log.Error("failed to wait service cache sync")
+	}
+}
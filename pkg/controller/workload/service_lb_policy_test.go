@@ -0,0 +1,115 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/constants"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+	"kmesh.net/kmesh/pkg/controller/workload/common"
+)
+
+func TestLbPolicyFromAnnotation(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "default"}}
+	_, ok := lbPolicyFromAnnotation(svc)
+	assert.False(t, ok)
+
+	svc.Annotations = map[string]string{constants.KmeshLbPolicyAnnotation: constants.KmeshLbPolicyMaglev}
+	policy, ok := lbPolicyFromAnnotation(svc)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(bpfcache.LbPolicyMaglev), policy)
+
+	svc.Annotations[constants.KmeshLbPolicyAnnotation] = "unknown"
+	_, ok = lbPolicyFromAnnotation(svc)
+	assert.False(t, ok)
+}
+
+func TestMaglevServiceLbPolicy(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+	maglevMap := bpfcache.NewFakeMaglevMap(t)
+	defer maglevMap.Close()
+
+	p := NewProcessor(workloadMap)
+	p.bpf.SetMaglevMap(maglevMap)
+	// the annotation takes precedence over the load balancing mode of the service
+	assert.True(t, p.lbPolicies.set("default", "svc1", bpfcache.LbPolicyMaglev, true))
+
+	svc := common.CreateFakeService("svc1", "10.240.10.1", "", createLoadBalancing(workloadapi.LoadBalancing_FAILOVER,
+		[]workloadapi.LoadBalancing_Scope{workloadapi.LoadBalancing_REGION, workloadapi.LoadBalancing_ZONE}))
+	wl1 := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc1")
+	wl1.Capacity = wrapperspb.UInt32(2)
+	wl2 := createWorkload("wl2", "10.244.0.2", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z2", "s1"), "svc1")
+	wl3 := createWorkload("wl3", "10.244.0.3", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r2", "z3", "s1"), "svc1")
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc}, []*workloadapi.Workload{wl1, wl2, wl3})
+	p.syncMaglevTables()
+
+	svcId := p.hashName.Hash(svc.ResourceName())
+	wl1Id := p.hashName.Hash(wl1.ResourceName())
+	wl2Id := p.hashName.Hash(wl2.ResourceName())
+	wl3Id := p.hashName.Hash(wl3.ResourceName())
+
+	sv := bpfcache.ServiceValue{}
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcId}, &sv))
+	assert.Equal(t, uint32(bpfcache.LbPolicyMaglev), sv.LbPolicy)
+	// all the endpoints are stored with the highest priority
+	assert.Equal(t, uint32(4), sv.EndpointCount[0])
+
+	table, err := p.bpf.MaglevLookup(svcId)
+	assert.NoError(t, err)
+	counts := map[uint32]int{}
+	for _, uid := range table {
+		counts[uid]++
+	}
+	assert.Len(t, counts, 3)
+	// wl1 occupies two backends of the table by its capacity
+	assert.Greater(t, counts[wl1Id], counts[wl2Id])
+	assert.Greater(t, counts[wl1Id], counts[wl3Id])
+
+	// removing a workload only moves the entries pointing to it
+	p.removeWorkloadResources([]string{wl3.ResourceName()})
+	p.syncMaglevTables()
+	newTable, err := p.bpf.MaglevLookup(svcId)
+	assert.NoError(t, err)
+	moved := 0
+	for i, uid := range table {
+		assert.NotEqual(t, wl3Id, newTable[i])
+		if uid != wl3Id && uid != newTable[i] {
+			moved++
+		}
+	}
+	assert.Less(t, moved, len(table)/20)
+
+	// removing the annotation restores the load balancing mode of the service
+	assert.True(t, p.lbPolicies.set("default", "svc1", 0, false))
+	p.updateServiceLbPolicy("default", "svc1")
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcId}, &sv))
+	assert.Equal(t, uint32(workloadapi.LoadBalancing_FAILOVER), sv.LbPolicy)
+	_, err = p.bpf.MaglevLookup(svcId)
+	assert.ErrorIs(t, err, ebpf.ErrKeyNotExist)
+
+	hashNameClean(p)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/api/v2/workloadapi/security"
+)
+
+const (
+	// maxResourceSize is the max size in bytes of a single resource accepted
+	maxResourceSize = 64 * 1024
+	// maxServicePorts is the max number of ports of a service, the ports exceeding bpfcache.MaxPortNum
+	// take the entries of the service port map shared by all the services
+	maxServicePorts = 256
+)
+
+// resourceError is the reason why a resource of a response is rejected.
+type resourceError struct {
+	name string
+	err  error
+}
+
+func (e resourceError) String() string {
+	return fmt.Sprintf("%s: %v", e.name, e.err)
+}
+
+// newNackRequest rejects the resources of a response, the error detail is sent back to the discovery server.
+func newNackRequest(rsp *service_discovery_v3.DeltaDiscoveryResponse, rejected []resourceError) *service_discovery_v3.DeltaDiscoveryRequest {
+	req := newAckRequest(rsp)
+	messages := make([]string, 0, len(rejected))
+	for _, r := range rejected {
+		messages = append(messages, r.String())
+	}
+	req.ErrorDetail = &status.Status{
+		Code:    int32(codes.InvalidArgument),
+		Message: strings.Join(messages, "; "),
+	}
+	return req
+}
+
+func validateResourceSize(resource *service_discovery_v3.Resource) error {
+	if size := proto.Size(resource.GetResource()); size > maxResourceSize {
+		return fmt.Errorf("resource size %d exceeds %d", size, maxResourceSize)
+	}
+	return nil
+}
+
+func validateIP(ip []byte) error {
+	if _, ok := netip.AddrFromSlice(ip); !ok {
+		return fmt.Errorf("invalid ip address of %d bytes", len(ip))
+	}
+	return nil
+}
+
+func validatePort(port uint32) error {
+	if port == 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
+
+// validateWorkload checks the workload can be stored into the bpf maps.
+func validateWorkload(workload *workloadapi.Workload) error {
+	if workload.GetUid() == "" {
+		return fmt.Errorf("workload uid is empty")
+	}
+	for _, ip := range workload.GetAddresses() {
+		if err := validateIP(ip); err != nil {
+			return err
+		}
+	}
+	switch workload.GetTunnelProtocol() {
+	case workloadapi.TunnelProtocol_NONE, workloadapi.TunnelProtocol_HBONE:
+	default:
+		return fmt.Errorf("unsupported tunnel protocol %v", workload.GetTunnelProtocol())
+	}
+	for service, ports := range workload.GetServices() {
+		for _, port := range ports.GetPorts() {
+			if err := validatePort(port.GetServicePort()); err != nil {
+				return fmt.Errorf("service %s: %v", service, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateService checks the service can be stored into the bpf maps.
+func validateService(service *workloadapi.Service) error {
+	if service.GetHostname() == "" {
+		return fmt.Errorf("service hostname is empty")
+	}
+	for _, address := range service.GetAddresses() {
+		if err := validateIP(address.GetAddress()); err != nil {
+			return err
+		}
+	}
+	if len(service.GetPorts()) > maxServicePorts {
+		return fmt.Errorf("too many ports %d, exceeds %d", len(service.GetPorts()), maxServicePorts)
+	}
+	for _, port := range service.GetPorts() {
+		if err := validatePort(port.GetServicePort()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAddress(address *workloadapi.Address) error {
+	switch address.GetType().(type) {
+	case *workloadapi.Address_Workload:
+		return validateWorkload(address.GetWorkload())
+	case *workloadapi.Address_Service:
+		return validateService(address.GetService())
+	default:
+		return fmt.Errorf("unknown address type")
+	}
+}
+
+func validateAuthorization(policy *security.Authorization) error {
+	if policy.GetName() == "" {
+		return fmt.Errorf("authorization name is empty")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package workload
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"istio.io/istio/pilot/pkg/util/protoconv"
+
+	"kmesh.net/kmesh/api/v2/workloadapi"
+	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address *workloadapi.Address
+		wantErr string
+	}{
+		{
+			name:    "valid workload",
+			address: workloadToAddress(createWorkload("wl1", "10.244.0.1", "node", workloadapi.NetworkMode_STANDARD, nil, "svc1")),
+		},
+		{
+			name: "workload without uid",
+			address: workloadToAddress(&workloadapi.Workload{
+				Addresses: [][]byte{{10, 244, 0, 1}},
+			}),
+			wantErr: "uid is empty",
+		},
+		{
+			name: "bad workload address",
+			address: workloadToAddress(&workloadapi.Workload{
+				Uid:       "cluster0//Pod/default/wl1",
+				Addresses: [][]byte{{10, 244, 0}},
+			}),
+			wantErr: "invalid ip address",
+		},
+		{
+			name: "unsupported tunnel protocol",
+			address: workloadToAddress(&workloadapi.Workload{
+				Uid:            "cluster0//Pod/default/wl1",
+				TunnelProtocol: workloadapi.TunnelProtocol(100),
+			}),
+			wantErr: "unsupported tunnel protocol",
+		},
+		{
+			name: "too many service ports",
+			address: serviceToAddress(&workloadapi.Service{
+				Hostname: "svc1.default.svc.cluster.local",
+				Ports:    make([]*workloadapi.Port, maxServicePorts+1),
+			}),
+			wantErr: "too many ports",
+		},
+		{
+			name: "bad service port",
+			address: serviceToAddress(&workloadapi.Service{
+				Hostname: "svc1.default.svc.cluster.local",
+				Ports:    []*workloadapi.Port{{ServicePort: 70000}},
+			}),
+			wantErr: "invalid port",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAddress(tt.address)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessWorkloadResponseNack(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+	valid := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil)
+	invalid := &workloadapi.Workload{
+		Uid:       "cluster0//Pod/default/wl2",
+		Addresses: [][]byte{{10, 244, 0}},
+	}
+	oversize := createWorkload("wl3", "10.244.0.3", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil)
+	oversize.CanonicalName = strings.Repeat("a", maxResourceSize)
+
+	rsp := &service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl: AddressType,
+		Nonce:   "nonce",
+	}
+	for _, wl := range []*workloadapi.Workload{valid, invalid, oversize} {
+		rsp.Resources = append(rsp.Resources, &service_discovery_v3.Resource{
+			Name:     wl.ResourceName(),
+			Version:  "1",
+			Resource: protoconv.MessageToAny(workloadToAddress(wl)),
+		})
+	}
+	p.processWorkloadResponse(rsp, nil)
+
+	// the valid resource is applied, and the invalid ones are rejected
+	assert.NotNil(t, p.WorkloadCache.GetWorkloadByUid(valid.Uid))
+	assert.Nil(t, p.WorkloadCache.GetWorkloadByUid(invalid.Uid))
+	assert.Nil(t, p.WorkloadCache.GetWorkloadByUid(oversize.Uid))
+	assert.Equal(t, "nonce", p.ack.ResponseNonce)
+	assert.Equal(t, int32(codes.InvalidArgument), p.ack.ErrorDetail.GetCode())
+	assert.Contains(t, p.ack.ErrorDetail.GetMessage(), invalid.Uid)
+	assert.Contains(t, p.ack.ErrorDetail.GetMessage(), oversize.Uid)
+	assert.Equal(t, "1", p.resourceVersion(AddressType, valid.Uid))
+	assert.Equal(t, "", p.resourceVersion(AddressType, invalid.Uid))
+
+	// the next response is acked
+	rsp = &service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl:          AddressType,
+		Nonce:            "nonce2",
+		RemovedResources: []string{valid.Uid},
+	}
+	p.processWorkloadResponse(rsp, nil)
+	assert.Nil(t, p.ack.ErrorDetail)
+	assert.Nil(t, p.rejected)
+}
+
+func TestProcessWorkloadResponseFlushFailure(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+	wl := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, nil)
+	p.recordResourceVersions(&service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl:   AddressType,
+		Resources: []*service_discovery_v3.Resource{{Name: wl.ResourceName(), Version: "1"}},
+	})
+
+	patches := gomonkey.ApplyMethodReturn(p.bpf, "FlushBatch", errors.New("map is full"))
+	defer patches.Reset()
+
+	p.processWorkloadResponse(&service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl: AddressType,
+		Nonce:   "nonce",
+		Resources: []*service_discovery_v3.Resource{{
+			Name:     wl.ResourceName(),
+			Version:  "2",
+			Resource: protoconv.MessageToAny(workloadToAddress(wl)),
+		}},
+	}, nil)
+
+	// the response is rejected, and the version last accepted is kept
+	assert.Equal(t, int32(codes.InvalidArgument), p.ack.ErrorDetail.GetCode())
+	assert.Contains(t, p.ack.ErrorDetail.GetMessage(), "map is full")
+	assert.Equal(t, "1", p.resourceVersion(AddressType, wl.ResourceName()))
+}
@@ -22,11 +22,14 @@ import (
 	"sync"
 
 	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"k8s.io/client-go/tools/record"
 
+	"kmesh.net/kmesh/daemon/options"
 	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/bpf/restart"
 	bpfwl "kmesh.net/kmesh/pkg/bpf/workload"
 	"kmesh.net/kmesh/pkg/controller/telemetry"
+	bpf "kmesh.net/kmesh/pkg/controller/workload/bpfcache"
 	"kmesh.net/kmesh/pkg/logger"
 )
 
@@ -46,6 +49,10 @@ type Controller struct {
 	OperationMetricController *telemetry.BpfProgMetric
 	bpfWorkloadObj            *bpfwl.BpfWorkload
 	dnsResolverController     *dnsController
+	// processorMutex serializes the processing of xds responses, the lb policy changes of services
+	// and the ejections of outlier detection
+	processorMutex  sync.Mutex
+	outlierDetector *outlierDetector
 }
 
 func NewController(bpfWorkload *bpfwl.BpfWorkload, enableMonitoring, enablePerfMonitor bool) (*Controller, error) {
@@ -67,12 +74,23 @@ func NewController(bpfWorkload *bpfwl.BpfWorkload, enableMonitoring, enablePerfM
 		bpfWorkloadObj:        bpfWorkload,
 		dnsResolverController: dnsResolverController,
 	}
+	if maglevMap, err := bpf.LoadMaglevMap(bpfWorkload.SockConn.Info.MapPath); err != nil {
+		log.Warnf("maglev load balancing is unavailable: %v", err)
+	} else {
+		processor.bpf.SetMaglevMap(maglevMap)
+	}
+	if servicePortMap, err := bpf.LoadServicePortMap(bpfWorkload.SockConn.Info.MapPath); err != nil {
+		log.Warnf("service ports exceeding %d are unavailable: %v", bpf.MaxPortNum, err)
+	} else {
+		processor.bpf.SetServicePortMap(servicePortMap)
+	}
 	// do some initialization when restart
 	// restore endpoint index, otherwise endpoint number can double
 	if restart.GetStartType() == restart.Restart {
 		c.Processor.bpf.RestoreEndpointKeys()
 	}
 	c.Rbac = auth.NewRbac(c.Processor.WorkloadCache)
+	c.Processor.isDryRun = c.Rbac.IsDryRun
 	c.MetricController = telemetry.NewMetric(c.Processor.WorkloadCache, c.Processor.ServiceCache, enableMonitoring)
 	if enablePerfMonitor {
 		c.OperationMetricController = telemetry.NewBpfProgMetric()
@@ -81,6 +99,18 @@ func NewController(bpfWorkload *bpfwl.BpfWorkload, enableMonitoring, enablePerfM
 	return c, nil
 }
 
+// EnableMapCapacityAlerts alerts when the utilization of a bpf map crosses the configured thresholds,
+// the map metric controller is created for it if perf monitoring is disabled. It must be called before Run.
+func (c *Controller) EnableMapCapacityAlerts(config *options.MapCapacityConfig, recorder record.EventRecorder) {
+	if !config.Enabled() {
+		return
+	}
+	if c.MapMetricController == nil {
+		c.MapMetricController = telemetry.NewMapMetric()
+	}
+	c.MapMetricController.SetCapacityAlerts(config.WarningThreshold, config.CriticalThreshold, recorder)
+}
+
 func (c *Controller) Run(ctx context.Context, stopCh <-chan struct{}) error {
 	if err := c.Processor.PrepareDNSProxy(); err != nil {
 		log.Errorf("failed to prepare for dns proxy, err: %+v", err)
@@ -134,13 +164,14 @@ func (c *Controller) WorkloadStreamCreateAndSend(client discoveryv3.AggregatedDi
 		cachedWorkloads := c.Processor.WorkloadCache.List()
 		initialResourceVersions = make(map[string]string, len(cachedServices)+len(cachedWorkloads))
 
-		// add cached resource names
+		// add cached resource names with the versions received, so that the discovery server, which may be
+		// another one failed over to, only pushes the changed resources
 		for _, service := range cachedServices {
-			initialResourceVersions[service.ResourceName()] = ""
+			initialResourceVersions[service.ResourceName()] = c.Processor.resourceVersion(AddressType, service.ResourceName())
 		}
 
 		for _, workload := range cachedWorkloads {
-			initialResourceVersions[workload.ResourceName()] = ""
+			initialResourceVersions[workload.ResourceName()] = c.Processor.resourceVersion(AddressType, workload.ResourceName())
 		}
 	}
 
@@ -150,6 +181,11 @@ func (c *Controller) WorkloadStreamCreateAndSend(client discoveryv3.AggregatedDi
 	}
 
 	initialResourceVersions = c.Rbac.GetAllPolicies()
+	if c.Processor != nil {
+		for name := range initialResourceVersions {
+			initialResourceVersions[name] = c.Processor.resourceVersion(AuthorizationType, name)
+		}
+	}
 	log.Debugf("send initial request with authorization resources: %v", initialResourceVersions)
 	if err = c.Stream.Send(newDeltaRequest(AuthorizationType, nil, initialResourceVersions)); err != nil {
 		return fmt.Errorf("authorization subscribe failed, %s", err)
@@ -169,7 +205,9 @@ func (c *Controller) HandleWorkloadStream() error {
 		return fmt.Errorf("stream recv failed, %s", err)
 	}
 
+	c.processorMutex.Lock()
 	c.Processor.processWorkloadResponse(rspDelta, c.Rbac)
+	c.processorMutex.Unlock()
 
 	if err = c.Stream.Send(c.Processor.ack); err != nil {
 		return fmt.Errorf("stream send ack failed, %s", err)
@@ -184,6 +222,13 @@ func (c *Controller) HandleWorkloadStream() error {
 	return nil
 }
 
+// HandleResponse applies a response which is not received from the workload stream, such as the one loaded from local files.
+func (c *Controller) HandleResponse(rsp *discoveryv3.DeltaDiscoveryResponse) {
+	c.processorMutex.Lock()
+	defer c.processorMutex.Unlock()
+	c.Processor.processWorkloadResponse(rsp, c.Rbac)
+}
+
 func (c *Controller) SetMonitoringTrigger(enabled bool) {
 	c.MetricController.EnableMonitoring.Store(enabled)
 }
@@ -200,6 +245,22 @@ func (c *Controller) GetAccesslogTrigger() bool {
 	return c.MetricController.EnableAccesslog.Load()
 }
 
+func (c *Controller) SetAccesslogFilter(filter telemetry.AccesslogFilter) error {
+	return c.MetricController.SetAccesslogFilter(filter)
+}
+
+func (c *Controller) GetAccesslogStatus() telemetry.AccesslogStatus {
+	return c.MetricController.GetAccesslogStatus()
+}
+
+func (c *Controller) GetServiceGraph() *telemetry.ServiceGraph {
+	return c.MetricController.GetServiceGraph()
+}
+
+func (c *Controller) SubscribeConnectionEvents(bufferSize int) (<-chan telemetry.ConnectionEvent, func()) {
+	return c.MetricController.SubscribeConnectionEvents(bufferSize)
+}
+
 func (c *Controller) SetWorkloadMetricTrigger(enable bool) {
 	c.MetricController.EnableWorkloadMetric.Store(enable)
 }
@@ -65,6 +65,20 @@ type Processor struct {
 	WaypointCache cache.WaypointCache
 	locality      bpf.LocalityCache
 
+	// lb policies selected by the annotation of kubernetes services
+	lbPolicies *serviceLbPolicies
+	// services whose maglev lookup table needs to be rebuilt
+	maglevDirty map[uint32]struct{}
+	// workloads ejected by outlier detection, and the services they were ejected from
+	ejectedWorkloads map[string][]uint32
+	// versions of the resources received by type url, sent as the initial resource versions of a new stream
+	resourceVersions map[string]map[string]string
+	// resources of the response being processed which failed to be applied
+	rejected []resourceError
+	// isDryRun returns whether the authorization policy is dry-run, the dry-run policies are not in km_authz_policy,
+	// so they are left out of km_wlpolicy as well, nil if no policy is dry-run
+	isDryRun func(policyKey string) bool
+
 	once      sync.Once
 	authzOnce sync.Once
 
@@ -86,17 +100,21 @@ func NewProcessor(workloadMap bpf2go.KmeshCgroupSockWorkloadMaps) *Processor {
 	serviceCache := cache.NewServiceCache()
 
 	return &Processor{
-		hashName:      utils.NewHashName(),
-		bpf:           bpf.NewCache(workloadMap),
-		nodeName:      os.Getenv("NODE_NAME"),
-		WorkloadCache: cache.NewWorkloadCache(),
-		ServiceCache:  serviceCache,
-		EndpointCache: cache.NewEndpointCache(),
-		WaypointCache: cache.NewWaypointCache(serviceCache),
-		locality:      bpf.NewLocalityCache(),
-		addressDone:   make(chan struct{}, 1),
-		authzDone:     make(chan struct{}, 1),
-		handlers:      map[string][]func(resp *service_discovery_v3.DeltaDiscoveryResponse) error{},
+		hashName:         utils.NewHashName(),
+		bpf:              bpf.NewCache(workloadMap),
+		nodeName:         os.Getenv("NODE_NAME"),
+		WorkloadCache:    cache.NewWorkloadCache(),
+		ServiceCache:     serviceCache,
+		EndpointCache:    cache.NewEndpointCache(),
+		WaypointCache:    cache.NewWaypointCache(serviceCache),
+		locality:         bpf.NewLocalityCache(),
+		lbPolicies:       newServiceLbPolicies(),
+		maglevDirty:      make(map[uint32]struct{}),
+		ejectedWorkloads: make(map[string][]uint32),
+		resourceVersions: make(map[string]map[string]string),
+		addressDone:      make(chan struct{}, 1),
+		authzDone:        make(chan struct{}, 1),
+		handlers:         map[string][]func(resp *service_discovery_v3.DeltaDiscoveryResponse) error{},
 	}
 }
 
@@ -169,12 +187,23 @@ func (p *Processor) GetHashName() *utils.HashName {
 }
 
 func (p *Processor) processWorkloadResponse(rsp *service_discovery_v3.DeltaDiscoveryResponse, rbac *auth.Rbac) {
-	var err error
+	var err, flushErr error
+
+	start := time.Now()
+	defer func() {
+		telemetry.ObserveXdsResponseApplyDuration(rsp.GetTypeUrl(), time.Since(start))
+	}()
 
 	p.ack = newAckRequest(rsp)
 	switch rsp.GetTypeUrl() {
 	case AddressType:
+		// group all the bpf map writes of this response, so that they are applied with as few syscalls as possible
+		p.bpf.BeginBatch()
 		err = p.handleAddressTypeResponse(rsp)
+		if flushErr = p.bpf.FlushBatch(); flushErr != nil {
+			log.Errorf("flush bpf map writes of address response failed: %v", flushErr)
+		}
+		p.syncMaglevTables()
 		p.addressRespOnce.Do(func() {
 			p.addressDone <- struct{}{}
 		})
@@ -187,6 +216,23 @@ func (p *Processor) processWorkloadResponse(rsp *service_discovery_v3.DeltaDisco
 		err = fmt.Errorf("unsupported type url %s", rsp.GetTypeUrl())
 	}
 
+	rejected := p.rejected
+	if flushErr != nil {
+		// the keys failed to be written can not be traced back to the resources, so the response is rejected as a whole
+		rejected = append(rejected, resourceError{name: "all resources", err: fmt.Errorf("flush bpf maps failed: %w", flushErr)})
+	}
+	if len(rejected) > 0 {
+		// NACK the response, the resources rejected keep the versions last accepted
+		log.Warnf("reject %d resources of %s: %v", len(rejected), rsp.GetTypeUrl(), rejected)
+		p.ack = newNackRequest(rsp, rejected)
+		telemetry.IncXdsRejection(rsp.GetTypeUrl())
+	}
+	// none of the versions is recorded when the flush fails, so that the resources are sent again on reconnection
+	if flushErr == nil {
+		p.recordResourceVersions(rsp)
+	}
+	p.rejected = nil
+
 	for _, h := range p.handlers[rsp.GetTypeUrl()] {
 		err := h(rsp)
 		if err != nil {
@@ -199,6 +245,45 @@ func (p *Processor) processWorkloadResponse(rsp *service_discovery_v3.DeltaDisco
 	}
 }
 
+func (p *Processor) recordResourceVersions(rsp *service_discovery_v3.DeltaDiscoveryResponse) {
+	if p.resourceVersions == nil {
+		p.resourceVersions = make(map[string]map[string]string)
+	}
+	versions := p.resourceVersions[rsp.GetTypeUrl()]
+	if versions == nil {
+		versions = make(map[string]string)
+		p.resourceVersions[rsp.GetTypeUrl()] = versions
+	}
+	for _, resource := range rsp.GetResources() {
+		if p.isRejected(resource.GetName()) {
+			continue
+		}
+		versions[resource.GetName()] = resource.GetVersion()
+	}
+	for _, name := range rsp.GetRemovedResources() {
+		delete(versions, name)
+	}
+}
+
+// reject records a resource of the response being processed failed to be applied.
+func (p *Processor) reject(name string, err error) {
+	p.rejected = append(p.rejected, resourceError{name: name, err: err})
+}
+
+func (p *Processor) isRejected(name string) bool {
+	for _, r := range p.rejected {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceVersion returns the version of the resource received, or empty if it is unknown.
+func (p *Processor) resourceVersion(typeUrl, name string) string {
+	return p.resourceVersions[typeUrl][name]
+}
+
 // TODO: optimize me by passing workload ip directly
 func (p *Processor) deletePodFrontendData(uid uint32) error {
 	var (
@@ -259,6 +344,7 @@ func (p *Processor) removeWorkload(uid string) error {
 	}
 
 	p.WorkloadCache.DeleteWorkload(uid)
+	delete(p.ejectedWorkloads, uid)
 	telemetry.DeleteWorkloadMetric(wl)
 	return p.removeWorkloadFromBpfMap(wl)
 }
@@ -376,6 +462,9 @@ func (p *Processor) removeServiceResourceFromBpfMap(svc *workloadapi.Service, na
 		if err = p.bpf.ServiceDelete(&skDelete); err != nil {
 			log.Errorf("service map delete %s failed: %v", name, err)
 		}
+		if err = p.bpf.ServicePortsDelete(serviceId); err != nil {
+			log.Errorf("delete extra ports of service %s failed: %v", name, err)
+		}
 
 		var i uint32
 		for j := 0; j < bpf.PrioCount; j++ {
@@ -395,32 +484,88 @@ func (p *Processor) removeServiceResourceFromBpfMap(svc *workloadapi.Service, na
 		}
 	}
 	p.EndpointCache.DeleteEndpointByServiceId(serviceId)
+	p.markMaglevDirty(serviceId)
 	p.hashName.Delete(name)
 	return nil
 }
 
-// addWorkloadToService update service & endpoint bpf map when a workload has new bound services
-func (p *Processor) addWorkloadToService(sk *bpf.ServiceKey, sv *bpf.ServiceValue, workloadUid uint32, priority uint32) (bpf.EndpointKey, error) {
+// endpointWeight returns the number of endpoint slots a workload occupies in a priority group of its services.
+// The bpf prog selects a slot uniformly at random, so the workload gets new connections in proportion to its capacity.
+// If capacity is unset, it defaults to 1.
+func endpointWeight(workload *workloadapi.Workload) uint32 {
+	capacity := workload.GetCapacity().GetValue()
+	if capacity <= 1 {
+		return 1
+	}
+	if capacity > bpf.MaxEndpointWeight {
+		log.Warnf("capacity %d of workload %s exceeds the max endpoint weight %d", capacity, workload.ResourceName(), bpf.MaxEndpointWeight)
+		return bpf.MaxEndpointWeight
+	}
+	return capacity
+}
+
+// addWorkloadToService update service & endpoint bpf map when a workload has new bound services,
+// the workload is stored in `weight` endpoint slots of the priority group.
+func (p *Processor) addWorkloadToService(sk *bpf.ServiceKey, sv *bpf.ServiceValue, workloadUid uint32, priority uint32, weight uint32) error {
 	var (
 		ek = bpf.EndpointKey{}
 		ev = bpf.EndpointValue{}
 	)
 
-	sv.EndpointCount[priority]++
-	ek.BackendIndex = sv.EndpointCount[priority]
 	ek.ServiceId = sk.ServiceId
 	ek.Prio = priority
 	ev.BackendUid = workloadUid
-	if err := p.bpf.EndpointUpdate(&ek, &ev); err != nil {
-		log.Errorf("Update endpoint map failed, err:%s", err)
-		return ek, err
+	for i := uint32(0); i < weight; i++ {
+		sv.EndpointCount[priority]++
+		ek.BackendIndex = sv.EndpointCount[priority]
+		if err := p.bpf.EndpointUpdate(&ek, &ev); err != nil {
+			log.Errorf("Update endpoint map failed, err:%s", err)
+			return err
+		}
 	}
 	p.EndpointCache.AddEndpointToService(cache.Endpoint{ServiceId: ek.ServiceId, Prio: ek.Prio, BackendIndex: ek.BackendIndex}, ev.BackendUid)
+	p.markMaglevDirty(sk.ServiceId)
 	if err := p.bpf.ServiceUpdate(sk, sv); err != nil {
 		log.Errorf("Update ServiceUpdate map failed, err:%s", err)
-		return ek, err
+		return err
+	}
+	return nil
+}
+
+// updateWorkloadEndpointWeight adds or removes endpoint slots of the workload in its bound services when its capacity changed.
+func (p *Processor) updateWorkloadEndpointWeight(workload *workloadapi.Workload) error {
+	workloadUid := p.hashName.Hash(workload.GetUid())
+	weight := endpointWeight(workload)
+
+	// endpoint slots by service id, all the slots of a workload in a service have the same priority
+	slots := make(map[uint32][]bpf.EndpointKey)
+	for ek := range p.bpf.GetEndpointKeys(workloadUid) {
+		slots[ek.ServiceId] = append(slots[ek.ServiceId], ek)
+	}
+
+	for serviceId, eks := range slots {
+		current := uint32(len(eks))
+		if current == weight {
+			continue
+		}
+
+		if current < weight {
+			sk := bpf.ServiceKey{ServiceId: serviceId}
+			sv := bpf.ServiceValue{}
+			if err := p.bpf.ServiceLookup(&sk, &sv); err != nil {
+				return fmt.Errorf("lookup service %d failed: %v", serviceId, err)
+			}
+			if err := p.addWorkloadToService(&sk, &sv, workloadUid, eks[0].Prio, weight-current); err != nil {
+				return fmt.Errorf("add endpoint slots of workload %d to service %d failed: %v", workloadUid, serviceId, err)
+			}
+			continue
+		}
+
+		if err := p.deleteEndpointRecords(eks[:current-weight]); err != nil {
+			return fmt.Errorf("delete endpoint slots of workload %d from service %d failed: %v", workloadUid, serviceId, err)
+		}
 	}
-	return ek, nil
+	return nil
 }
 
 // handleWorkloadUnboundServices handles when a workload's belonging services removed
@@ -446,13 +591,14 @@ func (p *Processor) handleWorkloadNewBoundServices(workload *workloadapi.Workloa
 
 	log.Debugf("handleWorkloadNewBoundServices %s: %v", workload.ResourceName(), newServices)
 	workloadId := p.hashName.Hash(workload.GetUid())
+	weight := endpointWeight(workload)
 	for _, svcUid := range newServices {
 		sk.ServiceId = svcUid
 		// the service already stored in map, add endpoint
 		if err := p.bpf.ServiceLookup(&sk, &sv); err == nil {
-			if sv.LbPolicy == uint32(workloadapi.LoadBalancing_UNSPECIFIED_MODE) { // random mode
-				// In random mode, we save all workload to max priority group
-				if _, err = p.addWorkloadToService(&sk, &sv, workloadId, 0); err != nil {
+			if !isLocalityLbPolicy(sv.LbPolicy) { // random or maglev mode
+				// In random and maglev mode, we save all workload to max priority group
+				if err = p.addWorkloadToService(&sk, &sv, workloadId, 0, weight); err != nil {
 					log.Errorf("addWorkloadToService workload %d service %d failed: %v", workloadId, sk.ServiceId, err)
 					return err
 				}
@@ -468,7 +614,7 @@ func (p *Processor) handleWorkloadNewBoundServices(workload *workloadapi.Workloa
 						// when locality is eventually set
 						prio = 0
 					}
-					if _, err = p.addWorkloadToService(&sk, &sv, workloadId, prio); err != nil {
+					if err = p.addWorkloadToService(&sk, &sv, workloadId, prio, weight); err != nil {
 						log.Errorf("addWorkloadToService workload %d service %d priority %d failed: %v", workloadId, sk.ServiceId, prio, err)
 						return err
 					}
@@ -549,6 +695,25 @@ func (p *Processor) getServiceByAddress(address []byte) *workloadapi.Service {
 	return nil
 }
 
+// updateWorkloadEndpoints keeps the endpoints of the workload consistent with its bound services and capacity.
+func (p *Processor) updateWorkloadEndpoints(workload *workloadapi.Workload) error {
+	unboundedEndpointKeys, newServices := p.compareWorkloadServices(workload)
+	if err := p.handleWorkloadUnboundServices(workload, unboundedEndpointKeys); err != nil {
+		return fmt.Errorf("handleWorkloadUnboundServices %s failed: %v", workload.ResourceName(), err)
+	}
+
+	// Add new services associated with the workload
+	if err := p.handleWorkloadNewBoundServices(workload, newServices); err != nil {
+		return fmt.Errorf("handleWorkloadNewBoundServices %s failed: %v", workload.ResourceName(), err)
+	}
+
+	// Keep the endpoint slots of the workload consistent with its capacity
+	if err := p.updateWorkloadEndpointWeight(workload); err != nil {
+		return fmt.Errorf("updateWorkloadEndpointWeight %s failed: %v", workload.ResourceName(), err)
+	}
+	return nil
+}
+
 func (p *Processor) handleWorkload(workload *workloadapi.Workload) error {
 	log.Debugf("handle workload: %s", workload.ResourceName())
 
@@ -586,15 +751,12 @@ func (p *Processor) handleWorkload(workload *workloadapi.Workload) error {
 		return fmt.Errorf("updateWorkloadInBackendMap %s failed: %v", workload.Uid, err)
 	}
 
-	// 2~3. update workload in endpoint map and service map
-	unboundedEndpointKeys, newServices := p.compareWorkloadServices(workload)
-	if err := p.handleWorkloadUnboundServices(workload, unboundedEndpointKeys); err != nil {
-		return fmt.Errorf("handleWorkloadUnboundServices %s failed: %v", workload.ResourceName(), err)
-	}
-
-	// Add new services associated with the workload
-	if err := p.handleWorkloadNewBoundServices(workload, newServices); err != nil {
-		return fmt.Errorf("handleWorkloadNewBoundServices %s failed: %v", workload.ResourceName(), err)
+	// 2~3. update workload in endpoint map and service map,
+	// an ejected workload is added back to its services when it is readmitted
+	if !p.isWorkloadEjected(workload.GetUid()) {
+		if err := p.updateWorkloadEndpoints(workload); err != nil {
+			return err
+		}
 	}
 
 	// 4. update workload in frontend map
@@ -658,44 +820,35 @@ func (p *Processor) updateServiceFrontendMap(serviceId uint32, service *workload
 	return nil
 }
 
-func (p *Processor) updateEndpointOneByOne(serviceId uint32, epsUpdate []cache.Endpoint, toLLb bool) error {
-	if len(epsUpdate) == 0 {
+func (p *Processor) updateEndpointOneByOne(serviceId uint32, workloadUids []uint32, toLLb bool) error {
+	if len(workloadUids) == 0 {
 		return nil
 	}
 
-	// When calling deleteEndpointRecords, it causes the endpoint to be updated and swaps it with the endpoint
-	// with the highest BackendIndex in the priority, leading to a Endpoint shift. Therefore, we
-	// sort Endpoint slice in reverse order to ensure that the BackendIndex of the endpoints updated later
-	// remains unchanged.
-	sort.Slice(epsUpdate, func(i, j int) bool {
-		if epsUpdate[i].Prio == epsUpdate[j].Prio {
-			return epsUpdate[i].BackendIndex > epsUpdate[j].BackendIndex
-		}
-		return epsUpdate[i].Prio > epsUpdate[j].Prio
-	})
-
 	service := p.ServiceCache.GetService(p.hashName.NumToStr(serviceId))
 
-	for _, ep := range epsUpdate {
-		ek := bpf.EndpointKey{
-			ServiceId:    ep.ServiceId,
-			Prio:         ep.Prio,
-			BackendIndex: ep.BackendIndex,
+	for _, workloadUid := range workloadUids {
+		// When calling deleteEndpointRecords, the endpoint with the highest BackendIndex in the priority is swapped
+		// into the deleted position, so we always get the current endpoint slots of the workload from the index.
+		var eks []bpf.EndpointKey
+		for ek := range p.bpf.GetEndpointKeys(workloadUid) {
+			if ek.ServiceId == serviceId {
+				eks = append(eks, ek)
+			}
 		}
-		ev := bpf.EndpointValue{}
-		if err := p.bpf.EndpointLookup(&ek, &ev); err != nil { // get backend Uid
-			return fmt.Errorf("lookup endpoint %#v failed: %v", ek, err)
+		if len(eks) == 0 {
+			continue
 		}
 
 		// Calc Priority
 		var prio uint32 = 0
 		if toLLb {
-			workload := p.WorkloadCache.GetWorkloadByUid(p.hashName.NumToStr(ev.BackendUid))
+			workload := p.WorkloadCache.GetWorkloadByUid(p.hashName.NumToStr(workloadUid))
 			prio = p.locality.CalcLocalityLBPrio(workload, service.LoadBalancing.GetRoutingPreference())
 		}
 
 		// If an endpoint's priority is not changed, we donot need to update the map.
-		if ek.Prio == prio {
+		if eks[0].Prio == prio {
 			continue
 		}
 
@@ -706,14 +859,13 @@ func (p *Processor) updateEndpointOneByOne(serviceId uint32, epsUpdate []cache.E
 			return fmt.Errorf("lookup service %v failed: %v", serviceId, err)
 		}
 
-		// add ek first to another priority group
-		if _, err := p.addWorkloadToService(&sKey, &sValue, ev.BackendUid, prio); err != nil {
-			return fmt.Errorf("update endpoint %d priority to %d failed: %v", ev.BackendUid, prio, err)
+		// add the endpoint slots first to another priority group
+		if err := p.addWorkloadToService(&sKey, &sValue, workloadUid, prio, uint32(len(eks))); err != nil {
+			return fmt.Errorf("update endpoint %d priority to %d failed: %v", workloadUid, prio, err)
 		}
-		epKeys := []bpf.EndpointKey{ek}
-		// delete ek from old priority group
-		if err := p.deleteEndpointRecords(epKeys); err != nil {
-			return fmt.Errorf("delete endpoint %d from old priority group %d failed: %v", ev.BackendUid, ek.Prio, err)
+		// delete the endpoint slots from old priority group
+		if err := p.deleteEndpointRecords(eks); err != nil {
+			return fmt.Errorf("delete endpoint %d from old priority group %d failed: %v", workloadUid, eks[0].Prio, err)
 		}
 	}
 	return nil
@@ -725,18 +877,14 @@ func (p *Processor) updateEndpointOneByOne(serviceId uint32, epsUpdate []cache.E
 // it represents a random strategy, in which case we just set the priority to 0.
 func (p *Processor) updateEndpointPriority(serviceId uint32, toLLb bool) error {
 	endpoints := p.EndpointCache.List(serviceId)
-	endpointSlice := make([]cache.Endpoint, 0, len(endpoints))
-	for _, endpoint := range endpoints {
-		endpointSlice = append(endpointSlice, endpoint)
-	}
-	if toLLb {
-		return p.updateEndpointOneByOne(serviceId, endpointSlice, toLLb)
-	} else {
-		filtered := slices.Filter(endpointSlice, func(e cache.Endpoint) bool {
-			return e.Prio > 0
-		})
-		return p.updateEndpointOneByOne(serviceId, filtered, toLLb)
+	workloadUids := make([]uint32, 0, len(endpoints))
+	for workloadUid, endpoint := range endpoints {
+		// when transit to random, only the endpoints not in the highest priority group need to be updated
+		if toLLb || endpoint.Prio > 0 {
+			workloadUids = append(workloadUids, workloadUid)
+		}
 	}
+	return p.updateEndpointOneByOne(serviceId, workloadUids, toLLb)
 }
 
 func (p *Processor) updateServiceMap(service, oldService *workloadapi.Service) error {
@@ -747,32 +895,46 @@ func (p *Processor) updateServiceMap(service, oldService *workloadapi.Service) e
 	serviceName := service.ResourceName()
 	waypoint := service.Waypoint
 	ports := service.Ports
-	lb := service.LoadBalancing
 
 	sk.ServiceId = p.hashName.Hash(serviceName)
-	newServiceInfo.LbPolicy = uint32(lb.GetMode()) // set loadbalance mode
+	newServiceInfo.LbPolicy = p.serviceLbPolicy(service) // set loadbalance mode
 
 	if waypoint != nil && waypoint.GetAddress() != nil {
 		nets.CopyIpByteFromSlice(&newServiceInfo.WaypointAddr, waypoint.GetAddress().Address)
 		newServiceInfo.WaypointPort = nets.ConvertPortToBigEndian(waypoint.GetHboneMtlsPort())
 	}
 
+	// the ports exceeding MaxPortNum are stored in the service port map
+	extraPorts := make(map[uint32]uint32)
 	for i, port := range ports {
-		if i >= bpf.MaxPortNum {
-			log.Warnf("exceed the max port count, current only support maximum of 10 ports, service: %s", serviceName)
-			break
-		}
-
-		newServiceInfo.ServicePort[i] = nets.ConvertPortToBigEndian(port.ServicePort)
+		servicePort := nets.ConvertPortToBigEndian(port.ServicePort)
+		var targetPort uint32
 		if strings.Contains(serviceName, "waypoint") {
-			newServiceInfo.TargetPort[i] = nets.ConvertPortToBigEndian(KmeshWaypointPort)
+			targetPort = nets.ConvertPortToBigEndian(KmeshWaypointPort)
 		} else if port.TargetPort == 0 {
 			// NOTE: Target port could be unset in service entry, in which case it should
 			// be consistent with the Service Port.
-			newServiceInfo.TargetPort[i] = nets.ConvertPortToBigEndian(port.ServicePort)
+			targetPort = nets.ConvertPortToBigEndian(port.ServicePort)
 		} else {
-			newServiceInfo.TargetPort[i] = nets.ConvertPortToBigEndian(port.TargetPort)
+			targetPort = nets.ConvertPortToBigEndian(port.TargetPort)
 		}
+
+		if i >= bpf.MaxPortNum {
+			extraPorts[servicePort] = targetPort
+			continue
+		}
+		newServiceInfo.ServicePort[i] = servicePort
+		newServiceInfo.TargetPort[i] = targetPort
+	}
+
+	// store the extra ports before the service, so that they can be found once the service is visible
+	dropped, err := p.bpf.ServicePortsUpdate(sk.ServiceId, extraPorts)
+	if err != nil {
+		log.Errorf("update extra ports of service %s failed: %v", serviceName, err)
+	}
+	if len(dropped) > 0 {
+		log.Errorf("%d of %d ports of service %s are dropped, connections to them will fail", len(dropped), len(ports), serviceName)
+		telemetry.AddServicePortsDropped(serviceName, len(dropped))
 	}
 
 	if err := p.bpf.ServiceLookup(&sk, &oldServiceInfo); err == nil {
@@ -782,8 +944,11 @@ func (p *Processor) updateServiceMap(service, oldService *workloadapi.Service) e
 		newServiceInfo.EndpointCount = oldServiceInfo.EndpointCount
 		// if it is a policy update
 		if newServiceInfo.LbPolicy != oldServiceInfo.LbPolicy {
-			// transit from locality loadbalance to random
-			if newServiceInfo.LbPolicy == uint32(workloadapi.LoadBalancing_UNSPECIFIED_MODE) {
+			if newServiceInfo.LbPolicy == bpf.LbPolicyMaglev || oldServiceInfo.LbPolicy == bpf.LbPolicyMaglev {
+				p.markMaglevDirty(sk.ServiceId)
+			}
+			// transit from locality loadbalance to random or maglev
+			if !isLocalityLbPolicy(newServiceInfo.LbPolicy) && isLocalityLbPolicy(oldServiceInfo.LbPolicy) {
 				// In locality load balancing mode, the workloads are stored according to the calculated corresponding priorities.
 				// When switching from locality load balancing mode to random, we first update the endpoint map, as at this point,
 				// there might not be any workload with the highest priority, and directly switching the service's LB policy could
@@ -800,8 +965,8 @@ func (p *Processor) updateServiceMap(service, oldService *workloadapi.Service) e
 					return fmt.Errorf("service map update failed: %v", err)
 				}
 				return nil
-			} else if oldServiceInfo.LbPolicy == uint32(workloadapi.LoadBalancing_UNSPECIFIED_MODE) {
-				// from random to locality loadbalance
+			} else if isLocalityLbPolicy(newServiceInfo.LbPolicy) && !isLocalityLbPolicy(oldServiceInfo.LbPolicy) {
+				// from random or maglev to locality loadbalance
 				// In random mode, the workloads are stored with the highest priority. When switching from random mode to locality
 				// load balancing, we first update the service map to quickly initiate the transition of the strategy. Subsequently,
 				// we update the endpoint map. During this update process, the load balancer may briefly exhibit abnormal random behavior,
@@ -904,14 +1069,23 @@ func (p *Processor) handleRemovedAddresses(removed []string) {
 }
 
 func (p *Processor) handleAddressTypeResponse(rsp *service_discovery_v3.DeltaDiscoveryResponse) error {
-	var err error
 	// sort resources, first process services, then workload
 	var services []*workloadapi.Service
 	var workloads []*workloadapi.Workload
 
 	for _, resource := range rsp.GetResources() {
 		address := &workloadapi.Address{}
-		if err = anypb.UnmarshalTo(resource.Resource, address, proto.UnmarshalOptions{}); err != nil {
+		if err := validateResourceSize(resource); err != nil {
+			p.reject(resource.GetName(), err)
+			continue
+		}
+		if err := anypb.UnmarshalTo(resource.Resource, address, proto.UnmarshalOptions{}); err != nil {
+			p.reject(resource.GetName(), err)
+			continue
+		}
+		// an invalid resource is rejected, the last one accepted is kept
+		if err := validateAddress(address); err != nil {
+			p.reject(resource.GetName(), err)
 			continue
 		}
 		switch address.GetType().(type) {
@@ -928,15 +1102,31 @@ func (p *Processor) handleAddressTypeResponse(rsp *service_discovery_v3.DeltaDis
 
 	p.handleRemovedAddresses(rsp.RemovedResources)
 	p.once.Do(p.handleRemovedAddressesDuringRestart)
-	return err
+	return nil
 }
 
 // Mainly for the convenience of testing.
 func (p *Processor) handleServicesAndWorkloads(services []*workloadapi.Service, workloads []*workloadapi.Workload) {
 	var servicesToRefresh []*workloadapi.Service
+	// the resources of the response, the deferred ones not in it were accepted by earlier responses,
+	// so their failures do not reject this one.
+	received := sets.New[string]()
+	for _, service := range services {
+		received.Insert(service.ResourceName())
+	}
+	for _, workload := range workloads {
+		received.Insert(workload.ResourceName())
+	}
+	rejectOrLog := func(name string, err error) {
+		if received.Contains(name) {
+			p.reject(name, err)
+			return
+		}
+		log.Errorf("handle deferred resource %s failed, err: %v", name, err)
+	}
 	for _, service := range services {
 		if err := p.handleService(service); err != nil {
-			log.Errorf("handle service %v failed, err: %v", service.ResourceName(), err)
+			p.reject(service.ResourceName(), err)
 		}
 		svcs, wls := p.WaypointCache.Refresh(service)
 		servicesToRefresh = append(servicesToRefresh, svcs...)
@@ -947,7 +1137,7 @@ func (p *Processor) handleServicesAndWorkloads(services []*workloadapi.Service,
 	// Handle services that are deferred due to waypoint hostname resolution.
 	for _, service := range servicesToRefresh {
 		if err := p.handleService(service); err != nil {
-			log.Errorf("handle deferred service %v failed, err: %v", service.ResourceName(), err)
+			rejectOrLog(service.ResourceName(), err)
 		}
 	}
 
@@ -982,7 +1172,7 @@ func (p *Processor) handleServicesAndWorkloads(services []*workloadapi.Service,
 		}
 
 		if err := p.handleWorkload(workload); err != nil {
-			log.Errorf("handle workload %s failed, err: %v", workload.ResourceName(), err)
+			rejectOrLog(workload.ResourceName(), err)
 		}
 	}
 }
@@ -1036,18 +1226,27 @@ func (p *Processor) handleAuthorizationTypeResponse(rsp *service_discovery_v3.De
 	// update resource
 	for _, resource := range rsp.GetResources() {
 		authPolicy := &security_v2.Authorization{}
+		if err := validateResourceSize(resource); err != nil {
+			p.reject(resource.GetName(), err)
+			continue
+		}
 		if err := anypb.UnmarshalTo(resource.Resource, authPolicy, proto.UnmarshalOptions{}); err != nil {
-			log.Errorf("unmarshal failed, err: %v", err)
+			p.reject(resource.GetName(), err)
+			continue
+		}
+		if err := validateAuthorization(authPolicy); err != nil {
+			p.reject(resource.GetName(), err)
 			continue
 		}
 		log.Debugf("handle authorization policy %s, auth %s", resource.GetName(), authPolicy.String())
 		if err := rbac.UpdatePolicy(authPolicy); err != nil {
-			return err
+			p.reject(resource.GetName(), err)
+			continue
 		}
 
 		policyKey := authPolicy.ResourceName()
-		if err := maps_v2.AuthorizationUpdate(p.hashName.Hash(policyKey), authPolicy); err != nil {
-			return fmt.Errorf("AuthorizationUpdate %s failed %v ", policyKey, err)
+		if err := p.syncAuthorization(policyKey, authPolicy, rbac.IsDryRun(policyKey)); err != nil {
+			p.reject(resource.GetName(), fmt.Errorf("sync authorization %s failed %v ", policyKey, err))
 		}
 	}
 
@@ -1127,7 +1326,7 @@ func (p *Processor) deleteEndpointRecords(endpointKeys []bpf.EndpointKey) error
 				log.Errorf("deleteEndpoint failed: %v", err)
 				continue
 			}
-			p.EndpointCache.DeleteEndpointWithPriority(ek.ServiceId, ev.BackendUid, ek.Prio)
+			p.deleteEndpointFromCache(ek.ServiceId, ev.BackendUid, ek.Prio)
 		} else {
 			// service not exist, we should also delete the endpoint
 			log.Warnf("service %d not found, should not occur: %v", ek.ServiceId, err)
@@ -1141,12 +1340,24 @@ func (p *Processor) deleteEndpointRecords(endpointKeys []bpf.EndpointKey) error
 				log.Errorf("EndpointDelete [%#v] failed: %v", ek, err)
 				continue
 			}
-			p.EndpointCache.DeleteEndpointWithPriority(ek.ServiceId, ev.BackendUid, ek.Prio)
+			p.deleteEndpointFromCache(ek.ServiceId, ev.BackendUid, ek.Prio)
 		}
 	}
 	return nil
 }
 
+// deleteEndpointFromCache deletes the endpoint from EndpointCache once the workload has no endpoint slot left
+// in the priority group of the service.
+func (p *Processor) deleteEndpointFromCache(serviceId, workloadUid, prio uint32) {
+	p.markMaglevDirty(serviceId)
+	for ek := range p.bpf.GetEndpointKeys(workloadUid) {
+		if ek.ServiceId == serviceId && ek.Prio == prio {
+			return
+		}
+	}
+	p.EndpointCache.DeleteEndpointWithPriority(serviceId, workloadUid, prio)
+}
+
 // In order to make sure the bpf prog can always get the healthy endpoint, we should update the bpf map in the following order:
 // 1. replace the current endpoint with the last endpoint
 // 2. update the service map's endpoint count
@@ -1184,7 +1395,19 @@ func (p *Processor) storeWorkloadPolicies(uid string, polices []string) {
 		return
 	}
 	key.WorklodId = p.hashName.Hash(uid)
-	for i, v := range polices {
+	// the xdp prog stops walking the policies of the workload at the first one not found in km_authz_policy,
+	// so a dry-run policy left in the list would disable the enforced policies after it
+	enforced := make([]string, 0, len(polices))
+	for _, v := range polices {
+		if p.isDryRun == nil || !p.isDryRun(v) {
+			enforced = append(enforced, v)
+		}
+	}
+	if len(enforced) == 0 {
+		p.deleteWorkloadPolicies(key.WorklodId)
+		return
+	}
+	for i, v := range enforced {
 		if i < len(value.PolicyIds) {
 			value.PolicyIds[i] = p.hashName.Hash(v)
 		} else {
@@ -17,13 +17,16 @@
 package workload
 
 import (
+	"errors"
 	"net/netip"
 	"os"
 	"testing"
 
+	"github.com/agiledragon/gomonkey/v2"
 	service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"istio.io/istio/pilot/pkg/util/protoconv"
 	"istio.io/istio/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/rand"
@@ -226,6 +229,33 @@ func Test_handleWaypointWithHostname(t *testing.T) {
 	checkBackendMap(t, p, wl3ID, wl3)
 }
 
+func Test_handleDeferredServiceFailure(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	p := NewProcessor(workloadMap)
+
+	// svc2 is deferred as its waypoint hostname is not resolved.
+	svc2 := common.CreateFakeService("svc2", "10.240.10.2", "default/waypoint.default.svc.cluster.local", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, make([]workloadapi.LoadBalancing_Scope, 0)))
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc2}, nil)
+	assert.Nil(t, p.rejected)
+
+	patches := gomonkey.ApplyPrivateMethod(p, "handleService", func(_ *Processor, service *workloadapi.Service) error {
+		if service.ResourceName() == svc2.ResourceName() {
+			return errors.New("bpf map is full")
+		}
+		return nil
+	})
+	defer patches.Reset()
+
+	// The failure of the deferred svc2 does not reject the response resolving its waypoint.
+	waypointsvc := common.CreateFakeService("waypoint", "10.240.10.3", "", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, make([]workloadapi.LoadBalancing_Scope, 0)))
+	p.handleServicesAndWorkloads([]*workloadapi.Service{waypointsvc}, nil)
+	assert.Nil(t, p.rejected)
+
+	// The failure of a service of the response rejects it.
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc2}, nil)
+	assert.True(t, p.isRejected(svc2.ResourceName()))
+}
+
 func Test_hostnameNetworkMode(t *testing.T) {
 	workloadMap := bpfcache.NewFakeWorkloadMap(t)
 	p := NewProcessor(workloadMap)
@@ -851,3 +881,185 @@ func TestLocalityLBWithNilLocalityInfo(t *testing.T) {
 
 	hashNameClean(p)
 }
+
+func TestProcessAddressResponseInBatch(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+
+	svc1 := common.CreateFakeService("svc1", "10.240.10.1", "", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, make([]workloadapi.LoadBalancing_Scope, 0)))
+	svc2 := common.CreateFakeService("svc2", "10.240.10.2", "", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, make([]workloadapi.LoadBalancing_Scope, 0)))
+	wl1 := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc1", "svc2")
+	wl2 := createWorkload("wl2", "10.244.0.2", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc2")
+	wl3 := createWorkload("wl3", "10.244.0.3", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc2")
+
+	res := &service_discovery_v3.DeltaDiscoveryResponse{TypeUrl: AddressType}
+	for _, addr := range []*workloadapi.Address{serviceToAddress(svc1), serviceToAddress(svc2), workloadToAddress(wl1), workloadToAddress(wl2), workloadToAddress(wl3)} {
+		res.Resources = append(res.Resources, &service_discovery_v3.Resource{
+			Resource: protoconv.MessageToAny(addr),
+		})
+	}
+	p.processWorkloadResponse(res, nil)
+
+	assert.Equal(t, 5, p.bpf.FrontendCount())
+	assert.Equal(t, 2, p.bpf.ServiceCount())
+	assert.Equal(t, 4, p.bpf.EndpointCount())
+	assert.Equal(t, 3, p.bpf.BackendCount())
+	checkServiceMap(t, p, p.hashName.Hash(svc1.ResourceName()), svc1, 0, 1)
+	checkServiceMap(t, p, p.hashName.Hash(svc2.ResourceName()), svc2, 0, 3)
+	checkEndpointMap(t, p, svc2, []uint32{p.hashName.Hash(wl1.ResourceName()), p.hashName.Hash(wl2.ResourceName()), p.hashName.Hash(wl3.ResourceName())})
+
+	// remove a workload and a service in one response
+	res = &service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl:          AddressType,
+		RemovedResources: []string{wl1.ResourceName(), svc1.ResourceName()},
+	}
+	p.processWorkloadResponse(res, nil)
+
+	assert.Equal(t, 3, p.bpf.FrontendCount())
+	assert.Equal(t, 1, p.bpf.ServiceCount())
+	assert.Equal(t, 2, p.bpf.EndpointCount())
+	assert.Equal(t, 2, p.bpf.BackendCount())
+	checkServiceMap(t, p, p.hashName.Hash(svc2.ResourceName()), svc2, 0, 2)
+	checkEndpointMap(t, p, svc2, []uint32{p.hashName.Hash(wl2.ResourceName()), p.hashName.Hash(wl3.ResourceName())})
+	checkNotExistInFrontEndMap(t, wl1.Addresses[0], p)
+	checkNotExistInFrontEndMap(t, svc1.Addresses[0].Address, p)
+
+	hashNameClean(p)
+}
+
+func TestWorkloadCapacityWeight(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+
+	svc := common.CreateFakeService("svc1", "10.240.10.1", "", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, make([]workloadapi.LoadBalancing_Scope, 0)))
+	wl1 := createWorkload("wl1", "10.244.0.1", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc1")
+	wl1.Capacity = wrapperspb.UInt32(3)
+	wl2 := createWorkload("wl2", "10.244.0.2", os.Getenv("NODE_NAME"), workloadapi.NetworkMode_STANDARD, createLocality("r1", "z1", "s1"), "svc1")
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc}, []*workloadapi.Workload{wl1, wl2})
+
+	svcId := p.hashName.Hash(svc.ResourceName())
+	wl1Id := p.hashName.Hash(wl1.ResourceName())
+	wl2Id := p.hashName.Hash(wl2.ResourceName())
+	checkWeights := func(weights map[uint32]int) {
+		count := 0
+		got := map[uint32]int{}
+		for _, ev := range p.bpf.GetAllEndpointsForService(svcId) {
+			got[ev.BackendUid]++
+			count++
+		}
+		assert.Equal(t, weights, got)
+		checkServiceMap(t, p, svcId, svc, 0, uint32(count))
+		for uid, weight := range weights {
+			assert.Equal(t, weight, p.bpf.GetEndpointKeys(uid).Len())
+		}
+	}
+	checkWeights(map[uint32]int{wl1Id: 3, wl2Id: 1})
+
+	// decrease capacity
+	wl1 = proto.Clone(wl1).(*workloadapi.Workload)
+	wl1.Capacity = wrapperspb.UInt32(2)
+	p.handleServicesAndWorkloads(nil, []*workloadapi.Workload{wl1})
+	checkWeights(map[uint32]int{wl1Id: 2, wl2Id: 1})
+
+	// increase capacity
+	wl2 = proto.Clone(wl2).(*workloadapi.Workload)
+	wl2.Capacity = wrapperspb.UInt32(4)
+	p.handleServicesAndWorkloads(nil, []*workloadapi.Workload{wl2})
+	checkWeights(map[uint32]int{wl1Id: 2, wl2Id: 4})
+
+	// capacity is capped by the max endpoint weight
+	wl1 = proto.Clone(wl1).(*workloadapi.Workload)
+	wl1.Capacity = wrapperspb.UInt32(bpfcache.MaxEndpointWeight + 1)
+	p.handleServicesAndWorkloads(nil, []*workloadapi.Workload{wl1})
+	checkWeights(map[uint32]int{wl1Id: bpfcache.MaxEndpointWeight, wl2Id: 4})
+
+	// remove a weighted workload
+	p.removeWorkloadResources([]string{wl1.ResourceName()})
+	checkWeights(map[uint32]int{wl2Id: 4})
+	assert.Equal(t, 1, len(p.EndpointCache.List(svcId)))
+
+	hashNameClean(p)
+}
+
+func TestServiceWithManyPorts(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+	servicePortMap := bpfcache.NewFakeServicePortMap(t)
+	defer servicePortMap.Close()
+
+	p := NewProcessor(workloadMap)
+	p.bpf.SetServicePortMap(servicePortMap)
+
+	newPorts := func(count int) []*workloadapi.Port {
+		ports := make([]*workloadapi.Port, 0, count)
+		for i := 0; i < count; i++ {
+			ports = append(ports, &workloadapi.Port{ServicePort: uint32(9000 + i), TargetPort: uint32(19000 + i)})
+		}
+		return ports
+	}
+	checkExtraPorts := func(svcId uint32, count int) {
+		keys, values := p.bpf.ServicePortLookupAll()
+		assert.Len(t, keys, count)
+		for i, k := range keys {
+			assert.Equal(t, svcId, k.ServiceId)
+			servicePort := nets.ConvertPortToLittleEndian(k.ServicePort)
+			assert.GreaterOrEqual(t, servicePort, uint32(9000+bpfcache.MaxPortNum))
+			assert.Equal(t, servicePort+10000, nets.ConvertPortToLittleEndian(values[i].TargetPort))
+		}
+	}
+
+	svc := common.CreateFakeService("svc1", "10.240.10.1", "", createLoadBalancing(workloadapi.LoadBalancing_UNSPECIFIED_MODE, nil))
+	svc.Ports = newPorts(15)
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc}, nil)
+
+	svcId := p.hashName.Hash(svc.ResourceName())
+	sv := bpfcache.ServiceValue{}
+	assert.NoError(t, p.bpf.ServiceLookup(&bpfcache.ServiceKey{ServiceId: svcId}, &sv))
+	for i := 0; i < bpfcache.MaxPortNum; i++ {
+		assert.Equal(t, uint32(9000+i), nets.ConvertPortToLittleEndian(sv.ServicePort[i]))
+	}
+	checkExtraPorts(svcId, 5)
+
+	// the stale ports are removed when the service shrinks
+	svc = proto.Clone(svc).(*workloadapi.Service)
+	svc.Ports = newPorts(12)
+	p.handleServicesAndWorkloads([]*workloadapi.Service{svc}, nil)
+	checkExtraPorts(svcId, 2)
+
+	p.removeServiceResourceFromBpfMap(svc, svc.ResourceName())
+	checkExtraPorts(svcId, 0)
+
+	// the extra ports are dropped if the service port map is unavailable
+	p.bpf.SetServicePortMap(nil)
+	dropped, err := p.bpf.ServicePortsUpdate(svcId, map[uint32]uint32{1: 1})
+	assert.Error(t, err)
+	assert.Equal(t, []uint32{1}, dropped)
+
+	hashNameClean(p)
+}
+
+func TestRecordResourceVersions(t *testing.T) {
+	workloadMap := bpfcache.NewFakeWorkloadMap(t)
+	defer bpfcache.CleanupFakeWorkloadMap(workloadMap)
+
+	p := NewProcessor(workloadMap)
+	p.recordResourceVersions(&service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl: AddressType,
+		Resources: []*service_discovery_v3.Resource{
+			{Name: "default/svc1", Version: "1"},
+			{Name: "cluster0//Pod/default/wl1", Version: "2"},
+		},
+	})
+	p.recordResourceVersions(&service_discovery_v3.DeltaDiscoveryResponse{
+		TypeUrl:          AddressType,
+		Resources:        []*service_discovery_v3.Resource{{Name: "default/svc1", Version: "3"}},
+		RemovedResources: []string{"cluster0//Pod/default/wl1"},
+	})
+	assert.Equal(t, "3", p.resourceVersion(AddressType, "default/svc1"))
+	assert.Equal(t, "", p.resourceVersion(AddressType, "cluster0//Pod/default/wl1"))
+	assert.Equal(t, "", p.resourceVersion(AuthorizationType, "default/svc1"))
+}
@@ -17,9 +17,14 @@
 package kube
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
 	nodeinfo "kmesh.net/kmesh/pkg/kube/nodeinfo/clientset/versioned"
 )
@@ -27,6 +32,32 @@ import (
 // CreateKubeClient creates a kube client with the given kubeconfig file, if no kubeconfig specified, in cluster kubeconfig will be used.
 // applyFuncs is optional, which can be used to tune client rest.Config
 func CreateKubeClient(kubeConfig string, applyFuncs ...func(c *rest.Config)) (kubernetes.Interface, error) {
+	restConfig, err := buildRestConfig(kubeConfig, applyFuncs...)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// CreateMetadataClient creates a client only retrieving the metadata of the resources, which works with any resource type,
+// including the custom resources whose types are not known by kmesh.
+func CreateMetadataClient(kubeConfig string, applyFuncs ...func(c *rest.Config)) (metadata.Interface, error) {
+	restConfig, err := buildRestConfig(kubeConfig, applyFuncs...)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.NewForConfig(restConfig)
+}
+
+// NewEventRecorder creates a recorder emitting the Kubernetes events of the given component through the client,
+// the events are sent in the background so recording never blocks the caller.
+func NewEventRecorder(client kubernetes.Interface, component, host string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: host})
+}
+
+func buildRestConfig(kubeConfig string, applyFuncs ...func(c *rest.Config)) (*rest.Config, error) {
 	var restConfig *rest.Config
 	var err error
 
@@ -42,8 +73,7 @@ func CreateKubeClient(kubeConfig string, applyFuncs ...func(c *rest.Config)) (ku
 	for _, fn := range applyFuncs {
 		fn(restConfig)
 	}
-
-	return kubernetes.NewForConfig(restConfig)
+	return restConfig, nil
 }
 
 func GetKmeshNodeInfoClient() (nodeinfo.Interface, error) {
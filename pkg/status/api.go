@@ -20,10 +20,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/api/v2/workloadapi/security"
+	"kmesh.net/kmesh/pkg/controller/workload"
 	"kmesh.net/kmesh/pkg/controller/workload/bpfcache"
 	"kmesh.net/kmesh/pkg/nets"
 	"kmesh.net/kmesh/pkg/utils"
@@ -47,6 +50,7 @@ type Workload struct {
 	Node                  string            `json:"node"`
 	Network               string            `json:"network,omitempty"`
 	Status                string            `json:"status"`
+	Capacity              uint32            `json:"capacity,omitempty"`
 	ApplicationTunnel     ApplicationTunnel `json:"applicationTunnel,omitempty"`
 	Services              []string          `json:"services,omitempty"`
 	AuthorizationPolicies []string          `json:"authorizationPolicies,omitempty"`
@@ -90,6 +94,16 @@ type AuthorizationPolicy struct {
 	Rules     []*security.Rule `json:"rules"`
 }
 
+type OutlierEjection struct {
+	Uid           string `json:"uid"`
+	Name          string `json:"name,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Reason        string `json:"reason"`
+	EjectionCount uint32 `json:"ejectionCount"`
+	EjectedAt     string `json:"ejectedAt"`
+	EjectedUntil  string `json:"ejectedUntil"`
+}
+
 type NetworkAddress struct {
 	// Network represents the network this address is on.
 	Network string
@@ -126,6 +140,7 @@ func ConvertWorkload(w *workloadapi.Workload) *Workload {
 		Node:                  w.Node,
 		Network:               w.Network,
 		Status:                w.Status.String(),
+		Capacity:              w.GetCapacity().GetValue(),
 		AuthorizationPolicies: w.AuthorizationPolicies,
 	}
 	if w.Locality != nil {
@@ -190,6 +205,19 @@ func ConvertAuthorizationPolicy(p *security.Authorization) *AuthorizationPolicy
 	return out
 }
 
+// ConvertOutlierEjection converts an ejection of outlier detection, w is the ejected workload and may be nil.
+func ConvertOutlierEjection(e workload.OutlierEjection, w *workloadapi.Workload) *OutlierEjection {
+	return &OutlierEjection{
+		Uid:           e.WorkloadUid,
+		Name:          w.GetName(),
+		Namespace:     w.GetNamespace(),
+		Reason:        fmt.Sprintf("%d consecutive connect failures", e.ConsecutiveFailures),
+		EjectionCount: e.EjectionCount,
+		EjectedAt:     e.EjectedAt.Format(time.RFC3339),
+		EjectedUntil:  e.EjectedUntil.Format(time.RFC3339),
+	}
+}
+
 type prettyArray[T any] []T
 
 func (a prettyArray[T]) MarshalJSON() ([]byte, error) {
@@ -231,12 +259,21 @@ type BpfEndpointValue struct {
 	BackendUid string `json:"backendUid,omitempty"`
 }
 
+type BpfEndpointWeight struct {
+	Service    string `json:"service"`
+	Priority   uint32 `json:"priority"`
+	BackendUid string `json:"backendUid"`
+	// Weight is the number of endpoint slots the backend occupies in the priority group of the service.
+	Weight uint32 `json:"weight"`
+}
+
 type WorkloadBpfDump struct {
 	hashName *utils.HashName
 
 	WorkloadPolicies []BpfWorkloadPolicyValue `json:"workloadPolicies"`
 	Backends         []BpfBackendValue        `json:"backends"`
 	Endpoints        []BpfEndpointValue       `json:"endpoints"`
+	EndpointWeights  []BpfEndpointWeight      `json:"endpointWeights"`
 	Frontends        []BpfFrontendValue       `json:"frontends"`
 	Services         []BpfServiceValue        `json:"services"`
 }
@@ -299,6 +336,40 @@ func (wd WorkloadBpfDump) WithEndpoints(endpoints []bpfcache.EndpointValue) Work
 	return wd
 }
 
+func (wd WorkloadBpfDump) WithEndpointWeights(keys []bpfcache.EndpointKey, values []bpfcache.EndpointValue) WorkloadBpfDump {
+	type weightKey struct {
+		serviceId  uint32
+		prio       uint32
+		backendUid uint32
+	}
+
+	weights := make(map[weightKey]uint32)
+	for i := range keys {
+		weights[weightKey{serviceId: keys[i].ServiceId, prio: keys[i].Prio, backendUid: values[i].BackendUid}]++
+	}
+
+	converted := make([]BpfEndpointWeight, 0, len(weights))
+	for k, weight := range weights {
+		converted = append(converted, BpfEndpointWeight{
+			Service:    wd.hashName.NumToStr(k.serviceId),
+			Priority:   k.prio,
+			BackendUid: wd.hashName.NumToStr(k.backendUid),
+			Weight:     weight,
+		})
+	}
+	sort.Slice(converted, func(i, j int) bool {
+		if converted[i].Service != converted[j].Service {
+			return converted[i].Service < converted[j].Service
+		}
+		if converted[i].Priority != converted[j].Priority {
+			return converted[i].Priority < converted[j].Priority
+		}
+		return converted[i].BackendUid < converted[j].BackendUid
+	})
+	wd.EndpointWeights = converted
+	return wd
+}
+
 func (wd WorkloadBpfDump) WithFrontends(frontends []bpfcache.FrontendValue) WorkloadBpfDump {
 	converted := make([]BpfFrontendValue, 0, len(frontends))
 	for _, frontend := range frontends {
@@ -310,16 +381,28 @@ func (wd WorkloadBpfDump) WithFrontends(frontends []bpfcache.FrontendValue) Work
 	return wd
 }
 
-func (wd WorkloadBpfDump) WithServices(services []bpfcache.ServiceValue) WorkloadBpfDump {
+// WithServices converts the services in the service map, the ports exceeding bpfcache.MaxPortNum are
+// taken from the service port map.
+func (wd WorkloadBpfDump) WithServices(keys []bpfcache.ServiceKey, services []bpfcache.ServiceValue,
+	portKeys []bpfcache.ServicePortKey, portValues []bpfcache.ServicePortValue) WorkloadBpfDump {
+	extraPorts := make(map[uint32][]int, len(portKeys))
+	for i, k := range portKeys {
+		extraPorts[k.ServiceId] = append(extraPorts[k.ServiceId], i)
+	}
+
 	converted := make([]BpfServiceValue, 0, len(services))
-	for _, s := range services {
+	for i, s := range services {
 		waypointAddr := ""
 		if s.WaypointAddr != [16]byte{} {
 			waypointAddr = nets.IpString(s.WaypointAddr)
 		}
+		lbPolicy := workloadapi.LoadBalancing_Mode_name[int32(s.LbPolicy)]
+		if s.LbPolicy == bpfcache.LbPolicyMaglev {
+			lbPolicy = "MAGLEV"
+		}
 		svc := BpfServiceValue{
 			EndpointCount: []uint32{},
-			LbPolicy:      workloadapi.LoadBalancing_Mode_name[int32(s.LbPolicy)],
+			LbPolicy:      lbPolicy,
 			WaypointAddr:  waypointAddr,
 			WaypointPort:  nets.ConvertPortToLittleEndian(s.WaypointPort),
 		}
@@ -342,6 +425,18 @@ func (wd WorkloadBpfDump) WithServices(services []bpfcache.ServiceValue) Workloa
 			svc.TargetPort = append(svc.TargetPort, nets.ConvertPortToLittleEndian(p))
 		}
 
+		if i < len(keys) {
+			indexes := extraPorts[keys[i].ServiceId]
+			sort.Slice(indexes, func(a, b int) bool {
+				return nets.ConvertPortToLittleEndian(portKeys[indexes[a]].ServicePort) <
+					nets.ConvertPortToLittleEndian(portKeys[indexes[b]].ServicePort)
+			})
+			for _, index := range indexes {
+				svc.ServicePort = append(svc.ServicePort, nets.ConvertPortToLittleEndian(portKeys[index].ServicePort))
+				svc.TargetPort = append(svc.TargetPort, nets.ConvertPortToLittleEndian(portValues[index].TargetPort))
+			}
+		}
+
 		converted = append(converted, svc)
 	}
 	wd.Services = converted
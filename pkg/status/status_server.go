@@ -23,21 +23,28 @@ import (
 	"io"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
+	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	adminv2 "kmesh.net/kmesh/api/v2/admin"
+	"kmesh.net/kmesh/api/v2/workloadapi"
 	"kmesh.net/kmesh/daemon/options"
+	"kmesh.net/kmesh/pkg/auth"
 	"kmesh.net/kmesh/pkg/bpf"
 	bpfads "kmesh.net/kmesh/pkg/bpf/ads"
 	maps_v2 "kmesh.net/kmesh/pkg/cache/v2/maps"
 	"kmesh.net/kmesh/pkg/constants"
 	"kmesh.net/kmesh/pkg/controller"
 	"kmesh.net/kmesh/pkg/controller/ads"
+	"kmesh.net/kmesh/pkg/controller/telemetry"
+	"kmesh.net/kmesh/pkg/controller/workload/cache"
 	"kmesh.net/kmesh/pkg/logger"
 	"kmesh.net/kmesh/pkg/version"
 )
@@ -60,12 +67,23 @@ const (
 	patternWorkloadMetrics    = "/workload_metrics"
 	patternConnectionMetrics  = "/connection_metrics"
 	patternAuthz              = "/authz"
+	patternXdsConnection      = "/debug/xds_connection"
+	patternAuthzDenies        = "/debug/authz/denies"
+	patternAuthzCheck         = "/debug/authz/check"
+	patternAuthzPolicies      = "/debug/authz/policies"
+	patternServiceGraph       = "/debug/service_graph"
+	patternTrace              = "/debug/trace"
 
 	bpfLoggerName = "bpf"
 
 	httpTimeout = time.Second * 20
 
 	invalidModeErrMessage = "\tInvalid Client Mode\n"
+
+	// denyLogBufferSize is the number of deny records buffered for a slow client, the ones beyond are dropped
+	denyLogBufferSize = 1024
+	// traceBufferSize is the number of connection events buffered for a slow client, the ones beyond are dropped
+	traceBufferSize = 1024
 )
 
 type Server struct {
@@ -101,6 +119,12 @@ func NewServer(c *controller.XdsClient, configs *options.BootstrapConfigs, loade
 	s.mux.HandleFunc(patternWorkloadMetrics, s.workloadMetricHandler)
 	s.mux.HandleFunc(patternConnectionMetrics, s.connectionMetricHandler)
 	s.mux.HandleFunc(patternAuthz, s.authzHandler)
+	s.mux.HandleFunc(patternXdsConnection, s.xdsConnection)
+	s.mux.HandleFunc(patternAuthzDenies, s.authzDenies)
+	s.mux.HandleFunc(patternAuthzCheck, s.authzCheck)
+	s.mux.HandleFunc(patternAuthzPolicies, s.authzPolicies)
+	s.mux.HandleFunc(patternServiceGraph, s.serviceGraph)
+	s.mux.HandleFunc(patternTrace, s.trace)
 
 	// TODO: add dump certificate, authorizationPolicies and services
 	s.mux.HandleFunc(patternReadyProbe, s.readyProbe)
@@ -154,11 +178,15 @@ func (s *Server) bpfWorkloadMaps(w http.ResponseWriter, r *http.Request) {
 	}
 	client := s.xdsClient
 	bpfMaps := client.WorkloadController.Processor.GetBpfCache()
+	endpointKeys, endpointValues := bpfMaps.EndpointLookupAllWithKey()
+	serviceKeys, serviceValues := bpfMaps.ServiceLookupAllWithKey()
+	servicePortKeys, servicePortValues := bpfMaps.ServicePortLookupAll()
 	workloadBpfDump := NewWorkloadBpfDump(s.xdsClient.WorkloadController.Processor.GetHashName()).
 		WithBackends(bpfMaps.BackendLookupAll()).
 		WithEndpoints(bpfMaps.EndpointLookupAll()).
+		WithEndpointWeights(endpointKeys, endpointValues).
 		WithFrontends(bpfMaps.FrontendLookupAll()).
-		WithServices(bpfMaps.ServiceLookupAll()).
+		WithServices(serviceKeys, serviceValues, servicePortKeys, servicePortValues).
 		WithWorkloadPolicies(bpfMaps.WorkloadPolicyLookupAll())
 
 	printWorkloadBpfDump(w, workloadBpfDump)
@@ -219,26 +247,96 @@ func (s *Server) loggersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) accesslogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.getAccesslogStatus(w)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	accesslogInfo := r.URL.Query().Get("enable")
-	enabled, err := strconv.ParseBool(accesslogInfo)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(fmt.Sprintf("invalid accesslog enable=%s", accesslogInfo)))
-		return
+	// the filter can be updated along with or without the trigger
+	query := r.URL.Query()
+	updateFilter := query.Has("namespaces") || query.Has("services") || query.Has("sampling")
+	updateTrigger := query.Has("enable") || !updateFilter
+
+	var enabled bool
+	if updateTrigger {
+		accesslogInfo := query.Get("enable")
+		var err error
+		enabled, err = strconv.ParseBool(accesslogInfo)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(fmt.Sprintf("invalid accesslog enable=%s", accesslogInfo)))
+			return
+		}
+
+		if s.loader.GetEnableMonitoring() == constants.DISABLED && enabled {
+			http.Error(w, "Kmesh monitoring is disabled, cannot enable accesslog.", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if s.loader.GetEnableMonitoring() == constants.DISABLED && enabled {
-		http.Error(w, "Kmesh monitoring is disabled, cannot enable accesslog.", http.StatusBadRequest)
-		return
+	if updateFilter {
+		if !s.checkWorkloadMode(w) {
+			return
+		}
+		filter, err := parseAccesslogFilter(query, s.xdsClient.WorkloadController.GetAccesslogStatus().Filter)
+		if err == nil {
+			err = s.xdsClient.WorkloadController.SetAccesslogFilter(filter)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid accesslog filter: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if updateTrigger {
+		s.xdsClient.WorkloadController.SetAccesslogTrigger(enabled)
 	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	s.xdsClient.WorkloadController.SetAccesslogTrigger(enabled)
+// parseAccesslogFilter overrides the fields of filter present in query, namespaces and services
+// are comma separated lists and an empty one matches all.
+func parseAccesslogFilter(query url.Values, filter telemetry.AccesslogFilter) (telemetry.AccesslogFilter, error) {
+	splitList := func(value string) []string {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	if query.Has("namespaces") {
+		filter.Namespaces = splitList(query.Get("namespaces"))
+	}
+	if query.Has("services") {
+		filter.Services = splitList(query.Get("services"))
+	}
+	if query.Has("sampling") {
+		ratio, err := strconv.ParseFloat(query.Get("sampling"), 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid sampling=%s", query.Get("sampling"))
+		}
+		filter.SamplingRatio = ratio
+	}
+	return filter, filter.Validate()
+}
+
+func (s *Server) getAccesslogStatus(w http.ResponseWriter) {
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+	data, err := json.MarshalIndent(s.xdsClient.WorkloadController.GetAccesslogStatus(), "", "  ")
+	if err != nil {
+		log.Errorf("Failed to marshal accesslog status: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
 }
 
 func (s *Server) monitoringHandler(w http.ResponseWriter, r *http.Request) {
@@ -356,6 +454,266 @@ func (s *Server) authzHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// authzDenies streams the records of the connections denied by the authorization as json lines,
+// until the client goes away.
+func (s *Server) authzDenies(w http.ResponseWriter, r *http.Request) {
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+	rbac := s.xdsClient.WorkloadController.Rbac
+	if rbac == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "\tauthorization is not started")
+		return
+	}
+
+	// the stream outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	records, cancel := rbac.SubscribeDenyLog(denyLogBufferSize)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case record := <-records:
+			if err := encoder.Encode(record); err != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+	}
+}
+
+// trace streams the connection open, close and deny events matching the query as newline delimited json,
+// e.g. /debug/trace?pod=sleep-7d5f9b9c4-x2x5z&namespace=default&ip=10.0.0.1&port=80
+func (s *Server) trace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+	filter, err := parseTraceFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	controller := s.xdsClient.WorkloadController
+	// the stream outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	events, cancel := controller.SubscribeConnectionEvents(traceBufferSize)
+	defer cancel()
+	// denies are only traced when authorization is started
+	var denies <-chan auth.DenyRecord
+	var workloadCache cache.WorkloadCache
+	if controller.Rbac != nil && controller.Processor != nil {
+		workloadCache = controller.Processor.WorkloadCache
+		var cancelDenies func()
+		denies, cancelDenies = controller.Rbac.SubscribeDenyLog(traceBufferSize)
+		defer cancelDenies()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		var event telemetry.ConnectionEvent
+		select {
+		case <-r.Context().Done():
+			return
+		case event = <-events:
+		case record := <-denies:
+			event = denyEvent(record, workloadCache)
+		}
+		if !filter.Match(&event) {
+			continue
+		}
+		if err := encoder.Encode(event); err != nil {
+			return
+		}
+		_ = rc.Flush()
+	}
+}
+
+func parseTraceFilter(query url.Values) (*telemetry.ConnectionEventFilter, error) {
+	filter := &telemetry.ConnectionEventFilter{
+		Pod:       query.Get("pod"),
+		Namespace: query.Get("namespace"),
+	}
+	if ip := query.Get("ip"); ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip=%s", ip)
+		}
+		filter.Ip = addr.String()
+	}
+	if port := query.Get("port"); port != "" {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port=%s", port)
+		}
+		filter.Port = uint32(p)
+	}
+	return filter, nil
+}
+
+// denyEvent converts the deny record into a connection event, with the source pod looked up by the source ip.
+func denyEvent(record auth.DenyRecord, workloadCache cache.WorkloadCache) telemetry.ConnectionEvent {
+	event := telemetry.ConnectionEvent{
+		Time:                 record.Time,
+		Type:                 telemetry.ConnectionEventDeny,
+		Direction:            "INBOUND",
+		SourceIp:             record.SourceIp,
+		DestinationIp:        record.DestinationIp,
+		DestinationPort:      record.DestinationPort,
+		DestinationPod:       record.DestinationWorkload,
+		DestinationNamespace: record.DestinationNamespace,
+		Policy:               record.Policy,
+		Reason:               record.Reason,
+		DryRun:               record.DryRun,
+	}
+	if addr, err := netip.ParseAddr(record.SourceIp); err == nil && workloadCache != nil {
+		if workload := workloadCache.GetWorkloadByAddr(cache.NetworkAddress{Address: addr}); workload != nil {
+			event.SourcePod = workload.GetName()
+			event.SourceNamespace = workload.GetNamespace()
+		}
+	}
+	return event
+}
+
+// authzCheck evaluates the connection given by the query against the live policies,
+// e.g. /debug/authz/check?src_ip=10.0.0.2&dst_ip=10.0.0.1&dst_port=80
+func (s *Server) authzCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	port, err := strconv.ParseUint(query.Get("dst_port"), 10, 16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid dst_port=%s", query.Get("dst_port")), http.StatusBadRequest)
+		return
+	}
+	req := &auth.CheckRequest{
+		SourceIp:        query.Get("src_ip"),
+		SourceIdentity:  query.Get("src_identity"),
+		DestinationIp:   query.Get("dst_ip"),
+		DestinationPort: uint32(port),
+		Network:         query.Get("network"),
+	}
+	result, err := s.xdsClient.WorkloadController.Rbac.Check(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Errorf("Failed to marshal authz check result: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// authzPolicies dumps the authorization policies with the warnings of them, or the effective policies of
+// the workload given by uid, namespace/name or ip, e.g. /debug/authz/policies?workload=default/sleep-7d5f9b9c4-x2x5z
+func (s *Server) authzPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+
+	controller := s.xdsClient.WorkloadController
+	var report any
+	if name := r.URL.Query().Get("workload"); name != "" {
+		workload := findWorkload(controller.Processor.WorkloadCache, name)
+		if workload == nil {
+			http.Error(w, fmt.Sprintf("workload %s not found", name), http.StatusNotFound)
+			return
+		}
+		report = controller.Rbac.WorkloadPolicyReport(workload)
+	} else {
+		report = controller.Rbac.PolicyReport()
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Errorf("Failed to marshal authz policy report: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serviceGraph returns the service graph built from the connections reported by this node,
+// in json by default or in Graphviz DOT with format=dot. The json graph keeps the edges of each
+// reporter apart, so that the graphs of all the nodes can be merged and deduplicated.
+func (s *Server) serviceGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkWorkloadMode(w) {
+		return
+	}
+
+	graph := s.xdsClient.WorkloadController.GetServiceGraph()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		data, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			log.Errorf("Failed to marshal service graph: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	case "dot":
+		graph.Deduplicate()
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(graph.DOT()))
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %s, must be json or dot", format), http.StatusBadRequest)
+	}
+}
+
+// findWorkload finds the workload by uid, namespace/name or ip.
+func findWorkload(workloadCache cache.WorkloadCache, name string) *workloadapi.Workload {
+	if workload := workloadCache.GetWorkloadByUid(name); workload != nil {
+		return workload
+	}
+	if addr, err := netip.ParseAddr(name); err == nil {
+		return workloadCache.GetWorkloadByAddr(cache.NetworkAddress{Address: addr})
+	}
+	for _, workload := range workloadCache.List() {
+		if workload.GetNamespace()+"/"+workload.GetName() == name {
+			return workload
+		}
+	}
+	return nil
+}
+
 func (s *Server) getLoggerNames(w http.ResponseWriter) {
 	loggerNames := append(logger.GetLoggerNames(), bpfLoggerName)
 	data, err := json.MarshalIndent(&loggerNames, "", "    ")
@@ -458,15 +816,28 @@ func (s *Server) configDumpAds(w http.ResponseWriter, r *http.Request) {
 	dynamicRes.RouteConfigs = cache.RouteCache.Dump()
 	ads.SetApiVersionInfo(dynamicRes)
 
-	fmt.Fprintln(w, protojson.Format(&adminv2.ConfigDump{
+	dump := protojson.Format(&adminv2.ConfigDump{
 		DynamicResources: dynamicRes,
-	}))
+	})
+	if health := client.AdsController.EndpointHealth(); len(health) > 0 {
+		// the health status of endpoints is not a part of the config dump proto, append it as an extra field
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(dump), &fields); err == nil {
+			fields["endpointHealth"], _ = json.Marshal(health)
+			if data, err := json.MarshalIndent(fields, "", "  "); err == nil {
+				dump = string(data)
+			}
+		}
+	}
+	fmt.Fprintln(w, dump)
 }
 
 type WorkloadDump struct {
 	Workloads []*Workload            `json:"workloads"`
 	Services  []*Service             `json:"services"`
 	Policies  []*AuthorizationPolicy `json:"policies"`
+	// OutlierEjections are the workloads ejected by outlier detection
+	OutlierEjections []*OutlierEjection `json:"outlierEjections,omitempty"`
 }
 
 func (s *Server) configDumpWorkload(w http.ResponseWriter, r *http.Request) {
@@ -493,9 +864,32 @@ func (s *Server) configDumpWorkload(w http.ResponseWriter, r *http.Request) {
 	for _, p := range policies {
 		workloadDump.Policies = append(workloadDump.Policies, ConvertAuthorizationPolicy(p))
 	}
+	for _, e := range client.WorkloadController.OutlierEjections() {
+		wl := client.WorkloadController.Processor.WorkloadCache.GetWorkloadByUid(e.WorkloadUid)
+		workloadDump.OutlierEjections = append(workloadDump.OutlierEjections, ConvertOutlierEjection(e, wl))
+	}
 	printWorkloadDump(w, workloadDump)
 }
 
+// xdsConnection shows the state of the connection to the discovery servers.
+func (s *Server) xdsConnection(w http.ResponseWriter, r *http.Request) {
+	if s.xdsClient == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "\txds client is not started")
+		return
+	}
+
+	data, err := json.MarshalIndent(s.xdsClient.ConnectionState(), "", "  ")
+	if err != nil {
+		log.Errorf("Failed to marshal xds connection state: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
 func (s *Server) readyProbe(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add some components check
 	w.WriteHeader(http.StatusOK)
@@ -18,12 +18,14 @@ package status
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"sort"
 	"testing"
 
@@ -662,3 +664,166 @@ func TestServerMonitoringHandler(t *testing.T) {
 		assert.Equal(t, constants.ENABLED, enableMonitoring)
 	})
 }
+
+func TestServerAccesslogFilter(t *testing.T) {
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{
+				MetricController: telemetry.NewMetric(nil, nil, true),
+			},
+		},
+	}
+
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, patternAccesslog+"?"+query, nil)
+		w := httptest.NewRecorder()
+		server.accesslogHandler(w, req)
+		return w
+	}
+	getStatus := func() telemetry.AccesslogStatus {
+		req := httptest.NewRequest(http.MethodGet, patternAccesslog, nil)
+		w := httptest.NewRecorder()
+		server.accesslogHandler(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var status telemetry.AccesslogStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status
+	}
+
+	// the filter is updated without changing the trigger
+	w := post("namespaces=default,%20foo&sampling=0.5")
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := getStatus()
+	assert.False(t, status.Enabled)
+	assert.Equal(t, "stdout", status.Sink)
+	assert.Equal(t, telemetry.AccesslogFilter{Namespaces: []string{"default", "foo"}, SamplingRatio: 0.5}, status.Filter)
+
+	// fields absent from the query are kept, an empty list clears the filter
+	w = post("services=httpbin&namespaces=")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, telemetry.AccesslogFilter{Services: []string{"httpbin"}, SamplingRatio: 0.5}, getStatus().Filter)
+
+	w = post("sampling=1.5")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post("sampling=abc")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post("")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, telemetry.AccesslogFilter{Services: []string{"httpbin"}, SamplingRatio: 0.5}, getStatus().Filter)
+}
+
+func TestServerServiceGraph(t *testing.T) {
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{
+				MetricController: telemetry.NewMetric(nil, nil, true),
+			},
+		},
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, patternServiceGraph+"?"+query, nil)
+		w := httptest.NewRecorder()
+		server.serviceGraph(w, req)
+		return w
+	}
+
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	graph := telemetry.ServiceGraph{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &graph))
+	assert.Empty(t, graph.Edges)
+	assert.Equal(t, "5m0s", graph.Window)
+
+	w = get("format=dot")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/vnd.graphviz", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "digraph kmesh {")
+
+	w = get("format=yaml")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req := httptest.NewRequest(http.MethodPost, patternServiceGraph, nil)
+	w = httptest.NewRecorder()
+	server.serviceGraph(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServerTrace(t *testing.T) {
+	server := &Server{
+		xdsClient: &controller.XdsClient{
+			WorkloadController: &workload.Controller{
+				MetricController: telemetry.NewMetric(nil, nil, true),
+			},
+		},
+	}
+
+	for _, query := range []string{"ip=10.0.0", "port=abc", "port=65536"} {
+		req := httptest.NewRequest(http.MethodGet, patternTrace+"?"+query, nil)
+		w := httptest.NewRecorder()
+		server.trace(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// the stream is ended once the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, patternTrace+"?pod=sleep&port=80", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		server.trace(w, req)
+		close(done)
+	}()
+	cancel()
+	<-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+}
+
+func TestParseTraceFilter(t *testing.T) {
+	filter, err := parseTraceFilter(url.Values{"pod": {"sleep"}, "namespace": {"default"}, "ip": {"fd00::0:1"}, "port": {"80"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &telemetry.ConnectionEventFilter{Pod: "sleep", Namespace: "default", Ip: "fd00::1", Port: 80}, filter)
+
+	filter, err = parseTraceFilter(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, &telemetry.ConnectionEventFilter{}, filter)
+}
+
+func TestDenyEvent(t *testing.T) {
+	workloadCache := cache.NewWorkloadCache()
+	workloadCache.AddOrUpdateWorkload(&workloadapi.Workload{
+		Uid:       "sleep-uid",
+		Name:      "sleep",
+		Namespace: "default",
+		Addresses: [][]byte{netip.MustParseAddr("10.0.0.2").AsSlice()},
+	})
+
+	record := auth.DenyRecord{
+		SourceIp:             "10.0.0.2",
+		DestinationIp:        "10.0.0.1",
+		DestinationPort:      80,
+		DestinationWorkload:  "httpbin",
+		DestinationNamespace: "default",
+		Policy:               "default/deny-sleep",
+		Reason:               auth.ReasonDenyMatched,
+	}
+	event := denyEvent(record, workloadCache)
+	assert.Equal(t, telemetry.ConnectionEvent{
+		Type:                 telemetry.ConnectionEventDeny,
+		Direction:            "INBOUND",
+		SourceIp:             "10.0.0.2",
+		SourcePod:            "sleep",
+		SourceNamespace:      "default",
+		DestinationIp:        "10.0.0.1",
+		DestinationPort:      80,
+		DestinationPod:       "httpbin",
+		DestinationNamespace: "default",
+		Policy:               "default/deny-sleep",
+		Reason:               auth.ReasonDenyMatched,
+	}, event)
+
+	// the source is unknown without the workload cache
+	event = denyEvent(record, nil)
+	assert.Empty(t, event.SourcePod)
+}
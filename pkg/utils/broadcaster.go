@@ -0,0 +1,66 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import "sync"
+
+// Broadcaster fans the values published out to the subscribers, a value is dropped for a subscriber not keeping up.
+// The zero value is ready to use.
+type Broadcaster[T any] struct {
+	mutex       sync.RWMutex
+	subscribers map[chan T]struct{}
+}
+
+// Subscribe returns the stream of the values published from now on, and the func to cancel the subscription.
+func (b *Broadcaster[T]) Subscribe(bufferSize int) (<-chan T, func()) {
+	ch := make(chan T, bufferSize)
+	b.mutex.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan T]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, ch)
+			b.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Enabled returns whether there is any subscriber, so the values are only built while they are consumed.
+func (b *Broadcaster[T]) Enabled() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// Publish sends the value to the subscribers without blocking.
+func (b *Broadcaster[T]) Publish(value T) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- value:
+		default:
+		}
+	}
+}
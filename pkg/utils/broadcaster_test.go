@@ -0,0 +1,53 @@
+/*
+ * Copyright The Kmesh Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcaster(t *testing.T) {
+	var b Broadcaster[int]
+	assert.False(t, b.Enabled())
+	// publishing without subscribers is a no-op
+	b.Publish(0)
+
+	ch1, cancel1 := b.Subscribe(1)
+	ch2, cancel2 := b.Subscribe(2)
+	assert.True(t, b.Enabled())
+
+	// the value is dropped for the subscriber whose buffer is full
+	b.Publish(1)
+	b.Publish(2)
+	assert.Equal(t, 1, <-ch1)
+	assert.Equal(t, 1, <-ch2)
+	assert.Equal(t, 2, <-ch2)
+	assert.Len(t, ch1, 0)
+
+	// the channel is closed once cancelled, and cancelling twice is safe
+	cancel1()
+	cancel1()
+	_, ok := <-ch1
+	assert.False(t, ok)
+	b.Publish(3)
+	assert.Equal(t, 3, <-ch2)
+
+	cancel2()
+	assert.False(t, b.Enabled())
+}